@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// addressHeadStart is how long the address a light last answered on is tried
+// alone, before its other addresses are tried alongside it.
+const addressHeadStart = 250 * time.Millisecond
+
+// AddressBook remembers which of a light's addresses it last answered on, so
+// that one is tried first next time.
+type AddressBook struct {
+	path string
+
+	mu        sync.Mutex
+	loaded    bool
+	preferred map[string]string
+}
+
+func defaultAddressBookPath() string {
+	dir := defaultStateDir()
+	if dir == "" {
+		return ""
+	}
+
+	return filepath.Join(dir, "addresses.json")
+}
+
+// addressBook is shared by every light's HTTP client. An empty path keeps
+// the preferences for this run only.
+var addressBook = &AddressBook{path: defaultAddressBookPath()}
+
+// load reads the remembered addresses, once. An unreadable file is treated as
+// empty. b.mu must be held.
+func (b *AddressBook) load() {
+	if b.loaded {
+		return
+	}
+	b.loaded = true
+	b.preferred = map[string]string{}
+
+	if b.path == "" {
+		return
+	}
+
+	data, err := os.ReadFile(b.path)
+	if err != nil {
+		return
+	}
+
+	if err := json.Unmarshal(data, &b.preferred); err != nil {
+		deviceLog.Debug("Ignoring unreadable address preferences", "path", b.path, "error", err)
+		b.preferred = map[string]string{}
+	}
+}
+
+// preferredAddress returns the address host last answered on, or "".
+func (b *AddressBook) preferredAddress(host string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.load()
+	return b.preferred[host]
+}
+
+// remember records that host answered on addr.
+func (b *AddressBook) remember(host, addr string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.load()
+	if b.preferred[host] == addr {
+		return
+	}
+	b.preferred[host] = addr
+	deviceLog.Debug("Preferring address", "host", host, "address", addr)
+
+	if b.path == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(b.preferred, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(b.path), 0o700)
+	}
+	if err == nil {
+		err = os.WriteFile(b.path, append(data, '\n'), 0o600)
+	}
+	if err != nil {
+		deviceLog.Debug("Failed to save address preferences", "path", b.path, "error", err)
+	}
+}
+
+// isIPAddress reports whether host is an IP address, rather than a name
+// which may have several.
+func isIPAddress(host string) bool {
+	ip, _, _ := strings.Cut(host, "%")
+	return net.ParseIP(ip) != nil
+}
+
+// addressProber connects to hosts with several addresses, such as the IPv4
+// and IPv6 addresses mDNS gives a light, by trying them all at once and using
+// the first which answers. Otherwise an IPv6 address which can't be reached
+// holds every request up until it times out.
+type addressProber struct {
+	dial   func(ctx context.Context, network, address string) (net.Conn, error)
+	lookup func(ctx context.Context, host string) ([]string, error)
+	book   *AddressBook
+
+	// headStart is how long the preferred address is tried alone.
+	headStart time.Duration
+}
+
+func newAddressProber(dialer *net.Dialer, book *AddressBook) *addressProber {
+	return &addressProber{
+		dial:      dialer.DialContext,
+		lookup:    net.DefaultResolver.LookupHost,
+		book:      book,
+		headStart: addressHeadStart,
+	}
+}
+
+func (p *addressProber) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || isIPAddress(host) {
+		return p.dial(ctx, network, address)
+	}
+
+	addrs, err := p.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 1 {
+		return p.dial(ctx, network, net.JoinHostPort(addrs[0], port))
+	}
+
+	// Stops the attempts which lose
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type attempt struct {
+		addr string
+		conn net.Conn
+		err  error
+	}
+	attempts := make(chan attempt)
+	pending := 0
+	try := func(addr string) {
+		pending++
+		go func() {
+			conn, err := p.dial(ctx, network, net.JoinHostPort(addr, port))
+			select {
+			case attempts <- attempt{addr, conn, err}:
+			case <-ctx.Done():
+				if conn != nil {
+					conn.Close()
+				}
+			}
+		}()
+	}
+
+	// The preferred address is tried first, if it's still one of the host's
+	var waiting []string
+	var headStart <-chan time.Time
+	preferred := p.book.preferredAddress(host)
+	for _, addr := range addrs {
+		if addr == preferred {
+			try(addr)
+			timer := time.NewTimer(p.headStart)
+			defer timer.Stop()
+			headStart = timer.C
+		} else {
+			waiting = append(waiting, addr)
+		}
+	}
+	if headStart == nil {
+		for _, addr := range waiting {
+			try(addr)
+		}
+		waiting = nil
+	}
+
+	var errs []error
+	for {
+		select {
+		case a := <-attempts:
+			pending--
+			if a.err == nil {
+				p.book.remember(host, a.addr)
+				return a.conn, nil
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", a.addr, a.err))
+
+		case <-headStart:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		// The others are tried once the preferred address has had its head
+		// start, or has failed
+		headStart = nil
+		for _, addr := range waiting {
+			try(addr)
+		}
+		waiting = nil
+
+		if pending == 0 {
+			return nil, fmt.Errorf("connecting to %s: %w", host, errors.Join(errs...))
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDialer connects to the addresses in up, and fails to connect to the
+// others. Addresses in hang don't answer until the dial is cancelled.
+type fakeDialer struct {
+	up, hang map[string]bool
+
+	mu     sync.Mutex
+	dialed []string
+}
+
+func (d *fakeDialer) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	d.mu.Lock()
+	d.dialed = append(d.dialed, address)
+	d.mu.Unlock()
+
+	switch {
+	case d.up[address]:
+		conn, _ := net.Pipe()
+		return conn, nil
+	case d.hang[address]:
+		<-ctx.Done()
+		return nil, ctx.Err()
+	default:
+		return nil, errors.New("connection refused")
+	}
+}
+
+func newTestProber(dialer *fakeDialer, book *AddressBook, addrs ...string) *addressProber {
+	return &addressProber{
+		dial: dialer.dial,
+		lookup: func(ctx context.Context, host string) ([]string, error) {
+			return addrs, nil
+		},
+		book:      book,
+		headStart: time.Hour,
+	}
+}
+
+func TestAddressProber(t *testing.T) {
+	ctx := context.Background()
+	book := &AddressBook{}
+
+	// The IPv6 address doesn't answer, so the IPv4 one is used without
+	// waiting for it
+	dialer := &fakeDialer{up: map[string]bool{"192.168.1.20:9123": true}, hang: map[string]bool{"[fe80::1]:9123": true}}
+	prober := newTestProber(dialer, book, "fe80::1", "192.168.1.20")
+
+	conn, err := prober.DialContext(ctx, "tcp", "key-light.local:9123")
+	require.NoError(t, err)
+	conn.Close()
+	require.Equal(t, "192.168.1.20", book.preferredAddress("key-light.local"))
+
+	// Next time the preferred address is tried alone first
+	dialer = &fakeDialer{up: map[string]bool{"192.168.1.20:9123": true, "[fe80::1]:9123": true}}
+	prober = newTestProber(dialer, book, "fe80::1", "192.168.1.20")
+
+	conn, err = prober.DialContext(ctx, "tcp", "key-light.local:9123")
+	require.NoError(t, err)
+	conn.Close()
+	require.Equal(t, []string{"192.168.1.20:9123"}, dialer.dialed)
+
+	// If it fails, the others are tried straight away
+	dialer = &fakeDialer{up: map[string]bool{"[fe80::1]:9123": true}}
+	prober = newTestProber(dialer, book, "fe80::1", "192.168.1.20")
+
+	conn, err = prober.DialContext(ctx, "tcp", "key-light.local:9123")
+	require.NoError(t, err)
+	conn.Close()
+	require.Equal(t, "fe80::1", book.preferredAddress("key-light.local"))
+
+	// Addresses are dialled as they are
+	dialer = &fakeDialer{up: map[string]bool{"192.168.1.30:9123": true}}
+	prober = newTestProber(dialer, book)
+
+	conn, err = prober.DialContext(ctx, "tcp", "192.168.1.30:9123")
+	require.NoError(t, err)
+	conn.Close()
+
+	// Every address failing
+	dialer = &fakeDialer{}
+	prober = newTestProber(dialer, book, "fe80::2", "192.168.1.40")
+
+	_, err = prober.DialContext(ctx, "tcp", "other.local:9123")
+	require.ErrorContains(t, err, "connecting to other.local")
+	require.ErrorContains(t, err, "fe80::2: connection refused")
+	require.ErrorContains(t, err, "192.168.1.40: connection refused")
+}
+
+func TestAddressBook(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "klctl", "addresses.json")
+
+	book := &AddressBook{path: path}
+	require.Equal(t, "", book.preferredAddress("key-light.local"))
+	book.remember("key-light.local", "192.168.1.20")
+
+	reloaded := &AddressBook{path: path}
+	require.Equal(t, "192.168.1.20", reloaded.preferredAddress("key-light.local"))
+}
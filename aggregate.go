@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Aggregation describes how the values of several lights are combined when
+// reporting a single field.
+type Aggregation int
+
+const (
+	// AggregateFirst takes the value of the first light, as get always has.
+	AggregateFirst Aggregation = iota
+	AggregateMax
+	AggregateMin
+	AggregateAvg
+	AggregateList
+)
+
+func (a Aggregation) String() string {
+	switch a {
+	case AggregateFirst:
+		return "first"
+	case AggregateMax:
+		return "max"
+	case AggregateMin:
+		return "min"
+	case AggregateAvg:
+		return "avg"
+	case AggregateList:
+		return "list"
+	}
+
+	return ""
+}
+
+func parseAggregation(s string) (Aggregation, error) {
+	for _, a := range []Aggregation{AggregateFirst, AggregateMax, AggregateMin, AggregateAvg, AggregateList} {
+		if a.String() == s {
+			return a, nil
+		}
+	}
+
+	return 0, fmt.Errorf("aggregate must be one of first, min, max, avg or list (got %s)", s)
+}
+
+// aggregateValues combines values according to the aggregation. All
+// aggregations apart from AggregateList return a single value, unless there
+// were no values to aggregate.
+func aggregateValues(values []int, aggregation Aggregation) []int {
+	if len(values) == 0 || aggregation == AggregateList {
+		return values
+	}
+
+	result := values[0]
+	sum := 0
+	for _, v := range values {
+		sum += v
+
+		switch aggregation {
+		case AggregateMax:
+			result = max(result, v)
+		case AggregateMin:
+			result = min(result, v)
+		}
+	}
+
+	if aggregation == AggregateAvg {
+		result = int(math.Round(float64(sum) / float64(len(values))))
+	}
+
+	return []int{result}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregateValues(t *testing.T) {
+	values := []int{10, 45, 20}
+
+	for _, test := range []struct {
+		aggregation Aggregation
+		expected    []int
+	}{
+		{AggregateFirst, []int{10}},
+		{AggregateMax, []int{45}},
+		{AggregateMin, []int{10}},
+		{AggregateAvg, []int{25}},
+		{AggregateList, []int{10, 45, 20}},
+	} {
+		t.Run(test.aggregation.String(), func(t *testing.T) {
+			require.Equal(t, test.expected, aggregateValues(values, test.aggregation))
+		})
+	}
+
+	require.Empty(t, aggregateValues(nil, AggregateAvg))
+}
+
+func TestParseAggregation(t *testing.T) {
+	a, err := parseAggregation("avg")
+	require.NoError(t, err)
+	require.Equal(t, AggregateAvg, a)
+
+	_, err = parseAggregation("median")
+	require.Error(t, err)
+}
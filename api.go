@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// apiMethods are the methods klctl api sends. The lights only answer GET and
+// PUT, but the others are there for seeing how the firmware responds.
+var apiMethods = []string{http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPost, http.MethodDelete, http.MethodOptions}
+
+// APIRequest is a raw request to the lights' HTTP API.
+type APIRequest struct {
+	Method string
+	Path   string
+	Body   []byte
+}
+
+// parseAPIRequest parses klctl api's arguments: a method, a path and, for
+// methods other than GET, an optional JSON body. A body of - is read from
+// stdin.
+func parseAPIRequest(args []string, stdin io.Reader) (APIRequest, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return APIRequest{}, invalidArgumentf("expected a method, a path and optionally a body, e.g. GET /elgato/lights")
+	}
+
+	req := APIRequest{Method: strings.ToUpper(args[0]), Path: "/" + strings.TrimPrefix(args[1], "/")}
+	if !slices.Contains(apiMethods, req.Method) {
+		return APIRequest{}, invalidArgumentf("method must be one of %s (got %s)", strings.Join(apiMethods, ", "), args[0])
+	}
+
+	if len(args) < 3 {
+		return req, nil
+	}
+
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return APIRequest{}, invalidArgumentf("%s requests don't take a body", req.Method)
+	}
+
+	body := []byte(args[2])
+	if args[2] == "-" {
+		var err error
+		if body, err = io.ReadAll(stdin); err != nil {
+			return APIRequest{}, err
+		}
+	}
+	if !json.Valid(body) {
+		return APIRequest{}, invalidArgumentf("the body isn't valid JSON")
+	}
+	req.Body = body
+
+	return req, nil
+}
+
+// sendAPIRequest sends req to every device at once. Responses are nil for
+// devices which didn't answer, and for requests a dry run doesn't send.
+func sendAPIRequest(ctx context.Context, devices []Device, req APIRequest) ([]*RawResponse, error) {
+	responses := make([]*RawResponse, len(devices))
+	err := forEachDevice(ctx, devices, func(ctx context.Context, i int, device Device) error {
+		resp, err := device.RawRequest(ctx, req.Method, req.Path, req.Body)
+		if err != nil {
+			return fmt.Errorf("%s: %w", deviceLabel(device), err)
+		}
+
+		responses[i] = resp
+		return nil
+	})
+
+	return responses, err
+}
+
+// APIResponse is a device's response to klctl api, for --output json.
+type APIResponse struct {
+	Device     string              `json:"device"`
+	StatusCode int                 `json:"status_code"`
+	Headers    map[string][]string `json:"headers,omitempty"`
+	// Body is the response as JSON if it's valid, otherwise as a string.
+	Body any `json:"body,omitempty"`
+}
+
+// renderAPIResponses prints the responses as they were sent. With several
+// devices, each is headed by which device it's from. include adds the status
+// line and headers, as curl -i does.
+func renderAPIResponses(w io.Writer, format string, devices []Device, responses []*RawResponse, include bool) error {
+	if format == OutputJSON {
+		out := []APIResponse{}
+		for i, resp := range responses {
+			if resp == nil {
+				continue
+			}
+
+			r := APIResponse{Device: devices[i].GetDNSAddr(), StatusCode: resp.StatusCode}
+			if include {
+				r.Headers = resp.Header
+			}
+			switch {
+			case len(resp.Body) == 0:
+			case json.Valid(resp.Body):
+				r.Body = json.RawMessage(resp.Body)
+			default:
+				r.Body = string(resp.Body)
+			}
+			out = append(out, r)
+		}
+
+		return writeJSON(w, out)
+	}
+
+	for i, resp := range responses {
+		if resp == nil {
+			continue
+		}
+
+		if len(devices) > 1 {
+			fmt.Fprintf(w, "==> %s <==\n", deviceLabel(devices[i]))
+		}
+
+		if include {
+			fmt.Fprintln(w, resp.Status)
+			keys := make([]string, 0, len(resp.Header))
+			for key := range resp.Header {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				for _, value := range resp.Header[key] {
+					fmt.Fprintf(w, "%s: %s\n", key, value)
+				}
+			}
+			fmt.Fprintln(w)
+		}
+
+		if _, err := w.Write(resp.Body); err != nil {
+			return err
+		}
+		if len(resp.Body) > 0 && !bytes.HasSuffix(resp.Body, []byte("\n")) {
+			fmt.Fprintln(w)
+		}
+	}
+
+	return nil
+}
+
+// apiResponseError reports the devices which didn't respond 200 OK, after
+// their responses have been shown.
+func apiResponseError(devices []Device, responses []*RawResponse) error {
+	var failed []string
+	for i, resp := range responses {
+		if resp != nil && resp.StatusCode != http.StatusOK {
+			failed = append(failed, fmt.Sprintf("%s responded %s", deviceLabel(devices[i]), resp.Status))
+		}
+	}
+
+	if len(failed) == 0 {
+		return nil
+	}
+
+	return errors.New(strings.Join(failed, ", "))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAPIRequest(t *testing.T) {
+	req, err := parseAPIRequest([]string{"get", "elgato/lights"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, APIRequest{Method: http.MethodGet, Path: "/elgato/lights"}, req)
+
+	req, err = parseAPIRequest([]string{"PUT", "/elgato/lights", `{"lights":[{"on":1}]}`}, nil)
+	require.NoError(t, err)
+	require.Equal(t, `{"lights":[{"on":1}]}`, string(req.Body))
+
+	req, err = parseAPIRequest([]string{"PUT", "/elgato/identify", "-"}, strings.NewReader("{}\n"))
+	require.NoError(t, err)
+	require.Equal(t, "{}\n", string(req.Body))
+
+	for _, args := range [][]string{
+		{"GET"},
+		{"FETCH", "/elgato/lights"},
+		{"GET", "/elgato/lights", "{}"},
+		{"PUT", "/elgato/lights", "{"},
+		{"PUT", "/elgato/lights", "{}", "extra"},
+	} {
+		_, err := parseAPIRequest(args, nil)
+		require.Error(t, err, args)
+		require.Equal(t, exitInvalidArgument, exitCode(err), args)
+	}
+}
+
+func TestAPIRequest(t *testing.T) {
+	left := &FakeDevice{Name: "key-left", DNSAddr: "192.168.1.1", Raw: &RawResponse{StatusCode: http.StatusOK, Status: "200 OK", Header: http.Header{"Content-Type": {"application/json"}}, Body: []byte(`{"numberOfLights":1}`)}}
+	right := &FakeDevice{Name: "key-right", DNSAddr: "192.168.1.2", Raw: &RawResponse{StatusCode: http.StatusNotFound, Status: "404 Not Found"}}
+	devices := []Device{left, right}
+
+	req := APIRequest{Method: http.MethodPut, Path: "/elgato/lights", Body: []byte("{}")}
+	responses, err := sendAPIRequest(context.Background(), devices, req)
+	require.NoError(t, err)
+	require.Equal(t, []string{"PUT /elgato/lights {}"}, left.RawRequests)
+
+	var out bytes.Buffer
+	require.NoError(t, renderAPIResponses(&out, OutputText, devices, responses, true))
+	require.Equal(t, "==> key-left <==\n200 OK\nContent-Type: application/json\n\n{\"numberOfLights\":1}\n==> key-right <==\n404 Not Found\n\n", out.String())
+
+	out.Reset()
+	require.NoError(t, renderAPIResponses(&out, OutputText, devices[:1], responses[:1], false))
+	require.Equal(t, "{\"numberOfLights\":1}\n", out.String())
+
+	require.EqualError(t, apiResponseError(devices, responses), "key-right responded 404 Not Found")
+
+	// A dry run sends reads, but only prints changes
+	var printed bytes.Buffer
+	dryRunDevices := withDryRun(devices, &printed)
+	responses, err = sendAPIRequest(context.Background(), dryRunDevices, req)
+	require.NoError(t, err)
+	require.Equal(t, []*RawResponse{nil, nil}, responses)
+	require.Len(t, left.RawRequests, 1)
+	require.Contains(t, printed.String(), "Would send PUT /elgato/lights to key-left: {}\n")
+
+	_, err = sendAPIRequest(context.Background(), dryRunDevices, APIRequest{Method: http.MethodGet, Path: "/elgato/lights"})
+	require.NoError(t, err)
+	require.Len(t, left.RawRequests, 2)
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Conflict policies, which say what klctl serve's schedules do once the
+// lights have been changed by hand.
+const (
+	// PolicyDaemonWins runs the schedules regardless.
+	PolicyDaemonWins = "daemon-wins"
+	// PolicyCLIWins holds the schedules off for a while after a change by
+	// hand, as in cli-wins-for-30m.
+	PolicyCLIWins = "cli-wins-for-"
+	// PolicyManual holds the schedules off after a change by hand until
+	// they're resumed.
+	PolicyManual = "manual"
+)
+
+// automationEnv is set for commands klctl serve runs itself, so they aren't
+// taken for changes by hand.
+const automationEnv = "KLCTL_AUTOMATION"
+
+// ConflictPolicy is what serve's schedules do once the lights have been
+// changed by hand.
+type ConflictPolicy struct {
+	Name string
+
+	// For is how long the schedules are held off, for PolicyCLIWins.
+	For time.Duration
+}
+
+func (p ConflictPolicy) String() string {
+	if p.Name == PolicyCLIWins {
+		return PolicyCLIWins + p.For.String()
+	}
+
+	return p.Name
+}
+
+func parseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch s {
+	case PolicyDaemonWins, PolicyManual:
+		return ConflictPolicy{Name: s}, nil
+	}
+
+	if after, ok := strings.CutPrefix(s, PolicyCLIWins); ok {
+		d, err := time.ParseDuration(after)
+		if err != nil || d <= 0 {
+			return ConflictPolicy{}, fmt.Errorf("invalid duration in %q, e.g. %s30m", s, PolicyCLIWins)
+		}
+
+		return ConflictPolicy{Name: PolicyCLIWins, For: d}, nil
+	}
+
+	return ConflictPolicy{}, fmt.Errorf("conflict policy must be %s, %s<duration> or %s (got %s)", PolicyDaemonWins, PolicyCLIWins, PolicyManual, s)
+}
+
+// Arbiter decides whether serve's schedules may change the lights, going by
+// when they were last changed by hand.
+type Arbiter struct {
+	policy ConflictPolicy
+	clock  Clock
+
+	mu sync.Mutex
+	// manualAt is when the lights were last changed by hand, or zero if they
+	// haven't been since the schedules were resumed.
+	manualAt time.Time
+}
+
+func newArbiter(policy ConflictPolicy, clock Clock) *Arbiter {
+	return &Arbiter{policy: policy, clock: clock}
+}
+
+// manualChange records the lights being changed by hand.
+func (a *Arbiter) manualChange() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.manualAt = a.clock.Now()
+	automationLog.Debug("Lights changed by hand", "policy", a.policy)
+}
+
+// resume lets the schedules change the lights again.
+func (a *Arbiter) resume() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.manualAt = time.Time{}
+}
+
+// ArbiterStatus is what the Arbiter is doing, for GET /manual.
+type ArbiterStatus struct {
+	Policy   string     `json:"policy"`
+	ManualAt *time.Time `json:"manual_at,omitempty"`
+	// HeldUntil is when the schedules may run again. It's nil when they
+	// aren't held off, or are until they're resumed.
+	HeldUntil *time.Time `json:"held_until,omitempty"`
+	Allowed   bool       `json:"allowed"`
+}
+
+func (a *Arbiter) status() ArbiterStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	status := ArbiterStatus{Policy: a.policy.String(), Allowed: a.allowedLocked()}
+	if !a.manualAt.IsZero() {
+		manualAt := a.manualAt
+		status.ManualAt = &manualAt
+
+		if a.policy.Name == PolicyCLIWins && !status.Allowed {
+			until := manualAt.Add(a.policy.For)
+			status.HeldUntil = &until
+		}
+	}
+
+	return status
+}
+
+// allowed reports whether the schedules may change the lights now.
+func (a *Arbiter) allowed() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.allowedLocked()
+}
+
+func (a *Arbiter) allowedLocked() bool {
+	if a.manualAt.IsZero() {
+		return true
+	}
+
+	switch a.policy.Name {
+	case PolicyCLIWins:
+		return !a.clock.Now().Before(a.manualAt.Add(a.policy.For))
+	case PolicyManual:
+		return false
+	default:
+		return true
+	}
+}
+
+func (s *APIServer) routeManual(r *http.Request) (any, error) {
+	if s.arbiter == nil {
+		return nil, apiErrorf(http.StatusNotFound, "no such endpoint %s", r.URL.Path)
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		s.arbiter.manualChange()
+	case http.MethodDelete:
+		s.arbiter.resume()
+	default:
+		return nil, apiErrorf(http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+	}
+
+	return s.arbiter.status(), nil
+}
+
+// changesLights reports whether a request to path changes the lights, when
+// it isn't a GET.
+func changesLights(path string) bool {
+	return strings.HasPrefix(path, "/lights/") || strings.HasPrefix(path, "/devices/")
+}
+
+// defaultSocketPath returns serve.sock in the state directory, where serve
+// listens for klctl on the same machine.
+func defaultSocketPath() string {
+	dir := defaultStateDir()
+	if dir == "" {
+		return ""
+	}
+
+	return filepath.Join(dir, "serve.sock")
+}
+
+// serveSocket serves the API on a unix socket at path until ctx is done, so
+// klctl on the same machine can tell serve about changes by hand.
+func serveSocket(ctx context.Context, path string, server *APIServer) error {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("another klctl serve is listening on %s", path)
+	}
+
+	// Left behind by a serve which didn't shut down cleanly
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{Handler: server, ReadHeaderTimeout: 10 * time.Second}
+
+	errCh := make(chan error, 1)
+	go func() {
+		apiLog.Info("Serving", "socket", path)
+		errCh <- srv.Serve(listener)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownTimeout)
+	defer cancel()
+
+	return srv.Shutdown(shutdownCtx)
+}
+
+// How long telling serve about a change may take. It's on the way out of a
+// command, so shouldn't hold it up.
+const socketRequestTimeout = time.Second
+
+// tellDaemon sends a request to the serve listening on the socket at path,
+// if there is one.
+func tellDaemon(ctx context.Context, path, method, endpoint string) error {
+	if path == "" {
+		return nil
+	}
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, socketRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, "http://localhost"+endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(apiClientHeader, "klctl")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("klctl serve responded %s", resp.Status)
+	}
+
+	return nil
+}
+
+// reportManualChange tells serve, if it's running, that the lights were
+// changed by hand, so its conflict policy can hold its schedules off. Changes
+// made through --server reach it anyway, and its own commands don't count.
+func reportManualChange(ctx context.Context) {
+	if serverAddr != "" || dryRun || os.Getenv(automationEnv) != "" {
+		return
+	}
+
+	if err := tellDaemon(ctx, defaultSocketPath(), http.MethodPost, "/manual"); err != nil {
+		automationLog.Debug("Failed to tell klctl serve about the change", "error", err)
+	}
+}
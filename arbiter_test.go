@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConflictPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    ConflictPolicy
+		wantErr bool
+	}{
+		{in: "daemon-wins", want: ConflictPolicy{Name: PolicyDaemonWins}},
+		{in: "manual", want: ConflictPolicy{Name: PolicyManual}},
+		{in: "cli-wins-for-30m", want: ConflictPolicy{Name: PolicyCLIWins, For: 30 * time.Minute}},
+		{in: "cli-wins-for-", wantErr: true},
+		{in: "cli-wins-for--5m", wantErr: true},
+		{in: "cli-wins", wantErr: true},
+	} {
+		t.Run(tc.in, func(t *testing.T) {
+			policy, err := parseConflictPolicy(tc.in)
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.want, policy)
+
+			again, err := parseConflictPolicy(policy.String())
+			require.NoError(t, err)
+			require.Equal(t, policy, again)
+		})
+	}
+}
+
+func TestArbiter(t *testing.T) {
+	t.Run("daemon wins", func(t *testing.T) {
+		arbiter := newArbiter(ConflictPolicy{Name: PolicyDaemonWins}, newFakeClock())
+
+		arbiter.manualChange()
+		require.True(t, arbiter.allowed())
+	})
+
+	t.Run("cli wins for a while", func(t *testing.T) {
+		clock := newFakeClock()
+		arbiter := newArbiter(ConflictPolicy{Name: PolicyCLIWins, For: 30 * time.Minute}, clock)
+		require.True(t, arbiter.allowed())
+
+		arbiter.manualChange()
+		require.False(t, arbiter.allowed())
+		require.Equal(t, clock.Now().Add(30*time.Minute), *arbiter.status().HeldUntil)
+
+		clock.Advance(29 * time.Minute)
+		require.False(t, arbiter.allowed())
+
+		clock.Advance(time.Minute)
+		require.True(t, arbiter.allowed())
+		require.Nil(t, arbiter.status().HeldUntil)
+	})
+
+	t.Run("manual waits to be resumed", func(t *testing.T) {
+		clock := newFakeClock()
+		arbiter := newArbiter(ConflictPolicy{Name: PolicyManual}, clock)
+
+		arbiter.manualChange()
+		clock.Advance(24 * time.Hour)
+		require.False(t, arbiter.allowed())
+
+		arbiter.resume()
+		require.True(t, arbiter.allowed())
+	})
+}
+
+func TestAPIServerManual(t *testing.T) {
+	server, _ := newTestAPIServer()
+	server.arbiter = newArbiter(ConflictPolicy{Name: PolicyManual}, newFakeClock())
+
+	code, body := doRequest(t, server, http.MethodGet, "/manual", "")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, true, body["allowed"])
+
+	// Changing the lights through the API is a change by hand
+	code, _ = doRequest(t, server, http.MethodPost, "/lights/all/on", "")
+	require.Equal(t, http.StatusOK, code)
+	require.False(t, server.arbiter.allowed())
+
+	code, body = doRequest(t, server, http.MethodDelete, "/manual", "")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, true, body["allowed"])
+
+	code, body = doRequest(t, server, http.MethodPost, "/manual", "")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, false, body["allowed"])
+}
+
+func TestServeSocket(t *testing.T) {
+	// Unix socket paths are short, so not in t.TempDir()
+	dir, err := os.MkdirTemp("", "klctl")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "serve.sock")
+
+	server, _ := newTestAPIServer()
+	server.arbiter = newArbiter(ConflictPolicy{Name: PolicyManual}, newFakeClock())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- serveSocket(ctx, path, server)
+	}()
+
+	require.Eventually(t, func() bool {
+		return tellDaemon(ctx, path, http.MethodPost, "/manual") == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	require.False(t, server.arbiter.allowed())
+
+	require.NoError(t, tellDaemon(ctx, path, http.MethodDelete, "/manual"))
+	require.True(t, server.arbiter.allowed())
+
+	cancel()
+	require.NoError(t, <-errCh)
+
+	// With nothing listening, there's no one to tell
+	require.NoError(t, tellDaemon(context.Background(), path, http.MethodPost, "/manual"))
+}
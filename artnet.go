@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Art-Net is DMX carried over UDP, which lighting consoles speak. Only ArtDmx
+// packets, which carry the channels of one universe, are needed here. See
+// https://art-net.org.uk/downloads/art-net.pdf.
+const (
+	artNetPort = 6454
+
+	artNetOpDmx   = 0x5000
+	artNetVersion = 14
+
+	// artNetHeaderLen is the length of an ArtDmx packet before its data.
+	artNetHeaderLen = 18
+
+	// dmxChannels is how many channels there are in a universe.
+	dmxChannels = 512
+
+	// maxArtNetUniverse is the highest 15 bit Port-Address.
+	maxArtNetUniverse = 1<<15 - 1
+)
+
+var artNetID = []byte("Art-Net\x00")
+
+// ArtDmx is an ArtDmx packet: the channels of a universe.
+type ArtDmx struct {
+	Sequence uint8
+	// Universe is the 15 bit Port-Address, of Net, Sub-Net and Universe.
+	Universe uint16
+	Data     []byte
+}
+
+// MarshalBinary encodes the packet. Art-Net needs an even number of channels,
+// so odd data is padded with a zero.
+func (p *ArtDmx) MarshalBinary() ([]byte, error) {
+	if len(p.Data) == 0 || len(p.Data) > dmxChannels {
+		return nil, fmt.Errorf("ArtDmx must have between 1 and %d channels (got %d)", dmxChannels, len(p.Data))
+	}
+	if p.Universe > maxArtNetUniverse {
+		return nil, fmt.Errorf("universe must be at most %d (got %d)", maxArtNetUniverse, p.Universe)
+	}
+
+	length := len(p.Data) + len(p.Data)%2
+
+	buf := make([]byte, artNetHeaderLen+length)
+	copy(buf, artNetID)
+	binary.LittleEndian.PutUint16(buf[8:], artNetOpDmx)
+	binary.BigEndian.PutUint16(buf[10:], artNetVersion)
+	buf[12] = p.Sequence
+	// buf[13] is the physical input port, which is only informative
+	binary.LittleEndian.PutUint16(buf[14:], p.Universe)
+	binary.BigEndian.PutUint16(buf[16:], uint16(length))
+	copy(buf[artNetHeaderLen:], p.Data)
+
+	return buf, nil
+}
+
+// errNotArtDmx is returned for Art-Net packets other than ArtDmx, such as
+// ArtPoll, which are ignored.
+var errNotArtDmx = errors.New("not an ArtDmx packet")
+
+// UnmarshalBinary decodes an ArtDmx packet.
+func (p *ArtDmx) UnmarshalBinary(buf []byte) error {
+	if len(buf) < artNetHeaderLen || !bytes.Equal(buf[:8], artNetID) {
+		return errors.New("not an Art-Net packet")
+	}
+	if binary.LittleEndian.Uint16(buf[8:]) != artNetOpDmx {
+		return errNotArtDmx
+	}
+
+	length := int(binary.BigEndian.Uint16(buf[16:]))
+	if length == 0 || length > dmxChannels || artNetHeaderLen+length > len(buf) {
+		return fmt.Errorf("invalid ArtDmx length %d", length)
+	}
+
+	p.Sequence = buf[12]
+	p.Universe = binary.LittleEndian.Uint16(buf[14:]) & maxArtNetUniverse
+	p.Data = append(p.Data[:0], buf[artNetHeaderLen:artNetHeaderLen+length]...)
+
+	return nil
+}
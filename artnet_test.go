@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestArtDmxRoundTrip(t *testing.T) {
+	packet := &ArtDmx{Sequence: 7, Universe: 0x1234, Data: []byte{255, 0, 128}}
+
+	buf, err := packet.MarshalBinary()
+	require.NoError(t, err)
+	require.Equal(t, []byte{
+		'A', 'r', 't', '-', 'N', 'e', 't', 0,
+		0x00, 0x50, // OpDmx, low byte first
+		0, 14, // protocol version
+		7, 0, // sequence, physical
+		0x34, 0x12, // universe, low byte first
+		0, 4, // length, padded to even
+		255, 0, 128, 0,
+	}, buf)
+
+	decoded := &ArtDmx{}
+	require.NoError(t, decoded.UnmarshalBinary(buf))
+	require.Equal(t, &ArtDmx{Sequence: 7, Universe: 0x1234, Data: []byte{255, 0, 128, 0}}, decoded)
+}
+
+func TestArtDmxErrors(t *testing.T) {
+	_, err := (&ArtDmx{}).MarshalBinary()
+	require.Error(t, err)
+	_, err = (&ArtDmx{Data: make([]byte, dmxChannels+1)}).MarshalBinary()
+	require.Error(t, err)
+	_, err = (&ArtDmx{Universe: maxArtNetUniverse + 1, Data: []byte{1}}).MarshalBinary()
+	require.Error(t, err)
+
+	buf, err := (&ArtDmx{Data: []byte{1, 2}}).MarshalBinary()
+	require.NoError(t, err)
+
+	p := &ArtDmx{}
+	require.Error(t, p.UnmarshalBinary(buf[:10]))
+	require.Error(t, p.UnmarshalBinary(append([]byte("Not-Net\x00"), buf[8:]...)))
+	require.Error(t, p.UnmarshalBinary(buf[:len(buf)-1]))
+
+	// ArtPoll
+	poll := append([]byte{}, buf...)
+	poll[8], poll[9] = 0x00, 0x20
+	require.ErrorIs(t, p.UnmarshalBinary(poll), errNotArtDmx)
+}
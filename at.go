@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+var atTimeLayouts = []string{"15:04", "3:04pm", "3pm"}
+
+// nextOccurrence returns the next time after now that the wall clock reads s,
+// which is either today or tomorrow.
+func nextOccurrence(now time.Time, s string) (time.Time, error) {
+	for _, layout := range atTimeLayouts {
+		t, err := time.Parse(layout, strings.ToLower(s))
+		if err != nil {
+			continue
+		}
+
+		next := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 1)
+		}
+
+		return next, nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time %q, expected e.g. 21:30 or 9:30pm", s)
+}
+
+// atCommand waits until the given time and then runs the rest of its arguments
+// as a klctl command, with the same global flags as this invocation. The
+// command is run as a new process so that lights are looked up, and timeouts
+// start, when it actually runs.
+func atCommand(ctx context.Context, clock Clock, c *cli.Context) error {
+	if c.NArg() < 2 {
+		return fmt.Errorf("usage: %s at TIME COMMAND [ARGS...]", c.App.Name)
+	}
+
+	when, err := nextOccurrence(clock.Now(), c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	command := c.Args().Tail()
+	if c.App.Command(command[0]) == nil {
+		return fmt.Errorf("unknown command %s", command[0])
+	}
+
+	// Everything before "at" on our own command line are global flags, which
+	// should apply to the scheduled command too.
+	globalArgs := os.Args[1 : len(os.Args)-c.NArg()-1]
+	args := append(append([]string{}, globalArgs...), command...)
+
+	automationLog.Info("Waiting to run command",
+		"at", when.Format(time.Kitchen),
+		"command", strings.Join(command, " "))
+
+	if err := waitUntil(ctx, clock, when); err != nil {
+		return err
+	}
+
+	return runKlctl(ctx, args)
+}
+
+// waitUntil waits for the clock to reach when.
+func waitUntil(ctx context.Context, clock Clock, when time.Time) error {
+	timer := clock.NewTimer(when.Sub(clock.Now()))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C():
+		return nil
+	}
+}
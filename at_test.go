@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNextOccurrence(t *testing.T) {
+	now := time.Date(2024, 3, 1, 18, 0, 0, 0, time.UTC)
+
+	for _, test := range []struct {
+		in       string
+		expected time.Time
+	}{
+		{"21:30", time.Date(2024, 3, 1, 21, 30, 0, 0, time.UTC)},
+		{"9:30PM", time.Date(2024, 3, 1, 21, 30, 0, 0, time.UTC)},
+		{"7am", time.Date(2024, 3, 2, 7, 0, 0, 0, time.UTC)},
+		{"18:00", time.Date(2024, 3, 2, 18, 0, 0, 0, time.UTC)},
+	} {
+		t.Run(test.in, func(t *testing.T) {
+			next, err := nextOccurrence(now, test.in)
+			require.NoError(t, err)
+			require.Equal(t, test.expected, next)
+		})
+	}
+
+	_, err := nextOccurrence(now, "teatime")
+	require.Error(t, err)
+}
+
+func TestWaitUntil(t *testing.T) {
+	clock := newFakeClock()
+	when := clock.Now().Add(time.Hour)
+
+	done := make(chan error)
+	go func() {
+		done <- waitUntil(context.Background(), clock, when)
+	}()
+
+	clock.WaitForTimers(t, 1)
+	clock.Advance(59 * time.Minute)
+	select {
+	case <-done:
+		t.Fatal("finished waiting early")
+	default:
+	}
+
+	clock.Advance(time.Minute)
+	require.NoError(t, <-done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, waitUntil(ctx, clock, clock.Now().Add(time.Hour)), context.Canceled)
+}
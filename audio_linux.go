@@ -0,0 +1,53 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// alsaMicrophones finds sound cards which can record, such as USB microphones
+// and headsets, through ALSA's /proc/asound. They're named by the card's ID,
+// such as Headset or PCH.
+type alsaMicrophones struct {
+	dir string
+}
+
+func newAudioSource() (PeripheralSource, error) {
+	return alsaMicrophones{"/proc/asound"}, nil
+}
+
+func (a alsaMicrophones) Connected() ([]string, error) {
+	cards, err := filepath.Glob(filepath.Join(a.dir, "card[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, card := range cards {
+		// Capture devices are pcmNc, next to playback's pcmNp
+		if capture, _ := filepath.Glob(filepath.Join(card, "pcm*c")); len(capture) == 0 {
+			continue
+		}
+
+		id, err := os.ReadFile(filepath.Join(card, "id"))
+		if err != nil {
+			// Cards come and go as they're plugged in
+			continue
+		}
+
+		names = append(names, strings.TrimSpace(string(id)))
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// Changes passes on uevents from the sound subsystem.
+func (a alsaMicrophones) Changes(ctx context.Context) (<-chan struct{}, error) {
+	return ueventChanges(ctx, "sound")
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestALSAMicrophonesConnected(t *testing.T) {
+	dir := t.TempDir()
+	for card, files := range map[string][]string{
+		"card0": {"pcm0p", "pcm0c"},
+		"card1": {"pcm3p", "pcm7p"},
+		"card2": {"pcm0c"},
+	} {
+		for _, file := range files {
+			require.NoError(t, os.MkdirAll(filepath.Join(dir, card, file), 0o755))
+		}
+	}
+	for card, id := range map[string]string{"card0": "PCH", "card1": "NVidia", "card2": "Headset"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, card, "id"), []byte(id+"\n"), 0o644))
+	}
+	// ALSA links each card's ID to it, too
+	require.NoError(t, os.Symlink("card2", filepath.Join(dir, "Headset")))
+
+	microphones, err := alsaMicrophones{dir}.Connected()
+	require.NoError(t, err)
+	require.Equal(t, []string{"Headset", "PCH"}, microphones)
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !windows
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Watching audio devices on macOS needs CoreAudio, and so cgo, which klctl is
+// built without.
+func newAudioSource() (PeripheralSource, error) {
+	return nil, fmt.Errorf("watching audio devices isn't supported on %s", runtime.GOOS)
+}
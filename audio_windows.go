@@ -0,0 +1,59 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"slices"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	winmm            = syscall.NewLazyDLL("winmm.dll")
+	waveInGetNumDevs = winmm.NewProc("waveInGetNumDevs")
+	waveInGetDevCaps = winmm.NewProc("waveInGetDevCapsW")
+)
+
+// waveInCaps is WAVEINCAPSW.
+type waveInCaps struct {
+	Mid           uint16
+	Pid           uint16
+	DriverVersion uint32
+	Pname         [32]uint16
+	Formats       uint32
+	Channels      uint16
+	Reserved1     uint16
+}
+
+// windowsMicrophones finds devices which can record, such as USB microphones
+// and headsets, with the wave input API. They're polled for, as displays are.
+// Names are as Windows gives them, cut short at 31 characters, such as
+// Headset Microphone (Jabra Evol.
+type windowsMicrophones struct{}
+
+func newAudioSource() (PeripheralSource, error) {
+	return windowsMicrophones{}, nil
+}
+
+func (windowsMicrophones) Connected() ([]string, error) {
+	names := []string{}
+
+	count, _, _ := waveInGetNumDevs.Call()
+	for i := uintptr(0); i < count; i++ {
+		caps := waveInCaps{}
+		// MMSYSERR_NOERROR is 0
+		if r, _, _ := waveInGetDevCaps.Call(i, uintptr(unsafe.Pointer(&caps)), unsafe.Sizeof(caps)); r != 0 {
+			continue
+		}
+
+		names = append(names, syscall.UTF16ToString(caps.Pname[:]))
+	}
+	slices.Sort(names)
+
+	return names, nil
+}
+
+func (m windowsMicrophones) Changes(ctx context.Context) (<-chan struct{}, error) {
+	return pollChanges(ctx, m.Connected)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+)
+
+// How long each half of a blink lasts.
+const blinkInterval = 200 * time.Millisecond
+
+// How many times, and how slowly, identify flashes a light by default. It's
+// slower than a confirmation blink, so it's easy to spot across a room.
+const (
+	defaultIdentifyTimes    = 5
+	defaultIdentifyInterval = 500 * time.Millisecond
+)
+
+// flipPower turns a light off if it's on, and on if it's off.
+func flipPower(light *keylight.Light) {
+	light.On = 1 - light.On
+}
+
+// flipBrightness turns a light on, at full brightness if it's dim and at its
+// dimmest if it's bright, for lights whose power shouldn't be toggled.
+func flipBrightness(light *keylight.Light) {
+	light.On = 1
+	if light.Brightness > 50 {
+		light.Brightness = minBrightness
+	} else {
+		light.Brightness = 100
+	}
+}
+
+// blinkDevices flips the power of every light on the devices and back again,
+// the given number of times. The lights are always left as they were found.
+func blinkDevices(ctx context.Context, devices []Device, times int) error {
+	return flashDevices(ctx, devices, times, blinkInterval, flipPower)
+}
+
+// flashDevices changes every light on the devices with flip, and back again,
+// the given number of times, holding each for interval. The lights are always
+// left as they were found, even if flashing fails or is interrupted.
+func flashDevices(ctx context.Context, devices []Device, times int, interval time.Duration, flip func(light *keylight.Light)) error {
+	original, err := fetchLightGroups(ctx, devices)
+	if err != nil {
+		return err
+	}
+
+	inverted := make([]DeviceLightGroup, 0, len(original))
+	for i, dlg := range original {
+		original[i].LightGroup = dlg.LightGroup.Copy()
+
+		lg := dlg.LightGroup.Copy()
+		for _, light := range lg.Lights {
+			flip(light)
+		}
+		inverted = append(inverted, DeviceLightGroup{dlg.Device, lg})
+	}
+
+	for i := 0; i < times; i++ {
+		for _, state := range [][]DeviceLightGroup{inverted, original} {
+			if err := applyLightGroups(ctx, state); err != nil {
+				restoreLightGroups(context.WithoutCancel(ctx), original)
+				return err
+			}
+
+			select {
+			case <-ctx.Done():
+				restoreLightGroups(context.WithoutCancel(ctx), original)
+				return ctx.Err()
+			case <-time.After(interval):
+			}
+		}
+	}
+
+	return nil
+}
+
+func applyLightGroups(ctx context.Context, lgs []DeviceLightGroup) error {
+	for _, dlg := range lgs {
+		if _, err := dlg.Device.UpdateLightGroup(ctx, dlg.LightGroup.Copy()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// confirmWithBlink double-blinks the devices a command succeeded on, as
+// physical confirmation that it ran.
+func confirmWithBlink(result *CommandResult) {
+	var devices []Device
+	for _, dr := range result.Devices {
+		if dr.Status != ResultFailed {
+			devices = append(devices, dr.device)
+		}
+	}
+
+	if len(devices) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	deviceLog.Debug("Blinking lights to confirm the command")
+	if err := blinkDevices(ctx, devices, 2); err != nil {
+		deviceLog.Warn("Failed to blink lights", "error", err)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingDevice remembers every light group sent to it.
+type recordingDevice struct {
+	*FakeDevice
+	updates []keylight.LightGroup
+}
+
+func (rd *recordingDevice) UpdateLightGroup(ctx context.Context, lg *keylight.LightGroup) (*keylight.LightGroup, error) {
+	rd.updates = append(rd.updates, *lg.Copy())
+	return rd.FakeDevice.UpdateLightGroup(ctx, lg)
+}
+
+// powerStates returns the power state of the first light in each update.
+func (rd *recordingDevice) powerStates() []int {
+	var states []int
+	for _, lg := range rd.updates {
+		states = append(states, lg.Lights[0].On)
+	}
+
+	return states
+}
+
+func TestBlinkDevices(t *testing.T) {
+	device := &recordingDevice{FakeDevice: &FakeDevice{
+		DNSAddr:  "192.168.1.1",
+		LightGrp: &keylight.LightGroup{Lights: []*keylight.Light{{On: 1, Brightness: 30}}},
+	}}
+
+	require.NoError(t, blinkDevices(context.Background(), []Device{device}, 2))
+	require.Equal(t, []int{0, 1, 0, 1}, device.powerStates())
+	require.Equal(t, 1, device.LightGrp.Lights[0].On)
+}
+
+func TestFlashDevicesBrightness(t *testing.T) {
+	device := &recordingDevice{FakeDevice: &FakeDevice{
+		DNSAddr:  "192.168.1.1",
+		LightGrp: &keylight.LightGroup{Lights: []*keylight.Light{{On: 0, Brightness: 30}}},
+	}}
+
+	require.NoError(t, flashDevices(context.Background(), []Device{device}, 1, time.Millisecond, flipBrightness))
+	require.Equal(t, []keylight.Light{{On: 1, Brightness: 100}, {On: 0, Brightness: 30}}, []keylight.Light{
+		*device.updates[0].Lights[0],
+		*device.updates[1].Lights[0],
+	})
+}
+
+func TestFlashDevicesRestoresWhenInterrupted(t *testing.T) {
+	device := &recordingDevice{FakeDevice: &FakeDevice{
+		DNSAddr:  "192.168.1.1",
+		LightGrp: &keylight.LightGroup{Lights: []*keylight.Light{{On: 1, Brightness: 30}}},
+	}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	require.ErrorIs(t, flashDevices(ctx, []Device{device}, 5, time.Hour, flipPower), context.DeadlineExceeded)
+	require.Equal(t, []int{0, 1}, device.powerStates())
+	require.Equal(t, 1, device.LightGrp.Lights[0].On)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/iainlane/klctl/pkg/keylightctl"
+)
+
+// CachedDevice is a device found by an earlier discovery.
+type CachedDevice struct {
+	Serial   string    `json:"serial,omitempty"`
+	Name     string    `json:"name"`
+	Address  string    `json:"address"`
+	Port     int       `json:"port"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// DiscoveryCache holds the devices found by the last discovery, so later
+// invocations can skip the mDNS sweep.
+type DiscoveryCache struct {
+	UpdatedAt time.Time      `json:"updated_at"`
+	Devices   []CachedDevice `json:"devices"`
+}
+
+func defaultDiscoveryCachePath() string {
+	dir := defaultStateDir()
+	if dir == "" {
+		return ""
+	}
+
+	return filepath.Join(dir, "devices.json")
+}
+
+// readDiscoveryCache returns the cached devices. A missing or unreadable
+// cache is an empty one.
+func readDiscoveryCache(path string) *DiscoveryCache {
+	cache := &DiscoveryCache{Devices: []CachedDevice{}}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cache
+	}
+
+	if err := json.Unmarshal(data, cache); err != nil {
+		discoveryLog.Debug("Ignoring unreadable discovery cache", "path", path, "error", err)
+		return &DiscoveryCache{Devices: []CachedDevice{}}
+	}
+
+	return cache
+}
+
+// writeDiscoveryCache replaces the cache with the devices from a discovery.
+func writeDiscoveryCache(path string, devices []CachedDevice) error {
+	if path == "" {
+		return nil
+	}
+
+	cache := DiscoveryCache{UpdatedAt: time.Now().UTC(), Devices: devices}
+	if cache.Devices == nil {
+		cache.Devices = []CachedDevice{}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+func clearDiscoveryCache(path string) error {
+	err := os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// cachedFromDiscovered converts the output of discover into cache entries.
+func cachedFromDiscovered(devices []DiscoveredDevice) []CachedDevice {
+	now := time.Now().UTC()
+	cached := make([]CachedDevice, 0, len(devices))
+
+	for _, d := range devices {
+		cached = append(cached, CachedDevice{
+			Serial:   d.Serial,
+			Name:     d.Name,
+			Address:  d.Address,
+			Port:     d.Port,
+			LastSeen: now,
+		})
+	}
+
+	return cached
+}
+
+// cacheDiscoveredDevices records devices found while setting up a command.
+// Serial numbers are carried over from the previous cache where the address
+// is the same, and otherwise fetched, so that the cache stays useful for
+// matching devices without slowing every command down.
+func cacheDiscoveredDevices(ctx context.Context, path string, devices []Device) error {
+	if path == "" {
+		return nil
+	}
+
+	serials := knownSerials(readDiscoveryCache(path))
+
+	now := time.Now().UTC()
+	cached := make([]CachedDevice, len(devices))
+
+	// Failing to fetch a serial only leaves it out of the cache
+	_ = forEachDevice(ctx, devices, func(ctx context.Context, i int, device Device) error {
+		address := strings.TrimSuffix(device.GetDNSAddr(), ".")
+		cached[i] = CachedDevice{
+			Serial:   serials[address],
+			Name:     device.GetName(),
+			Address:  address,
+			Port:     device.GetPort(),
+			LastSeen: now,
+		}
+
+		if cached[i].Serial != "" {
+			return nil
+		}
+
+		info, err := device.FetchDeviceInfo(ctx)
+		if err != nil {
+			discoveryLog.Debug("Failed to fetch serial number for the cache", "address", address, "error", err)
+			return nil
+		}
+
+		cached[i].Serial = info.SerialNumber
+		return nil
+	})
+
+	return writeDiscoveryCache(path, cached)
+}
+
+// devicesFromCache returns the cached devices, ready to be controlled.
+func devicesFromCache(cache *DiscoveryCache) []Device {
+	devices := make([]Device, 0, len(cache.Devices))
+	for _, cd := range cache.Devices {
+		devices = append(devices, keylightctl.NewDevice(cd.Name, cd.Address, cd.Port))
+	}
+
+	return devices
+}
+
+func renderDiscoveryCache(w io.Writer, format string, cache *DiscoveryCache) error {
+	if format == OutputJSON {
+		return writeJSON(w, cache)
+	}
+
+	if cache.UpdatedAt.IsZero() {
+		_, err := fmt.Fprintln(w, "The discovery cache is empty")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tADDRESS\tPORT\tSERIAL\tLAST SEEN")
+	for _, cd := range cache.Devices {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", cd.Name, cd.Address, cd.Port, cd.Serial, cd.LastSeen.Local().Format(time.DateTime))
+	}
+
+	return tw.Flush()
+}
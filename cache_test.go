@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscoveryCache(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "klctl", "devices.json")
+
+	require.Empty(t, readDiscoveryCache(path).Devices)
+	require.True(t, readDiscoveryCache(path).UpdatedAt.IsZero())
+
+	require.NoError(t, writeDiscoveryCache(path, cachedFromDiscovered([]DiscoveredDevice{
+		{Name: "Key Light A", Address: "a.local", Port: 9123, Serial: "AAAA1"},
+	})))
+
+	a := &FakeDevice{Name: "Key Light A", DNSAddr: "a.local.", Port: 9123, FetchDeviceInfoError: errors.New("unused")}
+	b := &FakeDevice{Name: "Key Light B", DNSAddr: "b.local.", Port: 9123, DeviceInfo: &keylight.DeviceInfo{SerialNumber: "BBBB1"}}
+	c := &FakeDevice{Name: "Key Light C", DNSAddr: "c.local.", Port: 9123, FetchDeviceInfoError: errors.New("unreachable")}
+
+	// The serial number for a is remembered, b's is fetched, and c is cached
+	// without one
+	require.NoError(t, cacheDiscoveredDevices(ctx, path, []Device{a, b, c}))
+
+	cache := readDiscoveryCache(path)
+	require.False(t, cache.UpdatedAt.IsZero())
+	require.Len(t, cache.Devices, 3)
+	require.Equal(t, "AAAA1", cache.Devices[0].Serial)
+	require.Equal(t, "a.local", cache.Devices[0].Address)
+	require.Equal(t, "BBBB1", cache.Devices[1].Serial)
+	require.Equal(t, "", cache.Devices[2].Serial)
+
+	devices := devicesFromCache(cache)
+	require.Len(t, devices, 3)
+	require.Equal(t, "b.local", devices[1].GetDNSAddr())
+	require.Equal(t, 9123, devices[1].GetPort())
+
+	var buf bytes.Buffer
+	require.NoError(t, renderDiscoveryCache(&buf, OutputText, cache))
+	require.Contains(t, buf.String(), "Key Light B  b.local  9123  BBBB1")
+
+	require.NoError(t, clearDiscoveryCache(path))
+	require.NoError(t, clearDiscoveryCache(path))
+	require.Empty(t, readDiscoveryCache(path).Devices)
+
+	buf.Reset()
+	require.NoError(t, renderDiscoveryCache(&buf, OutputText, readDiscoveryCache(path)))
+	require.Equal(t, "The discovery cache is empty\n", buf.String())
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+)
+
+// ChaosConfig controls the faults injected by ChaosDevice.
+type ChaosConfig struct {
+	// FailureRate is the probability, between 0 and 1, of a call failing.
+	FailureRate float64
+	// MaxLatency is the upper bound of a random delay added to each call.
+	MaxLatency time.Duration
+}
+
+var errChaos = errors.New("chaos: injected failure")
+
+// parseChaosConfig parses a comma separated list of key=value pairs, for
+// example "failures=0.2,latency=500ms".
+func parseChaosConfig(s string) (ChaosConfig, error) {
+	var cfg ChaosConfig
+
+	for _, part := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return cfg, fmt.Errorf("chaos settings must be key=value (got %s)", part)
+		}
+
+		var err error
+		switch key {
+		case "failures":
+			cfg.FailureRate, err = parseFraction(value)
+		case "latency":
+			cfg.MaxLatency, err = parseNonNegativeDuration(value)
+		default:
+			err = fmt.Errorf("unknown setting")
+		}
+
+		if err != nil {
+			return cfg, fmt.Errorf("invalid chaos setting %s: %w", part, err)
+		}
+	}
+
+	return cfg, nil
+}
+
+// ChaosDevice wraps a Device and injects random latency and failures into
+// every call to it. It exists to exercise error handling without needing to
+// unplug real lights.
+type ChaosDevice struct {
+	Device
+	config ChaosConfig
+}
+
+func withChaos(devices []Device, cfg ChaosConfig) []Device {
+	wrapped := make([]Device, 0, len(devices))
+	for _, device := range devices {
+		wrapped = append(wrapped, &ChaosDevice{device, cfg})
+	}
+
+	return wrapped
+}
+
+func (cd *ChaosDevice) inject(ctx context.Context, call string) error {
+	log := deviceLog.With("address", cd.GetDNSAddr(), "call", call)
+
+	if cd.config.MaxLatency > 0 {
+		delay := time.Duration(rand.Int63n(int64(cd.config.MaxLatency)))
+		log.Debug("Chaos: delaying call", "delay", delay)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+
+	if rand.Float64() < cd.config.FailureRate {
+		log.Debug("Chaos: failing call")
+		return errChaos
+	}
+
+	return nil
+}
+
+func (cd *ChaosDevice) FetchDeviceInfo(ctx context.Context) (*keylight.DeviceInfo, error) {
+	if err := cd.inject(ctx, "FetchDeviceInfo"); err != nil {
+		return nil, err
+	}
+
+	return cd.Device.FetchDeviceInfo(ctx)
+}
+
+func (cd *ChaosDevice) FetchSettings(ctx context.Context) (*keylight.DeviceSettings, error) {
+	if err := cd.inject(ctx, "FetchSettings"); err != nil {
+		return nil, err
+	}
+
+	return cd.Device.FetchSettings(ctx)
+}
+
+func (cd *ChaosDevice) FetchLightGroup(ctx context.Context) (*keylight.LightGroup, error) {
+	if err := cd.inject(ctx, "FetchLightGroup"); err != nil {
+		return nil, err
+	}
+
+	return cd.Device.FetchLightGroup(ctx)
+}
+
+func (cd *ChaosDevice) UpdateLightGroup(ctx context.Context, lg *keylight.LightGroup) (*keylight.LightGroup, error) {
+	if err := cd.inject(ctx, "UpdateLightGroup"); err != nil {
+		return nil, err
+	}
+
+	return cd.Device.UpdateLightGroup(ctx, lg)
+}
+
+var _ Device = &ChaosDevice{}
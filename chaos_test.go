@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseChaosConfig(t *testing.T) {
+	cfg, err := parseChaosConfig("failures=0.25, latency=50ms")
+	require.NoError(t, err)
+	require.Equal(t, ChaosConfig{FailureRate: 0.25, MaxLatency: 50 * time.Millisecond}, cfg)
+
+	for _, s := range []string{"failures", "failures=2", "latency=soon", "colour=red"} {
+		_, err := parseChaosConfig(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestChaosDevice(t *testing.T) {
+	ctx := context.Background()
+	fake := &FakeDevice{
+		DNSAddr:  "192.168.1.1",
+		LightGrp: &keylight.LightGroup{},
+	}
+
+	devices := withChaos([]Device{fake}, ChaosConfig{FailureRate: 1})
+	_, err := devices[0].FetchLightGroup(ctx)
+	require.ErrorIs(t, err, errChaos)
+	require.Equal(t, "192.168.1.1", devices[0].GetDNSAddr())
+
+	devices = withChaos([]Device{fake}, ChaosConfig{MaxLatency: time.Millisecond})
+	lg, err := devices[0].FetchLightGroup(ctx)
+	require.NoError(t, err)
+	require.Same(t, fake.LightGrp, lg)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Claim records who a device belongs to.
+type Claim struct {
+	Owner     string    `yaml:"owner"`
+	ClaimedAt time.Time `yaml:"claimed_at"`
+}
+
+// Claims maps device serial numbers to their claims. On a shared network,
+// everyone can point --claims-file at the same file, so discovery leaves
+// other people's lights alone.
+type Claims map[string]Claim
+
+// defaultClaimsPath returns claims.yaml next to the config file.
+func defaultClaimsPath() string {
+	path := defaultConfigPath()
+	if path == "" {
+		return ""
+	}
+
+	return filepath.Join(filepath.Dir(path), "claims.yaml")
+}
+
+// readClaims reads the claims at path. A missing file has no claims.
+func readClaims(path string) (Claims, error) {
+	claims := Claims{}
+	if path == "" {
+		return claims, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return claims, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read claims: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, &claims); err != nil {
+		return nil, fmt.Errorf("failed to parse claims %s: %w", path, err)
+	}
+
+	return claims, nil
+}
+
+// writeClaims replaces the claims at path. The file is readable by everyone,
+// since it's meant to be shared.
+func writeClaims(path string, claims Claims) error {
+	if path == "" {
+		return errors.New("no claims file to write to")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(claims)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o644)
+}
+
+// currentOwner is the name claims are made in by default: the user's login.
+func currentOwner() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+
+	return os.Getenv("USER")
+}
+
+// deviceSerials fetches the serial number of every device.
+func deviceSerials(ctx context.Context, devices []Device) ([]string, error) {
+	serials := make([]string, len(devices))
+
+	err := forEachDevice(ctx, devices, func(ctx context.Context, i int, device Device) error {
+		info, err := device.FetchDeviceInfo(ctx)
+		if err != nil {
+			return err
+		}
+
+		serials[i] = info.SerialNumber
+		return nil
+	})
+
+	return serials, err
+}
+
+// ClaimFilter decides which devices may be controlled.
+type ClaimFilter struct {
+	Claims Claims
+	Owner  string
+
+	// Mine keeps only the devices claimed by Owner.
+	Mine bool
+
+	// Force allows devices claimed by someone else to be controlled.
+	Force bool
+}
+
+// apply removes the devices the filter doesn't allow. Devices which were
+// asked for explicitly, rather than discovered, are refused with an error
+// instead, since leaving them out would be surprising.
+func (f ClaimFilter) apply(ctx context.Context, devices []Device, explicit bool) ([]Device, error) {
+	if len(f.Claims) == 0 && !f.Mine {
+		return devices, nil
+	}
+
+	serials, err := deviceSerials(ctx, devices)
+	if err != nil {
+		return nil, err
+	}
+
+	var allowed []Device
+	for i, device := range devices {
+		claim, claimed := f.Claims[serials[i]]
+		mine := claimed && claim.Owner == f.Owner
+
+		switch {
+		case f.Mine && !mine:
+			deviceLog.Debug("Leaving out a device which isn't ours", "address", device.GetDNSAddr(), "serial", serials[i])
+			continue
+
+		case claimed && !mine && !f.Force:
+			if explicit {
+				return nil, fmt.Errorf("%s is claimed by %s, use --force to control it anyway", device.GetDNSAddr(), claim.Owner)
+			}
+
+			deviceLog.Info("Leaving out a device claimed by someone else",
+				"address", device.GetDNSAddr(),
+				"owner", claim.Owner)
+			continue
+		}
+
+		allowed = append(allowed, device)
+	}
+
+	if len(allowed) == 0 && len(devices) > 0 {
+		return nil, errors.New("none of the lights found are ours to control, use --force to control them anyway")
+	}
+
+	return allowed, nil
+}
+
+// claimDevices claims the devices for owner, or releases them if owner is
+// empty. The updated claims are returned.
+func claimDevices(ctx context.Context, claims Claims, devices []Device, owner string) (Claims, error) {
+	serials, err := deviceSerials(ctx, devices)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	for i, serial := range serials {
+		if serial == "" {
+			return nil, fmt.Errorf("%s didn't report a serial number", devices[i].GetDNSAddr())
+		}
+
+		if owner == "" {
+			delete(claims, serial)
+			continue
+		}
+
+		claims[serial] = Claim{Owner: owner, ClaimedAt: now}
+	}
+
+	return claims, nil
+}
+
+// renderClaims lists the claims, ordered by serial number.
+func renderClaims(w io.Writer, format string, claims Claims) error {
+	if format == OutputJSON {
+		return writeJSON(w, claims)
+	}
+
+	serials := make([]string, 0, len(claims))
+	for serial := range claims {
+		serials = append(serials, serial)
+	}
+	sort.Strings(serials)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERIAL\tOWNER\tCLAIMED")
+	for _, serial := range serials {
+		claim := claims[serial]
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", serial, claim.Owner, claim.ClaimedAt.Local().Format(time.DateTime))
+	}
+
+	return tw.Flush()
+}
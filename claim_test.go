@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+func claimTestDevices() []Device {
+	newDevice := func(addr, serial string) *FakeDevice {
+		return &FakeDevice{
+			DNSAddr:    addr,
+			DeviceInfo: &keylight.DeviceInfo{SerialNumber: serial},
+			LightGrp:   &keylight.LightGroup{Lights: []*keylight.Light{{}}},
+		}
+	}
+
+	return []Device{
+		newDevice("192.168.1.1", "SERIAL1"),
+		newDevice("192.168.1.2", "SERIAL2"),
+		newDevice("192.168.1.3", "SERIAL3"),
+	}
+}
+
+func addresses(devices []Device) []string {
+	var addrs []string
+	for _, device := range devices {
+		addrs = append(addrs, device.GetDNSAddr())
+	}
+
+	return addrs
+}
+
+func TestClaimFilter(t *testing.T) {
+	ctx := context.Background()
+	devices := claimTestDevices()
+	claims := Claims{
+		"SERIAL1": {Owner: "alice"},
+		"SERIAL2": {Owner: "bob"},
+	}
+
+	filter := ClaimFilter{Claims: claims, Owner: "alice"}
+	allowed, err := filter.apply(ctx, devices, false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"192.168.1.1", "192.168.1.3"}, addresses(allowed))
+
+	filter.Mine = true
+	allowed, err = filter.apply(ctx, devices, false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"192.168.1.1"}, addresses(allowed))
+
+	// Asking for someone else's light by address is refused
+	_, err = ClaimFilter{Claims: claims, Owner: "alice"}.apply(ctx, devices[1:2], true)
+	require.ErrorContains(t, err, "claimed by bob")
+
+	allowed, err = ClaimFilter{Claims: claims, Owner: "alice", Force: true}.apply(ctx, devices[1:2], true)
+	require.NoError(t, err)
+	require.Len(t, allowed, 1)
+
+	// Nothing claimed, so nothing to look up
+	devices[0].(*FakeDevice).FetchDeviceInfoError = context.DeadlineExceeded
+	allowed, err = ClaimFilter{Owner: "alice"}.apply(ctx, devices, false)
+	require.NoError(t, err)
+	require.Len(t, allowed, 3)
+}
+
+func TestClaimDevices(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "claims.yaml")
+
+	claims, err := readClaims(path)
+	require.NoError(t, err)
+	require.Empty(t, claims)
+
+	devices := claimTestDevices()
+	claims, err = claimDevices(ctx, claims, devices[:2], "alice")
+	require.NoError(t, err)
+	require.NoError(t, writeClaims(path, claims))
+
+	claims, err = readClaims(path)
+	require.NoError(t, err)
+	require.Equal(t, "alice", claims["SERIAL2"].Owner)
+	require.False(t, claims["SERIAL2"].ClaimedAt.IsZero())
+
+	claims, err = claimDevices(ctx, claims, devices[1:2], "")
+	require.NoError(t, err)
+	require.Len(t, claims, 1)
+
+	var out bytes.Buffer
+	require.NoError(t, renderClaims(&out, OutputText, claims))
+	require.Contains(t, out.String(), "SERIAL1")
+	require.Contains(t, out.String(), "alice")
+}
@@ -0,0 +1,14 @@
+package main
+
+import "github.com/iainlane/klctl/pkg/keylightctl"
+
+// Clock tells the time and waits for it to pass. Code which waits, such as
+// discovery and fades, takes one so that tests can control time rather than
+// sleeping through it.
+type (
+	Clock = keylightctl.Clock
+	Timer = keylightctl.Timer
+)
+
+// systemClock is the real time.
+type systemClock = keylightctl.SystemClock
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a Clock whose time only moves when Advance is called.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, time.March, 1, 9, 30, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1)}
+	t.Reset(d)
+
+	return t
+}
+
+// Advance moves the time on by d, firing the timers which are due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.when.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+
+		select {
+		case t.c <- c.now:
+		default:
+		}
+	}
+	c.timers = pending
+}
+
+// Waiting returns how many timers are waiting to fire.
+func (c *fakeClock) Waiting() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.timers)
+}
+
+// WaitForTimers waits, in real time, until n timers are waiting to fire.
+func (c *fakeClock) WaitForTimers(t *testing.T, n int) {
+	t.Helper()
+
+	require.Eventually(t, func() bool { return c.Waiting() >= n }, time.Second, time.Millisecond)
+}
+
+type fakeTimer struct {
+	clock *fakeClock
+	c     chan time.Time
+	when  time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	active := t.Stop()
+
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t.when = c.now.Add(d)
+	if d <= 0 {
+		select {
+		case t.c <- c.now:
+		default:
+		}
+		return active
+	}
+
+	c.timers = append(c.timers, t)
+	return active
+}
+
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestFakeClock(t *testing.T) {
+	clock := newFakeClock()
+	start := clock.Now()
+
+	after := clock.After(time.Second)
+	timer := clock.NewTimer(2 * time.Second)
+	require.Equal(t, 2, clock.Waiting())
+
+	clock.Advance(time.Second)
+	require.Equal(t, start.Add(time.Second), <-after)
+	require.Len(t, timer.C(), 0)
+
+	require.True(t, timer.Reset(time.Second))
+	clock.Advance(500 * time.Millisecond)
+	require.Len(t, timer.C(), 0)
+	require.True(t, timer.Stop())
+	clock.Advance(time.Hour)
+	require.Len(t, timer.C(), 0)
+	require.Equal(t, 0, clock.Waiting())
+
+	require.Len(t, clock.After(0), 1)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+)
+
+// coalescingWriter sends light groups to a device as fast as it will take
+// them, but no faster. Only one write is in flight at a time, and only the
+// newest light group is kept while it is, so a fast stream of changes never
+// queues up behind a slow light.
+type coalescingWriter struct {
+	device Device
+
+	// requestTimeout bounds each write.
+	requestTimeout time.Duration
+
+	mu      sync.Mutex
+	pending *keylight.LightGroup
+	// flushing stops run once the pending light group is written.
+	flushing bool
+	wake     chan struct{}
+	done     chan struct{}
+}
+
+func newCoalescingWriter(device Device, requestTimeout time.Duration) *coalescingWriter {
+	return &coalescingWriter{
+		device:         device,
+		requestTimeout: requestTimeout,
+		wake:           make(chan struct{}, 1),
+		done:           make(chan struct{}),
+	}
+}
+
+// set replaces whatever is waiting to be written with lg.
+func (cw *coalescingWriter) set(lg *keylight.LightGroup) {
+	cw.mu.Lock()
+	cw.pending = lg
+	cw.mu.Unlock()
+
+	cw.poke()
+}
+
+// flush waits for whatever is waiting to be written, then stops run.
+func (cw *coalescingWriter) flush() {
+	cw.mu.Lock()
+	cw.flushing = true
+	cw.mu.Unlock()
+
+	cw.poke()
+	<-cw.done
+}
+
+func (cw *coalescingWriter) poke() {
+	select {
+	case cw.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run writes light groups until ctx is done, or flush is called. A failed write is logged, and
+// the next light group is tried all the same.
+func (cw *coalescingWriter) run(ctx context.Context) {
+	defer close(cw.done)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-cw.wake:
+		}
+
+		cw.mu.Lock()
+		lg, flushing := cw.pending, cw.flushing
+		cw.pending = nil
+		cw.mu.Unlock()
+
+		if lg != nil {
+			writeCtx, cancel := context.WithTimeout(ctx, cw.requestTimeout)
+			_, err := cw.device.UpdateLightGroup(writeCtx, lg)
+			cancel()
+			if err != nil && ctx.Err() == nil {
+				deviceLog.Warn("Failed to update lights", "address", cw.device.GetDNSAddr(), "error", err)
+			}
+		}
+
+		if flushing {
+			return
+		}
+	}
+}
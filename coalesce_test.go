@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+// slowDevice passes each light group written to it to the test, and holds the
+// write until the test releases it, like a light which is slow to respond.
+type slowDevice struct {
+	*FakeDevice
+	updates chan keylight.LightGroup
+	release chan struct{}
+}
+
+func newSlowDevice(fake *FakeDevice) *slowDevice {
+	return &slowDevice{FakeDevice: fake, updates: make(chan keylight.LightGroup), release: make(chan struct{})}
+}
+
+func (sd *slowDevice) UpdateLightGroup(ctx context.Context, lg *keylight.LightGroup) (*keylight.LightGroup, error) {
+	sd.updates <- *lg.Copy()
+
+	select {
+	case <-sd.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	return lg, nil
+}
+
+func TestCoalescingWriter(t *testing.T) {
+	device := newSlowDevice(&FakeDevice{DNSAddr: "key.local"})
+	cw := newCoalescingWriter(device, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go cw.run(ctx)
+
+	brightness := func(b int) *keylight.LightGroup {
+		return &keylight.LightGroup{Lights: []*keylight.Light{{On: 1, Brightness: b}}}
+	}
+
+	cw.set(brightness(10))
+	require.Equal(t, 10, (<-device.updates).Lights[0].Brightness)
+
+	// While the first write is in flight, only the newest is kept
+	cw.set(brightness(20))
+	cw.set(brightness(30))
+	cw.set(brightness(40))
+	device.release <- struct{}{}
+
+	require.Equal(t, 40, (<-device.updates).Lights[0].Brightness)
+	device.release <- struct{}{}
+
+	select {
+	case lg := <-device.updates:
+		t.Fatalf("unexpected write of %v", lg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
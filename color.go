@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+)
+
+// The Elgato API expresses colour temperature in mireds (micro reciprocal
+// degrees), so converting to and from Kelvin is a reciprocal.
+const miredsPerKelvin = 1_000_000
+
+// The range of temperatures a light accepts, in mireds (about 7000K to 2900K).
+const (
+	minTemperature = 143
+	maxTemperature = 344
+)
+
+// miredToKelvin converts a temperature as reported by a light into Kelvin,
+// rounded to the nearest degree.
+func miredToKelvin(mired int) int {
+	if mired <= 0 {
+		return 0
+	}
+
+	return int(math.Round(float64(miredsPerKelvin) / float64(mired)))
+}
+
+// kelvinToMired converts a temperature in Kelvin into the units a light
+// expects, rounded to the nearest mired.
+func kelvinToMired(kelvin int) int {
+	if kelvin <= 0 {
+		return 0
+	}
+
+	return int(math.Round(float64(miredsPerKelvin) / float64(kelvin)))
+}
+
+// RGB is a colour in the sRGB colour space.
+type RGB struct {
+	R, G, B uint8
+}
+
+// kelvinToRGB approximates the colour of a black body radiator at the given
+// temperature. It uses Tanner Helland's curve fit, which is plenty accurate for
+// a terminal swatch across the range a Key Light supports.
+func kelvinToRGB(kelvin int) RGB {
+	t := float64(kelvin) / 100
+
+	var r, g, b float64
+
+	if t <= 66 {
+		r = 255
+		g = 99.4708025861*math.Log(t) - 161.1195681661
+	} else {
+		r = 329.698727446 * math.Pow(t-60, -0.1332047592)
+		g = 288.1221695283 * math.Pow(t-60, -0.0755148492)
+	}
+
+	switch {
+	case t >= 66:
+		b = 255
+	case t <= 19:
+		b = 0
+	default:
+		b = 138.5177312231*math.Log(t-10) - 305.0447927307
+	}
+
+	return RGB{clampColor(r), clampColor(g), clampColor(b)}
+}
+
+func clampColor(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, math.Round(v))))
+}
+
+// Swatch returns a two-cell block of the colour using truecolour ANSI escapes.
+func (c RGB) Swatch() string {
+	return fmt.Sprintf("\x1b[48;2;%d;%d;%dm  \x1b[0m", c.R, c.G, c.B)
+}
+
+// temperatureString renders a light's temperature in the unit, followed by a
+// swatch approximating its colour when color is set.
+func temperatureString(mired int, unit TemperatureUnit, color bool) string {
+	kelvin := miredToKelvin(mired)
+	s := unit.Format(mired)
+
+	if color && kelvin > 0 {
+		s += " " + kelvinToRGB(kelvin).Swatch()
+	}
+
+	return s
+}
+
+// colorEnabled reports whether we should emit colour escapes to the given
+// file. We only do so for terminals, and honour https://no-color.org.
+func colorEnabled(f *os.File) bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return fi.Mode()&os.ModeCharDevice != 0
+}
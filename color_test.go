@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiredToKelvin(t *testing.T) {
+	require.Equal(t, 6993, miredToKelvin(143))
+	require.Equal(t, 2907, miredToKelvin(344))
+	require.Equal(t, 0, miredToKelvin(0))
+}
+
+func TestKelvinToRGB(t *testing.T) {
+	// Warm light is red-heavy, cool light is close to white.
+	warm := kelvinToRGB(2900)
+	require.Equal(t, uint8(255), warm.R)
+	require.Less(t, warm.B, warm.G)
+
+	cool := kelvinToRGB(7000)
+	require.Equal(t, uint8(255), cool.B)
+	require.Less(t, cool.R, uint8(255))
+}
+
+func TestTemperatureString(t *testing.T) {
+	require.Equal(t, "5000K", temperatureString(200, UnitKelvin, false))
+	require.Equal(t, "200 mired", temperatureString(200, UnitMired, false))
+	require.Contains(t, temperatureString(200, UnitKelvin, true), "\x1b[48;2;")
+	require.Equal(t, "0K", temperatureString(0, UnitKelvin, true))
+}
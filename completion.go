@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// How long discovered devices are remembered for completion before they're
+// looked for again. Discovery takes at least a second, which is too long to
+// make someone wait every time they press tab.
+const completionCacheTTL = 10 * time.Minute
+
+// The longest completion will wait to discover devices when the cache is stale.
+const completionDiscoveryTimeout = 2 * time.Second
+
+// Completion is a candidate for completing a --light value.
+type Completion struct {
+	Value       string `json:"value"`
+	Description string `json:"description,omitempty"`
+}
+
+// lightCompletions returns the values --light can complete to which start with
+// prefix: the names from the config file, then discovered devices. Discovery
+// only happens when the cache is older than completionCacheTTL.
+func lightCompletions(
+	ctx context.Context,
+	cfg *Config,
+	cachePath string,
+	prefix string,
+	discover func(ctx context.Context) ([]DiscoveredDevice, error),
+) []Completion {
+	completions := []Completion{}
+	seen := map[string]bool{}
+
+	add := func(c Completion) {
+		if seen[c.Value] || !strings.HasPrefix(c.Value, prefix) {
+			return
+		}
+		seen[c.Value] = true
+		completions = append(completions, c)
+	}
+
+	names := make([]string, 0, len(cfg.Lights))
+	for name := range cfg.Lights {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		add(Completion{Value: name, Description: cfg.Lights[name].Address})
+	}
+
+	cache := readDiscoveryCache(cachePath)
+	if time.Since(cache.UpdatedAt) > completionCacheTTL {
+		ctx, cancel := context.WithTimeout(ctx, completionDiscoveryTimeout)
+		defer cancel()
+
+		// A failed discovery still leaves whatever was cached before
+		if devices, err := discover(ctx); err == nil {
+			cached := cachedFromDiscovered(devices)
+			if err := writeDiscoveryCache(cachePath, cached); err != nil {
+				discoveryLog.Debug("Failed to cache discovered devices", "error", err)
+			}
+			cache.Devices = cached
+		}
+	}
+
+	// Devices are completed by address, since that's what --light accepts,
+	// and described by name
+	for _, cd := range cache.Devices {
+		add(Completion{Value: cd.Address, Description: cd.Name})
+	}
+
+	return completions
+}
+
+// completionScripts complete klctl's commands and flags using urfave/cli's
+// --generate-bash-completion, and --light values using the hidden __complete
+// command.
+var completionScripts = map[string]string{
+	"bash": `_klctl() {
+  local cur prev opts
+  if declare -F _get_comp_words_by_ref >/dev/null; then
+    # Addresses have colons in them, which bash splits words on
+    _get_comp_words_by_ref -n : cur prev
+  else
+    cur=${COMP_WORDS[COMP_CWORD]}
+    prev=${COMP_WORDS[COMP_CWORD-1]}
+  fi
+
+  if [[ $prev == --light ]]; then
+    opts=$(klctl __complete "$cur" 2>/dev/null | cut -f1)
+  elif [[ $cur == -* ]]; then
+    opts=$("${COMP_WORDS[@]:0:COMP_CWORD}" "$cur" --generate-bash-completion 2>/dev/null)
+  else
+    opts=$("${COMP_WORDS[@]:0:COMP_CWORD}" --generate-bash-completion 2>/dev/null)
+  fi
+
+  COMPREPLY=($(compgen -W "$opts" -- "$cur"))
+  if declare -F __ltrim_colon_completions >/dev/null; then
+    __ltrim_colon_completions "$cur"
+  fi
+}
+
+complete -o default -F _klctl klctl
+`,
+	"zsh": `#compdef klctl
+
+_klctl() {
+  local -a candidates
+  local line value
+
+  if [[ ${words[CURRENT-1]} == --light ]]; then
+    for line in ${(f)"$(klctl __complete "$PREFIX" 2>/dev/null)"}; do
+      value=${line%%$'\t'*}
+      candidates+=("${value//:/\\:}:${line#*$'\t'}")
+    done
+    _describe 'light' candidates
+    return
+  fi
+
+  if [[ $PREFIX == -* ]]; then
+    candidates=(${(f)"$(${words[1,CURRENT-1]} $PREFIX --generate-bash-completion 2>/dev/null)"})
+  else
+    candidates=(${(f)"$(${words[1,CURRENT-1]} --generate-bash-completion 2>/dev/null)"})
+  fi
+  compadd -a candidates
+}
+
+if [[ $zsh_eval_context[-1] == loadautofunc ]]; then
+  _klctl "$@"
+else
+  compdef _klctl klctl
+fi
+`,
+	"fish": `function __klctl_complete
+    set -l args (commandline -opc)
+    set -l cur (commandline -ct)
+    if test "$args[-1]" = --light
+        klctl __complete $cur 2>/dev/null
+    else if string match -q -- '-*' $cur
+        $args $cur --generate-bash-completion 2>/dev/null
+    else
+        $args --generate-bash-completion 2>/dev/null
+    end
+end
+
+complete -c klctl -f -a '(__klctl_complete)'
+`,
+}
+
+// completionShells lists the shells there are scripts for.
+func completionShells() []string {
+	shells := make([]string, 0, len(completionScripts))
+	for shell := range completionScripts {
+		shells = append(shells, shell)
+	}
+	sort.Strings(shells)
+
+	return shells
+}
+
+func completionScript(shell string) (string, error) {
+	script, ok := completionScripts[shell]
+	if !ok {
+		return "", fmt.Errorf("unsupported shell %q, must be one of %s", shell, strings.Join(completionShells(), ", "))
+	}
+
+	return script, nil
+}
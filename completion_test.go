@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLightCompletions(t *testing.T) {
+	ctx := context.Background()
+	cachePath := filepath.Join(t.TempDir(), "devices.json")
+	cfg := &Config{Lights: map[string]LightConfig{
+		"key-left":  {Address: "192.168.1.10"},
+		"key-right": {Address: "192.168.1.11"},
+		"fill":      {Address: "192.168.1.12"},
+	}}
+
+	discoveries := 0
+	discover := func(ctx context.Context) ([]DiscoveredDevice, error) {
+		discoveries++
+		return []DiscoveredDevice{
+			{Name: "Elgato Key Light 1A2B", Address: "elgato-key-light-1a2b.local"},
+		}, nil
+	}
+
+	completions := lightCompletions(ctx, cfg, cachePath, "", discover)
+	require.Equal(t, []Completion{
+		{Value: "fill", Description: "192.168.1.12"},
+		{Value: "key-left", Description: "192.168.1.10"},
+		{Value: "key-right", Description: "192.168.1.11"},
+		{Value: "elgato-key-light-1a2b.local", Description: "Elgato Key Light 1A2B"},
+	}, completions)
+
+	// The second time round, discovered devices come from the cache
+	completions = lightCompletions(ctx, cfg, cachePath, "key", discover)
+	require.Equal(t, []string{"key-left", "key-right"}, completionValues(completions))
+
+	completions = lightCompletions(ctx, cfg, cachePath, "elgato", discover)
+	require.Equal(t, []string{"elgato-key-light-1a2b.local"}, completionValues(completions))
+	require.Equal(t, 1, discoveries)
+
+	// A failed discovery still completes configured names
+	failing := func(ctx context.Context) ([]DiscoveredDevice, error) {
+		return nil, errors.New("no network")
+	}
+	completions = lightCompletions(ctx, cfg, filepath.Join(t.TempDir(), "devices.json"), "f", failing)
+	require.Equal(t, []string{"fill"}, completionValues(completions))
+}
+
+func completionValues(completions []Completion) []string {
+	values := []string{}
+	for _, c := range completions {
+		values = append(values, c.Value)
+	}
+
+	return values
+}
+
+func TestCompletionScript(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		script, err := completionScript(shell)
+		require.NoError(t, err)
+		require.Contains(t, script, "klctl __complete")
+		require.Contains(t, script, "--generate-bash-completion")
+	}
+
+	_, err := completionScript("tcsh")
+	require.ErrorContains(t, err, "bash, fish, zsh")
+}
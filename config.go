@@ -0,0 +1,503 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// LightConfig is the configuration for a single named light.
+type LightConfig struct {
+	// Address is the host:port of the light. The port is optional.
+	Address string `yaml:"address"`
+
+	// Names are names for the device's lights, by index, such as 0: front and
+	// 1: rear, for --index and to show alongside them.
+	Names map[int]string `yaml:"names"`
+
+	// HTTPSettings override the --connect-timeout, --request-timeout,
+	// --keepalive and --proxy flags for this light.
+	HTTPSettings `yaml:",inline"`
+}
+
+// Config is the user's configuration file.
+type Config struct {
+	// Lights maps friendly names to lights, so they can be used with --light.
+	Lights map[string]LightConfig `yaml:"lights"`
+
+	// Groups maps group names to the lights in them, for use with --group.
+	// Lights are configured names or addresses, as --light takes.
+	Groups map[string][]string `yaml:"groups"`
+
+	// MQTT is the broker for the mqtt command.
+	MQTT MQTTConfig `yaml:"mqtt"`
+
+	// Weather is where the weather command gets the weather from.
+	Weather WeatherConfig `yaml:"weather"`
+
+	// Profiles are sets of lights, groups and flags for different places,
+	// chosen with --profile.
+	Profiles map[string]Profile `yaml:"profiles"`
+}
+
+// Profile is a set of lights, groups and flags for one place, such as home or
+// the office. Its lights and groups are added to those outside any profile,
+// replacing any with the same names.
+type Profile struct {
+	Lights map[string]LightConfig `yaml:"lights"`
+	Groups map[string][]string    `yaml:"groups"`
+
+	// Defaults are values for global flags, by name, used when the flags
+	// aren't given, e.g. timeout: 10.
+	Defaults map[string]string `yaml:"defaults"`
+}
+
+// defaultConfigPath returns ~/.config/klctl/config.yaml, respecting
+// $XDG_CONFIG_HOME.
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+
+	return filepath.Join(dir, "klctl", "config.yaml")
+}
+
+// loadConfig reads the configuration at path. A missing file isn't an error,
+// and gives an empty configuration.
+func loadConfig(path string) (*Config, error) {
+	cfg := &Config{}
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+
+	if err := validateLights(cfg.Lights, path); err != nil {
+		return nil, err
+	}
+	for name, profile := range cfg.Profiles {
+		if err := validateLights(profile.Lights, fmt.Sprintf("profile %s of %s", name, path)); err != nil {
+			return nil, err
+		}
+	}
+
+	return cfg, nil
+}
+
+// validateLights checks the lights configured in where.
+func validateLights(lights map[string]LightConfig, where string) error {
+	for name, light := range lights {
+		if light.Address == "" {
+			return fmt.Errorf("light %s in %s has no address", name, where)
+		}
+
+		if err := light.HTTPSettings.validate(); err != nil {
+			return fmt.Errorf("light %s in %s: %w", name, where, err)
+		}
+
+		if err := validateLightNames(light.Names); err != nil {
+			return fmt.Errorf("light %s in %s: %w", name, where, err)
+		}
+	}
+
+	return nil
+}
+
+// loadActiveConfig loads the config file, with the --profile in use merged
+// in.
+func loadActiveConfig() (*Config, error) {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := cfg.useProfile(profileName); err != nil {
+		return nil, invalidArgument(err)
+	}
+
+	return cfg, nil
+}
+
+// useProfile merges the named profile's lights and groups into the config,
+// and returns its flag defaults. An empty name uses no profile.
+func (c *Config) useProfile(name string) (map[string]string, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	profile, ok := c.Profiles[name]
+	if !ok {
+		if len(c.Profiles) == 0 {
+			return nil, fmt.Errorf("unknown profile %q, the config file has none", name)
+		}
+		return nil, fmt.Errorf("unknown profile %q, must be one of %s", name, strings.Join(c.profileNames(), ", "))
+	}
+
+	if len(profile.Lights) > 0 && c.Lights == nil {
+		c.Lights = map[string]LightConfig{}
+	}
+	for light, lc := range profile.Lights {
+		c.Lights[light] = lc
+	}
+
+	if len(profile.Groups) > 0 && c.Groups == nil {
+		c.Groups = map[string][]string{}
+	}
+	for group, members := range profile.Groups {
+		c.Groups[group] = members
+	}
+
+	return profile.Defaults, nil
+}
+
+// profileNames returns the names of the profiles, in order.
+func (c *Config) profileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// applyProfileDefaults sets the flags in a profile's defaults which weren't
+// given on the command line or in the environment.
+func applyProfileDefaults(c *cli.Context, profile string, defaults map[string]string) error {
+	var flags []string
+	for _, flag := range c.App.Flags {
+		flags = append(flags, flag.Names()...)
+	}
+
+	names := make([]string, 0, len(defaults))
+	for name := range defaults {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !slices.Contains(flags, name) {
+			return fmt.Errorf("profile %s sets unknown flag %q", profile, name)
+		}
+		if c.IsSet(name) {
+			continue
+		}
+
+		if err := c.Set(name, defaults[name]); err != nil {
+			return fmt.Errorf("profile %s sets --%s: %w", profile, name, err)
+		}
+	}
+
+	return nil
+}
+
+// httpSettings returns the HTTP settings for a light, given the defaults from
+// the command line. name is the light's configured name, if it has one.
+func (c *Config) httpSettings(name string, defaults HTTPSettings) HTTPSettings {
+	if lc, ok := c.Lights[name]; ok {
+		return defaults.merge(lc.HTTPSettings)
+	}
+
+	return defaults
+}
+
+// groupLights returns the lights in the named groups, without duplicates.
+func (c *Config) groupLights(groups []string) ([]string, error) {
+	var lights []string
+	seen := map[string]bool{}
+
+	for _, group := range groups {
+		members, ok := c.Groups[group]
+		if !ok {
+			return nil, fmt.Errorf("unknown group %q, must be one of %s", group, strings.Join(c.groupNames(), ", "))
+		}
+
+		for _, light := range members {
+			if !seen[light] {
+				seen[light] = true
+				lights = append(lights, light)
+			}
+		}
+	}
+
+	return lights, nil
+}
+
+// groupNames returns the names of the groups, in order.
+func (c *Config) groupNames() []string {
+	names := make([]string, 0, len(c.Groups))
+	for name := range c.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// editConfig changes the config file at path by editing its YAML document,
+// rather than rewriting it from a Config, so that comments and layout
+// survive. A missing file is created.
+func editConfig(path string, edit func(root *yaml.Node) error) error {
+	if path == "" {
+		return errors.New("no config file to edit")
+	}
+
+	var doc yaml.Node
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return fmt.Errorf("failed to read config: %w", err)
+	default:
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("failed to parse config %s: %w", path, err)
+		}
+	}
+
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("config %s isn't a mapping", path)
+	}
+
+	if err := edit(root); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, buf.Bytes(), 0o600)
+}
+
+// mappingEntry returns the value for key in a YAML mapping. If there isn't
+// one, it's added as an empty node of the given kind.
+func mappingEntry(mapping *yaml.Node, key string, kind yaml.Kind) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+
+	value := &yaml.Node{Kind: kind}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+
+	return value
+}
+
+// deleteMappingEntry removes key from a YAML mapping, if it's there.
+func deleteMappingEntry(mapping *yaml.Node, key string) bool {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// profileSection returns where the profile's settings are in the config's
+// YAML document: the root, for no profile.
+func profileSection(root *yaml.Node, profile string) (*yaml.Node, error) {
+	if profile == "" {
+		return root, nil
+	}
+
+	section := mappingEntry(mappingEntry(root, "profiles", yaml.MappingNode), profile, yaml.MappingNode)
+	if section.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("profile %s isn't a mapping", profile)
+	}
+
+	return section, nil
+}
+
+// addToGroup adds lights to a group in the config file, creating it if need
+// be. The group is in the profile, if one is given.
+func addToGroup(path, profile, group string, lights []string) error {
+	return editConfig(path, func(root *yaml.Node) error {
+		root, err := profileSection(root, profile)
+		if err != nil {
+			return err
+		}
+
+		members := mappingEntry(mappingEntry(root, "groups", yaml.MappingNode), group, yaml.SequenceNode)
+		if members.Kind != yaml.SequenceNode {
+			return fmt.Errorf("group %s in %s isn't a list", group, path)
+		}
+
+		for _, light := range lights {
+			present := false
+			for _, member := range members.Content {
+				present = present || member.Value == light
+			}
+
+			if !present {
+				members.Content = append(members.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: light})
+			}
+		}
+
+		return nil
+	})
+}
+
+// removeFromGroup removes lights from a group in the config file, or the
+// whole group if no lights are given. A group left empty is removed. The group
+// is in the profile, if one is given.
+func removeFromGroup(path, profile, group string, lights []string) error {
+	return editConfig(path, func(root *yaml.Node) error {
+		root, err := profileSection(root, profile)
+		if err != nil {
+			return err
+		}
+
+		groups := mappingEntry(root, "groups", yaml.MappingNode)
+		members := mappingEntry(groups, group, 0)
+		if members.Kind != yaml.SequenceNode {
+			deleteMappingEntry(groups, group)
+			return fmt.Errorf("unknown group %q", group)
+		}
+
+		if len(lights) > 0 {
+			var kept []*yaml.Node
+			for _, member := range members.Content {
+				removed := false
+				for _, light := range lights {
+					removed = removed || member.Value == light
+				}
+
+				if !removed {
+					kept = append(kept, member)
+				}
+			}
+			members.Content = kept
+		}
+
+		if len(lights) == 0 || len(members.Content) == 0 {
+			deleteMappingEntry(groups, group)
+		}
+
+		return nil
+	})
+}
+
+// renderGroups lists the groups and their lights.
+func renderGroups(w io.Writer, format string, cfg *Config) error {
+	if format == OutputJSON {
+		groups := cfg.Groups
+		if groups == nil {
+			groups = map[string][]string{}
+		}
+
+		return writeJSON(w, groups)
+	}
+
+	for _, name := range cfg.groupNames() {
+		if _, err := fmt.Fprintf(w, "%s: %s\n", name, strings.Join(cfg.Groups[name], ", ")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resolveLight looks up a --light value in the configured lights. It returns
+// the address to use and the light's name, which is empty if the value wasn't
+// a configured name.
+func (c *Config) resolveLight(light string) (address, name string) {
+	if lc, ok := c.Lights[light]; ok {
+		return lc.Address, light
+	}
+
+	return light, ""
+}
+
+// checkLights checks --light values, so a mistake is reported before anything
+// is done, rather than part way through finding the lights. Each must be a
+// configured name or a host with an optional port, and no two may be the same
+// light.
+func (c *Config) checkLights(lights []string) error {
+	seen := map[string]string{}
+
+	for _, light := range lights {
+		addr, name := c.resolveLight(light)
+
+		host, port, err := splitLightAddress(addr)
+		switch {
+		case err == nil:
+		case name != "":
+			return fmt.Errorf("light %q in the config file has an invalid address: %w", name, err)
+		case validHost(hostOf(addr)):
+			return fmt.Errorf("invalid --light %s: %w", light, err)
+		case len(c.Lights) == 0:
+			return fmt.Errorf("--light %s isn't a host[:port], and no lights are named in the config file", light)
+		default:
+			return fmt.Errorf("--light %s isn't a host[:port] or a light named in the config file, which are %s", light, strings.Join(c.lightNames(), ", "))
+		}
+
+		key := lightKey(host, port)
+		if other, ok := seen[key]; ok {
+			return fmt.Errorf("--light %s and --light %s are the same light, %s", other, light, key)
+		}
+		seen[key] = light
+	}
+
+	return nil
+}
+
+// hostOf returns the host of an address, with or without a port.
+func hostOf(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+
+	return addr
+}
+
+// lightNames returns the names of the configured lights, in order.
+func (c *Config) lightNames() []string {
+	names := make([]string, 0, len(c.Lights))
+	for name := range c.Lights {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
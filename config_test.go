@@ -0,0 +1,300 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0o600))
+
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, `
+lights:
+  desk-left:
+    address: 192.168.1.20:9123
+  desk-right:
+    address: keylight-right.local
+`)
+
+	cfg, err := loadConfig(path)
+	require.NoError(t, err)
+	require.Equal(t, map[string]LightConfig{
+		"desk-left":  {Address: "192.168.1.20:9123"},
+		"desk-right": {Address: "keylight-right.local"},
+	}, cfg.Lights)
+
+	address, name := cfg.resolveLight("desk-left")
+	require.Equal(t, "192.168.1.20:9123", address)
+	require.Equal(t, "desk-left", name)
+
+	address, name = cfg.resolveLight("192.168.1.30")
+	require.Equal(t, "192.168.1.30", address)
+	require.Equal(t, "", name)
+}
+
+func TestLoadConfigHTTPSettings(t *testing.T) {
+	path := writeConfig(t, `
+lights:
+  garage:
+    address: 10.8.0.20
+    connect_timeout: 3s
+    request_timeout: 10s
+    keepalive: -1s
+    proxy: socks5://localhost:1080
+  desk:
+    address: 192.168.1.20
+`)
+
+	cfg, err := loadConfig(path)
+	require.NoError(t, err)
+
+	defaults := HTTPSettings{ConnectTimeout: time.Second}
+	require.Equal(t, HTTPSettings{ConnectTimeout: 3 * time.Second, RequestTimeout: 10 * time.Second, KeepAlive: -time.Second, Proxy: "socks5://localhost:1080"},
+		cfg.httpSettings("garage", defaults))
+	require.Equal(t, defaults, cfg.httpSettings("desk", defaults))
+	require.Equal(t, defaults, cfg.httpSettings("", defaults))
+}
+
+func TestLoadConfigMissing(t *testing.T) {
+	cfg, err := loadConfig(filepath.Join(t.TempDir(), "config.yaml"))
+	require.NoError(t, err)
+	require.Empty(t, cfg.Lights)
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	_, err := loadConfig(writeConfig(t, "lights: [nope"))
+	require.Error(t, err)
+
+	_, err = loadConfig(writeConfig(t, "lights:\n  desk: {}\n"))
+	require.ErrorContains(t, err, "no address")
+
+	_, err = loadConfig(writeConfig(t, "lights:\n  desk:\n    address: 10.0.0.2\n    proxy: ftp://proxy\n"))
+	require.ErrorContains(t, err, "unsupported proxy scheme")
+
+	_, err = loadConfig(writeConfig(t, "lights:\n  desk:\n    address: 10.0.0.2\n    names:\n      0: \"1\"\n"))
+	require.ErrorContains(t, err, "names can't be numbers")
+
+	_, err = loadConfig(writeConfig(t, "lights:\n  desk:\n    address: 10.0.0.2\n    names:\n      0: front\n      1: front\n"))
+	require.ErrorContains(t, err, "more than one light is named front")
+}
+
+func TestLoadConfigLightNames(t *testing.T) {
+	cfg, err := loadConfig(writeConfig(t, `
+lights:
+  desk-left:
+    address: 192.168.1.20
+    names:
+      0: front
+      1: rear
+`))
+	require.NoError(t, err)
+	require.Equal(t, map[int]string{0: "front", 1: "rear"}, cfg.Lights["desk-left"].Names)
+}
+
+func TestDefaultConfigPath(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "/xdg")
+	require.Equal(t, "/xdg/klctl/config.yaml", defaultConfigPath())
+}
+
+func TestConfigGroups(t *testing.T) {
+	path := writeConfig(t, `
+lights:
+  desk-left:
+    address: 192.168.1.20
+groups:
+  streaming: [desk-left, 192.168.1.21]
+  desk: [desk-left]
+`)
+
+	cfg, err := loadConfig(path)
+	require.NoError(t, err)
+
+	lights, err := cfg.groupLights([]string{"streaming", "desk"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"desk-left", "192.168.1.21"}, lights)
+
+	_, err = cfg.groupLights([]string{"office"})
+	require.ErrorContains(t, err, "must be one of desk, streaming")
+}
+
+func TestCheckLights(t *testing.T) {
+	path := writeConfig(t, `
+lights:
+  desk-left:
+    address: 192.168.1.20
+  desk-right:
+    address: 192.168.1.21:9124
+  broken:
+    address: "192.168.1.22:99999"
+`)
+
+	cfg, err := loadConfig(path)
+	require.NoError(t, err)
+
+	require.NoError(t, cfg.checkLights([]string{"desk-left", "desk-right", "192.168.1.21", "elgato-key-light.local:9123"}))
+
+	for lights, message := range map[string]string{
+		"desk left":                   "isn't a host[:port] or a light named in the config file, which are broken, desk-left, desk-right",
+		"192.168.1.30:0":              "invalid --light 192.168.1.30:0: port must be a number between 1 and 65535 (got 0)",
+		"broken":                      `light "broken" in the config file has an invalid address`,
+		"desk-left,192.168.1.20:9123": "--light desk-left and --light 192.168.1.20:9123 are the same light, 192.168.1.20:9123",
+		"Key.local,key.local.":        "are the same light, key.local:9123",
+	} {
+		err := cfg.checkLights(strings.Split(lights, ","))
+		require.ErrorContains(t, err, message, lights)
+	}
+
+	err = (&Config{}).checkLights([]string{"desk"})
+	require.NoError(t, err)
+	err = (&Config{}).checkLights([]string{"desk/left"})
+	require.ErrorContains(t, err, "no lights are named in the config file")
+}
+
+func TestConfigProfiles(t *testing.T) {
+	path := writeConfig(t, `
+lights:
+  desk:
+    address: 192.168.1.20
+groups:
+  all: [desk]
+profiles:
+  office:
+    lights:
+      desk:
+        address: 10.0.0.20
+      shelf:
+        address: 10.0.0.21
+    groups:
+      streaming: [desk, shelf]
+    defaults:
+      timeout: "10"
+  home: {}
+`)
+
+	cfg, err := loadConfig(path)
+	require.NoError(t, err)
+
+	defaults, err := cfg.useProfile("office")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"timeout": "10"}, defaults)
+	require.Equal(t, "10.0.0.20", cfg.Lights["desk"].Address)
+	require.Equal(t, "10.0.0.21", cfg.Lights["shelf"].Address)
+	require.Equal(t, map[string][]string{"all": {"desk"}, "streaming": {"desk", "shelf"}}, cfg.Groups)
+
+	_, err = cfg.useProfile("cafe")
+	require.EqualError(t, err, `unknown profile "cafe", must be one of home, office`)
+
+	// Groups are edited in the profile
+	require.NoError(t, addToGroup(path, "home", "desk", []string{"192.168.1.20"}))
+	cfg, err = loadConfig(path)
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{"desk": {"192.168.1.20"}}, cfg.Profiles["home"].Groups)
+	require.Equal(t, map[string][]string{"all": {"desk"}}, cfg.Groups)
+
+	require.NoError(t, removeFromGroup(path, "office", "streaming", []string{"shelf"}))
+	cfg, err = loadConfig(path)
+	require.NoError(t, err)
+	require.Equal(t, []string{"desk"}, cfg.Profiles["office"].Groups["streaming"])
+
+	_, err = loadConfig(writeConfig(t, `
+profiles:
+  office:
+    lights:
+      desk: {}
+`))
+	require.ErrorContains(t, err, "light desk in profile office of")
+}
+
+func TestApplyProfileDefaults(t *testing.T) {
+	run := func(defaults map[string]string, args ...string) (int, error) {
+		var timeout int
+		app := &cli.App{
+			Flags: []cli.Flag{&cli.IntFlag{Name: "timeout", Value: 5, EnvVars: []string{"KLCTL_TIMEOUT"}, Destination: &timeout}},
+			Before: func(c *cli.Context) error {
+				return applyProfileDefaults(c, "office", defaults)
+			},
+			Action: func(c *cli.Context) error { return nil },
+		}
+
+		err := app.Run(append([]string{"klctl"}, args...))
+		return timeout, err
+	}
+
+	timeout, err := run(map[string]string{"timeout": "10"})
+	require.NoError(t, err)
+	require.Equal(t, 10, timeout)
+
+	// Flags which are given win
+	timeout, err = run(map[string]string{"timeout": "10"}, "--timeout", "2")
+	require.NoError(t, err)
+	require.Equal(t, 2, timeout)
+
+	_, err = run(map[string]string{"timeout": "soon"})
+	require.ErrorContains(t, err, "profile office sets --timeout")
+
+	_, err = run(map[string]string{"colour": "red"})
+	require.ErrorContains(t, err, `unknown flag "colour"`)
+
+	// Flags from the environment win too, but not over the command line
+	t.Setenv("KLCTL_TIMEOUT", "3")
+	timeout, err = run(map[string]string{"timeout": "10"})
+	require.NoError(t, err)
+	require.Equal(t, 3, timeout)
+
+	timeout, err = run(map[string]string{"timeout": "10"}, "--timeout", "2")
+	require.NoError(t, err)
+	require.Equal(t, 2, timeout)
+}
+
+func TestEditGroups(t *testing.T) {
+	path := writeConfig(t, `# My lights
+lights:
+  desk-left:
+    address: 192.168.1.20 # by the window
+`)
+
+	require.NoError(t, addToGroup(path, "", "streaming", []string{"desk-left", "192.168.1.21"}))
+	require.NoError(t, addToGroup(path, "", "streaming", []string{"desk-left"}))
+
+	cfg, err := loadConfig(path)
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{"streaming": {"desk-left", "192.168.1.21"}}, cfg.Groups)
+	require.Equal(t, "192.168.1.20", cfg.Lights["desk-left"].Address)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "# My lights")
+	require.Contains(t, string(data), "# by the window")
+
+	require.NoError(t, removeFromGroup(path, "", "streaming", []string{"desk-left"}))
+	cfg, err = loadConfig(path)
+	require.NoError(t, err)
+	require.Equal(t, []string{"192.168.1.21"}, cfg.Groups["streaming"])
+
+	require.NoError(t, removeFromGroup(path, "", "streaming", nil))
+	cfg, err = loadConfig(path)
+	require.NoError(t, err)
+	require.Empty(t, cfg.Groups)
+
+	require.ErrorContains(t, removeFromGroup(path, "", "office", nil), "unknown group")
+
+	// Groups can be added before there's a config file at all
+	newPath := filepath.Join(t.TempDir(), "klctl", "config.yaml")
+	require.NoError(t, addToGroup(newPath, "", "desk", []string{"192.168.1.20"}))
+	cfg, err = loadConfig(newPath)
+	require.NoError(t, err)
+	require.Equal(t, []string{"192.168.1.20"}, cfg.Groups["desk"])
+}
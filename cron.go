@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// cronField describes one of the five fields of a cron expression.
+type cronField struct {
+	name     string
+	min, max int
+
+	// names, if set, can be used instead of numbers, starting from min.
+	names []string
+}
+
+var (
+	cronMinute = cronField{name: "minute", min: 0, max: 59}
+	cronHour   = cronField{name: "hour", min: 0, max: 23}
+	cronDay    = cronField{name: "day of month", min: 1, max: 31}
+	cronMonth  = cronField{name: "month", min: 1, max: 12,
+		names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	// Sunday is both 0 and 7.
+	cronWeekday = cronField{name: "day of week", min: 0, max: 7,
+		names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat", "sun"}}
+)
+
+// cronMacros are the @ shorthands cron understands.
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// CronSpec is a parsed cron expression, such as "0 9 * * 1-5". Each field is
+// a bit set of the values it matches.
+type CronSpec struct {
+	minute, hour, day, month, weekday uint64
+
+	// dayStar and weekdayStar are set when those fields start with *. As in
+	// Vixie cron, when both are restricted a time matches either of them.
+	dayStar, weekdayStar bool
+}
+
+// parseCron parses a standard five field cron expression, or one of the @
+// shorthands. Fields take *, numbers, names, ranges, lists and steps.
+func parseCron(expr string) (*CronSpec, error) {
+	if macro, ok := cronMacros[strings.ToLower(strings.TrimSpace(expr))]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields: minute hour day month weekday", expr)
+	}
+
+	spec := &CronSpec{}
+	for _, f := range []struct {
+		s     string
+		field cronField
+		bits  *uint64
+		star  *bool
+	}{
+		{fields[0], cronMinute, &spec.minute, nil},
+		{fields[1], cronHour, &spec.hour, nil},
+		{fields[2], cronDay, &spec.day, &spec.dayStar},
+		{fields[3], cronMonth, &spec.month, nil},
+		{fields[4], cronWeekday, &spec.weekday, &spec.weekdayStar},
+	} {
+		bits, err := parseCronField(f.s, f.field)
+		if err != nil {
+			return nil, err
+		}
+
+		*f.bits = bits
+		if f.star != nil {
+			*f.star = strings.HasPrefix(f.s, "*")
+		}
+	}
+
+	// Sunday as 7 is the same as 0
+	if spec.weekday&(1<<7) != 0 {
+		spec.weekday |= 1
+	}
+
+	return spec, nil
+}
+
+func parseCronField(s string, field cronField) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(s, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = parseIntInRange(stepStr, 1, field.max); err != nil {
+				return 0, fmt.Errorf("invalid step in %s %q", field.name, part)
+			}
+		}
+
+		lo, hi := field.min, field.max
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+
+			var err error
+			if lo, err = parseCronValue(from, field, false); err != nil {
+				return 0, err
+			}
+
+			switch {
+			case isRange:
+				if hi, err = parseCronValue(to, field, true); err != nil {
+					return 0, err
+				}
+				if hi < lo {
+					return 0, fmt.Errorf("invalid range in %s %q", field.name, part)
+				}
+			case !hasStep:
+				hi = lo
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+
+	return bits, nil
+}
+
+// parseCronValue parses a number or name. A name which appears twice, like
+// sun, is the later value at the end of a range, so sat-sun works.
+func parseCronValue(s string, field cronField, end bool) (int, error) {
+	value := -1
+	for i, name := range field.names {
+		if strings.EqualFold(s, name) && (value < 0 || end) {
+			value = field.min + i
+		}
+	}
+	if value >= 0 {
+		return value, nil
+	}
+
+	v, err := parseIntInRange(s, field.min, field.max)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q, must be between %d and %d", field.name, s, field.min, field.max)
+	}
+
+	return v, nil
+}
+
+func (s *CronSpec) matchesDay(t time.Time) bool {
+	day := s.day&(1<<t.Day()) != 0
+	weekday := s.weekday&(1<<int(t.Weekday())) != 0
+
+	if s.dayStar || s.weekdayStar {
+		return day && weekday
+	}
+
+	return day || weekday
+}
+
+// Matches reports whether the spec matches the minute t is in.
+func (s *CronSpec) Matches(t time.Time) bool {
+	return s.minute&(1<<t.Minute()) != 0 &&
+		s.hour&(1<<t.Hour()) != 0 &&
+		s.month&(1<<int(t.Month())) != 0 &&
+		s.matchesDay(t)
+}
+
+// Next returns the first minute after t which the spec matches, or the zero
+// time if there's none in the next five years, such as for 30 February.
+func (s *CronSpec) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
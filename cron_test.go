@@ -0,0 +1,116 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, tc := range []struct {
+		expr    string
+		matches []time.Time
+		misses  []time.Time
+	}{
+		{
+			expr:    "0 9 * * 1-5",
+			matches: []time.Time{time.Date(2024, time.March, 1, 9, 0, 0, 0, time.UTC)},
+			misses: []time.Time{
+				time.Date(2024, time.March, 2, 9, 0, 0, 0, time.UTC),
+				time.Date(2024, time.March, 1, 9, 1, 0, 0, time.UTC),
+			},
+		},
+		{
+			expr: "*/15 * * * *",
+			matches: []time.Time{
+				time.Date(2024, time.March, 1, 9, 0, 0, 0, time.UTC),
+				time.Date(2024, time.March, 1, 9, 45, 0, 0, time.UTC),
+			},
+			misses: []time.Time{time.Date(2024, time.March, 1, 9, 20, 0, 0, time.UTC)},
+		},
+		{
+			expr:    "30 18 * jan,DEC sat-sun",
+			matches: []time.Time{time.Date(2023, time.December, 31, 18, 30, 0, 0, time.UTC)},
+			misses:  []time.Time{time.Date(2024, time.March, 2, 18, 30, 0, 0, time.UTC)},
+		},
+		{
+			expr:    "0 0 * * 7",
+			matches: []time.Time{time.Date(2024, time.March, 3, 0, 0, 0, 0, time.UTC)},
+		},
+		{
+			expr: "@daily",
+			matches: []time.Time{
+				time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, time.March, 2, 0, 0, 0, 0, time.UTC),
+			},
+			misses: []time.Time{time.Date(2024, time.March, 1, 1, 0, 0, 0, time.UTC)},
+		},
+		{
+			// Either the 13th or a Friday
+			expr: "0 12 13 * 5",
+			matches: []time.Time{
+				time.Date(2024, time.March, 13, 12, 0, 0, 0, time.UTC),
+				time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC),
+			},
+			misses: []time.Time{time.Date(2024, time.March, 14, 12, 0, 0, 0, time.UTC)},
+		},
+	} {
+		t.Run(tc.expr, func(t *testing.T) {
+			spec, err := parseCron(tc.expr)
+			require.NoError(t, err)
+
+			for _, at := range tc.matches {
+				require.True(t, spec.Matches(at), "should match %s", at)
+			}
+			for _, at := range tc.misses {
+				require.False(t, spec.Matches(at), "shouldn't match %s", at)
+			}
+		})
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * * foo *",
+		"@often",
+	} {
+		t.Run(expr, func(t *testing.T) {
+			_, err := parseCron(expr)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// A Friday
+	now := time.Date(2024, time.March, 1, 9, 30, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, time.March, 1, 9, 31, 0, 0, time.UTC)},
+		{"30 9 * * *", time.Date(2024, time.March, 2, 9, 30, 0, 0, time.UTC)},
+		{"0 9 * * 1-5", time.Date(2024, time.March, 4, 9, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	} {
+		t.Run(tc.expr, func(t *testing.T) {
+			spec, err := parseCron(tc.expr)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, spec.Next(now))
+		})
+	}
+}
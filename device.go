@@ -1,56 +1,123 @@
 package main
 
 import (
-	"context"
-	"fmt"
+	"net"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/endocrimes/keylight-go"
+	"github.com/iainlane/klctl/pkg/keylightctl"
 )
 
-type Device interface {
-	GetDNSAddr() string
-	FetchDeviceInfo(ctx context.Context) (*keylight.DeviceInfo, error)
-	FetchSettings(ctx context.Context) (*keylight.DeviceSettings, error)
-	FetchLightGroup(ctx context.Context) (*keylight.LightGroup, error)
-	UpdateLightGroup(ctx context.Context, lg *keylight.LightGroup) (*keylight.LightGroup, error)
+// Device, WifiInfo, KeylightDevice, colours and raw responses live in keylightctl, so other
+// programs can use them too.
+type (
+	Device         = keylightctl.Device
+	WifiInfo       = keylightctl.WifiInfo
+	KeylightDevice = keylightctl.KeylightDevice
+	Color          = keylightctl.Color
+	ColorGroup     = keylightctl.ColorGroup
+	RawResponse    = keylightctl.RawResponse
+)
+
+// sortDevices puts devices into a stable order, so output doesn't depend on
+// the order discovery happened to find them in. Devices are ordered by name,
+// falling back to their address for devices without one, such as those given
+// with --light.
+func sortDevices(devices []Device) {
+	sort.SliceStable(devices, func(i, j int) bool {
+		if devices[i].GetName() != devices[j].GetName() {
+			return devices[i].GetName() < devices[j].GetName()
+		}
+
+		return devices[i].GetDNSAddr() < devices[j].GetDNSAddr()
+	})
 }
 
-// KeylightDevice is a wrapper around keylight.Device that implements the
-// interface above. This allows us to use the upstream keylight.Device directly,
-// but also to mock it out in tests, including property accessors.
-type KeylightDevice struct {
-	*keylight.Device
+// deviceAddress is how a device's address is shown: its host and port, so
+// devices sharing a host, such as emulated ones, can be told apart.
+func deviceAddress(device Device) string {
+	return hostPort(device.GetDNSAddr(), device.GetPort())
 }
 
-func (device KeylightDevice) GetDNSAddr() string {
-	return device.DNSAddr
+// hostPort joins a device's host and port, leaving out the trailing dot of a
+// fully qualified name. Without a port, it's the host as it is.
+func hostPort(host string, port int) string {
+	if port == 0 {
+		return host
+	}
+
+	return net.JoinHostPort(strings.TrimSuffix(host, "."), strconv.Itoa(port))
+}
+
+// LightStatus is the state of one light in a device's light group.
+type LightStatus struct {
+	Index int `json:"index"`
+
+	// Name is the light's name in the config file, if it has one.
+	Name              string `json:"name,omitempty"`
+	On                bool   `json:"on"`
+	Brightness        int    `json:"brightness"`
+	Temperature       int    `json:"temperature"`
+	TemperatureKelvin int    `json:"temperature_kelvin"`
+
+	// Color is set for lights which can show colours.
+	Color *Color `json:"color,omitempty"`
 }
 
-func DeviceString(
+// DeviceStatus is everything we know about a device, in a form suitable for
+// machine-readable output.
+type DeviceStatus struct {
+	Address  string                   `json:"address"`
+	Port     int                      `json:"port,omitempty"`
+	Name     string                   `json:"name,omitempty"`
+	Info     *keylight.DeviceInfo     `json:"info"`
+	Settings *keylight.DeviceSettings `json:"settings"`
+	Lights   []LightStatus            `json:"lights"`
+	Wifi     *WifiInfo                `json:"wifi,omitempty"`
+
+	// Errors holds why each section which couldn't be fetched is missing,
+	// keyed by section: info, settings or lights.
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+func newDeviceStatus(
 	device Device,
-	info keylight.DeviceInfo,
-	settings keylight.DeviceSettings,
-	lightGroup keylight.LightGroup,
-) string {
-	var sb strings.Builder
-
-	sb.WriteString("Device: ")
-	sb.WriteString(device.GetDNSAddr())
-	sb.WriteString("\n")
-	sb.WriteString("DeviceInfo: ")
-	sb.WriteString(fmt.Sprintf("%+v", info))
-	sb.WriteString("\n")
-	sb.WriteString("DeviceSettings: ")
-	sb.WriteString(fmt.Sprintf("%+v", settings))
-	sb.WriteString("\n")
-	sb.WriteString("LightGroup: ")
-	for _, light := range lightGroup.Lights {
-		sb.WriteString(fmt.Sprintf("%+v", light))
+	info *keylight.DeviceInfo,
+	settings *keylight.DeviceSettings,
+	lightGroup *keylight.LightGroup,
+) DeviceStatus {
+	status := DeviceStatus{
+		Address:  device.GetDNSAddr(),
+		Port:     device.GetPort(),
+		Name:     device.GetName(),
+		Info:     info,
+		Settings: settings,
+		Lights:   []LightStatus{},
+	}
+
+	if lightGroup == nil {
+		return status
+	}
+
+	for i, light := range lightGroup.Lights {
+		status.Lights = append(status.Lights, LightStatus{
+			Index:             i,
+			Name:              indexNames.of(device)[i],
+			On:                light.On == 1,
+			Brightness:        light.Brightness,
+			Temperature:       light.Temperature,
+			TemperatureKelvin: miredToKelvin(light.Temperature),
+		})
 	}
 
-	return sb.String()
+	return status
 }
 
-// Make sure the upstream keylight.Device implements this interface.
-var _ Device = &KeylightDevice{}
+// The sections of a device's status, which are fetched separately.
+const (
+	SectionInfo     = "info"
+	SectionSettings = "settings"
+	SectionLights   = "lights"
+)
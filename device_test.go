@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSortDevices(t *testing.T) {
+	devices := []Device{
+		&FakeDevice{Name: "Key Light B", DNSAddr: "192.168.1.1"},
+		&FakeDevice{DNSAddr: "192.168.1.9"},
+		&FakeDevice{Name: "Key Light A", DNSAddr: "192.168.1.5"},
+		&FakeDevice{DNSAddr: "192.168.1.3"},
+	}
+
+	sortDevices(devices)
+
+	var addrs []string
+	for _, device := range devices {
+		addrs = append(addrs, device.GetDNSAddr())
+	}
+	require.Equal(t, []string{"192.168.1.3", "192.168.1.9", "192.168.1.5", "192.168.1.1"}, addrs)
+}
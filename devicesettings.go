@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/urfave/cli/v2"
+)
+
+// What a light does when it gets power, as its settings' powerOnBehavior.
+const (
+	powerOnRestore  = 1
+	powerOnSettings = 2
+)
+
+// The device settings which can be changed, as named in a Change. Durations
+// are in milliseconds.
+const (
+	FieldRestoreLastState    = "restore_last_state"
+	FieldPowerOnBrightness   = "power_on_brightness"
+	FieldPowerOnTemperature  = "power_on_temperature"
+	FieldSwitchOnDuration    = "switch_on_duration_ms"
+	FieldSwitchOffDuration   = "switch_off_duration_ms"
+	FieldColorChangeDuration = "color_change_duration_ms"
+)
+
+// The longest transition a light accepts.
+const maxSettingsDuration = 10 * time.Second
+
+// DeviceSettingsUpdate is a change to devices' settings. Fields which are nil
+// are left as they are.
+type DeviceSettingsUpdate struct {
+	// RestoreLastState makes a light come back as it was when it gets power,
+	// rather than with its power-on brightness and temperature.
+	RestoreLastState    *bool
+	PowerOnBrightness   *int
+	PowerOnTemperature  *int
+	SwitchOnDuration    *time.Duration
+	SwitchOffDuration   *time.Duration
+	ColorChangeDuration *time.Duration
+}
+
+// IsZero reports whether the update leaves everything as it is.
+func (u DeviceSettingsUpdate) IsZero() bool {
+	return u == DeviceSettingsUpdate{}
+}
+
+// apply changes settings, returning what changed.
+func (u DeviceSettingsUpdate) apply(settings *keylight.DeviceSettings) []Change {
+	var changes []Change
+	set := func(field string, current *int, value int) {
+		if *current != value {
+			changes = append(changes, Change{Field: field, Old: *current, New: value})
+			*current = value
+		}
+	}
+
+	if u.RestoreLastState != nil {
+		behavior := powerOnSettings
+		if *u.RestoreLastState {
+			behavior = powerOnRestore
+		}
+		set(FieldRestoreLastState, &settings.PowerOnBehavior, behavior)
+	}
+	if u.PowerOnBrightness != nil {
+		set(FieldPowerOnBrightness, &settings.PowerOnBrightness, *u.PowerOnBrightness)
+	}
+	if u.PowerOnTemperature != nil {
+		set(FieldPowerOnTemperature, &settings.PowerOnTemperature, *u.PowerOnTemperature)
+	}
+	for _, d := range []struct {
+		field    string
+		current  *int
+		duration *time.Duration
+	}{
+		{FieldSwitchOnDuration, &settings.SwitchOnDurationMs, u.SwitchOnDuration},
+		{FieldSwitchOffDuration, &settings.SwitchOffDurationMs, u.SwitchOffDuration},
+		{FieldColorChangeDuration, &settings.ColorChangeDurationMs, u.ColorChangeDuration},
+	} {
+		if d.duration != nil {
+			set(d.field, d.current, int(d.duration.Milliseconds()))
+		}
+	}
+
+	return changes
+}
+
+// settingsUpdateFromFlags builds an update from settings set's flags.
+func settingsUpdateFromFlags(c *cli.Context) (DeviceSettingsUpdate, error) {
+	var u DeviceSettingsUpdate
+
+	if c.IsSet("restore-last-state") {
+		restore := c.Bool("restore-last-state")
+		u.RestoreLastState = &restore
+	}
+
+	if c.IsSet("power-on-brightness") {
+		brightness := c.Int("power-on-brightness")
+		if brightness < minBrightness || brightness > 100 {
+			return u, fmt.Errorf("power-on brightness must be between %d and 100 (got %d)", minBrightness, brightness)
+		}
+		u.PowerOnBrightness = &brightness
+	}
+
+	if c.IsSet("power-on-temperature") {
+		temperature, err := parseTemperature(c.String("power-on-temperature"), temperatureUnit)
+		if err == nil {
+			err = validateTemperature(temperature)
+		}
+		if err != nil {
+			return u, err
+		}
+		u.PowerOnTemperature = &temperature
+	}
+
+	for _, d := range []struct {
+		flag     string
+		duration **time.Duration
+	}{
+		{"switch-on-duration", &u.SwitchOnDuration},
+		{"switch-off-duration", &u.SwitchOffDuration},
+		{"color-change-duration", &u.ColorChangeDuration},
+	} {
+		if !c.IsSet(d.flag) {
+			continue
+		}
+
+		duration := c.Duration(d.flag)
+		if duration < 0 || duration > maxSettingsDuration {
+			return u, fmt.Errorf("--%s must be between 0 and %s (got %s)", d.flag, maxSettingsDuration, duration)
+		}
+		*d.duration = &duration
+	}
+
+	if u.IsZero() {
+		return u, errors.New("nothing to set, give at least one setting to change")
+	}
+
+	return u, nil
+}
+
+// setDeviceSettings changes the settings of every device.
+func setDeviceSettings(ctx context.Context, lightList []Device, u DeviceSettingsUpdate) (*CommandResult, error) {
+	unlock, err := acquireDeviceLocks(ctx, lightList)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	result := newCommandResult()
+	var mu sync.Mutex
+	err = forEachDevice(ctx, lightList, func(ctx context.Context, i int, device Device) error {
+		start := time.Now()
+
+		settings, err := device.FetchSettings(ctx)
+		var changes []Change
+		if err == nil {
+			changes = u.apply(settings)
+			if len(changes) > 0 {
+				_, err = device.UpdateSettings(ctx, settings)
+			}
+		}
+
+		mu.Lock()
+		result.addDevice(device, start, changes, err)
+		mu.Unlock()
+
+		return err
+	})
+
+	return result, err
+}
+
+// DeviceSettingsStatus is a device's settings, for settings get.
+type DeviceSettingsStatus struct {
+	Device   string                   `json:"device"`
+	Name     string                   `json:"name,omitempty"`
+	Settings *keylight.DeviceSettings `json:"settings"`
+}
+
+// getDeviceSettings returns the settings of every device.
+func getDeviceSettings(ctx context.Context, lightList []Device) ([]DeviceSettingsStatus, error) {
+	statuses := make([]DeviceSettingsStatus, len(lightList))
+	err := forEachDevice(ctx, lightList, func(ctx context.Context, i int, device Device) error {
+		settings, err := device.FetchSettings(ctx)
+		if err != nil {
+			return err
+		}
+
+		statuses[i] = DeviceSettingsStatus{Device: device.GetDNSAddr(), Name: device.GetName(), Settings: settings}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return statuses, nil
+}
+
+func renderDeviceSettings(w io.Writer, format string, statuses []DeviceSettingsStatus, unit TemperatureUnit) error {
+	if format == OutputJSON {
+		return writeJSON(w, statuses)
+	}
+
+	ms := func(ms int) string { return (time.Duration(ms) * time.Millisecond).String() }
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tPOWER ON\tBRIGHTNESS\tTEMPERATURE\tSWITCH ON\tSWITCH OFF\tCOLOR CHANGE")
+	for _, status := range statuses {
+		s := status.Settings
+
+		powerOn := "settings"
+		if s.PowerOnBehavior == powerOnRestore {
+			powerOn = "last state"
+		}
+
+		name := status.Name
+		if name == "" {
+			name = status.Device
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%d%%\t%s\t%s\t%s\t%s\n",
+			name, powerOn, s.PowerOnBrightness, unit.Format(s.PowerOnTemperature),
+			ms(s.SwitchOnDurationMs), ms(s.SwitchOffDurationMs), ms(s.ColorChangeDurationMs))
+	}
+
+	return tw.Flush()
+}
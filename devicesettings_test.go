@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetDeviceSettings(t *testing.T) {
+	device := &FakeDevice{DNSAddr: "a.local", DeviceSet: &keylight.DeviceSettings{
+		PowerOnBehavior:     powerOnRestore,
+		PowerOnBrightness:   20,
+		PowerOnTemperature:  200,
+		SwitchOffDurationMs: 300,
+	}}
+
+	restore := false
+	brightness := 20
+	off := time.Second
+	result, err := setDeviceSettings(context.Background(), []Device{device}, DeviceSettingsUpdate{
+		RestoreLastState:  &restore,
+		PowerOnBrightness: &brightness,
+		SwitchOffDuration: &off,
+	})
+	require.NoError(t, err)
+
+	// The brightness was already right, so it isn't a change
+	require.Equal(t, []Change{
+		{Field: FieldRestoreLastState, Old: powerOnRestore, New: powerOnSettings},
+		{Field: FieldSwitchOffDuration, Old: 300, New: 1000},
+	}, result.Devices[0].Changes)
+	require.Equal(t, &keylight.DeviceSettings{
+		PowerOnBehavior:     powerOnSettings,
+		PowerOnBrightness:   20,
+		PowerOnTemperature:  200,
+		SwitchOffDurationMs: 1000,
+	}, device.DeviceSet)
+
+	// Nothing to change means nothing is sent
+	device.UpdateSettingsError = errors.New("unexpected update")
+	result, err = setDeviceSettings(context.Background(), []Device{device}, DeviceSettingsUpdate{PowerOnBrightness: &brightness})
+	require.NoError(t, err)
+	require.Equal(t, ResultSkipped, result.Devices[0].Status)
+}
+
+func TestRenderDeviceSettings(t *testing.T) {
+	statuses := []DeviceSettingsStatus{{
+		Device: "a.local",
+		Name:   "Key Light",
+		Settings: &keylight.DeviceSettings{
+			PowerOnBehavior:       powerOnRestore,
+			PowerOnBrightness:     20,
+			PowerOnTemperature:    200,
+			SwitchOnDurationMs:    100,
+			SwitchOffDurationMs:   300,
+			ColorChangeDurationMs: 100,
+		},
+	}}
+
+	var buf bytes.Buffer
+	require.NoError(t, renderDeviceSettings(&buf, OutputText, statuses, UnitKelvin))
+	require.Equal(t, ""+
+		"NAME       POWER ON    BRIGHTNESS  TEMPERATURE  SWITCH ON  SWITCH OFF  COLOR CHANGE\n"+
+		"Key Light  last state  20%         5000K        100ms      300ms       100ms\n",
+		buf.String())
+}
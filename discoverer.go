@@ -2,76 +2,69 @@ package main
 
 import (
 	"context"
-	"fmt"
-	"time"
+	"net"
+	"strings"
 
-	"github.com/endocrimes/keylight-go"
+	"github.com/iainlane/klctl/pkg/keylightctl"
 )
 
-type Discovery interface {
-	Run(ctx context.Context) error
-	ResultsCh() <-chan Device
-}
-
-type DiscoveryWrapper struct {
-	discovery keylight.Discovery
-}
+type (
+	Discovery        = keylightctl.Discovery
+	DiscoveryWrapper = keylightctl.DiscoveryWrapper
+)
 
-func (w *DiscoveryWrapper) Run(ctx context.Context) error {
-	return w.discovery.Run(ctx)
+// DiscoveredDevice describes a device found on the network.
+type DiscoveredDevice struct {
+	Name    string   `json:"name"`
+	Address string   `json:"address"`
+	IPs     []string `json:"ips"`
+	Port    int      `json:"port"`
+	Serial  string   `json:"serial,omitempty"`
+	Error   string   `json:"error,omitempty"`
 }
 
-func (w *DiscoveryWrapper) ResultsCh() <-chan Device {
-	outCh := make(chan Device)
+// describeDevices looks up the IP addresses and serial number of each device.
+// Failures are recorded on the device rather than returned, so one
+// unresponsive light doesn't hide the others.
+func describeDevices(
+	ctx context.Context,
+	devices []Device,
+	lookupHost func(ctx context.Context, host string) ([]string, error),
+) []DiscoveredDevice {
+	described := make([]DiscoveredDevice, 0, len(devices))
 
-	go func() {
-		for device := range w.discovery.ResultsCh() {
-			outCh <- KeylightDevice{device}
+	for _, device := range devices {
+		dd := DiscoveredDevice{
+			Name:    device.GetName(),
+			Address: strings.TrimSuffix(device.GetDNSAddr(), "."),
+			IPs:     []string{},
+			Port:    device.GetPort(),
 		}
-		close(outCh)
-	}()
 
-	return outCh
-}
+		ips, err := lookupHost(ctx, dd.Address)
+		if err == nil {
+			dd.IPs = ips
+		}
 
-type discoveryTimeoutError struct{}
+		info, err := device.FetchDeviceInfo(ctx)
+		if err != nil {
+			dd.Error = err.Error()
+		} else {
+			dd.Serial = info.SerialNumber
+		}
 
-func (te *discoveryTimeoutError) Error() string {
-	return "timed out while discovering devices"
-}
+		described = append(described, dd)
+	}
 
-func (te *discoveryTimeoutError) ExitCode() int {
-	return 1
+	return described
 }
 
-func Discover(ctx context.Context, discoverer Discovery) ([]Device, error) {
-	// make sure the discovery is stopped when we return from this function
-	subCtx, cancel := context.WithCancelCause(ctx)
-	defer cancel(fmt.Errorf("finished discovering devices"))
-
-	var devices []Device
-	errCh := make(chan error)
-	go func() {
-		errCh <- discoverer.Run(subCtx)
-	}()
-
-	// keep trying until it's been a second since the last device was found or
-	// we hit the global timeout, then return
-	discoveryTimeout := time.NewTimer(time.Second)
-	for {
-		select {
-		case <-ctx.Done():
-			if ctx.Err() == context.DeadlineExceeded {
-				return nil, &discoveryTimeoutError{}
-			}
-			return nil, ctx.Err()
-		case device := <-discoverer.ResultsCh():
-			devices = append(devices, device)
-			discoveryTimeout.Reset(time.Second)
-		case <-discoveryTimeout.C:
-			return devices, nil
-		case err := <-errCh:
-			return nil, err
-		}
+func discoverCommand(ctx context.Context, clock Clock, discoverer Discovery) ([]DiscoveredDevice, error) {
+	devices, err := keylightctl.Discover(ctx, clock, discoverer)
+	if err != nil {
+		return nil, err
 	}
+	sortDevices(devices)
+
+	return describeDevices(ctx, devices, net.DefaultResolver.LookupHost), nil
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDescribeDevices(t *testing.T) {
+	devices := []Device{
+		&FakeDevice{
+			Name:       "Key Light Left",
+			DNSAddr:    "elgato-key-light-1234.local.",
+			Port:       9123,
+			DeviceInfo: &keylight.DeviceInfo{SerialNumber: "CW12AB345678"},
+		},
+		&FakeDevice{
+			Name:                 "Key Light Right",
+			DNSAddr:              "elgato-key-light-5678.local.",
+			Port:                 9123,
+			FetchDeviceInfoError: errors.New("fetch error"),
+		},
+	}
+
+	lookupHost := func(ctx context.Context, host string) ([]string, error) {
+		if host == "elgato-key-light-1234.local" {
+			return []string{"192.168.1.20"}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+
+	described := describeDevices(context.Background(), devices, lookupHost)
+	require.Equal(t, []DiscoveredDevice{
+		{
+			Name:    "Key Light Left",
+			Address: "elgato-key-light-1234.local",
+			IPs:     []string{"192.168.1.20"},
+			Port:    9123,
+			Serial:  "CW12AB345678",
+		},
+		{
+			Name:    "Key Light Right",
+			Address: "elgato-key-light-5678.local",
+			IPs:     []string{},
+			Port:    9123,
+			Error:   "fetch error",
+		},
+	}, described)
+
+	var buf bytes.Buffer
+	require.NoError(t, renderDiscovered(&buf, OutputText, described))
+	require.Equal(t, `NAME             ADDRESS                      IP            PORT  SERIAL
+Key Light Left   elgato-key-light-1234.local  192.168.1.20  9123  CW12AB345678
+Key Light Right  elgato-key-light-5678.local                9123  unavailable
+`, buf.String())
+}
@@ -0,0 +1,50 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// drmDisplays finds displays through the kernel's DRM connectors, which is
+// what udev and RandR see too. Displays are named by connector, such as
+// card1-DP-3.
+type drmDisplays struct {
+	dir string
+}
+
+func newDisplaySource() (PeripheralSource, error) {
+	return drmDisplays{"/sys/class/drm"}, nil
+}
+
+func (d drmDisplays) Connected() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(d.dir, "card*-*", "status"))
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, path := range paths {
+		status, err := os.ReadFile(path)
+		if err != nil {
+			// Connectors come and go with docks
+			continue
+		}
+
+		if strings.TrimSpace(string(status)) == "connected" {
+			names = append(names, filepath.Base(filepath.Dir(path)))
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// Changes passes on uevents from DRM.
+func (d drmDisplays) Changes(ctx context.Context) (<-chan struct{}, error) {
+	return ueventChanges(ctx, "drm")
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDRMDisplaysConnected(t *testing.T) {
+	dir := t.TempDir()
+	for connector, status := range map[string]string{
+		"card1-eDP-1":    "connected\n",
+		"card1-DP-3":     "connected\n",
+		"card1-HDMI-A-1": "disconnected\n",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, connector), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, connector, "status"), []byte(status), 0o644))
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "card1"), 0o755))
+
+	displays, err := drmDisplays{dir}.Connected()
+	require.NoError(t, err)
+	require.Equal(t, []string{"card1-DP-3", "card1-eDP-1"}, displays)
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !windows
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Watching displays on macOS needs CoreGraphics, and so cgo, which klctl is
+// built without.
+func newDisplaySource() (PeripheralSource, error) {
+	return nil, fmt.Errorf("watching displays isn't supported on %s", runtime.GOOS)
+}
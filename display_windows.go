@@ -0,0 +1,67 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"slices"
+	"syscall"
+	"unsafe"
+)
+
+// displayDeviceAttached is DISPLAY_DEVICE_ATTACHED_TO_DESKTOP.
+const displayDeviceAttached = 0x1
+
+var enumDisplayDevices = syscall.NewLazyDLL("user32.dll").NewProc("EnumDisplayDevicesW")
+
+// displayDevice is DISPLAY_DEVICEW.
+type displayDevice struct {
+	cb           uint32
+	DeviceName   [32]uint16
+	DeviceString [128]uint16
+	StateFlags   uint32
+	DeviceID     [128]uint16
+	DeviceKey    [128]uint16
+}
+
+// windowsDisplays finds displays with EnumDisplayDevices. Hearing about
+// changes as they happen, with WM_DISPLAYCHANGE, needs a window and a message
+// loop, so they're polled for instead. Displays are named by adapter and
+// monitor, such as \\.\DISPLAY2 Generic PnP Monitor.
+type windowsDisplays struct{}
+
+func newDisplaySource() (PeripheralSource, error) {
+	return windowsDisplays{}, nil
+}
+
+func (windowsDisplays) Connected() ([]string, error) {
+	names := []string{}
+
+	for i := 0; ; i++ {
+		adapter := displayDevice{}
+		adapter.cb = uint32(unsafe.Sizeof(adapter))
+		if r, _, _ := enumDisplayDevices.Call(0, uintptr(i), uintptr(unsafe.Pointer(&adapter)), 0); r == 0 {
+			break
+		}
+		if adapter.StateFlags&displayDeviceAttached == 0 {
+			continue
+		}
+
+		name := syscall.UTF16ToString(adapter.DeviceName[:])
+
+		monitor := displayDevice{}
+		monitor.cb = uint32(unsafe.Sizeof(monitor))
+		if r, _, _ := enumDisplayDevices.Call(uintptr(unsafe.Pointer(&adapter.DeviceName[0])), 0, uintptr(unsafe.Pointer(&monitor)), 0); r != 0 {
+			name += " " + syscall.UTF16ToString(monitor.DeviceString[:])
+		}
+
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names, nil
+}
+
+func (d windowsDisplays) Changes(ctx context.Context) (<-chan struct{}, error) {
+	return pollChanges(ctx, d.Connected)
+}
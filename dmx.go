@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+)
+
+// How often the state of the lights is sent to a console by default. Art-Net
+// receivers expect to hear at least every few seconds, even when nothing
+// changes.
+const defaultDMXInterval = time.Second
+
+// Each light is patched as a fixture with two channels: intensity, then colour
+// temperature.
+const dmxChannelsPerLight = 2
+
+// lightToDMX returns a light's intensity and colour temperature channels.
+// Intensity 0 is off. Colour temperature runs from the warmest the lights go at
+// 0 to the coolest at 255, evenly in Kelvin.
+func lightToDMX(light keylight.Light) (intensity, cct byte) {
+	if light.On == 1 {
+		intensity = byte(lerp(0, 255, float64(light.Brightness)/100))
+	}
+
+	warmest, coolest := miredToKelvin(maxTemperature), miredToKelvin(minTemperature)
+	t := float64(miredToKelvin(light.Temperature)-warmest) / float64(coolest-warmest)
+	cct = byte(lerp(0, 255, max(0, min(1, t))))
+
+	return intensity, cct
+}
+
+// applyDMX sets a light from intensity and colour temperature channels, the
+// other way from lightToDMX. A light turned off keeps its brightness, so it
+// comes back on as it was.
+func applyDMX(light *keylight.Light, intensity, cct byte) {
+	light.On = 0
+	if intensity > 0 {
+		light.On = 1
+		light.Brightness = max(minBrightness, lerp(0, 100, float64(intensity)/255))
+	}
+
+	kelvin := lerp(miredToKelvin(maxTemperature), miredToKelvin(minTemperature), float64(cct)/255)
+	light.Temperature = max(minTemperature, min(maxTemperature, kelvinToMired(kelvin)))
+}
+
+// DMXBridge makes lights look like DMX fixtures to a lighting console, over
+// Art-Net. Every light of every device is patched in turn, from Address.
+type DMXBridge struct {
+	devices []Device
+	clock   Clock
+
+	// requestTimeout bounds each request to a device.
+	requestTimeout time.Duration
+
+	Universe uint16
+	// Address is the first channel, counting from 1 as consoles do.
+	Address int
+
+	// lights is how many lights each device has, found by patch.
+	lights []int
+}
+
+// patch finds how many lights each device has, so each can be given its
+// channels. A device's lights keep their channels from then on.
+func (db *DMXBridge) patch(ctx context.Context) ([]DeviceLightGroup, error) {
+	lgs, err := fetchLightGroups(ctx, db.devices)
+	if err != nil {
+		return nil, err
+	}
+
+	db.lights = make([]int, len(lgs))
+	channel := db.Address
+	for i, dlg := range lgs {
+		db.lights[i] = len(dlg.LightGroup.Lights)
+		for light := range dlg.LightGroup.Lights {
+			automationLog.Info("Patched light",
+				"name", dlg.Device.GetName(),
+				"address", dlg.Device.GetDNSAddr(),
+				"light", light,
+				"channel", channel)
+			channel += dmxChannelsPerLight
+		}
+	}
+
+	if last := channel - 1; last > dmxChannels {
+		return nil, fmt.Errorf("the lights need channels up to %d, past the end of the universe at %d", last, dmxChannels)
+	}
+
+	return lgs, nil
+}
+
+// channel returns the index into a universe's data of the first channel of a
+// device's light.
+func (db *DMXBridge) channel(device, light int) int {
+	channel := db.Address - 1
+	for _, n := range db.lights[:device] {
+		channel += n * dmxChannelsPerLight
+	}
+
+	return channel + light*dmxChannelsPerLight
+}
+
+// send sends the state of the lights to a console every interval, until ctx
+// is done. A device which can't be read keeps its last values.
+func (db *DMXBridge) send(ctx context.Context, conn io.Writer, interval time.Duration) error {
+	setupCtx, cancel := context.WithTimeout(ctx, db.requestTimeout)
+	lgs, err := db.patch(setupCtx)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	packet := &ArtDmx{Universe: db.Universe, Data: make([]byte, db.channel(len(db.devices), 0))}
+	for {
+		err := forEachDevice(ctx, db.devices, func(ctx context.Context, i int, device Device) error {
+			ctx, cancel := context.WithTimeout(ctx, db.requestTimeout)
+			defer cancel()
+
+			lg, err := device.FetchLightGroup(ctx)
+			if err != nil {
+				return err
+			}
+			lgs[i].LightGroup = lg
+			return nil
+		})
+		if err != nil && ctx.Err() == nil {
+			deviceLog.Warn("Failed to read lights", "error", err)
+		}
+
+		for i, dlg := range lgs {
+			for light := 0; light < min(db.lights[i], len(dlg.LightGroup.Lights)); light++ {
+				c := db.channel(i, light)
+				packet.Data[c], packet.Data[c+1] = lightToDMX(*dlg.LightGroup.Lights[light])
+			}
+		}
+
+		// Sequence 0 means it isn't used, so it counts from 1 and wraps to 1
+		packet.Sequence = packet.Sequence%255 + 1
+		buf, err := packet.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		if _, err := conn.Write(buf); err != nil {
+			return fmt.Errorf("failed to send Art-Net: %w", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-db.clock.After(interval):
+		}
+	}
+}
+
+// receive lets a console control the lights, until ctx is done. Changes are
+// sent to each device as fast as it takes them; those which arrive while a
+// device is busy are merged into its next update.
+func (db *DMXBridge) receive(ctx context.Context, conn net.PacketConn) error {
+	setupCtx, cancel := context.WithTimeout(ctx, db.requestTimeout)
+	lgs, err := db.patch(setupCtx)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	writers := make([]*coalescingWriter, len(db.devices))
+	for i, device := range db.devices {
+		writers[i] = newCoalescingWriter(device, db.requestTimeout)
+		go writers[i].run(ctx)
+	}
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buf := make([]byte, artNetHeaderLen+dmxChannels)
+	packet := &ArtDmx{}
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to receive Art-Net: %w", err)
+		}
+
+		if err := packet.UnmarshalBinary(buf[:n]); err != nil {
+			if !errors.Is(err, errNotArtDmx) {
+				automationLog.Debug("Ignoring packet", "error", err)
+			}
+			continue
+		}
+		if packet.Universe != db.Universe {
+			continue
+		}
+
+		for i, dlg := range lgs {
+			next := dlg.LightGroup.Copy()
+			for light := 0; light < db.lights[i]; light++ {
+				c := db.channel(i, light)
+				if c+1 >= len(packet.Data) {
+					break
+				}
+				applyDMX(next.Lights[light], packet.Data[c], packet.Data[c+1])
+			}
+
+			if !sameLights(dlg.LightGroup, next) {
+				lgs[i].LightGroup = next
+				writers[i].set(next.Copy())
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLightToDMX(t *testing.T) {
+	for _, tc := range []struct {
+		light          keylight.Light
+		intensity, cct byte
+	}{
+		{keylight.Light{On: 1, Brightness: 100, Temperature: minTemperature}, 255, 255},
+		{keylight.Light{On: 1, Brightness: 50, Temperature: maxTemperature}, 128, 0},
+		{keylight.Light{On: 0, Brightness: 50, Temperature: maxTemperature}, 0, 0},
+	} {
+		intensity, cct := lightToDMX(tc.light)
+		require.Equal(t, tc.intensity, intensity)
+		require.Equal(t, tc.cct, cct)
+	}
+}
+
+func TestApplyDMX(t *testing.T) {
+	light := keylight.Light{On: 1, Brightness: 40, Temperature: 200}
+	applyDMX(&light, 0, 0)
+	require.Equal(t, keylight.Light{On: 0, Brightness: 40, Temperature: maxTemperature}, light)
+
+	applyDMX(&light, 255, 255)
+	require.Equal(t, keylight.Light{On: 1, Brightness: 100, Temperature: minTemperature}, light)
+
+	applyDMX(&light, 1, 255)
+	require.Equal(t, minBrightness, light.Brightness)
+
+	// Values survive going to DMX and back, give or take rounding
+	for _, light := range []keylight.Light{
+		{On: 1, Brightness: 40, Temperature: 200},
+		{On: 1, Brightness: 75, Temperature: 300},
+	} {
+		back := keylight.Light{}
+		intensity, cct := lightToDMX(light)
+		applyDMX(&back, intensity, cct)
+		require.InDelta(t, light.Brightness, back.Brightness, 1)
+		require.InDelta(t, miredToKelvin(light.Temperature), miredToKelvin(back.Temperature), 20)
+	}
+}
+
+func twoLightDevices() (*FakeDevice, *FakeDevice) {
+	a := &FakeDevice{
+		DNSAddr:  "a.local",
+		LightGrp: &keylight.LightGroup{Lights: []*keylight.Light{{On: 1, Brightness: 100, Temperature: minTemperature}}},
+	}
+	b := &FakeDevice{
+		DNSAddr: "b.local",
+		LightGrp: &keylight.LightGroup{Lights: []*keylight.Light{
+			{On: 0, Brightness: 50, Temperature: maxTemperature},
+			{On: 1, Brightness: 50, Temperature: maxTemperature},
+		}},
+	}
+
+	return a, b
+}
+
+func TestDMXBridgePatch(t *testing.T) {
+	a, b := twoLightDevices()
+	bridge := &DMXBridge{devices: []Device{a, b}, Address: 10}
+
+	_, err := bridge.patch(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 9, bridge.channel(0, 0))
+	require.Equal(t, 11, bridge.channel(1, 0))
+	require.Equal(t, 13, bridge.channel(1, 1))
+
+	bridge.Address = dmxChannels - 4
+	_, err = bridge.patch(context.Background())
+	require.ErrorContains(t, err, "past the end of the universe")
+}
+
+// packetWriter passes each packet written to it to the test.
+type packetWriter chan []byte
+
+func (pw packetWriter) Write(b []byte) (int, error) {
+	pw <- append([]byte{}, b...)
+	return len(b), nil
+}
+
+func TestDMXBridgeSend(t *testing.T) {
+	a, b := twoLightDevices()
+	clock := newFakeClock()
+	bridge := &DMXBridge{devices: []Device{a, b}, clock: clock, requestTimeout: time.Second, Universe: 3, Address: 1}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	packets := make(packetWriter)
+	done := make(chan error)
+	go func() { done <- bridge.send(ctx, packets, time.Second) }()
+
+	packet := &ArtDmx{}
+	require.NoError(t, packet.UnmarshalBinary(<-packets))
+	require.Equal(t, &ArtDmx{Sequence: 1, Universe: 3, Data: []byte{255, 255, 0, 0, 128, 0}}, packet)
+
+	clock.WaitForTimers(t, 1)
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestDMXBridgeReceive(t *testing.T) {
+	fakeA, b := twoLightDevices()
+	a := newSlowDevice(fakeA)
+	bridge := &DMXBridge{devices: []Device{a, b}, requestTimeout: time.Second, Universe: 3, Address: 1}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- bridge.receive(ctx, conn) }()
+
+	console, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer console.Close()
+
+	send := func(universe uint16, data ...byte) {
+		buf, err := (&ArtDmx{Universe: universe, Data: data}).MarshalBinary()
+		require.NoError(t, err)
+		_, err = console.Write(buf)
+		require.NoError(t, err)
+	}
+
+	// Another universe is ignored, and only the first device changes
+	send(4, 0, 0, 0, 0, 0, 0)
+	send(3, 128, 255, 0, 0, 128, 0)
+	require.Equal(t, keylight.Light{On: 1, Brightness: 50, Temperature: minTemperature}, *(<-a.updates).Lights[0])
+	a.release <- struct{}{}
+
+	cancel()
+	require.NoError(t, <-done)
+}
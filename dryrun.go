@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/endocrimes/keylight-go"
+)
+
+// DryRunDevice wraps a Device and, rather than sending updates to it, prints
+// how they would change its lights. Later reads see the lights as if the
+// updates had been made, so commands which read back what they wrote carry on
+// as they would have.
+type DryRunDevice struct {
+	Device
+	out io.Writer
+
+	mu sync.Mutex
+	// state is the light group as it would be after the updates so far, or
+	// nil before the first.
+	state *keylight.LightGroup
+}
+
+func withDryRun(devices []Device, out io.Writer) []Device {
+	wrapped := make([]Device, 0, len(devices))
+	for _, device := range devices {
+		wrapped = append(wrapped, &DryRunDevice{Device: device, out: out})
+	}
+
+	return wrapped
+}
+
+func (dd *DryRunDevice) FetchLightGroup(ctx context.Context) (*keylight.LightGroup, error) {
+	dd.mu.Lock()
+	state := dd.state
+	dd.mu.Unlock()
+
+	if state != nil {
+		return state.Copy(), nil
+	}
+
+	return dd.Device.FetchLightGroup(ctx)
+}
+
+func (dd *DryRunDevice) UpdateLightGroup(ctx context.Context, lg *keylight.LightGroup) (*keylight.LightGroup, error) {
+	current, err := dd.FetchLightGroup(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	diffs := describeLightChanges(current, lg)
+	if len(diffs) == 0 {
+		diffs = []string{"no change"}
+	}
+	fmt.Fprintf(dd.out, "Would set %s: %s\n", deviceLabel(dd), strings.Join(diffs, "; "))
+
+	dd.mu.Lock()
+	dd.state = lg.Copy()
+	dd.mu.Unlock()
+
+	return lg.Copy(), nil
+}
+
+func (dd *DryRunDevice) UpdateColors(ctx context.Context, cg *ColorGroup) (*ColorGroup, error) {
+	current, err := dd.FetchColors(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []string
+	for i, color := range cg.Lights[:min(len(current.Lights), len(cg.Lights))] {
+		if was := current.Lights[i]; *was != *color {
+			diffs = append(diffs, fmt.Sprintf("light %d colour %s -> %s", i, formatColor(*was), formatColor(*color)))
+		}
+	}
+	if len(diffs) == 0 {
+		diffs = []string{"no change"}
+	}
+	fmt.Fprintf(dd.out, "Would set %s: %s\n", deviceLabel(dd), strings.Join(diffs, "; "))
+
+	return cg.Copy(), nil
+}
+
+func (dd *DryRunDevice) UpdateSettings(ctx context.Context, settings *keylight.DeviceSettings) (*keylight.DeviceSettings, error) {
+	current, err := dd.FetchSettings(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []string
+	for _, f := range []struct {
+		name     string
+		was, now int
+	}{
+		{"power on behaviour", current.PowerOnBehavior, settings.PowerOnBehavior},
+		{"power on brightness", current.PowerOnBrightness, settings.PowerOnBrightness},
+		{"power on temperature", current.PowerOnTemperature, settings.PowerOnTemperature},
+		{"switch on duration", current.SwitchOnDurationMs, settings.SwitchOnDurationMs},
+		{"switch off duration", current.SwitchOffDurationMs, settings.SwitchOffDurationMs},
+		{"colour change duration", current.ColorChangeDurationMs, settings.ColorChangeDurationMs},
+	} {
+		if f.was != f.now {
+			diffs = append(diffs, fmt.Sprintf("%s %d -> %d", f.name, f.was, f.now))
+		}
+	}
+	if len(diffs) == 0 {
+		diffs = []string{"no change"}
+	}
+	fmt.Fprintf(dd.out, "Would set %s settings: %s\n", deviceLabel(dd), strings.Join(diffs, ", "))
+
+	copied := *settings
+	return &copied, nil
+}
+
+// RawRequest sends GET requests, which change nothing, and prints the others.
+// The response is nil for those which aren't sent.
+func (dd *DryRunDevice) RawRequest(ctx context.Context, method, path string, body []byte) (*RawResponse, error) {
+	if method == http.MethodGet || method == http.MethodHead {
+		return dd.Device.RawRequest(ctx, method, path, body)
+	}
+
+	fmt.Fprintf(dd.out, "Would send %s /%s to %s", method, strings.TrimPrefix(path, "/"), deviceLabel(dd))
+	if len(body) > 0 {
+		fmt.Fprintf(dd.out, ": %s", bytes.TrimSpace(body))
+	}
+	fmt.Fprintln(dd.out)
+
+	return nil, nil
+}
+
+// describeLightChanges lists what changes between two states of a device's
+// lights, one entry per light which changes.
+func describeLightChanges(before, after *keylight.LightGroup) []string {
+	unit := statusTemperatureUnit()
+
+	var diffs []string
+	for i, light := range after.Lights[:min(len(before.Lights), len(after.Lights))] {
+		was := before.Lights[i]
+
+		var fields []string
+		if was.On != light.On {
+			fields = append(fields, fmt.Sprintf("power %s -> %s", LightState(was.On), LightState(light.On)))
+		}
+		if was.Brightness != light.Brightness {
+			fields = append(fields, fmt.Sprintf("brightness %d%% -> %d%%", was.Brightness, light.Brightness))
+		}
+		if was.Temperature != light.Temperature {
+			fields = append(fields, fmt.Sprintf("temperature %s -> %s", unit.Format(was.Temperature), unit.Format(light.Temperature)))
+		}
+
+		if len(fields) > 0 {
+			diffs = append(diffs, fmt.Sprintf("light %d %s", i, strings.Join(fields, ", ")))
+		}
+	}
+
+	return diffs
+}
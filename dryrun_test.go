@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRunDevice(t *testing.T) {
+	ctx := context.Background()
+
+	fake := &FakeDevice{
+		Name:    "Key Light",
+		DNSAddr: "a.local",
+		LightGrp: &keylight.LightGroup{Count: 2, Lights: []*keylight.Light{
+			{On: 0, Brightness: 20, Temperature: 200},
+			{On: 1, Brightness: 50, Temperature: 250},
+		}},
+	}
+	var out bytes.Buffer
+	device := withDryRun([]Device{fake}, &out)[0]
+
+	lg, err := device.FetchLightGroup(ctx)
+	require.NoError(t, err)
+
+	lg = lg.Copy()
+	lg.Lights[0].On = 1
+	lg.Lights[0].Brightness = 40
+	lg.Lights[0].Temperature = 250
+	_, err = device.UpdateLightGroup(ctx, lg)
+	require.NoError(t, err)
+
+	require.Equal(t, "Would set Key Light: light 0 power off -> on, brightness 20% -> 40%, temperature 5000K -> 4000K\n", out.String())
+
+	// The device itself is left alone
+	require.Equal(t, 0, fake.LightGrp.Lights[0].On)
+	require.Equal(t, 20, fake.LightGrp.Lights[0].Brightness)
+
+	// But reads see the change, so the next one is compared with it
+	lg, err = device.FetchLightGroup(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, lg.Lights[0].On)
+	require.Equal(t, 40, lg.Lights[0].Brightness)
+
+	out.Reset()
+	_, err = device.UpdateLightGroup(ctx, lg)
+	require.NoError(t, err)
+	require.Equal(t, "Would set Key Light: no change\n", out.String())
+}
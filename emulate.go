@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+)
+
+// The mDNS service Key Lights announce themselves as.
+const elgatoService = "_elg._tcp"
+
+// emulatedBackend is a light which isn't a Key Light, which klctl emulate
+// makes look like one.
+type emulatedBackend interface {
+	// Name is what the light calls itself, if anything.
+	Name(ctx context.Context) (string, error)
+	Light(ctx context.Context) (*keylight.Light, error)
+	// SetLight changes the light over duration.
+	SetLight(ctx context.Context, light *keylight.Light, duration time.Duration) error
+}
+
+// emulatedBackends are the kinds of light klctl emulate can make look like a
+// Key Light, by name, with how to reach one at an address.
+var emulatedBackends = map[string]func(addr string) (emulatedBackend, error){
+	"lifx": func(addr string) (emulatedBackend, error) { return newLIFXLight(addr) },
+}
+
+func emulatedBackendNames() []string {
+	names := make([]string, 0, len(emulatedBackends))
+	for name := range emulatedBackends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Emulator serves the Key Light HTTP API for a light which isn't one, so
+// software which only speaks that API, such as Control Center, can control
+// it.
+type Emulator struct {
+	backend emulatedBackend
+	info    keylight.DeviceInfo
+
+	// requestTimeout bounds each request to the backend.
+	requestTimeout time.Duration
+
+	mu sync.Mutex
+	// settings are kept here, as the backend has nowhere to keep them. The
+	// switch and colour change durations are used for changes.
+	settings keylight.DeviceSettings
+}
+
+func newEmulator(backend emulatedBackend, name, addr string, requestTimeout time.Duration) *Emulator {
+	// A stable serial number, so the light is recognised as the same one
+	// each time
+	h := fnv.New32a()
+	h.Write([]byte(addr))
+
+	return &Emulator{
+		backend: backend,
+		info: keylight.DeviceInfo{
+			ProductName:         "Elgato Key Light",
+			HardwareBoardType:   53,
+			FirmwareBuildNumber: 218,
+			FirmwareVersion:     "1.0.3",
+			SerialNumber:        fmt.Sprintf("KLCTL%08X", h.Sum32()),
+			DisplayName:         name,
+			Features:            []string{"lights"},
+		},
+		requestTimeout: requestTimeout,
+		settings: keylight.DeviceSettings{
+			PowerOnBrightness:     20,
+			PowerOnTemperature:    213,
+			SwitchOnDurationMs:    100,
+			SwitchOffDurationMs:   300,
+			ColorChangeDurationMs: 100,
+		},
+	}
+}
+
+// text returns the TXT records Key Lights announce.
+func (e *Emulator) text() []string {
+	return []string{
+		"mf=Elgato",
+		"dt=" + fmt.Sprint(e.info.HardwareBoardType),
+		"id=" + e.info.SerialNumber,
+		"md=" + e.info.ProductName,
+		"pv=1.0",
+	}
+}
+
+// lightUpdate is a change to a light, in which only the fields given change,
+// as Key Lights take them.
+type lightUpdate struct {
+	On          *int `json:"on"`
+	Brightness  *int `json:"brightness"`
+	Temperature *int `json:"temperature"`
+}
+
+func (e *Emulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), e.requestTimeout)
+	defer cancel()
+
+	var body any
+	var err error
+
+	switch route := r.Method + " " + strings.TrimSuffix(r.URL.Path, "/"); route {
+	case "GET /elgato/accessory-info":
+		body = e.info
+
+	case "GET /elgato/lights":
+		body, err = e.lights(ctx)
+
+	case "PUT /elgato/lights":
+		var update struct {
+			Lights []lightUpdate `json:"lights"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil || len(update.Lights) == 0 {
+			http.Error(w, "invalid light group", http.StatusBadRequest)
+			return
+		}
+		body, err = e.update(ctx, update.Lights[0])
+
+	case "GET /elgato/lights/settings":
+		e.mu.Lock()
+		body = e.settings
+		e.mu.Unlock()
+
+	case "PUT /elgato/lights/settings":
+		var settings keylight.DeviceSettings
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			http.Error(w, "invalid settings", http.StatusBadRequest)
+			return
+		}
+		e.mu.Lock()
+		e.settings = settings
+		e.mu.Unlock()
+		body = settings
+
+	case "POST /elgato/identify":
+		err = e.identify(ctx)
+
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if err != nil {
+		deviceLog.Warn("Failed to reach the emulated light", "error", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if body != nil {
+		_ = json.NewEncoder(w).Encode(body)
+	}
+}
+
+func (e *Emulator) lights(ctx context.Context) (*keylight.LightGroup, error) {
+	light, err := e.backend.Light(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &keylight.LightGroup{Count: 1, Lights: []*keylight.Light{light}}, nil
+}
+
+// update applies a change to the light, over the duration the settings give
+// for it, and returns the light group as it is then.
+func (e *Emulator) update(ctx context.Context, update lightUpdate) (*keylight.LightGroup, error) {
+	light, err := e.backend.Light(ctx)
+	if err != nil {
+		return nil, err
+	}
+	wasOn := light.On
+
+	if update.On != nil {
+		light.On = *update.On
+	}
+	if update.Brightness != nil {
+		light.Brightness = max(0, min(100, *update.Brightness))
+	}
+	if update.Temperature != nil {
+		light.Temperature = max(minTemperature, min(maxTemperature, *update.Temperature))
+	}
+
+	e.mu.Lock()
+	duration := e.settings.ColorChangeDurationMs
+	switch {
+	case light.On != wasOn && light.On == int(LightOn):
+		duration = e.settings.SwitchOnDurationMs
+	case light.On != wasOn:
+		duration = e.settings.SwitchOffDurationMs
+	}
+	e.mu.Unlock()
+
+	if err := e.backend.SetLight(ctx, light, time.Duration(duration)*time.Millisecond); err != nil {
+		return nil, err
+	}
+
+	return &keylight.LightGroup{Count: 1, Lights: []*keylight.Light{light}}, nil
+}
+
+// identify flashes the light, as Key Lights do, and puts it back.
+func (e *Emulator) identify(ctx context.Context) error {
+	light, err := e.backend.Light(ctx)
+	if err != nil {
+		return err
+	}
+
+	flash := light.Copy()
+	flash.On, flash.Brightness = int(LightOn), 100
+	if light.On == int(LightOn) && light.Brightness > 50 {
+		flash.Brightness = 5
+	}
+
+	if err := e.backend.SetLight(ctx, flash, 0); err != nil {
+		return err
+	}
+
+	select {
+	case <-time.After(300 * time.Millisecond):
+	case <-ctx.Done():
+	}
+
+	return e.backend.SetLight(context.WithoutCancel(ctx), light, 0)
+}
+
+// runEmulator serves the Key Light API for the emulator's light on addr,
+// announced with mDNS so it's found as a Key Light would be, until ctx is
+// done.
+func runEmulator(ctx context.Context, addr string, emulator *Emulator) error {
+	stop, err := announce(emulator.info.DisplayName, elgatoService, addr, emulator.text())
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	apiLog.Info("Emulating a Key Light", "address", addr, "name", emulator.info.DisplayName, "serial", emulator.info.SerialNumber)
+	return serveHTTP(ctx, addr, emulator)
+}
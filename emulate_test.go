@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBackend is an emulatedBackend which records the changes made to it.
+type fakeBackend struct {
+	light     keylight.Light
+	durations []time.Duration
+	err       error
+}
+
+func (f *fakeBackend) Name(ctx context.Context) (string, error) {
+	return "fake", f.err
+}
+
+func (f *fakeBackend) Light(ctx context.Context) (*keylight.Light, error) {
+	return f.light.Copy(), f.err
+}
+
+func (f *fakeBackend) SetLight(ctx context.Context, light *keylight.Light, duration time.Duration) error {
+	f.light = *light
+	f.durations = append(f.durations, duration)
+	return f.err
+}
+
+func TestEmulator(t *testing.T) {
+	backend := &fakeBackend{light: keylight.Light{On: 0, Brightness: 20, Temperature: 200}}
+	emulator := newEmulator(backend, "Desk lamp", "192.168.1.50", time.Second)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		emulator.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	w := do(http.MethodGet, "/elgato/accessory-info", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var info keylight.DeviceInfo
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&info))
+	require.Equal(t, "Desk lamp", info.DisplayName)
+	require.Equal(t, newEmulator(backend, "", "192.168.1.50", time.Second).info.SerialNumber, info.SerialNumber)
+
+	// Only the fields given change, over the switch-on duration
+	w = do(http.MethodPut, "/elgato/lights", `{"numberOfLights":1,"lights":[{"on":1}]}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var lg keylight.LightGroup
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&lg))
+	require.Equal(t, keylight.Light{On: 1, Brightness: 20, Temperature: 200}, *lg.Lights[0])
+	require.Equal(t, keylight.Light{On: 1, Brightness: 20, Temperature: 200}, backend.light)
+	require.Equal(t, []time.Duration{100 * time.Millisecond}, backend.durations)
+
+	// Settings are kept, and used for changes
+	w = do(http.MethodPut, "/elgato/lights/settings", `{"colorChangeDurationMs":500}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	w = do(http.MethodPut, "/elgato/lights", `{"lights":[{"brightness":150,"temperature":250}]}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, keylight.Light{On: 1, Brightness: 100, Temperature: 250}, backend.light)
+	require.Equal(t, 500*time.Millisecond, backend.durations[1])
+
+	w = do(http.MethodGet, "/elgato/lights", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"numberOfLights":1,"lights":[{"on":1,"brightness":100,"temperature":250}]}`, w.Body.String())
+
+	require.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/elgato/lights", `{"lights":[]}`).Code)
+	require.Equal(t, http.StatusNotFound, do(http.MethodGet, "/elgato/nothing", "").Code)
+
+	backend.err = errors.New("no answer")
+	require.Equal(t, http.StatusBadGateway, do(http.MethodGet, "/elgato/lights", "").Code)
+}
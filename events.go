@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// How many events a slow listener can fall behind by before it misses some.
+const eventBuffer = 64
+
+// How long writing an event to a listener may take before it's given up on.
+const eventWriteTimeout = 10 * time.Second
+
+// EventHub polls the lights and pushes each change to everyone listening at
+// /ws, so dashboards and overlays can update live without polling klctl
+// themselves. Changes made through the API are picked up straight away.
+type EventHub struct {
+	watcher *Watcher
+
+	mu        sync.Mutex
+	listeners map[chan WatchEvent]struct{}
+
+	// poke asks for a poll before the next is due.
+	poke chan struct{}
+}
+
+func newEventHub(devices []Device, requestTimeout time.Duration) *EventHub {
+	return &EventHub{
+		watcher:   newWatcher(devices, requestTimeout),
+		listeners: map[chan WatchEvent]struct{}{},
+		poke:      make(chan struct{}, 1),
+	}
+}
+
+// subscribe returns a channel of events, and a function to stop them.
+func (h *EventHub) subscribe() (<-chan WatchEvent, func()) {
+	events := make(chan WatchEvent, eventBuffer)
+
+	h.mu.Lock()
+	h.listeners[events] = struct{}{}
+	h.mu.Unlock()
+
+	return events, func() {
+		h.mu.Lock()
+		delete(h.listeners, events)
+		h.mu.Unlock()
+	}
+}
+
+// publish passes events to every listener. A listener which has fallen too far
+// behind misses them, rather than holding up the others.
+func (h *EventHub) publish(events []WatchEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for listener := range h.listeners {
+		for _, event := range events {
+			select {
+			case listener <- event:
+			default:
+				apiLog.Debug("Event listener is behind, dropping event")
+			}
+		}
+	}
+}
+
+// changed says the lights have been changed through the API, so they should
+// be polled now rather than when the next poll is due.
+func (h *EventHub) changed() {
+	select {
+	case h.poke <- struct{}{}:
+	default:
+	}
+}
+
+// run polls the lights every interval until ctx is done.
+func (h *EventHub) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		h.publish(h.watcher.poll(ctx))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-h.poke:
+		}
+	}
+}
+
+// The events are read-only, so pages from anywhere, such as an overlay loaded
+// from a file, may listen to them.
+var eventUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// ServeHTTP sends events to a WebSocket client, one JSON object per message,
+// until it goes away.
+func (h *EventHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := eventUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already responded
+		apiLog.Debug("Failed to upgrade to WebSocket", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	apiLog.Info("Event listener connected", "remote", r.RemoteAddr)
+	defer apiLog.Info("Event listener disconnected", "remote", r.RemoteAddr)
+
+	events, unsubscribe := h.subscribe()
+	defer unsubscribe()
+
+	// Nothing is expected from the client, but reading is how a close is
+	// noticed
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-gone:
+			return
+		case <-r.Context().Done():
+			return
+		case event := <-events:
+			conn.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+			if err := conn.WriteJSON(event); err != nil {
+				apiLog.Debug("Failed to send event", "error", err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventHub(t *testing.T) {
+	server, _ := newTestAPIServer()
+	server.events = newEventHub(server.devices, time.Second)
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.Eventually(t, func() bool {
+		server.events.mu.Lock()
+		defer server.events.mu.Unlock()
+		return len(server.events.listeners) == 1
+	}, time.Second, time.Millisecond)
+
+	sent := WatchEvent{
+		Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		Address: "192.168.1.1",
+		Name:    "key-left",
+		Change:  Change{Field: "brightness", Old: 20, New: 40},
+	}
+	server.events.publish([]WatchEvent{sent})
+
+	var received WatchEvent
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	require.NoError(t, conn.ReadJSON(&received))
+	require.Equal(t, sent, received)
+
+	// Closing the connection unsubscribes it
+	conn.Close()
+	require.Eventually(t, func() bool {
+		server.events.mu.Lock()
+		defer server.events.mu.Unlock()
+		return len(server.events.listeners) == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestEventHubChangedDoesNotBlock(t *testing.T) {
+	hub := newEventHub(nil, time.Second)
+
+	hub.changed()
+	hub.changed()
+
+	require.Len(t, hub.poke, 1)
+}
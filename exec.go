@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"slices"
+
+	"github.com/urfave/cli/v2"
+)
+
+// globalArgsBefore returns the global flags klctl was run with, which are
+// everything on its command line before command. Commands run later, such as
+// on a schedule, are given them too.
+func globalArgsBefore(command string) []string {
+	args := os.Args[1:]
+	if i := slices.Index(args, command); i >= 0 {
+		return args[:i:i]
+	}
+
+	return args
+}
+
+// runKlctl runs klctl again as a child process with the given arguments,
+// sharing our standard streams. If the child fails, we exit with its status.
+// env is added to our environment for the child.
+func runKlctl(ctx context.Context, args []string, env ...string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, exe, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), env...)
+
+	err = cmd.Run()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// The command has already reported its own error
+		return cli.Exit("", exitErr.ExitCode())
+	}
+
+	return err
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Exit codes, so scripts can tell why klctl failed. Anything not covered below
+// exits with exitFailure. A discovery timeout exits with exitNoDevices too.
+const (
+	exitFailure         = 1
+	exitNoDevices       = 2
+	exitUnreachable     = 3
+	exitInvalidArgument = 4
+	exitPartialFailure  = 5
+)
+
+// categorizedError is an error which klctl exits with a particular code for.
+// It implements cli.ExitCoder.
+type categorizedError struct {
+	err  error
+	code int
+}
+
+func (e *categorizedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *categorizedError) Unwrap() error {
+	return e.err
+}
+
+func (e *categorizedError) ExitCode() int {
+	return e.code
+}
+
+// errNoDevices is returned when there are no lights to control.
+var errNoDevices = &categorizedError{errors.New("no lights found"), exitNoDevices}
+
+// invalidArgument marks err as caused by what klctl was asked to do.
+func invalidArgument(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &categorizedError{err, exitInvalidArgument}
+}
+
+// invalidArgumentf is invalidArgument for a new error.
+func invalidArgumentf(format string, a ...any) error {
+	return invalidArgument(fmt.Errorf(format, a...))
+}
+
+// unreachable marks err as a device not answering.
+func unreachable(err error) error {
+	return &categorizedError{err, exitUnreachable}
+}
+
+// partialFailure marks err as some devices failing while others succeeded.
+func partialFailure(err error) error {
+	return &categorizedError{err, exitPartialFailure}
+}
+
+// isUnreachable reports whether err is from a device which couldn't be
+// reached at all, rather than one which answered with an error.
+func isUnreachable(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// exitCode returns the code klctl exits with for err.
+func exitCode(err error) int {
+	var coder cli.ExitCoder
+	if errors.As(err, &coder) {
+		return coder.ExitCode()
+	}
+
+	if isUnreachable(err) {
+		return exitUnreachable
+	}
+
+	return exitFailure
+}
+
+// usageError reports a flag which couldn't be parsed as an invalid argument,
+// rather than showing the whole of the command's help.
+func usageError(c *cli.Context, err error, isSubcommand bool) error {
+	return invalidArgumentf("%w, see %s --help", err, helpName(c))
+}
+
+// unknownCommand reports the first argument as a command which doesn't exist.
+func unknownCommand(c *cli.Context) error {
+	return invalidArgumentf("unknown command %q, see %s --help", c.Args().First(), helpName(c))
+}
+
+// helpName returns how to run the command c is for.
+func helpName(c *cli.Context) string {
+	if c.Command != nil && c.Command.HelpName != "" {
+		return c.Command.HelpName
+	}
+
+	return c.App.HelpName
+}
+
+// showSubcommands shows a command's subcommands, or reports an unknown one.
+// Otherwise the CLI reports an unknown subcommand with its own exit code.
+func showSubcommands(c *cli.Context) error {
+	if c.Args().Present() {
+		return unknownCommand(c)
+	}
+
+	return cli.ShowSubcommandHelp(c)
+}
+
+// setUsageErrors sets usageError on commands and all of their subcommands, and
+// has commands which only group others report unknown ones as invalid.
+func setUsageErrors(commands []*cli.Command) {
+	for _, command := range commands {
+		if command.OnUsageError == nil {
+			command.OnUsageError = usageError
+		}
+		if command.Action == nil && len(command.Subcommands) > 0 {
+			command.Action = showSubcommands
+		}
+		setUsageErrors(command.Subcommands)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/iainlane/klctl/pkg/keylightctl"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestExitCode(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"other", errors.New("broken"), exitFailure},
+		{"no devices", errNoDevices, exitNoDevices},
+		{"discovery timeout", &keylightctl.DiscoveryTimeoutError{}, exitNoDevices},
+		{"invalid argument", invalidArgumentf("--interval must be positive"), exitInvalidArgument},
+		{"wrapped", fmt.Errorf("while setting: %w", invalidArgumentf("bad")), exitInvalidArgument},
+		{"timeout", fmt.Errorf("a.local: %w", context.DeadlineExceeded), exitUnreachable},
+		{"command", cli.Exit("", 7), 7},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, exitCode(tc.err))
+		})
+	}
+}
+
+func TestInvalidArgumentNil(t *testing.T) {
+	require.NoError(t, invalidArgument(nil))
+}
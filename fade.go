@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+)
+
+// fadeStepInterval is how often a fading light is updated. Each step is an
+// HTTP request, so this is a rate limit: faster doesn't look any smoother, and
+// just floods the light.
+const fadeStepInterval = 100 * time.Millisecond
+
+// minBrightness is the dimmest a light goes while still being on. Fading on
+// starts here, and fading off ends here.
+const minBrightness = 3
+
+func lerp(from, to int, t float64) int {
+	return from + int(math.Round(float64(to-from)*t))
+}
+
+// fadeFrame returns the state of a light t (0 to 1) of the way through a fade
+// from one state to another. Lights being switched on or off stay on for the
+// whole fade and ramp from or to minBrightness.
+func fadeFrame(from, to keylight.Light, t float64) keylight.Light {
+	startBrightness, endBrightness := from.Brightness, to.Brightness
+
+	switch {
+	case from.On == 0 && to.On == 0:
+		return to
+	case from.On == 0:
+		startBrightness = minBrightness
+	case to.On == 0:
+		endBrightness = minBrightness
+	}
+
+	return keylight.Light{
+		On:          1,
+		Brightness:  lerp(startBrightness, endBrightness, t),
+		Temperature: lerp(from.Temperature, to.Temperature, t),
+	}
+}
+
+// beforeChanges returns a copy of lg as it was before changes were made to it.
+func beforeChanges(lg *keylight.LightGroup, changes []Change) *keylight.LightGroup {
+	before := lg.Copy()
+
+	for _, change := range changes {
+		light := before.Lights[change.Light]
+
+		switch change.Field {
+		case "on":
+			light.On = change.Old
+		case ControlBrightness.String():
+			light.Brightness = change.Old
+		case ControlTemperature.String():
+			light.Temperature = change.Old
+		}
+	}
+
+	return before
+}
+
+// fadeLightGroup moves a device's lights from one state to another over the
+// given duration, in steps of fadeStepInterval. The final update is always
+// exactly to, so an interrupted fade can be finished by running it again.
+func fadeLightGroup(ctx context.Context, clock Clock, device Device, from, to *keylight.LightGroup, duration time.Duration) error {
+	steps := int(duration / fadeStepInterval)
+	if steps < 1 {
+		steps = 1
+	}
+
+	deviceLog.Debug("Fading lights",
+		"address", device.GetDNSAddr(),
+		"duration", duration,
+		"steps", steps)
+
+	// Steps are timed from the start, so the time taken by each update
+	// doesn't add up over the fade
+	start := clock.Now()
+	interval := duration / time.Duration(steps)
+
+	var previous *keylight.LightGroup
+	for step := 1; step < steps; step++ {
+		frame := to.Copy()
+		for i, light := range frame.Lights {
+			*light = fadeFrame(*from.Lights[i], *to.Lights[i], float64(step)/float64(steps))
+		}
+
+		if previous == nil || !sameLights(previous, frame) {
+			if _, err := device.UpdateLightGroup(ctx, frame); err != nil {
+				return err
+			}
+			previous = frame
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-clock.After(start.Add(time.Duration(step) * interval).Sub(clock.Now())):
+		}
+	}
+
+	_, err := device.UpdateLightGroup(ctx, to)
+	return err
+}
+
+func sameLights(a, b *keylight.LightGroup) bool {
+	if len(a.Lights) != len(b.Lights) {
+		return false
+	}
+
+	for i := range a.Lights {
+		if *a.Lights[i] != *b.Lights[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFadeFrame(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to keylight.Light
+		want     keylight.Light
+	}{
+		{
+			name: "brightness",
+			from: keylight.Light{On: 1, Brightness: 10, Temperature: 200},
+			to:   keylight.Light{On: 1, Brightness: 50, Temperature: 300},
+			want: keylight.Light{On: 1, Brightness: 30, Temperature: 250},
+		},
+		{
+			name: "on",
+			from: keylight.Light{On: 0, Brightness: 53, Temperature: 200},
+			to:   keylight.Light{On: 1, Brightness: 53, Temperature: 200},
+			want: keylight.Light{On: 1, Brightness: 28, Temperature: 200},
+		},
+		{
+			name: "off",
+			from: keylight.Light{On: 1, Brightness: 53, Temperature: 200},
+			to:   keylight.Light{On: 0, Brightness: 53, Temperature: 200},
+			want: keylight.Light{On: 1, Brightness: 28, Temperature: 200},
+		},
+		{
+			name: "stays off",
+			from: keylight.Light{On: 0, Brightness: 10, Temperature: 200},
+			to:   keylight.Light{On: 0, Brightness: 50, Temperature: 200},
+			want: keylight.Light{On: 0, Brightness: 50, Temperature: 200},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, fadeFrame(tt.from, tt.to, 0.5))
+		})
+	}
+}
+
+func TestBeforeChanges(t *testing.T) {
+	lg := &keylight.LightGroup{Count: 1, Lights: []*keylight.Light{{On: 1, Brightness: 50, Temperature: 200}}}
+
+	before := beforeChanges(lg, []Change{
+		{Light: 0, Field: "on", Old: 0, New: 1},
+		{Light: 0, Field: "brightness", Old: 20, New: 50},
+	})
+
+	require.Equal(t, keylight.Light{On: 0, Brightness: 20, Temperature: 200}, *before.Lights[0])
+	require.Equal(t, 50, lg.Lights[0].Brightness)
+}
+
+func TestFadeLightGroup(t *testing.T) {
+	device := &recordingDevice{FakeDevice: &FakeDevice{DNSAddr: "a.local"}}
+	from := &keylight.LightGroup{Count: 1, Lights: []*keylight.Light{{On: 1, Brightness: 53, Temperature: 200}}}
+	to := &keylight.LightGroup{Count: 1, Lights: []*keylight.Light{{On: 0, Brightness: 53, Temperature: 200}}}
+
+	clock := newFakeClock()
+	done := make(chan error)
+	go func() {
+		done <- fadeLightGroup(context.Background(), clock, device, from, to, 4*fadeStepInterval)
+	}()
+
+	for step := 1; step < 4; step++ {
+		clock.WaitForTimers(t, 1)
+		clock.Advance(fadeStepInterval)
+	}
+	require.NoError(t, <-done)
+
+	var brightness []int
+	for _, lg := range device.updates {
+		brightness = append(brightness, lg.Lights[0].Brightness)
+	}
+	require.Equal(t, []int{40, 28, 15, 53}, brightness)
+
+	last := device.updates[len(device.updates)-1]
+	require.Equal(t, keylight.Light{On: 0, Brightness: 53, Temperature: 200}, *last.Lights[0])
+
+	// An interrupted fade gives up part way
+	device.updates = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	require.ErrorIs(t, fadeLightGroup(ctx, clock, device, from, to, time.Hour), context.Canceled)
+	require.Len(t, device.updates, 1)
+}
@@ -5,23 +5,31 @@ go 1.21
 toolchain go1.21.0
 
 require (
+	github.com/eclipse/paho.mqtt.golang v1.4.3
 	github.com/endocrimes/keylight-go v0.0.0-20201110202118-a45c372ed336
-	github.com/sirupsen/logrus v1.9.3
+	github.com/gorilla/websocket v1.5.0
+	github.com/oleksandr/bonjour v0.0.0-20210301155756-30f43c61b915
 	github.com/stretchr/testify v1.10.0
 	github.com/urfave/cli/v2 v2.27.5
+	github.com/zalando/go-keyring v0.2.6
+	golang.org/x/crypto v0.31.0
+	golang.org/x/sync v0.8.0
+	golang.org/x/term v0.27.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
+	al.essio.dev/pkg/shellescape v1.5.1 // indirect
 	github.com/cpuguy83/go-md2man/v2 v2.0.5 // indirect
+	github.com/danieljoos/wincred v1.2.2 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/godbus/dbus/v5 v5.1.0 // indirect
 	github.com/miekg/dns v1.1.55 // indirect
-	github.com/oleksandr/bonjour v0.0.0-20210301155756-30f43c61b915 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
 	github.com/xrash/smetrics v0.0.0-20240521201337-686a1a2994c1 // indirect
 	golang.org/x/mod v0.11.0 // indirect
-	golang.org/x/net v0.11.0 // indirect
-	golang.org/x/sys v0.9.0 // indirect
+	golang.org/x/net v0.21.0 // indirect
+	golang.org/x/sys v0.28.0 // indirect
 	golang.org/x/tools v0.10.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// requireGolden compares got with testdata/golden/name. With -update, the file
+// is rewritten instead.
+func requireGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", "golden", name)
+	if *updateGolden {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, got, 0o644))
+	}
+
+	want, err := os.ReadFile(path)
+	require.NoError(t, err, "run go test -update to create the golden files")
+	require.Equal(t, string(want), string(got), "run go test -update if the change is intended")
+}
+
+// goldenDevices are lights in a fixed state, one of which only partly
+// answers.
+func goldenDevices() []Device {
+	return []Device{
+		&FakeDevice{
+			Name:       "key-left",
+			DNSAddr:    "192.168.1.1",
+			Port:       9123,
+			DeviceInfo: &keylight.DeviceInfo{ProductName: "Elgato Key Light", SerialNumber: "CW12AB345678", FirmwareVersion: "1.0.3"},
+			DeviceSet:  &keylight.DeviceSettings{PowerOnBehavior: 1, PowerOnBrightness: 20, PowerOnTemperature: 213},
+			Wifi:       &WifiInfo{SSID: "studio", FrequencyMHz: 2400, RSSI: -58},
+			LightGrp:   &keylight.LightGroup{Count: 1, Lights: []*keylight.Light{{On: 1, Brightness: 40, Temperature: 200}}},
+		},
+		&FakeDevice{
+			Name:                     "key-right",
+			DNSAddr:                  "192.168.1.2",
+			Port:                     9123,
+			DeviceInfo:               &keylight.DeviceInfo{ProductName: "Elgato Key Light Air", SerialNumber: "CW98ZY765432"},
+			FetchDeviceSettingsError: errors.New("connection reset by peer"),
+			LightGrp:                 &keylight.LightGroup{Count: 1, Lights: []*keylight.Light{{On: 0, Brightness: 75, Temperature: 300}}},
+		},
+	}
+}
+
+// TestGoldenOutput renders everything klctl prints in every output format, and
+// compares it with the golden files.
+func TestGoldenOutput(t *testing.T) {
+	// Times are shown in local time, so fix it
+	local := time.Local
+	time.Local = time.UTC
+	t.Cleanup(func() { time.Local = local })
+
+	clock := newFakeClock()
+	ctx := context.Background()
+
+	for _, test := range []struct {
+		name   string
+		render func(w io.Writer, format string) error
+
+		// formats are the formats rendered besides text and JSON.
+		formats []string
+	}{
+		{
+			name: "values",
+			render: func(w io.Writer, format string) error {
+				return renderValues(w, format, ControlBrightness, "", AggregateList, []int{40, 75})
+			},
+		},
+		{
+			name: "light-values",
+			render: func(w io.Writer, format string) error {
+				lights, err := getLightValues(ctx, goldenDevices(), ControlTemperature)
+				if err != nil {
+					return err
+				}
+				return renderLightValues(w, format, ControlTemperature, UnitMired, lights)
+			},
+		},
+		{
+			name: "status",
+			render: func(w io.Writer, format string) error {
+				statuses, err := fetchDeviceStatuses(ctx, goldenDevices(), false)
+				if err != nil {
+					return err
+				}
+				return renderStatuses(w, format, statuses, UnitKelvin, false)
+			},
+			formats: []string{OutputWide, OutputYAML},
+		},
+		{
+			name: "discovered",
+			render: func(w io.Writer, format string) error {
+				lookupHost := func(context.Context, string) ([]string, error) {
+					return []string{"192.168.1.1"}, nil
+				}
+				return renderDiscovered(w, format, describeDevices(ctx, goldenDevices()[:1], lookupHost))
+			},
+		},
+		{
+			name: "result",
+			render: func(w io.Writer, format string) error {
+				result, err := setLightState(ctx, goldenDevices(), LightToggle)
+				if err != nil {
+					return err
+				}
+
+				result = result.finish()
+				result.DurationMS = 0
+				for i := range result.Devices {
+					result.Devices[i].DurationMS = 0
+				}
+				return renderResult(w, format, result)
+			},
+		},
+		{
+			name: "claims",
+			render: func(w io.Writer, format string) error {
+				return renderClaims(w, format, Claims{
+					"CW12AB345678": {Owner: "alice", ClaimedAt: clock.Now()},
+					"CW98ZY765432": {Owner: "bob", ClaimedAt: clock.Now().Add(time.Hour)},
+				})
+			},
+		},
+		{
+			name: "groups",
+			render: func(w io.Writer, format string) error {
+				return renderGroups(w, format, &Config{Groups: map[string][]string{
+					"desk":  {"key-left", "key-right"},
+					"video": {"192.168.1.3"},
+				}})
+			},
+		},
+		{
+			name: "watch",
+			render: func(w io.Writer, format string) error {
+				watcher := newWatcher(goldenDevices()[:1], time.Second)
+				watcher.poll(ctx)
+				watcher.devices[0].(*FakeDevice).LightGrp.Lights[0].On = 0
+
+				for _, event := range watcher.poll(ctx) {
+					event.Time = clock.Now()
+					var err error
+					if format == OutputJSON {
+						err = writeJSON(w, event)
+					} else {
+						_, err = fmt.Fprintln(w, event)
+					}
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+	} {
+		for _, format := range append([]string{OutputText, OutputJSON}, test.formats...) {
+			t.Run(test.name+"/"+format, func(t *testing.T) {
+				var buf bytes.Buffer
+				require.NoError(t, test.render(&buf, format))
+				requireGolden(t, test.name+"."+format, buf.Bytes())
+			})
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/urfave/cli/v2"
+)
+
+// LightGuard decides whether a light should be changed, based on its device,
+// its position in the device's light group and its current state. Guards let
+// a single invocation express conditional updates without racing a separate
+// get.
+type LightGuard func(device Device, index int, light *keylight.Light) bool
+
+// indexFlag selects individual lights within a device's light group, for
+// devices with more than one.
+var indexFlag = &cli.StringSliceFlag{
+	Name:    "index",
+	Aliases: []string{"light-id"},
+	Usage:   "Only use the light at this position in each device's group, counting from 0, or with this name from the config file (can be repeated)",
+}
+
+var guardFlags = []cli.Flag{
+	indexFlag,
+	&cli.BoolFlag{
+		Name:  "if-on",
+		Usage: "Only change lights which are on",
+	},
+	&cli.BoolFlag{
+		Name:  "if-off",
+		Usage: "Only change lights which are off",
+	},
+	&cli.IntFlag{
+		Name:  "if-brightness-below",
+		Usage: "Only change lights whose brightness is below this value",
+	},
+}
+
+func guardsFromFlags(c *cli.Context) ([]LightGuard, error) {
+	if c.Bool("if-on") && c.Bool("if-off") {
+		return nil, invalidArgumentf("--if-on and --if-off can't be used together")
+	}
+
+	guards, err := indexGuards(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Bool("if-on") {
+		guards = append(guards, func(_ Device, _ int, light *keylight.Light) bool { return light.On == 1 })
+	}
+
+	if c.Bool("if-off") {
+		guards = append(guards, func(_ Device, _ int, light *keylight.Light) bool { return light.On == 0 })
+	}
+
+	if c.IsSet("if-brightness-below") {
+		below := c.Int("if-brightness-below")
+		guards = append(guards, func(_ Device, _ int, light *keylight.Light) bool { return light.Brightness < below })
+	}
+
+	return guards, nil
+}
+
+// indexGuards returns a guard which only allows the lights selected with
+// --index, if it was given. Lights are selected by index, or by the names
+// indexNames gives them.
+func indexGuards(c *cli.Context) ([]LightGuard, error) {
+	if !c.IsSet(indexFlag.Name) {
+		return nil, nil
+	}
+
+	selected := map[int]bool{}
+	named := map[string]bool{}
+	for _, s := range c.StringSlice(indexFlag.Name) {
+		// Names can't be numbers, so anything which is one is an index
+		if _, err := strconv.Atoi(strings.TrimSpace(s)); err == nil {
+			index, err := parseLightIndex(s)
+			if err != nil {
+				return nil, invalidArgument(err)
+			}
+			selected[index] = true
+			continue
+		}
+
+		if !indexNames.has(s) {
+			return nil, invalidArgumentf("--index %s isn't a light index or a light named in the config file", s)
+		}
+		named[s] = true
+	}
+
+	return []LightGuard{func(device Device, index int, _ *keylight.Light) bool {
+		return selected[index] || named[indexNames.of(device)[index]]
+	}}, nil
+}
+
+// guardsAllow reports whether every guard allows the light to be changed.
+func guardsAllow(device Device, index int, light *keylight.Light, guards []LightGuard) bool {
+	for _, guard := range guards {
+		if !guard(device, index, light) {
+			return false
+		}
+	}
+
+	return true
+}
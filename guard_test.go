@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"testing"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func guardsFromArgs(t *testing.T, args ...string) ([]LightGuard, error) {
+	t.Helper()
+
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	for _, f := range guardFlags {
+		require.NoError(t, f.Apply(set))
+	}
+	require.NoError(t, set.Parse(args))
+
+	return guardsFromFlags(cli.NewContext(nil, set, nil))
+}
+
+func TestGuardsFromFlags(t *testing.T) {
+	device := &FakeDevice{DNSAddr: "192.168.1.1"}
+	on := &keylight.Light{On: 1, Brightness: 30}
+	off := &keylight.Light{On: 0, Brightness: 30}
+
+	guards, err := guardsFromArgs(t, "--if-on")
+	require.NoError(t, err)
+	require.True(t, guardsAllow(device, 0, on, guards))
+	require.False(t, guardsAllow(device, 0, off, guards))
+
+	guards, err = guardsFromArgs(t, "--if-off", "--if-brightness-below", "20")
+	require.NoError(t, err)
+	require.False(t, guardsAllow(device, 0, off, guards))
+
+	guards, err = guardsFromArgs(t)
+	require.NoError(t, err)
+	require.True(t, guardsAllow(device, 0, off, guards))
+
+	guards, err = guardsFromArgs(t, "--index", "1", "--light-id", "3", "--if-on")
+	require.NoError(t, err)
+	require.False(t, guardsAllow(device, 0, on, guards))
+	require.True(t, guardsAllow(device, 1, on, guards))
+	require.True(t, guardsAllow(device, 3, on, guards))
+	require.False(t, guardsAllow(device, 3, off, guards))
+
+	_, err = guardsFromArgs(t, "--if-on", "--if-off")
+	require.Error(t, err)
+
+	_, err = guardsFromArgs(t, "--index", "-1")
+	require.Error(t, err)
+}
+
+func TestIndexGuardsByName(t *testing.T) {
+	cfg := &Config{Lights: map[string]LightConfig{
+		"desk":  {Address: "desk.local", Names: map[int]string{0: "front", 1: "rear"}},
+		"shelf": {Address: "192.168.1.9:9124", Names: map[int]string{1: "front"}},
+	}}
+	indexNames = cfg.indexNames()
+	t.Cleanup(func() { indexNames = IndexNames{} })
+
+	desk := &FakeDevice{Name: "desk"}
+	shelf := &FakeDevice{Name: "Elgato Light Strip 1A2B", DNSAddr: "192.168.1.9.", Port: 9124}
+	other := &FakeDevice{Name: "Elgato Key Light 3C4D", DNSAddr: "kl-3c4d.local.", Port: 9123}
+	light := &keylight.Light{}
+
+	guards, err := guardsFromArgs(t, "--index", "front")
+	require.NoError(t, err)
+	require.True(t, guardsAllow(desk, 0, light, guards))
+	require.False(t, guardsAllow(desk, 1, light, guards))
+	require.True(t, guardsAllow(shelf, 1, light, guards), "matched by address")
+	require.False(t, guardsAllow(shelf, 0, light, guards))
+	require.False(t, guardsAllow(other, 0, light, guards))
+
+	guards, err = guardsFromArgs(t, "--index", "rear", "--index", "2")
+	require.NoError(t, err)
+	require.True(t, guardsAllow(desk, 1, light, guards))
+	require.True(t, guardsAllow(other, 2, light, guards))
+	require.False(t, guardsAllow(other, 1, light, guards))
+
+	_, err = guardsFromArgs(t, "--index", "middle")
+	require.ErrorContains(t, err, "isn't a light index or a light named in the config file")
+}
+
+func TestSetLightControlFieldWithGuards(t *testing.T) {
+	device := &FakeDevice{
+		DNSAddr: "192.168.1.1",
+		LightGrp: &keylight.LightGroup{Lights: []*keylight.Light{
+			{On: 1, Brightness: 20},
+			{On: 0, Brightness: 20},
+		}},
+	}
+
+	ifOn := func(_ Device, _ int, light *keylight.Light) bool { return light.On == 1 }
+	result, err := setLightControlFieldWithValue(context.Background(), []Device{device}, ControlBrightness, 60, ifOn)
+	require.NoError(t, err)
+	require.Equal(t, []Change{{Light: 0, Field: "brightness", Old: 20, New: 60}}, result.Devices[0].Changes)
+	require.Equal(t, 20, device.LightGrp.Lights[1].Brightness)
+}
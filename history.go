@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The number of entries kept in the history file. Older ones are dropped.
+const maxHistoryEntries = 1000
+
+// Commands which aren't worth recording, either because they only look at the
+// history or because rerunning them would be pointless.
+var commandsNotRecorded = map[string]bool{
+	"history":    true,
+	"last":       true,
+	"help":       true,
+	"h":          true,
+	"__complete": true,
+	"completion": true,
+}
+
+// noHistoryEnv stops a klctl from recording its command when set. It's used
+// for commands run on our behalf, which the user didn't type.
+const noHistoryEnv = "KLCTL_NO_HISTORY"
+
+// secretFlags are the flags whose values can be secrets, such as a password
+// or an Authorization header, which aren't written to the history.
+var secretFlags = map[string]bool{
+	"password": true,
+	"header":   true,
+}
+
+// redactedArg replaces a secret flag's value in the history.
+const redactedArg = "<redacted>"
+
+// HistoryEntry is one recorded klctl invocation.
+type HistoryEntry struct {
+	Time time.Time `json:"time"`
+	Args []string  `json:"args"`
+
+	// Redacted is set when secrets were left out of Args, so the command
+	// can't be run again from the history.
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+// newHistoryEntry records args, with the values of secretFlags replaced by
+// redactedArg.
+func newHistoryEntry(now time.Time, args []string) HistoryEntry {
+	entry := HistoryEntry{Time: now, Args: append([]string{}, args...)}
+
+	for i := 0; i < len(entry.Args); i++ {
+		arg := entry.Args[i]
+		if arg == "--" {
+			break
+		}
+
+		name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || !secretFlags[name] {
+			continue
+		}
+
+		entry.Redacted = true
+		if hasValue {
+			entry.Args[i] = arg[:strings.Index(arg, "=")+1] + redactedArg
+		} else if i+1 < len(entry.Args) {
+			i++
+			entry.Args[i] = redactedArg
+		}
+	}
+
+	return entry
+}
+
+// rerunArgs returns the arguments to run the command again with.
+func (e HistoryEntry) rerunArgs() ([]string, error) {
+	if e.Redacted {
+		return nil, invalidArgumentf("the command had secrets which weren't recorded, so it can't be run again, run it by hand instead")
+	}
+
+	return e.Args, nil
+}
+
+func (e HistoryEntry) String() string {
+	return fmt.Sprintf("%s  klctl %s", e.Time.Local().Format(time.DateTime), strings.Join(e.Args, " "))
+}
+
+// defaultStateDir returns ~/.local/state/klctl, respecting $XDG_STATE_HOME.
+func defaultStateDir() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+
+	return filepath.Join(dir, "klctl")
+}
+
+// defaultHistoryPath returns history.jsonl in the state directory.
+func defaultHistoryPath() string {
+	dir := defaultStateDir()
+	if dir == "" {
+		return ""
+	}
+
+	return filepath.Join(dir, "history.jsonl")
+}
+
+// readHistory returns the recorded invocations, oldest first. A missing file
+// is an empty history.
+func readHistory(path string) ([]HistoryEntry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	defer f.Close()
+
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// Skip anything mangled, e.g. by a write being interrupted
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+func writeHistoryEntries(w io.Writer, entries []HistoryEntry) error {
+	enc := json.NewEncoder(w)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// appendHistory records an invocation, dropping the oldest entries once there
+// are more than maxHistoryEntries.
+func appendHistory(path string, entry HistoryEntry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	entries, err := readHistory(path)
+	if err != nil {
+		return err
+	}
+
+	if len(entries) >= maxHistoryEntries {
+		entries = append(entries[len(entries)-maxHistoryEntries+1:], entry)
+
+		tmp := path + ".tmp"
+		f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to write history: %w", err)
+		}
+
+		if err := writeHistoryEntries(f, entries); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to write history: %w", err)
+		}
+
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("failed to write history: %w", err)
+		}
+
+		return os.Rename(tmp, path)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	defer f.Close()
+
+	return writeHistoryEntries(f, []HistoryEntry{entry})
+}
+
+// lastHistoryEntry returns the most recent invocation.
+func lastHistoryEntry(path string) (*HistoryEntry, error) {
+	entries, err := readHistory(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entries) == 0 {
+		return nil, errors.New("no commands have been recorded yet")
+	}
+
+	return &entries[len(entries)-1], nil
+}
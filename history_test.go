@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "klctl", "history.jsonl")
+
+	_, err := lastHistoryEntry(path)
+	require.Error(t, err)
+
+	now := time.Date(2024, 3, 1, 18, 0, 0, 0, time.UTC)
+	require.NoError(t, appendHistory(path, HistoryEntry{Time: now, Args: []string{"on"}}))
+	require.NoError(t, appendHistory(path, HistoryEntry{Time: now, Args: []string{"brightness", "set", "40"}}))
+
+	entries, err := readHistory(path)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	last, err := lastHistoryEntry(path)
+	require.NoError(t, err)
+	require.Equal(t, []string{"brightness", "set", "40"}, last.Args)
+}
+
+func TestHistoryLeavesOutSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+
+	entry := newHistoryEntry(time.Now(), []string{
+		"--header", "Authorization: Bearer hunter2",
+		"mqtt", "--broker", "tcp://localhost:1883", "--password=swordfish", "-password", "letmein",
+	})
+	require.True(t, entry.Redacted)
+	require.NoError(t, appendHistory(path, entry))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	for _, secret := range []string{"hunter2", "swordfish", "letmein"} {
+		require.NotContains(t, string(data), secret)
+	}
+
+	last, err := lastHistoryEntry(path)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"--header", redactedArg,
+		"mqtt", "--broker", "tcp://localhost:1883", "--password=" + redactedArg, "-password", redactedArg,
+	}, last.Args)
+
+	_, err = last.rerunArgs()
+	require.ErrorContains(t, err, "can't be run again")
+
+	// Commands without secrets are kept as they are
+	entry = newHistoryEntry(time.Now(), []string{"brightness", "set", "--", "--password"})
+	require.False(t, entry.Redacted)
+	args, err := entry.rerunArgs()
+	require.NoError(t, err)
+	require.Equal(t, []string{"brightness", "set", "--", "--password"}, args)
+}
+
+func TestHistoryTrimmed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+
+	for i := 0; i < maxHistoryEntries+5; i++ {
+		require.NoError(t, appendHistory(path, HistoryEntry{Args: []string{"on", string(rune('a' + i%26))}}))
+	}
+
+	entries, err := readHistory(path)
+	require.NoError(t, err)
+	require.Len(t, entries, maxHistoryEntries)
+	require.Equal(t, string(rune('a'+(maxHistoryEntries+4)%26)), entries[len(entries)-1].Args[1])
+}
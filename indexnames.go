@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// IndexNames are the names the config file gives the lights of devices with
+// more than one, such as front and rear, so they can be picked out with
+// --index and are shown alongside their positions.
+type IndexNames struct {
+	// byName and byAddress hold each configured light's names, by the name
+	// it's configured with and by its address.
+	byName    map[string]map[int]string
+	byAddress map[string]map[int]string
+}
+
+// indexNames returns the names of the lights given in the config.
+func (c *Config) indexNames() IndexNames {
+	names := IndexNames{byName: map[string]map[int]string{}, byAddress: map[string]map[int]string{}}
+	for name, lc := range c.Lights {
+		if len(lc.Names) == 0 {
+			continue
+		}
+
+		names.byName[name] = lc.Names
+		if host, port, err := splitLightAddress(lc.Address); err == nil {
+			names.byAddress[lightKey(host, port)] = lc.Names
+		}
+	}
+
+	return names
+}
+
+// of returns the names of the device's lights, by index. A device has the
+// names of the configured light with its name, or else its address.
+func (n IndexNames) of(device Device) map[int]string {
+	if names, ok := n.byName[device.GetName()]; ok {
+		return names
+	}
+
+	return n.byAddress[lightKey(device.GetDNSAddr(), device.GetPort())]
+}
+
+// has reports whether any light is configured with name.
+func (n IndexNames) has(name string) bool {
+	for _, names := range n.byName {
+		for _, named := range names {
+			if named == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// lightKey identifies a light by its address, whichever way the host is
+// written.
+func lightKey(host string, port int) string {
+	return net.JoinHostPort(strings.ToLower(strings.TrimSuffix(host, ".")), strconv.Itoa(port))
+}
+
+// lightLabel is how a light is shown: its index, followed by its name if it
+// has one.
+func lightLabel(index int, name string) string {
+	if name == "" {
+		return strconv.Itoa(index)
+	}
+
+	return fmt.Sprintf("%d %s", index, name)
+}
+
+// validateLightNames checks the names given to a configured light's lights.
+// Names can't be numbers, so they can't be mistaken for indices, and no two
+// lights of a device can share one.
+func validateLightNames(names map[int]string) error {
+	seen := map[string]bool{}
+	for index, name := range names {
+		if index < 0 || index > maxNumber {
+			return fmt.Errorf("invalid light index %d", index)
+		}
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("light %d has an empty name", index)
+		}
+		if _, err := strconv.Atoi(name); err == nil {
+			return fmt.Errorf("light %d can't be named %s, names can't be numbers", index, name)
+		}
+		if seen[name] {
+			return fmt.Errorf("more than one light is named %s", name)
+		}
+		seen[name] = true
+	}
+
+	return nil
+}
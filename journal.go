@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+)
+
+// ExternalChange is a light which was found in a different state from the
+// one klctl last saw or left it in. Something else changed it in between:
+// the physical button, the Elgato app, or some other automation.
+type ExternalChange struct {
+	Time   time.Time `json:"time"`
+	Device string    `json:"device"`
+	Light  int       `json:"light"`
+	Field  string    `json:"field"`
+	Before int       `json:"before"`
+	After  int       `json:"after"`
+}
+
+func (c ExternalChange) String() string {
+	return fmt.Sprintf("%s  %s [%d] %s %d -> %d",
+		c.Time.Local().Format(time.DateTime), c.Device, c.Light, c.Field, c.Before, c.After)
+}
+
+// ChangeJournal remembers the last known state of each light, keyed by
+// address, and records an ExternalChange whenever a light is found to differ
+// from it.
+type ChangeJournal struct {
+	dir string
+
+	mu sync.Mutex
+}
+
+func newChangeJournal(dir string) *ChangeJournal {
+	return &ChangeJournal{dir: dir}
+}
+
+func (j *ChangeJournal) knownPath() string {
+	return filepath.Join(j.dir, "lights.json")
+}
+
+func (j *ChangeJournal) externalPath() string {
+	return filepath.Join(j.dir, "external.jsonl")
+}
+
+func (j *ChangeJournal) readKnown() (map[string][]keylight.Light, error) {
+	known := map[string][]keylight.Light{}
+
+	data, err := os.ReadFile(j.knownPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return known, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &known); err != nil {
+		// Start afresh rather than failing every command
+		deviceLog.Debug("Discarding unreadable light state", "error", err)
+		return map[string][]keylight.Light{}, nil
+	}
+
+	return known, nil
+}
+
+func (j *ChangeJournal) writeKnown(known map[string][]keylight.Light) error {
+	if err := os.MkdirAll(j.dir, 0o700); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(known)
+	if err != nil {
+		return err
+	}
+
+	tmp := j.knownPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, j.knownPath())
+}
+
+func lightGroupValues(lg *keylight.LightGroup) []keylight.Light {
+	lights := make([]keylight.Light, len(lg.Lights))
+	for i, light := range lg.Lights {
+		lights[i] = *light
+	}
+
+	return lights
+}
+
+// lightChanges returns the fields which differ between two readings of a
+// device's lights.
+func lightChanges(before, after []keylight.Light) []Change {
+	var changes []Change
+
+	for i := 0; i < len(before) && i < len(after); i++ {
+		for _, f := range []struct {
+			field    string
+			old, new int
+		}{
+			{"on", before[i].On, after[i].On},
+			{ControlBrightness.String(), before[i].Brightness, after[i].Brightness},
+			{ControlTemperature.String(), before[i].Temperature, after[i].Temperature},
+		} {
+			if f.old != f.new {
+				changes = append(changes, Change{Light: i, Field: f.field, Old: f.old, New: f.new})
+			}
+		}
+	}
+
+	return changes
+}
+
+// diffLights returns the fields which differ between the known and current
+// state of a device's lights.
+func diffLights(address string, known, current []keylight.Light) []ExternalChange {
+	var changes []ExternalChange
+	now := time.Now().UTC()
+
+	for _, c := range lightChanges(known, current) {
+		changes = append(changes, ExternalChange{
+			Time:   now,
+			Device: address,
+			Light:  c.Light,
+			Field:  c.Field,
+			Before: c.Old,
+			After:  c.New,
+		})
+	}
+
+	return changes
+}
+
+// observe compares a freshly fetched light group with the last known state,
+// recording any differences, and then remembers it.
+func (j *ChangeJournal) observe(address string, lg *keylight.LightGroup) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	known, err := j.readKnown()
+	if err != nil {
+		return err
+	}
+
+	current := lightGroupValues(lg)
+	if previous, ok := known[address]; ok {
+		changes := diffLights(address, previous, current)
+		for _, change := range changes {
+			deviceLog.Info("External change detected",
+				"address", change.Device,
+				"light", change.Light,
+				"field", change.Field,
+				"before", change.Before,
+				"after", change.After)
+		}
+
+		if err := j.appendExternal(changes); err != nil {
+			return err
+		}
+	}
+
+	known[address] = current
+	return j.writeKnown(known)
+}
+
+// remember records the state klctl has just set a device's lights to.
+func (j *ChangeJournal) remember(address string, lg *keylight.LightGroup) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	known, err := j.readKnown()
+	if err != nil {
+		return err
+	}
+
+	known[address] = lightGroupValues(lg)
+	return j.writeKnown(known)
+}
+
+func (j *ChangeJournal) appendExternal(changes []ExternalChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(j.dir, 0o700); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(j.externalPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, change := range changes {
+		if err := enc.Encode(change); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readExternalChanges returns the recorded external changes, oldest first.
+func readExternalChanges(dir string) ([]ExternalChange, error) {
+	data, err := os.ReadFile(newChangeJournal(dir).externalPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return []ExternalChange{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read external changes: %w", err)
+	}
+
+	changes := []ExternalChange{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for dec.More() {
+		var change ExternalChange
+		if err := dec.Decode(&change); err != nil {
+			break
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// JournalDevice records the state of a device's lights in a ChangeJournal as
+// it is fetched and updated. Failing to write the journal never fails the
+// call, since it's only there to help debugging.
+type JournalDevice struct {
+	Device
+	journal *ChangeJournal
+}
+
+func withJournal(devices []Device, journal *ChangeJournal) []Device {
+	wrapped := make([]Device, 0, len(devices))
+	for _, device := range devices {
+		wrapped = append(wrapped, &JournalDevice{device, journal})
+	}
+
+	return wrapped
+}
+
+func (jd *JournalDevice) FetchLightGroup(ctx context.Context) (*keylight.LightGroup, error) {
+	lg, err := jd.Device.FetchLightGroup(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := jd.journal.observe(jd.GetDNSAddr(), lg); err != nil {
+		deviceLog.Debug("Failed to update change journal", "error", err)
+	}
+
+	return lg, nil
+}
+
+func (jd *JournalDevice) UpdateLightGroup(ctx context.Context, lg *keylight.LightGroup) (*keylight.LightGroup, error) {
+	updated, err := jd.Device.UpdateLightGroup(ctx, lg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := jd.journal.remember(jd.GetDNSAddr(), lg); err != nil {
+		deviceLog.Debug("Failed to update change journal", "error", err)
+	}
+
+	return updated, nil
+}
+
+var _ Device = &JournalDevice{}
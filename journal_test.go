@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournalDevice(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	fake := &FakeDevice{
+		DNSAddr: "a.local",
+		LightGrp: &keylight.LightGroup{Count: 1, Lights: []*keylight.Light{
+			{On: 0, Brightness: 20, Temperature: 200},
+		}},
+	}
+	device := withJournal([]Device{fake}, newChangeJournal(dir))[0]
+
+	// The first time a light is seen there's nothing to compare it with
+	lg, err := device.FetchLightGroup(ctx)
+	require.NoError(t, err)
+
+	lg = lg.Copy()
+	lg.Lights[0].On = 1
+	_, err = device.UpdateLightGroup(ctx, lg)
+	require.NoError(t, err)
+
+	// Our own change isn't external
+	_, err = device.FetchLightGroup(ctx)
+	require.NoError(t, err)
+
+	changes, err := readExternalChanges(dir)
+	require.NoError(t, err)
+	require.Empty(t, changes)
+
+	// Someone presses the button and changes the brightness
+	fake.LightGrp = &keylight.LightGroup{Count: 1, Lights: []*keylight.Light{
+		{On: 0, Brightness: 50, Temperature: 200},
+	}}
+
+	_, err = device.FetchLightGroup(ctx)
+	require.NoError(t, err)
+
+	changes, err = readExternalChanges(dir)
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	require.Equal(t, "on", changes[0].Field)
+	require.Equal(t, 1, changes[0].Before)
+	require.Equal(t, 0, changes[0].After)
+	require.Equal(t, "brightness", changes[1].Field)
+	require.Equal(t, 50, changes[1].After)
+
+	// It's only reported once
+	_, err = device.FetchLightGroup(ctx)
+	require.NoError(t, err)
+
+	changes, err = readExternalChanges(dir)
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oleksandr/bonjour"
+)
+
+// The --role values for klctl serve. When several servers run on the same
+// network, one of them is elected leader, and only the leader runs anything
+// which acts on its own, such as schedules. A primary is always preferred, and
+// a standby only leads when no other server can be found.
+const (
+	RolePrimary = "primary"
+	RoleStandby = "standby"
+	RoleAuto    = "auto"
+)
+
+var roles = []string{RolePrimary, RoleAuto, RoleStandby}
+
+// How often the servers on the network are looked for, and for how long.
+const (
+	electionInterval = 30 * time.Second
+	electionBrowse   = 2 * time.Second
+)
+
+// rolePrefix starts the TXT record a server announces its role in.
+const rolePrefix = "role="
+
+func validateRole(role string) error {
+	for _, r := range roles {
+		if role == r {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid role %q, must be one of %s", role, strings.Join(roles, ", "))
+}
+
+// Peer is a klctl server taking part in an election.
+type Peer struct {
+	Instance string `json:"instance"`
+	Role     string `json:"role"`
+}
+
+func rolePriority(role string) int {
+	switch role {
+	case RolePrimary:
+		return 0
+	case RoleStandby:
+		return 2
+	}
+
+	return 1
+}
+
+// electLeader picks the leader from the peers: the one with the best role,
+// breaking ties by instance name so that every server picks the same one.
+func electLeader(peers []Peer) Peer {
+	sorted := append([]Peer{}, peers...)
+	sort.Slice(sorted, func(i, j int) bool {
+		pi, pj := rolePriority(sorted[i].Role), rolePriority(sorted[j].Role)
+		if pi != pj {
+			return pi < pj
+		}
+
+		return sorted[i].Instance < sorted[j].Instance
+	})
+
+	return sorted[0]
+}
+
+// peerFromEntry reads a peer from a server's announcement. Servers which
+// don't announce a role are treated as RoleAuto.
+func peerFromEntry(entry *bonjour.ServiceEntry) Peer {
+	peer := Peer{Instance: entry.Instance, Role: RoleAuto}
+	for _, txt := range entry.Text {
+		if role, ok := strings.CutPrefix(txt, rolePrefix); ok && validateRole(role) == nil {
+			peer.Role = role
+		}
+	}
+
+	return peer
+}
+
+// Election keeps track of whether this server is the leader.
+type Election struct {
+	self Peer
+
+	// browse finds the other servers on the network.
+	browse func(ctx context.Context) ([]Peer, error)
+
+	mu     sync.Mutex
+	leader Peer
+}
+
+// newElection starts out with this server as the leader, until it finds
+// others. Without browse, it always leads.
+func newElection(self Peer, browse func(ctx context.Context) ([]Peer, error)) *Election {
+	return &Election{self: self, browse: browse, leader: self}
+}
+
+// IsLeader reports whether this server is the leader.
+func (e *Election) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.leader == e.self
+}
+
+// Leader returns the current leader.
+func (e *Election) Leader() Peer {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.leader
+}
+
+// update looks for the other servers and elects a leader among them.
+func (e *Election) update(ctx context.Context) error {
+	peers, err := e.browse(ctx)
+	if err != nil {
+		return err
+	}
+
+	leader := electLeader(append(peers, e.self))
+
+	e.mu.Lock()
+	changed := leader != e.leader
+	e.leader = leader
+	e.mu.Unlock()
+
+	if changed {
+		apiLog.Info("Elected leader", "leader", leader.Instance, "role", leader.Role, "self", leader == e.self)
+	}
+
+	return nil
+}
+
+// run holds elections every electionInterval until ctx is done. A failed
+// election leaves the previous leader in place.
+func (e *Election) run(ctx context.Context) {
+	if e.browse == nil {
+		return
+	}
+
+	ticker := time.NewTicker(electionInterval)
+	defer ticker.Stop()
+
+	for {
+		browseCtx, cancel := context.WithTimeout(ctx, electionBrowse)
+		if err := e.update(browseCtx); err != nil {
+			apiLog.Debug("Failed to look for other servers", "error", err)
+		}
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// browsePeers returns every klctl server announced on the network within the
+// time ctx allows, including this one if it is announced.
+func browsePeers(ctx context.Context) ([]Peer, error) {
+	seen := map[string]bool{}
+	var peers []Peer
+
+	err := browseServers(ctx, func(entry *bonjour.ServiceEntry) bool {
+		if !seen[entry.Instance] {
+			seen[entry.Instance] = true
+			peers = append(peers, peerFromEntry(entry))
+		}
+
+		return false
+	})
+	if err != nil && ctx.Err() == nil {
+		return nil, err
+	}
+
+	return peers, nil
+}
+
+// LeaderStatus is the response to GET /leader.
+type LeaderStatus struct {
+	Self   Peer `json:"self"`
+	Leader Peer `json:"leader"`
+	Leads  bool `json:"leads"`
+}
+
+func (e *Election) status() LeaderStatus {
+	leader := e.Leader()
+	return LeaderStatus{Self: e.self, Leader: leader, Leads: leader == e.self}
+}
+
+func (s *APIServer) routeLeader(r *http.Request) (any, error) {
+	if err := requireMethod(r, http.MethodGet); err != nil {
+		return nil, err
+	}
+
+	return s.election.status(), nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/oleksandr/bonjour"
+	"github.com/stretchr/testify/require"
+)
+
+func TestElectLeader(t *testing.T) {
+	desktop := Peer{Instance: "klctl on desktop", Role: RoleAuto}
+	pi := Peer{Instance: "klctl on pi", Role: RoleAuto}
+	laptop := Peer{Instance: "klctl on laptop", Role: RoleStandby}
+
+	require.Equal(t, desktop, electLeader([]Peer{pi, desktop, laptop}))
+	require.Equal(t, laptop, electLeader([]Peer{laptop}))
+
+	pi.Role = RolePrimary
+	require.Equal(t, pi, electLeader([]Peer{desktop, laptop, pi}))
+}
+
+func TestPeerFromEntry(t *testing.T) {
+	entry := bonjour.NewServiceEntry("klctl on pi", serverService, "local")
+	require.Equal(t, Peer{Instance: "klctl on pi", Role: RoleAuto}, peerFromEntry(entry))
+
+	entry.Text = []string{"role=standby"}
+	require.Equal(t, RoleStandby, peerFromEntry(entry).Role)
+
+	entry.Text = []string{"role=boss"}
+	require.Equal(t, RoleAuto, peerFromEntry(entry).Role)
+}
+
+func TestElection(t *testing.T) {
+	self := Peer{Instance: "klctl on pi", Role: RoleAuto}
+
+	var peers []Peer
+	var browseErr error
+	election := newElection(self, func(ctx context.Context) ([]Peer, error) {
+		return peers, browseErr
+	})
+	require.True(t, election.IsLeader())
+
+	// Our own announcement is seen too
+	peers = []Peer{self, {Instance: "klctl on desktop", Role: RoleAuto}}
+	require.NoError(t, election.update(context.Background()))
+	require.False(t, election.IsLeader())
+	require.Equal(t, "klctl on desktop", election.Leader().Instance)
+
+	// A failed election leaves the leader alone
+	browseErr = errors.New("no network")
+	require.Error(t, election.update(context.Background()))
+	require.False(t, election.IsLeader())
+
+	browseErr = nil
+	peers = nil
+	require.NoError(t, election.update(context.Background()))
+	require.True(t, election.IsLeader())
+
+	require.Error(t, validateRole("boss"))
+}
+
+func TestAPIServerLeader(t *testing.T) {
+	server, _ := newTestAPIServer()
+
+	status, body := doRequest(t, server, http.MethodGet, "/leader", "")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, true, body["leads"])
+
+	status, _ = doRequest(t, server, http.MethodPost, "/leader", "")
+	require.Equal(t, http.StatusMethodNotAllowed, status)
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+)
+
+// The UDP port LIFX lights listen on.
+const lifxPort = "56700"
+
+// LIFX LAN protocol message types used here.
+const (
+	lifxAcknowledgement = 45
+	lifxGetColor        = 101
+	lifxSetColor        = 102
+	lifxLightState      = 107
+	lifxSetLightPower   = 117
+)
+
+// The range of white LIFX lights take, in Kelvin.
+const (
+	lifxMinKelvin = 1500
+	lifxMaxKelvin = 9000
+)
+
+// How long to wait for a LIFX light to answer each attempt at a request, and
+// how many attempts to make. Requests go over UDP, so can be lost.
+const (
+	lifxAttemptTimeout = 500 * time.Millisecond
+	lifxAttempts       = 3
+)
+
+// lifxHeaderSize is the size of the header before each message's payload.
+const lifxHeaderSize = 36
+
+// LIFXLight is a LIFX light, spoken to with the LIFX LAN protocol.
+type LIFXLight struct {
+	addr   string
+	source uint32
+
+	mu       sync.Mutex
+	sequence uint8
+}
+
+// newLIFXLight returns the LIFX light at addr, whose port is optional.
+func newLIFXLight(addr string) (*LIFXLight, error) {
+	host, port, err := parseHostPort(addr, lifxPort)
+	if err != nil {
+		return nil, err
+	}
+
+	// The source identifies this client in responses. Zero asks for them to
+	// be broadcast.
+	return &LIFXLight{addr: net.JoinHostPort(host, strconv.Itoa(port)), source: rand.Uint32() | 1}, nil
+}
+
+// lifxState is a LIFX light's colour and power.
+type lifxState struct {
+	Hue, Saturation, Brightness, Kelvin uint16
+	Power                               uint16
+	Label                               string
+}
+
+// encodeLIFX makes a message to send to a light.
+func encodeLIFX(source uint32, sequence uint8, msgType uint16, ackRequired, resRequired bool, payload []byte) []byte {
+	var buf bytes.Buffer
+
+	var flags uint8
+	if resRequired {
+		flags |= 1
+	}
+	if ackRequired {
+		flags |= 2
+	}
+
+	// Frame: size, then protocol 1024 and addressable; untagged, as the
+	// message is for the light it's sent to
+	_ = binary.Write(&buf, binary.LittleEndian, uint16(lifxHeaderSize+len(payload)))
+	_ = binary.Write(&buf, binary.LittleEndian, uint16(1024|1<<12))
+	_ = binary.Write(&buf, binary.LittleEndian, source)
+	// Frame address: target (any), reserved, flags and sequence
+	buf.Write(make([]byte, 8+6))
+	buf.WriteByte(flags)
+	buf.WriteByte(sequence)
+	// Protocol header: reserved, type and reserved
+	buf.Write(make([]byte, 8))
+	_ = binary.Write(&buf, binary.LittleEndian, msgType)
+	buf.Write(make([]byte, 2))
+
+	buf.Write(payload)
+	return buf.Bytes()
+}
+
+// decodeLIFX returns the source, sequence, type and payload of a message from
+// a light.
+func decodeLIFX(msg []byte) (uint32, uint8, uint16, []byte, error) {
+	if len(msg) < lifxHeaderSize {
+		return 0, 0, 0, nil, fmt.Errorf("LIFX message of %d bytes is too short", len(msg))
+	}
+
+	size := int(binary.LittleEndian.Uint16(msg[0:2]))
+	if size < lifxHeaderSize || size > len(msg) {
+		return 0, 0, 0, nil, fmt.Errorf("LIFX message claims to be %d bytes, but is %d", size, len(msg))
+	}
+
+	source := binary.LittleEndian.Uint32(msg[4:8])
+	sequence := msg[23]
+	msgType := binary.LittleEndian.Uint16(msg[32:34])
+
+	return source, sequence, msgType, msg[lifxHeaderSize:size], nil
+}
+
+// request sends a message, and returns the payload of the response of type
+// want, retrying if none comes.
+func (l *LIFXLight) request(ctx context.Context, msgType uint16, payload []byte, want uint16) ([]byte, error) {
+	l.mu.Lock()
+	l.sequence++
+	sequence := l.sequence
+	l.mu.Unlock()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", l.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	msg := encodeLIFX(l.source, sequence, msgType, want == lifxAcknowledgement, want != lifxAcknowledgement, payload)
+	buf := make([]byte, 512)
+
+	for attempt := 0; attempt < lifxAttempts; attempt++ {
+		if _, err := conn.Write(msg); err != nil {
+			return nil, err
+		}
+
+		deadline := time.Now().Add(lifxAttemptTimeout)
+		if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+			deadline = ctxDeadline
+		}
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+
+		for {
+			k, err := conn.Read(buf)
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			source, seq, respType, resp, err := decodeLIFX(buf[:k])
+			if err != nil || source != l.source || seq != sequence || respType != want {
+				// Not the answer to this request
+				continue
+			}
+
+			return resp, nil
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("LIFX light %s didn't answer: %w", l.addr, context.DeadlineExceeded)
+}
+
+func (l *LIFXLight) state(ctx context.Context) (lifxState, error) {
+	payload, err := l.request(ctx, lifxGetColor, nil, lifxLightState)
+	if err != nil {
+		return lifxState{}, err
+	}
+	if len(payload) < 44 {
+		return lifxState{}, fmt.Errorf("LIFX state of %d bytes is too short", len(payload))
+	}
+
+	u16 := func(i int) uint16 { return binary.LittleEndian.Uint16(payload[i : i+2]) }
+	return lifxState{
+		Hue:        u16(0),
+		Saturation: u16(2),
+		Brightness: u16(4),
+		Kelvin:     u16(6),
+		Power:      u16(10),
+		Label:      strings.TrimRight(string(payload[12:44]), "\x00"),
+	}, nil
+}
+
+func (l *LIFXLight) setColor(ctx context.Context, state lifxState, duration time.Duration) error {
+	var payload bytes.Buffer
+	payload.WriteByte(0)
+	for _, v := range []uint16{state.Hue, state.Saturation, state.Brightness, state.Kelvin} {
+		_ = binary.Write(&payload, binary.LittleEndian, v)
+	}
+	_ = binary.Write(&payload, binary.LittleEndian, uint32(duration.Milliseconds()))
+
+	_, err := l.request(ctx, lifxSetColor, payload.Bytes(), lifxAcknowledgement)
+	return err
+}
+
+func (l *LIFXLight) setPower(ctx context.Context, level uint16, duration time.Duration) error {
+	var payload bytes.Buffer
+	_ = binary.Write(&payload, binary.LittleEndian, level)
+	_ = binary.Write(&payload, binary.LittleEndian, uint32(duration.Milliseconds()))
+
+	_, err := l.request(ctx, lifxSetLightPower, payload.Bytes(), lifxAcknowledgement)
+	return err
+}
+
+// Name returns the light's label.
+func (l *LIFXLight) Name(ctx context.Context) (string, error) {
+	state, err := l.state(ctx)
+	return state.Label, err
+}
+
+// Light returns the light's state as a Key Light's. Colours show as the
+// nearest white.
+func (l *LIFXLight) Light(ctx context.Context) (*keylight.Light, error) {
+	state, err := l.state(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	light := &keylight.Light{
+		Brightness:  (int(state.Brightness)*100 + 65535/2) / 65535,
+		Temperature: max(minTemperature, min(maxTemperature, kelvinToMired(int(state.Kelvin)))),
+	}
+	if state.Power > 0 {
+		light.On = int(LightOn)
+	}
+
+	return light, nil
+}
+
+// SetLight sets the light to white of the Key Light's brightness and
+// temperature, and powers it on or off, over duration.
+func (l *LIFXLight) SetLight(ctx context.Context, light *keylight.Light, duration time.Duration) error {
+	color := lifxState{
+		Brightness: uint16(max(0, min(100, light.Brightness)) * 65535 / 100),
+		Kelvin:     uint16(max(lifxMinKelvin, min(lifxMaxKelvin, miredToKelvin(light.Temperature)))),
+	}
+	if err := l.setColor(ctx, color, duration); err != nil {
+		return err
+	}
+
+	var power uint16
+	if light.On == int(LightOn) {
+		power = 65535
+	}
+
+	return l.setPower(ctx, power, duration)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLIFX answers the LIFX LAN protocol as a light would, dropping the first
+// drop messages it's sent.
+type fakeLIFX struct {
+	conn *net.UDPConn
+	drop int
+
+	mu                      sync.Mutex
+	brightness, kelvin, pwr uint16
+	duration                uint32
+}
+
+func newFakeLIFX(t *testing.T) *fakeLIFX {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	f := &fakeLIFX{conn: conn, brightness: 32768, kelvin: 4000}
+	go f.run()
+
+	return f
+}
+
+func (f *fakeLIFX) run() {
+	buf := make([]byte, 512)
+	for {
+		k, from, err := f.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		source, sequence, msgType, payload, err := decodeLIFX(buf[:k])
+		if err != nil {
+			continue
+		}
+
+		f.mu.Lock()
+		if f.drop > 0 {
+			f.drop--
+			f.mu.Unlock()
+			continue
+		}
+
+		var respType uint16 = lifxAcknowledgement
+		var resp bytes.Buffer
+		switch msgType {
+		case lifxGetColor:
+			respType = lifxLightState
+			for _, v := range []uint16{0, 0, f.brightness, f.kelvin, 0, f.pwr} {
+				_ = binary.Write(&resp, binary.LittleEndian, v)
+			}
+			label := make([]byte, 32)
+			copy(label, "Desk lamp")
+			resp.Write(label)
+			resp.Write(make([]byte, 8))
+		case lifxSetColor:
+			f.brightness = binary.LittleEndian.Uint16(payload[5:7])
+			f.kelvin = binary.LittleEndian.Uint16(payload[7:9])
+			f.duration = binary.LittleEndian.Uint32(payload[9:13])
+		case lifxSetLightPower:
+			f.pwr = binary.LittleEndian.Uint16(payload[0:2])
+		}
+		f.mu.Unlock()
+
+		_, _ = f.conn.WriteToUDP(encodeLIFX(source, sequence, respType, false, false, resp.Bytes()), from)
+	}
+}
+
+func TestLIFXLight(t *testing.T) {
+	fake := newFakeLIFX(t)
+	light, err := newLIFXLight(fake.conn.LocalAddr().String())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	name, err := light.Name(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "Desk lamp", name)
+
+	state, err := light.Light(ctx)
+	require.NoError(t, err)
+	require.Equal(t, &keylight.Light{On: 0, Brightness: 50, Temperature: 250}, state)
+
+	require.NoError(t, light.SetLight(ctx, &keylight.Light{On: 1, Brightness: 100, Temperature: 200}, 300*time.Millisecond))
+	fake.mu.Lock()
+	require.Equal(t, uint16(65535), fake.brightness)
+	require.Equal(t, uint16(5000), fake.kelvin)
+	require.Equal(t, uint16(65535), fake.pwr)
+	require.Equal(t, uint32(300), fake.duration)
+	fake.drop = 1
+	fake.mu.Unlock()
+
+	// Lost messages are sent again
+	state, err = light.Light(ctx)
+	require.NoError(t, err)
+	require.Equal(t, &keylight.Light{On: 1, Brightness: 100, Temperature: 200}, state)
+}
+
+func TestLIFXLightUnreachable(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	light, err := newLIFXLight(conn.LocalAddr().String())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err = light.Light(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/iainlane/klctl/pkg/keylightctl"
+)
+
+// errNoColorLights is returned when colour is asked of lights which can only
+// show white, such as Key Lights.
+var errNoColorLights = errors.New("none of the lights can show colours")
+
+// LightColor is the colour of one light, for get.
+type LightColor struct {
+	Device     string  `json:"device"`
+	Index      int     `json:"index"`
+	Name       string  `json:"name,omitempty"`
+	Hue        float64 `json:"hue"`
+	Saturation float64 `json:"saturation"`
+}
+
+// parseColor parses a hue, in degrees, and a saturation, in percent.
+func parseColor(hue, saturation string) (Color, error) {
+	h, err := parseFloatInRange(hue, 0, keylightctl.MaxHue)
+	if err != nil {
+		return Color{}, fmt.Errorf("invalid hue %q, must be between 0 and %d degrees", hue, keylightctl.MaxHue)
+	}
+
+	s, err := parseFloatInRange(saturation, 0, keylightctl.MaxSaturation)
+	if err != nil {
+		return Color{}, fmt.Errorf("invalid saturation %q, must be between 0 and %d%%", saturation, keylightctl.MaxSaturation)
+	}
+
+	return Color{Hue: h, Saturation: s}, nil
+}
+
+// colorDevices picks out the devices which can show colours.
+func colorDevices(ctx context.Context, devices []Device) ([]Device, error) {
+	supported := make([]bool, len(devices))
+	err := forEachDevice(ctx, devices, func(ctx context.Context, i int, device Device) error {
+		info, err := device.FetchDeviceInfo(ctx)
+		if err != nil {
+			return err
+		}
+
+		supported[i] = keylightctl.SupportsColor(info)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var colorful []Device
+	for i, device := range devices {
+		if supported[i] {
+			colorful = append(colorful, device)
+		} else {
+			deviceLog.Debug("Device can't show colours", "address", device.GetDNSAddr())
+		}
+	}
+
+	if len(colorful) == 0 {
+		return nil, errNoColorLights
+	}
+
+	return colorful, nil
+}
+
+// getLightColors returns the colour of every light of every device which can
+// show one.
+func getLightColors(ctx context.Context, lightList []Device) ([]LightColor, error) {
+	devices, err := colorDevices(ctx, lightList)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]*ColorGroup, len(devices))
+	err = forEachDevice(ctx, devices, func(ctx context.Context, i int, device Device) error {
+		var err error
+		groups[i], err = device.FetchColors(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var colors []LightColor
+	for i, cg := range groups {
+		for index, color := range cg.Lights {
+			colors = append(colors, LightColor{
+				Device:     deviceAddress(devices[i]),
+				Index:      index,
+				Name:       indexNames.of(devices[i])[index],
+				Hue:        color.Hue,
+				Saturation: color.Saturation,
+			})
+		}
+	}
+
+	return colors, nil
+}
+
+// colorChanges sets every light in cg to color, returning what changed.
+// Changes are whole numbers, so a difference smaller than that is none.
+func colorChanges(cg *ColorGroup, color Color) []Change {
+	var changes []Change
+	for i, c := range cg.Lights {
+		for _, f := range []struct {
+			name     string
+			old, new float64
+		}{
+			{keylightctl.FieldHue, c.Hue, color.Hue},
+			{keylightctl.FieldSaturation, c.Saturation, color.Saturation},
+		} {
+			if old, new := int(math.Round(f.old)), int(math.Round(f.new)); old != new {
+				changes = append(changes, Change{Light: i, Field: f.name, Old: old, New: new})
+			}
+		}
+
+		*c = color
+	}
+
+	return changes
+}
+
+// setLightColors sets every light which can show colours to color. Devices
+// which can't are left out of the result.
+func setLightColors(ctx context.Context, lightList []Device, color Color) (*CommandResult, error) {
+	devices, err := colorDevices(ctx, lightList)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := acquireDeviceLocks(ctx, devices)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	result := newCommandResult()
+	var mu sync.Mutex
+	err = forEachDevice(ctx, devices, func(ctx context.Context, i int, device Device) error {
+		start := time.Now()
+
+		cg, err := device.FetchColors(ctx)
+		var changes []Change
+		if err == nil {
+			changes = colorChanges(cg, color)
+			if len(changes) > 0 {
+				_, err = device.UpdateColors(ctx, cg)
+			}
+		}
+
+		mu.Lock()
+		result.addDevice(device, start, changes, err)
+		mu.Unlock()
+
+		return err
+	})
+
+	return result, err
+}
+
+func renderLightColors(w io.Writer, format string, colors []LightColor) error {
+	if format == OutputJSON {
+		return writeJSON(w, colors)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, lc := range colors {
+		fmt.Fprintf(tw, "%s\t[%s]\t%s\n", lc.Device, lightLabel(lc.Index, lc.Name), formatColor(Color{Hue: lc.Hue, Saturation: lc.Saturation}))
+	}
+
+	return tw.Flush()
+}
+
+// formatColor renders a colour as its hue and saturation.
+func formatColor(color Color) string {
+	return fmt.Sprintf("%g° %g%%", math.Round(color.Hue), math.Round(color.Saturation))
+}
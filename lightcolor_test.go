@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseColor(t *testing.T) {
+	color, err := parseColor("240", "87.5")
+	require.NoError(t, err)
+	require.Equal(t, Color{Hue: 240, Saturation: 87.5}, color)
+
+	for _, args := range [][2]string{{"361", "50"}, {"-1", "50"}, {"120", "101"}, {"red", "50"}, {"NaN", "50"}, {"120", "nan"}, {"Inf", "50"}} {
+		_, err := parseColor(args[0], args[1])
+		require.Error(t, err, args)
+	}
+}
+
+func TestLightColors(t *testing.T) {
+	ctx := context.Background()
+
+	strip := &FakeDevice{
+		DNSAddr:    "strip.local",
+		DeviceInfo: &keylight.DeviceInfo{ProductName: "Elgato Light Strip"},
+		Colors:     &ColorGroup{Count: 1, Lights: []*Color{{Hue: 40, Saturation: 77}}},
+	}
+	key := &FakeDevice{
+		DNSAddr:    "key.local",
+		DeviceInfo: &keylight.DeviceInfo{ProductName: "Elgato Key Light"},
+	}
+
+	colors, err := getLightColors(ctx, []Device{key, strip})
+	require.NoError(t, err)
+	require.Equal(t, []LightColor{{Device: "strip.local", Index: 0, Hue: 40, Saturation: 77}}, colors)
+
+	// Only the Light Strip is touched
+	result, err := setLightColors(ctx, []Device{key, strip}, Color{Hue: 240, Saturation: 77})
+	require.NoError(t, err)
+	require.Len(t, result.Devices, 1)
+	require.Equal(t, []Change{{Light: 0, Field: "hue", Old: 40, New: 240}}, result.Devices[0].Changes)
+	require.Equal(t, []*Color{{Hue: 240, Saturation: 77}}, strip.Colors.Lights)
+
+	_, err = getLightColors(ctx, []Device{key})
+	require.ErrorIs(t, err, errNoColorLights)
+}
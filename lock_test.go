@@ -0,0 +1,35 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockDevices(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+
+	devices := []Device{
+		&FakeDevice{DNSAddr: "192.168.1.1"},
+		&FakeDevice{DNSAddr: "192.168.1.2"},
+	}
+
+	unlock, err := lockDevices(context.Background(), devices)
+	require.NoError(t, err)
+
+	// A second locker of an overlapping set has to wait for the first
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = lockDevices(ctx, devices[1:])
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	unlock()
+
+	unlock, err = lockDevices(context.Background(), devices[1:])
+	require.NoError(t, err)
+	unlock()
+}
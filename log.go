@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Subsystems tag log records with the part of klctl they come from, so that
+// logs can be filtered by component and given their own levels.
+const (
+	SubsystemDiscovery  = "discovery"
+	SubsystemDevice     = "device"
+	SubsystemAPI        = "api"
+	SubsystemAutomation = "automation"
+	SubsystemTunnel     = "tunnel"
+	SubsystemMQTT       = "mqtt"
+)
+
+var subsystems = []string{SubsystemDiscovery, SubsystemDevice, SubsystemAPI, SubsystemAutomation, SubsystemTunnel, SubsystemMQTT}
+
+// The supported --log-format values.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// The loggers for each subsystem. They are usable before setupLogging is
+// called, and pick up its configuration afterwards.
+var (
+	discoveryLog  = subsystemLogger(SubsystemDiscovery)
+	deviceLog     = subsystemLogger(SubsystemDevice)
+	apiLog        = subsystemLogger(SubsystemAPI)
+	automationLog = subsystemLogger(SubsystemAutomation)
+	tunnelLog     = subsystemLogger(SubsystemTunnel)
+	mqttLog       = subsystemLogger(SubsystemMQTT)
+)
+
+// logLevels is the level for each subsystem, and for everything else.
+type logLevels struct {
+	level      slog.Level
+	subsystems map[string]slog.Level
+}
+
+func (l *logLevels) forSubsystem(subsystem string) slog.Level {
+	if level, ok := l.subsystems[subsystem]; ok {
+		return level
+	}
+
+	return l.level
+}
+
+func parseLevel(s string) (slog.Level, error) {
+	// logrus called warnings "warning", and so did our --log-level
+	if strings.EqualFold(s, "warning") {
+		s = "warn"
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q, must be one of debug, info, warn or error", s)
+	}
+
+	return level, nil
+}
+
+// parseLogLevels parses a --log-level value: a level, optionally followed by
+// per-subsystem overrides, e.g. "info,device=debug".
+func parseLogLevels(s string) (*logLevels, error) {
+	levels := &logLevels{level: slog.LevelInfo, subsystems: map[string]slog.Level{}}
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		subsystem, value, found := strings.Cut(part, "=")
+		if !found {
+			level, err := parseLevel(part)
+			if err != nil {
+				return nil, err
+			}
+			levels.level = level
+			continue
+		}
+
+		if !isSubsystem(subsystem) {
+			return nil, fmt.Errorf("unknown log subsystem %q, must be one of %s", subsystem, strings.Join(subsystems, ", "))
+		}
+
+		level, err := parseLevel(value)
+		if err != nil {
+			return nil, err
+		}
+		levels.subsystems[subsystem] = level
+	}
+
+	return levels, nil
+}
+
+func isSubsystem(s string) bool {
+	for _, subsystem := range subsystems {
+		if s == subsystem {
+			return true
+		}
+	}
+
+	return false
+}
+
+// subsystemHandler filters records by the level of the subsystem they were
+// logged from.
+type subsystemHandler struct {
+	inner     slog.Handler
+	levels    *logLevels
+	subsystem string
+}
+
+func (h *subsystemHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.levels.forSubsystem(h.subsystem) && h.inner.Enabled(ctx, level)
+}
+
+func (h *subsystemHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.inner.Handle(ctx, r)
+}
+
+func (h *subsystemHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	subsystem := h.subsystem
+	for _, attr := range attrs {
+		if attr.Key == "subsystem" {
+			subsystem = attr.Value.String()
+		}
+	}
+
+	return &subsystemHandler{h.inner.WithAttrs(attrs), h.levels, subsystem}
+}
+
+func (h *subsystemHandler) WithGroup(name string) slog.Handler {
+	return &subsystemHandler{h.inner.WithGroup(name), h.levels, h.subsystem}
+}
+
+// newLogHandler returns a handler writing to w in the given format, filtering
+// by levels. With redact, addresses and serial numbers are masked.
+func newLogHandler(w io.Writer, format string, levels *logLevels, redact bool) (slog.Handler, error) {
+	// Let everything through to the inner handler; subsystemHandler does the
+	// filtering
+	opts := &slog.HandlerOptions{Level: slog.Level(-1 << 10)}
+	if redact {
+		opts.ReplaceAttr = redactAttr
+	}
+
+	var inner slog.Handler
+	switch format {
+	case LogFormatText:
+		inner = slog.NewTextHandler(w, opts)
+	case LogFormatJSON:
+		inner = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format %q, must be one of %s or %s", format, LogFormatText, LogFormatJSON)
+	}
+
+	return &subsystemHandler{inner: inner, levels: levels}, nil
+}
+
+// setupLogging configures the default logger, which all the subsystem loggers
+// write through.
+func setupLogging(w io.Writer, format, level string, redact bool) error {
+	levels, err := parseLogLevels(level)
+	if err != nil {
+		return err
+	}
+
+	handler, err := newLogHandler(w, format, levels, redact)
+	if err != nil {
+		return err
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// defaultHandler forwards to whatever the default logger's handler is at the
+// time of each call, so that loggers created at init time follow
+// setupLogging.
+type defaultHandler struct {
+	attrs []slog.Attr
+}
+
+func (h *defaultHandler) handler() slog.Handler {
+	return slog.Default().Handler().WithAttrs(h.attrs)
+}
+
+func (h *defaultHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler().Enabled(ctx, level)
+}
+
+func (h *defaultHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.handler().Handle(ctx, r)
+}
+
+func (h *defaultHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &defaultHandler{append(append([]slog.Attr{}, h.attrs...), attrs...)}
+}
+
+func (h *defaultHandler) WithGroup(name string) slog.Handler {
+	return h.handler().WithGroup(name)
+}
+
+func subsystemLogger(subsystem string) *slog.Logger {
+	return slog.New(&defaultHandler{[]slog.Attr{slog.String("subsystem", subsystem)}})
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLogLevels(t *testing.T) {
+	levels, err := parseLogLevels("warning,device=debug")
+	require.NoError(t, err)
+	require.Equal(t, slog.LevelWarn, levels.forSubsystem(SubsystemDiscovery))
+	require.Equal(t, slog.LevelDebug, levels.forSubsystem(SubsystemDevice))
+
+	levels, err = parseLogLevels("")
+	require.NoError(t, err)
+	require.Equal(t, slog.LevelInfo, levels.forSubsystem(SubsystemDevice))
+
+	for _, s := range []string{"loud", "info,bulbs=debug", "device=loud"} {
+		_, err := parseLogLevels(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestSubsystemLogging(t *testing.T) {
+	previous := slog.Default()
+	defer slog.SetDefault(previous)
+
+	var buf bytes.Buffer
+	require.NoError(t, setupLogging(&buf, LogFormatJSON, "info,device=debug", false))
+
+	deviceLog.Debug("Fetching light group", "address", "a.local")
+	discoveryLog.Debug("Not shown")
+	discoveryLog.Info("Found a light")
+	slog.Debug("Not shown either")
+
+	var records []map[string]any
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var record map[string]any
+		require.NoError(t, dec.Decode(&record))
+		records = append(records, record)
+	}
+
+	require.Len(t, records, 2)
+	require.Equal(t, "Fetching light group", records[0]["msg"])
+	require.Equal(t, SubsystemDevice, records[0]["subsystem"])
+	require.Equal(t, "a.local", records[0]["address"])
+	require.Equal(t, SubsystemDiscovery, records[1]["subsystem"])
+
+	require.Error(t, setupLogging(&buf, "xml", "info", false))
+}
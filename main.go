@@ -2,17 +2,24 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"math"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/endocrimes/keylight-go"
-	"github.com/sirupsen/logrus"
+	"github.com/iainlane/klctl/pkg/keylightctl"
 	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
 )
 
 type LightState int
@@ -42,338 +49,3415 @@ const (
 	ControlTemperature
 )
 
+func (cf LightControlField) String() string {
+	switch cf {
+	case ControlBrightness:
+		return "brightness"
+	case ControlTemperature:
+		return "temperature"
+	}
+
+	return ""
+}
+
+func parseLightControlField(s string) (LightControlField, error) {
+	for _, cf := range []LightControlField{ControlBrightness, ControlTemperature} {
+		if cf.String() == s {
+			return cf, nil
+		}
+	}
+
+	return 0, fmt.Errorf("field must be brightness or temperature (got %s)", s)
+}
+
 const defaultPort = "9123"
 
-var (
-	logLevel string
-	timeout  int
-)
+// Commands which don't talk to lights themselves, so there's no need to find
+// any before running them. Subcommands are given as "command subcommand".
+var commandsWithoutDevices = map[string]bool{
+	"at":            true,
+	"displays":      true,
+	"audio":         true,
+	"schedule":      true,
+	"discover":      true,
+	"__complete":    true,
+	"completion":    true,
+	"serve":         true,
+	"watch":         true,
+	"tui":           true,
+	"emulate":       true,
+	"log":           true,
+	"dmx":           true,
+	"stream":        true,
+	"wake":          true,
+	"mqtt":          true,
+	"tunnel":        true,
+	"weather":       true,
+	"cache":         true,
+	"claims":        true,
+	"group":         true,
+	"group list":    true,
+	"group add":     true,
+	"group remove":  true,
+	"secret":        true,
+	"secret set":    true,
+	"secret delete": true,
+	"resume":        true,
+	"history":       true,
+	"last":          true,
+	"scene list":    true,
+	"scene delete":  true,
+}
+
+// needsDevices reports whether the command in args talks to lights.
+func needsDevices(args cli.Args) bool {
+	if !args.Present() {
+		return false
+	}
+
+	return !commandsWithoutDevices[args.First()] &&
+		!commandsWithoutDevices[args.First()+" "+args.Get(1)]
+}
+
+// onlyShowsHelp reports whether args only ask for help, such as by naming a
+// command which groups others but not which of them, so need no lights.
+func onlyShowsHelp(app *cli.App, args cli.Args) bool {
+	if args.First() == "help" || args.First() == "h" ||
+		slices.Contains(args.Slice(), "--help") || slices.Contains(args.Slice(), "-h") {
+		return true
+	}
+
+	command := app.Command(args.First())
+	for i := 1; command != nil && len(command.Subcommands) > 0; i++ {
+		if i >= args.Len() {
+			return true
+		}
+
+		parent := command
+		command = nil
+		for _, sub := range parent.Subcommands {
+			if sub.HasName(args.Get(i)) {
+				command = sub
+			}
+		}
+	}
+
+	return false
+}
+
+var (
+	logLevel    string
+	logFormat   string
+	timeout     int
+	colorOutput bool
+
+	// temperatureUnit is the unit temperatures are shown and given in. When
+	// it's empty, commands use their defaults: status shows Kelvin, while get
+	// and set use the light's own units (mireds).
+	temperatureUnit TemperatureUnit
+	useLocks        bool
+	outputFormat    string
+	chaos           string
+	configPath      string
+	// profileName is the profile in the config file in use, if any.
+	profileName  string
+	confirmBlink bool
+	stagger      time.Duration
+	httpDefaults HTTPSettings
+	retries      RetryConfig
+
+	useDiscoveryCache     bool
+	refreshDiscoveryCache bool
+	fade                  time.Duration
+
+	// serverAddr is a klctl server to control the lights through, or
+	// serverAuto to find one.
+	serverAddr string
+
+	claimsPath string
+	onlyMine   bool
+	force      bool
+
+	// deviceMatch picks out which of the lights found to control.
+	deviceMatch DeviceMatch
+
+	// indexNames are the names the config file gives the lights of the
+	// devices being controlled.
+	indexNames IndexNames
+
+	// schedulesPath holds the commands serve runs on a schedule.
+	schedulesPath string
+
+	// dryRun prints the changes commands would make, rather than making them.
+	dryRun bool
+
+	// continueOnError changes the lights which can be reached when others
+	// can't, rather than none of them.
+	continueOnError bool
+
+	// noRedact turns off masking addresses and serial numbers in logs and
+	// reports.
+	noRedact bool
+)
+
+// prepareDevices finds the devices to control, as configured by the global
+// flags, and wraps them as those flags ask.
+func prepareDevices(ctx context.Context, lightAddrs, groups []string) ([]Device, error) {
+	cfg, err := loadActiveConfig()
+	if err != nil {
+		return nil, err
+	}
+	indexNames = cfg.indexNames()
+
+	if len(groups) > 0 {
+		members, err := cfg.groupLights(groups)
+		if err != nil {
+			return nil, err
+		}
+		lightAddrs = append(append([]string{}, lightAddrs...), members...)
+	}
+
+	var devices []Device
+	if serverAddr != "" {
+		devices, err = serverDevices(ctx, serverAddr, httpDefaults, lightAddrs)
+	} else {
+		devices, err = findDevices(ctx, cfg, lightAddrs)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(devices) == 0 {
+		return nil, errNoDevices
+	}
+
+	if !deviceMatch.isZero() {
+		// Through a server, the addresses aren't the ones discovery cached
+		var known map[string]string
+		if serverAddr == "" {
+			known = knownSerials(readDiscoveryCache(defaultDiscoveryCachePath()))
+		}
+
+		if devices, err = deviceMatch.apply(ctx, devices, known); err != nil {
+			return nil, err
+		}
+	}
+
+	claims, err := readClaims(claimsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := ClaimFilter{Claims: claims, Owner: currentOwner(), Mine: onlyMine, Force: force}
+	if devices, err = filter.apply(ctx, devices, len(lightAddrs) > 0); err != nil {
+		return nil, err
+	}
+
+	if chaos != "" {
+		cfg, err := parseChaosConfig(chaos)
+		if err != nil {
+			return nil, err
+		}
+
+		deviceLog.Warn("Injecting faults into device calls", "config", fmt.Sprintf("%+v", cfg))
+		devices = withChaos(devices, cfg)
+	}
+
+	devices = withRetries(devices, retries)
+
+	if dir := defaultStateDir(); dir != "" {
+		devices = withJournal(devices, newChangeJournal(dir))
+	}
+
+	// Outermost, so nothing unsent is journalled or retried
+	if dryRun {
+		devices = withDryRun(devices, os.Stderr)
+	}
+
+	return devices, nil
+}
+
+// findDevices returns the devices to control. When discovery is needed, the
+// discovery cache is used instead if --cached was given, and is updated
+// otherwise.
+func findDevices(ctx context.Context, cfg *Config, lightAddrs []string) ([]Device, error) {
+	cachePath := defaultDiscoveryCachePath()
+
+	if len(lightAddrs) == 0 && useDiscoveryCache && !refreshDiscoveryCache {
+		if cache := readDiscoveryCache(cachePath); len(cache.Devices) > 0 {
+			discoveryLog.Debug("Using cached devices", "updated", cache.UpdatedAt)
+
+			devices := devicesFromCache(cache)
+			for i, device := range devices {
+				var err error
+				if devices[i], err = withHTTPSettings(device, httpDefaults); err != nil {
+					return nil, err
+				}
+			}
+			sortDevices(devices)
+
+			return devices, nil
+		}
+	}
+
+	discovery, err := keylight.NewDiscovery()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create discovery client: %w", err)
+	}
+
+	devices, err := setupDevices(ctx, systemClock{}, cfg, lightAddrs, &DiscoveryWrapper{Discovery: discovery})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(lightAddrs) == 0 {
+		if err := cacheDiscoveredDevices(ctx, cachePath, devices); err != nil {
+			discoveryLog.Debug("Failed to cache discovered devices", "error", err)
+		}
+	}
+
+	return devices, nil
+}
+
+// splitLightAddress splits a light's address into its host and port, which
+// defaults to the usual Key Light port.
+func splitLightAddress(addr string) (string, int, error) {
+	return parseHostPort(addr, defaultPort)
+}
+
+// setupDevices returns the devices to control. --light values are looked up in
+// the config first, so they can be names, and if there aren't any we discover
+// lights on the network.
+func setupDevices(ctx context.Context, clock Clock, cfg *Config, lightAddrs []string, discoverer Discovery) ([]Device, error) {
+	var devices []Device
+
+	for _, light := range lightAddrs {
+		lightAddr, name := cfg.resolveLight(light)
+
+		host, p, err := splitLightAddress(lightAddr)
+		if err != nil {
+			return nil, err
+		}
+
+		device := keylightctl.NewDevice(name, host, p)
+		tuned, err := withHTTPSettings(device, cfg.httpSettings(name, httpDefaults))
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, tuned)
+	}
+
+	if len(devices) == 0 {
+		discoveryLog.Debug("No lights provided, running discovery")
+		devices, err := keylightctl.Discover(ctx, clock, discoverer)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, device := range devices {
+			if devices[i], err = withHTTPSettings(device, httpDefaults); err != nil {
+				return nil, err
+			}
+		}
+
+		sortDevices(devices)
+		return devices, nil
+	}
+
+	sortDevices(devices)
+	return devices, nil
+}
+
+func main() {
+	lightList := []Device{}
+
+	lightAddrs := cli.NewStringSlice()
+	lightGroups := cli.NewStringSlice()
+
+	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx := signalCtx
+	var cancel context.CancelFunc
+
+	app := &cli.App{
+		EnableBashCompletion: true,
+		// Exit codes are handled below, once the error has been logged
+		ExitErrHandler: func(*cli.Context, error) {},
+		OnUsageError:   usageError,
+		Description: "Every global flag can also be set with an environment variable named after it, " +
+			"such as KLCTL_LOG_LEVEL for --log-level, or in the defaults of the config profile in use. " +
+			"A flag given on the command line wins over the environment, which wins over the profile, " +
+			"which wins over the flag's own default. Slice flags such as KLCTL_LIGHT take comma-separated values.",
+		Flags: []cli.Flag{
+			&cli.StringSliceFlag{
+				Name:        "light",
+				Usage:       "Light to control (host:port, or a name from the config file)",
+				EnvVars:     []string{"KLCTL_LIGHT"},
+				Destination: lightAddrs,
+			},
+			&cli.StringSliceFlag{
+				Name:        "group",
+				Usage:       "Group of lights from the config file to control (can be repeated, and combined with --light)",
+				EnvVars:     []string{"KLCTL_GROUP"},
+				Destination: lightGroups,
+			},
+			&cli.StringFlag{
+				Name:        "config",
+				Usage:       "Path to the config file",
+				Value:       defaultConfigPath(),
+				EnvVars:     []string{"KLCTL_CONFIG"},
+				Destination: &configPath,
+			},
+			&cli.StringFlag{
+				Name:        "profile",
+				Usage:       "Profile in the config file to use, with its own lights, groups and flag defaults",
+				EnvVars:     []string{"KLCTL_PROFILE"},
+				Destination: &profileName,
+			},
+			&cli.StringFlag{
+				Name:        "log-level",
+				Usage:       "Level of logging, optionally per subsystem, e.g. info,device=debug (subsystems: discovery, device, api, automation)",
+				Value:       "info",
+				EnvVars:     []string{"KLCTL_LOG_LEVEL"},
+				Destination: &logLevel,
+			},
+			&cli.BoolFlag{
+				Name:        "no-redact",
+				Usage:       "Show addresses and serial numbers in logs and reports, rather than masking them",
+				EnvVars:     []string{"KLCTL_NO_REDACT"},
+				Destination: &noRedact,
+			},
+			&cli.StringFlag{
+				Name:        "log-format",
+				Usage:       "Format of log output (text or json)",
+				Value:       LogFormatText,
+				EnvVars:     []string{"KLCTL_LOG_FORMAT"},
+				Destination: &logFormat,
+			},
+			&cli.IntFlag{
+				Name:        "timeout",
+				Usage:       "Timeout in seconds for operations",
+				Value:       10,
+				EnvVars:     []string{"KLCTL_TIMEOUT"},
+				Destination: &timeout,
+			},
+			&cli.BoolFlag{
+				Name:        "lock",
+				Usage:       "Take per-device lock files so concurrent invocations don't clobber each other",
+				EnvVars:     []string{"KLCTL_LOCK"},
+				Destination: &useLocks,
+			},
+			&cli.StringFlag{
+				Name:        "output",
+				Aliases:     []string{"o"},
+				Usage:       "Output format (text or json). status also takes table, wide and yaml",
+				Value:       OutputText,
+				EnvVars:     []string{"KLCTL_OUTPUT"},
+				Destination: &outputFormat,
+			},
+			&cli.StringFlag{
+				Name:    "temperature-unit",
+				Usage:   "Unit to show and accept temperatures in (kelvin or mired). By default status shows Kelvin, and get and set use mireds",
+				EnvVars: []string{"KLCTL_TEMPERATURE_UNIT"},
+			},
+			&cli.DurationFlag{
+				Name:        "fade",
+				Usage:       "Change brightness, temperature and power gradually over this long, rather than all at once",
+				EnvVars:     []string{"KLCTL_FADE"},
+				Destination: &fade,
+			},
+			&cli.StringFlag{
+				Name:        "server",
+				Usage:       "Control the lights through the klctl serve at this address, or \"auto\" to find one on the network",
+				EnvVars:     []string{"KLCTL_SERVER"},
+				Destination: &serverAddr,
+			},
+			&cli.StringFlag{
+				Name:        "claims-file",
+				Usage:       "File recording who lights belong to, which can be shared with others on the network",
+				Value:       defaultClaimsPath(),
+				EnvVars:     []string{"KLCTL_CLAIMS_FILE"},
+				Destination: &claimsPath,
+			},
+			&cli.StringFlag{
+				Name:        "schedules-file",
+				Usage:       "File holding the commands klctl serve runs on a schedule",
+				Value:       defaultSchedulesPath(),
+				EnvVars:     []string{"KLCTL_SCHEDULES_FILE"},
+				Destination: &schedulesPath,
+			},
+			&cli.StringSliceFlag{
+				Name:    "match",
+				Usage:   "Only control lights whose name matches this pattern, e.g. 'Elgato Key Light Air*' (can be repeated)",
+				EnvVars: []string{"KLCTL_MATCH"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "serial",
+				Usage:   "Only control the light with this serial number (can be repeated)",
+				EnvVars: []string{"KLCTL_SERIAL"},
+			},
+			&cli.BoolFlag{
+				Name:        "mine",
+				Usage:       "Only control lights claimed by you",
+				EnvVars:     []string{"KLCTL_MINE"},
+				Destination: &onlyMine,
+			},
+			&cli.BoolFlag{
+				Name:        "force",
+				Usage:       "Control lights claimed by someone else",
+				EnvVars:     []string{"KLCTL_FORCE"},
+				Destination: &force,
+			},
+			&cli.BoolFlag{
+				Name:        "cached",
+				Usage:       "Use the lights found by the last discovery, rather than discovering them again",
+				EnvVars:     []string{"KLCTL_CACHED"},
+				Destination: &useDiscoveryCache,
+			},
+			&cli.BoolFlag{
+				Name:        "refresh",
+				Usage:       "Discover lights afresh and update the cache, even with --cached",
+				EnvVars:     []string{"KLCTL_REFRESH"},
+				Destination: &refreshDiscoveryCache,
+			},
+			&cli.DurationFlag{
+				Name:        "connect-timeout",
+				Usage:       "Timeout for connecting to each light (overridable per light in the config)",
+				EnvVars:     []string{"KLCTL_CONNECT_TIMEOUT"},
+				Destination: &httpDefaults.ConnectTimeout,
+			},
+			&cli.DurationFlag{
+				Name:        "request-timeout",
+				Usage:       "Timeout for each request to a light (overridable per light in the config)",
+				EnvVars:     []string{"KLCTL_REQUEST_TIMEOUT"},
+				Destination: &httpDefaults.RequestTimeout,
+			},
+			&cli.DurationFlag{
+				Name:        "keepalive",
+				Usage:       "TCP keepalive period for connections to lights, negative to disable (overridable per light in the config)",
+				EnvVars:     []string{"KLCTL_KEEPALIVE"},
+				Destination: &httpDefaults.KeepAlive,
+			},
+			&cli.StringFlag{
+				Name:        "proxy",
+				Usage:       "Reach lights through this proxy, e.g. socks5://localhost:1080 (overridable per light in the config)",
+				EnvVars:     []string{"KLCTL_PROXY"},
+				Destination: &httpDefaults.Proxy,
+			},
+			&cli.StringFlag{
+				Name:        "user-agent",
+				Usage:       "User-Agent to send with requests to lights (overridable per light in the config)",
+				EnvVars:     []string{"KLCTL_USER_AGENT"},
+				Destination: &httpDefaults.UserAgent,
+			},
+			&cli.StringSliceFlag{
+				Name:    "header",
+				Usage:   "Header to send with requests to lights, e.g. \"X-Auth: secret\" (can be repeated, and added to per light in the config)",
+				EnvVars: []string{"KLCTL_HEADER"},
+			},
+			&cli.IntFlag{
+				Name:        "retries",
+				Usage:       "Number of times to retry a failed request to a light",
+				Value:       defaultRetries,
+				EnvVars:     []string{"KLCTL_RETRIES"},
+				Destination: &retries.Retries,
+			},
+			&cli.DurationFlag{
+				Name:        "retry-delay",
+				Usage:       "Delay before the first retry of a failed request, doubling for each retry after that",
+				Value:       defaultRetryDelay,
+				EnvVars:     []string{"KLCTL_RETRY_DELAY"},
+				Destination: &retries.Delay,
+			},
+			&cli.DurationFlag{
+				Name:        "stagger",
+				Usage:       "Delay between powering on each device, to spread out the current draw",
+				EnvVars:     []string{"KLCTL_STAGGER"},
+				Destination: &stagger,
+			},
+			&cli.BoolFlag{
+				Name:        "confirm-blink",
+				Usage:       "Double-blink the lights after a successful change, as confirmation",
+				EnvVars:     []string{"KLCTL_CONFIRM_BLINK"},
+				Destination: &confirmBlink,
+			},
+			&cli.BoolFlag{
+				Name:        "continue-on-error",
+				Usage:       "Change the lights which can be reached, rather than none of them, when some can't",
+				EnvVars:     []string{"KLCTL_CONTINUE_ON_ERROR"},
+				Destination: &continueOnError,
+			},
+			&cli.BoolFlag{
+				Name:        "dry-run",
+				Usage:       "Print how the lights would change, without changing them",
+				EnvVars:     []string{"KLCTL_DRY_RUN"},
+				Destination: &dryRun,
+			},
+			&cli.StringFlag{
+				Name:        "chaos",
+				Usage:       "Inject faults into device calls, e.g. failures=0.2,latency=500ms",
+				Hidden:      true,
+				EnvVars:     []string{"KLCTL_CHAOS"},
+				Destination: &chaos,
+			},
+		},
+
+		Before: func(c *cli.Context) error {
+			// First, so the profile's defaults are used as if they were given
+			if profileName != "" {
+				cfg, err := loadConfig(configPath)
+				if err != nil {
+					return err
+				}
+
+				defaults, err := cfg.useProfile(profileName)
+				if err != nil {
+					return invalidArgument(err)
+				}
+				if err := applyProfileDefaults(c, profileName, defaults); err != nil {
+					return invalidArgument(err)
+				}
+			}
+
+			if err := setupLogging(os.Stderr, logFormat, logLevel, !noRedact); err != nil {
+				return err
+			}
+
+			if err := validateOutputFormat(outputFormat, c.Args().First()); err != nil {
+				return invalidArgument(err)
+			}
+
+			colorOutput = colorEnabled(os.Stdout)
+
+			if dryRun {
+				// Nothing happens, so there's nothing to fade, stagger or confirm
+				fade, stagger, confirmBlink = 0, 0, false
+			}
+
+			for _, header := range c.StringSlice("header") {
+				name, value, err := parseHeader(header)
+				if err != nil {
+					return invalidArgument(err)
+				}
+
+				httpDefaults = httpDefaults.merge(HTTPSettings{Headers: map[string]string{name: value}})
+			}
+
+			deviceMatch = DeviceMatch{Names: c.StringSlice("match"), Serials: c.StringSlice("serial")}
+			if err := deviceMatch.validate(); err != nil {
+				return err
+			}
+
+			if c.IsSet("temperature-unit") {
+				var err error
+				temperatureUnit, err = parseTemperatureUnit(c.String("temperature-unit"))
+				if err != nil {
+					return invalidArgument(err)
+				}
+			}
+
+			if c.NArg() > 0 && c.App.Command(c.Args().First()) == nil {
+				return unknownCommand(c)
+			}
+
+			// Through a server, lights are named in its config, not ours
+			if lights := lightAddrs.Value(); len(lights) > 0 && serverAddr == "" {
+				cfg, err := loadActiveConfig()
+				if err != nil {
+					return err
+				}
+				if err := cfg.checkLights(lights); err != nil {
+					return invalidArgument(err)
+				}
+			}
+
+			if c.NArg() > 0 && !commandsNotRecorded[c.Args().First()] && os.Getenv(noHistoryEnv) == "" {
+				entry := newHistoryEntry(time.Now().UTC(), os.Args[1:])
+				if err := appendHistory(defaultHistoryPath(), entry); err != nil {
+					slog.Debug("Failed to record command history", "error", err)
+				}
+			}
+
+			if !needsDevices(c.Args()) || onlyShowsHelp(c.App, c.Args()) {
+				return nil
+			}
+
+			// A fade takes as long as it takes, on top of the usual timeout
+			ctx, cancel = context.WithTimeout(signalCtx, time.Duration(timeout)*time.Second+fade)
+
+			var err error
+			lightList, err = prepareDevices(ctx, lightAddrs.Value(), lightGroups.Value())
+			return err
+		},
+
+		After: func(c *cli.Context) error {
+			if cancel != nil {
+				cancel()
+			}
+			return nil
+		},
+
+		Commands: []*cli.Command{
+			{
+				Name:  "history",
+				Usage: "Show previously run commands",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "external",
+						Usage: "List changes made to the lights by something other than klctl",
+						Action: func(c *cli.Context) error {
+							changes, err := readExternalChanges(defaultStateDir())
+							if err != nil {
+								return err
+							}
+
+							if outputFormat == OutputJSON {
+								return writeJSON(os.Stdout, changes)
+							}
+
+							for _, change := range changes {
+								fmt.Println(change)
+							}
+							return nil
+						},
+					},
+					{
+						Name:  "commands",
+						Usage: "List recorded klctl invocations, oldest first",
+						Action: func(c *cli.Context) error {
+							entries, err := readHistory(defaultHistoryPath())
+							if err != nil {
+								return err
+							}
+
+							if outputFormat == OutputJSON {
+								if entries == nil {
+									entries = []HistoryEntry{}
+								}
+								return writeJSON(os.Stdout, entries)
+							}
+
+							for _, entry := range entries {
+								fmt.Println(entry)
+							}
+							return nil
+						},
+					},
+				},
+			},
+			{
+				Name:  "last",
+				Usage: "Show the last command, or run it again with --rerun",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "rerun",
+						Usage: "Run the command again",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					entry, err := lastHistoryEntry(defaultHistoryPath())
+					if err != nil {
+						return err
+					}
+
+					if !c.Bool("rerun") {
+						fmt.Println(entry)
+						return nil
+					}
+
+					args, err := entry.rerunArgs()
+					if err != nil {
+						return err
+					}
+
+					slog.Info("Running command again", "command", strings.Join(args, " "))
+					return runKlctl(signalCtx, args)
+				},
+			},
+			{
+				Name:  "discover",
+				Usage: "List the lights found on the network",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:  "timeout",
+						Usage: "Timeout in seconds for discovery (defaults to the global --timeout)",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					discoveryTimeout := timeout
+					if c.IsSet("timeout") {
+						discoveryTimeout = c.Int("timeout")
+					}
+
+					discoverCtx, cancel := context.WithTimeout(signalCtx, time.Duration(discoveryTimeout)*time.Second)
+					defer cancel()
+
+					discovery, err := keylight.NewDiscovery()
+					if err != nil {
+						return fmt.Errorf("failed to create discovery client: %w", err)
+					}
+
+					devices, err := discoverCommand(discoverCtx, systemClock{}, &DiscoveryWrapper{Discovery: discovery})
+					if err != nil {
+						return err
+					}
+
+					if err := writeDiscoveryCache(defaultDiscoveryCachePath(), cachedFromDiscovered(devices)); err != nil {
+						discoveryLog.Debug("Failed to cache discovered devices", "error", err)
+					}
+
+					// Every light is cached, but only the ones picked out are shown
+					if devices, err = deviceMatch.filterDiscovered(devices); err != nil {
+						return err
+					}
+
+					return renderDiscovered(os.Stdout, outputFormat, devices)
+				},
+			},
+			{
+				Name:  "cache",
+				Usage: "Inspect or clear the cache of discovered lights",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "show",
+						Usage: "List the cached lights",
+						Action: func(c *cli.Context) error {
+							return renderDiscoveryCache(os.Stdout, outputFormat, readDiscoveryCache(defaultDiscoveryCachePath()))
+						},
+					},
+					{
+						Name:  "clear",
+						Usage: "Forget the cached lights",
+						Action: func(c *cli.Context) error {
+							return clearDiscoveryCache(defaultDiscoveryCachePath())
+						},
+					},
+				},
+			},
+			{
+				Name:  "secret",
+				Usage: "Manage secrets kept in the OS keychain rather than the config file",
+				Description: "A config value of keyring:NAME is replaced by the secret NAME from the keychain\n" +
+					"when it's needed, for example:\n\n" +
+					"   mqtt:\n" +
+					"     password: keyring:mqtt",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "set",
+						Usage:     "Store a secret, prompting for it or reading it from standard input",
+						ArgsUsage: "NAME",
+						Action: func(c *cli.Context) error {
+							if c.NArg() != 1 {
+								return invalidArgumentf("usage: %s secret set NAME", c.App.Name)
+							}
+							name := c.Args().First()
+
+							secret, err := readSecret(os.Stdin, os.Stderr, name)
+							if err != nil {
+								return err
+							}
+							if err := setSecret(name, secret); err != nil {
+								return err
+							}
+
+							fmt.Fprintf(os.Stderr, "Stored %s. Use it in the config as %s%s\n", name, secretPrefix, name)
+							return nil
+						},
+					},
+					{
+						Name:      "delete",
+						Usage:     "Remove a secret",
+						ArgsUsage: "NAME",
+						Action: func(c *cli.Context) error {
+							if c.NArg() != 1 {
+								return invalidArgumentf("usage: %s secret delete NAME", c.App.Name)
+							}
+
+							return deleteSecret(c.Args().First())
+						},
+					},
+				},
+			},
+			{
+				Name:  "group",
+				Usage: "Manage groups of lights in the config file",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "list",
+						Usage: "List the groups and the lights in them",
+						Action: func(c *cli.Context) error {
+							cfg, err := loadActiveConfig()
+							if err != nil {
+								return err
+							}
+
+							return renderGroups(os.Stdout, outputFormat, cfg)
+						},
+					},
+					{
+						Name:      "add",
+						Usage:     "Add lights to a group, creating it if need be",
+						ArgsUsage: "GROUP LIGHT...",
+						Action: func(c *cli.Context) error {
+							if c.NArg() < 2 {
+								return invalidArgumentf("usage: %s group add GROUP LIGHT...", c.App.Name)
+							}
+
+							return addToGroup(configPath, profileName, c.Args().First(), c.Args().Tail())
+						},
+					},
+					{
+						Name:      "remove",
+						Usage:     "Remove lights from a group, or the whole group if no lights are given",
+						ArgsUsage: "GROUP [LIGHT...]",
+						Action: func(c *cli.Context) error {
+							if c.NArg() < 1 {
+								return invalidArgumentf("usage: %s group remove GROUP [LIGHT...]", c.App.Name)
+							}
+
+							return removeFromGroup(configPath, profileName, c.Args().First(), c.Args().Tail())
+						},
+					},
+				},
+			},
+			{
+				Name:  "claims",
+				Usage: "List who lights have been claimed by",
+				Action: func(c *cli.Context) error {
+					claims, err := readClaims(claimsPath)
+					if err != nil {
+						return err
+					}
+
+					return renderClaims(os.Stdout, outputFormat, claims)
+				},
+			},
+			{
+				Name:  "serve",
+				Usage: "Serve a local REST API for controlling the lights",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "listen",
+						Usage: "Address to listen on",
+						Value: defaultListenAddress,
+					},
+					&cli.BoolFlag{
+						Name:  "announce",
+						Usage: "Announce the server with mDNS, so --server auto can find it (unless listening on loopback)",
+						Value: true,
+					},
+					&cli.StringFlag{
+						Name:  "role",
+						Usage: "When several servers run on the network, whether this one should lead (primary), only lead when alone (standby), or be elected (auto)",
+						Value: RoleAuto,
+					},
+					&cli.DurationFlag{
+						Name:  "metrics-interval",
+						Usage: "How often to poll the lights for the Prometheus metrics at /metrics, or 0 to not serve metrics",
+						Value: defaultMetricsInterval,
+					},
+					&cli.DurationFlag{
+						Name:  "events-interval",
+						Usage: "How often to poll the lights for changes to send to WebSocket clients at /ws, or 0 to not serve events",
+						Value: defaultWatchInterval,
+					},
+					&cli.StringFlag{
+						Name:  "conflict-policy",
+						Usage: "What schedules do once the lights are changed by hand: run anyway (daemon-wins), wait a while (e.g. cli-wins-for-30m), or wait until klctl resume (manual)",
+						Value: PolicyDaemonWins,
+					},
+					&cli.StringFlag{
+						Name:  "socket",
+						Usage: "Unix socket to listen on as well, for klctl on this machine to say when it changes the lights, or empty to not listen",
+						Value: defaultSocketPath(),
+					},
+				},
+				Action: func(c *cli.Context) error {
+					// Find the lights once, up front, with the usual timeout.
+					// Requests then get the timeout for their own device calls.
+					setupCtx, cancel := context.WithTimeout(signalCtx, time.Duration(timeout)*time.Second)
+					devices, err := prepareDevices(setupCtx, lightAddrs.Value(), lightGroups.Value())
+					cancel()
+					if err != nil {
+						return err
+					}
+
+					role := c.String("role")
+					if err := validateRole(role); err != nil {
+						return invalidArgument(err)
+					}
+
+					policy, err := parseConflictPolicy(c.String("conflict-policy"))
+					if err != nil {
+						return invalidArgument(err)
+					}
+
+					var metrics *Metrics
+					if c.Duration("metrics-interval") > 0 {
+						metrics = newMetrics()
+						devices = metrics.wrap(devices)
+						go metrics.run(signalCtx, c.Duration("metrics-interval"), time.Duration(timeout)*time.Second)
+					}
+
+					server := newAPIServer(devices, time.Duration(timeout)*time.Second+fade)
+					server.allowHost(c.String("listen"))
+					server.metrics = metrics
+					if c.Duration("events-interval") > 0 {
+						server.events = newEventHub(devices, time.Duration(timeout)*time.Second)
+						go server.events.run(signalCtx, c.Duration("events-interval"))
+					}
+					server.timers = newTimers(signalCtx, systemClock{}, time.Duration(timeout)*time.Second+fade)
+					server.arbiter = newArbiter(policy, systemClock{})
+
+					// The election is set up before the scheduler and socket,
+					// which ask it who leads, are started
+					if c.Bool("announce") && !isLoopback(c.String("listen")) {
+						stop, err := announceServer(c.String("listen"), []string{rolePrefix + role})
+						if err != nil {
+							return err
+						}
+						defer stop()
+
+						server.election = newElection(Peer{Instance: serverInstance(), Role: role}, browsePeers)
+						go server.election.run(signalCtx)
+					}
+
+					scheduler := &Scheduler{
+						path:     schedulesPath,
+						clock:    systemClock{},
+						isLeader: func() bool { return server.election.IsLeader() },
+						arbiter:  server.arbiter,
+						exec: func(ctx context.Context, command []string) error {
+							return runKlctl(ctx, append(globalArgsBefore("serve"), command...), automationEnv+"=1")
+						},
+					}
+					go scheduler.run(signalCtx)
+
+					if socket := c.String("socket"); socket != "" {
+						go func() {
+							if err := serveSocket(signalCtx, socket, server); err != nil {
+								apiLog.Warn("Not listening on the socket, so changes by hand on this machine won't be noticed", "socket", socket, "error", err)
+							}
+						}()
+					}
+
+					return serve(signalCtx, c.String("listen"), server)
+				},
+			},
+			{
+				Name:      "emulate",
+				Usage:     "Make a light which isn't a Key Light look like one, so Control Center and Stream Deck can control it",
+				ArgsUsage: " ",
+				Description: "The Key Light API is served on --listen and announced with mDNS, as a Key Light would be.\n" +
+					"Colour lights are set to white.",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "backend",
+						Usage:    "Kind of light to emulate a Key Light with: " + strings.Join(emulatedBackendNames(), ", "),
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "address",
+						Usage:    "Address of the light, host[:port]",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "listen",
+						Usage: "Address to serve the Key Light API on",
+						Value: ":" + defaultPort,
+					},
+					&cli.StringFlag{
+						Name:  "name",
+						Usage: "Name to announce the light as (default: the light's own name)",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					newBackend, ok := emulatedBackends[c.String("backend")]
+					if !ok {
+						return invalidArgumentf("--backend must be one of %s (got %s)", strings.Join(emulatedBackendNames(), ", "), c.String("backend"))
+					}
+
+					backend, err := newBackend(c.String("address"))
+					if err != nil {
+						return invalidArgument(err)
+					}
+
+					requestTimeout := time.Duration(timeout) * time.Second
+					name := c.String("name")
+					if name == "" {
+						nameCtx, cancel := context.WithTimeout(signalCtx, requestTimeout)
+						name, err = backend.Name(nameCtx)
+						cancel()
+						if err != nil {
+							return err
+						}
+						if name == "" {
+							name = "klctl " + c.String("backend")
+						}
+					}
+
+					emulator := newEmulator(backend, name, c.String("address"), requestTimeout)
+					return runEmulator(signalCtx, c.String("listen"), emulator)
+				},
+			},
+			{
+				Name:  "displays",
+				Usage: "Run commands when displays are connected or disconnected, such as when docking",
+				Subcommands: makePeripheralSubcommands(signalCtx, PeripheralCommand{
+					Name:      "displays",
+					Singular:  "display",
+					Plural:    "displays",
+					NewSource: newDisplaySource,
+					Example:   "to apply the desk scene when docking:\n\n   klctl displays watch --connect 'scene apply desk' --disconnect off",
+				}),
+			},
+			{
+				Name:  "audio",
+				Usage: "Run commands when microphones or headsets are connected or disconnected, such as before recording",
+				Subcommands: makePeripheralSubcommands(signalCtx, PeripheralCommand{
+					Name:      "audio",
+					Singular:  "device",
+					Plural:    "microphones and headsets",
+					NewSource: newAudioSource,
+					Example:   "to apply the recording scene when a headset is plugged in:\n\n   klctl audio watch --connect 'scene apply recording' --disconnect 'scene apply desk'",
+				}),
+			},
+			{
+				Name:  "schedule",
+				Usage: "Run commands on a schedule, with klctl serve",
+				Subcommands: []*cli.Command{
+					{
+						Name:            "add",
+						Usage:           "Run a command whenever a cron expression matches, e.g. schedule add \"0 9 * * 1-5\" set --on --brightness 50",
+						ArgsUsage:       "CRON COMMAND [ARGS...]",
+						SkipFlagParsing: true,
+						Action: func(c *cli.Context) error {
+							if c.NArg() < 2 {
+								return invalidArgumentf("usage: %s schedule add CRON COMMAND [ARGS...]", c.App.Name)
+							}
+
+							command := c.Args().Tail()
+							if c.App.Command(command[0]) == nil {
+								return invalidArgumentf("unknown command %s", command[0])
+							}
+
+							schedules, err := readSchedules(schedulesPath)
+							if err != nil {
+								return err
+							}
+
+							schedules, entry, err := schedules.add(c.Args().First(), command, time.Now())
+							if err != nil {
+								return err
+							}
+
+							if err := writeSchedules(schedulesPath, schedules); err != nil {
+								return err
+							}
+
+							automationLog.Info("Added schedule", "id", entry.ID, "cron", entry.Cron, "command", strings.Join(command, " "))
+							return nil
+						},
+					},
+					{
+						Name:  "list",
+						Usage: "List the schedules, and when they next run",
+						Action: func(c *cli.Context) error {
+							schedules, err := readSchedules(schedulesPath)
+							if err != nil {
+								return err
+							}
+
+							return renderSchedules(os.Stdout, outputFormat, schedules, time.Now())
+						},
+					},
+					{
+						Name:      "remove",
+						Usage:     "Remove a schedule",
+						ArgsUsage: "ID",
+						Action: func(c *cli.Context) error {
+							id, err := parseIntInRange(c.Args().First(), 1, maxNumber)
+							if err != nil {
+								return invalidArgumentf("invalid schedule ID: %w", err)
+							}
+
+							schedules, err := readSchedules(schedulesPath)
+							if err != nil {
+								return err
+							}
+
+							if schedules, err = schedules.remove(id); err != nil {
+								return err
+							}
+
+							return writeSchedules(schedulesPath, schedules)
+						},
+					},
+				},
+			},
+			{
+				Name:  "watch",
+				Usage: "Print a line, or a JSON object with --output json, whenever a light changes",
+				Flags: []cli.Flag{
+					&cli.DurationFlag{
+						Name:  "interval",
+						Usage: "How often to check the lights",
+						Value: defaultWatchInterval,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.Duration("interval") <= 0 {
+						return invalidArgumentf("--interval must be positive")
+					}
+
+					// Watching goes on until interrupted, so only finding the
+					// lights, and each poll, gets the timeout
+					setupCtx, cancel := context.WithTimeout(signalCtx, time.Duration(timeout)*time.Second)
+					devices, err := prepareDevices(setupCtx, lightAddrs.Value(), lightGroups.Value())
+					cancel()
+					if err != nil {
+						return err
+					}
+
+					watcher := newWatcher(devices, time.Duration(timeout)*time.Second)
+					return watcher.run(signalCtx, c.Duration("interval"), os.Stdout, outputFormat)
+				},
+			},
+			{
+				Name:      "log",
+				Usage:     "Record the state of the lights to a CSV file every so often, to line up with recordings later",
+				ArgsUsage: " ",
+				Description: "Rows are added to the file if it already exists, so logging can be stopped and\n" +
+					"started again. With --rotate, each day is logged to its own file, named with the date.",
+				Flags: []cli.Flag{
+					&cli.DurationFlag{
+						Name:  "interval",
+						Usage: "How often to record the lights",
+						Value: defaultStateLogInterval,
+					},
+					&cli.StringFlag{
+						Name:     "out",
+						Usage:    "CSV file to add to",
+						Required: true,
+					},
+					&cli.BoolFlag{
+						Name:  "rotate",
+						Usage: "Start a new file each day, such as state-2024-03-01.csv for --out state.csv",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.Duration("interval") <= 0 {
+						return invalidArgumentf("--interval must be positive")
+					}
+
+					// Logging goes on until interrupted, so only finding the
+					// lights, and each sample, gets the timeout
+					setupCtx, cancel := context.WithTimeout(signalCtx, time.Duration(timeout)*time.Second)
+					devices, err := prepareDevices(setupCtx, lightAddrs.Value(), lightGroups.Value())
+					cancel()
+					if err != nil {
+						return err
+					}
+
+					logger := &StateLogger{
+						devices:        devices,
+						clock:          systemClock{},
+						requestTimeout: time.Duration(timeout) * time.Second,
+						path:           c.String("out"),
+						rotate:         c.Bool("rotate"),
+					}
+					return logger.run(signalCtx, c.Duration("interval"))
+				},
+			},
+			{
+				Name:      "dmx",
+				Usage:     "Make the lights look like DMX fixtures to a lighting console, over Art-Net",
+				ArgsUsage: " ",
+				Description: "Each light takes two channels, intensity then colour temperature, starting at\n" +
+					"--address and going through the lights in order. Intensity 0 is off, and colour\n" +
+					"temperature runs from warmest at 0 to coolest at 255.\n\n" +
+					"By default the state of the lights is sent to the console. With --control, the\n" +
+					"console sets the lights instead.",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:  "universe",
+						Usage: "Art-Net universe, as a 15 bit Port-Address",
+					},
+					&cli.IntFlag{
+						Name:  "address",
+						Usage: "Channel of the first light, from 1",
+						Value: 1,
+					},
+					&cli.StringFlag{
+						Name:  "send-to",
+						Usage: "Where to send the state of the lights",
+						Value: "255.255.255.255",
+					},
+					&cli.DurationFlag{
+						Name:  "interval",
+						Usage: "How often to send the state of the lights",
+						Value: defaultDMXInterval,
+					},
+					&cli.BoolFlag{
+						Name:  "control",
+						Usage: "Let the console control the lights, rather than sending their state to it",
+					},
+					&cli.StringFlag{
+						Name:  "listen",
+						Usage: "Address to receive Art-Net on, with --control",
+						Value: fmt.Sprintf(":%d", artNetPort),
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if u := c.Int("universe"); u < 0 || u > maxArtNetUniverse {
+						return invalidArgumentf("--universe must be between 0 and %d (got %d)", maxArtNetUniverse, u)
+					}
+					if a := c.Int("address"); a < 1 || a > dmxChannels {
+						return invalidArgumentf("--address must be between 1 and %d (got %d)", dmxChannels, a)
+					}
+					if c.Duration("interval") <= 0 {
+						return invalidArgumentf("--interval must be positive")
+					}
+
+					// The bridge runs until interrupted, so only finding the
+					// lights, and each request, gets the timeout
+					setupCtx, cancel := context.WithTimeout(signalCtx, time.Duration(timeout)*time.Second)
+					devices, err := prepareDevices(setupCtx, lightAddrs.Value(), lightGroups.Value())
+					cancel()
+					if err != nil {
+						return err
+					}
+
+					bridge := &DMXBridge{
+						devices:        devices,
+						clock:          systemClock{},
+						requestTimeout: time.Duration(timeout) * time.Second,
+						Universe:       uint16(c.Int("universe")),
+						Address:        c.Int("address"),
+					}
+
+					if c.Bool("control") {
+						conn, err := net.ListenPacket("udp", c.String("listen"))
+						if err != nil {
+							return err
+						}
+						return bridge.receive(signalCtx, conn)
+					}
+
+					host, port, err := parseHostPort(c.String("send-to"), strconv.Itoa(artNetPort))
+					if err != nil {
+						return invalidArgument(err)
+					}
+					conn, err := net.Dial("udp", net.JoinHostPort(host, strconv.Itoa(port)))
+					if err != nil {
+						return err
+					}
+					defer conn.Close()
+
+					return bridge.send(signalCtx, conn, c.Duration("interval"))
+				},
+			},
+			{
+				Name:      "stream",
+				Usage:     "Set the brightness of the lights from a fast stream of values, such as from a music visualiser",
+				ArgsUsage: " ",
+				Description: "Each line of standard input, or each UDP packet with --listen, is a frame of\n" +
+					"brightness values from 0 to 100, separated by spaces or commas. One value sets\n" +
+					"every light; otherwise the values go to the lights in order. 0 turns a light\n" +
+					"off. Each light is sent the newest frame as fast as it can take them, skipping\n" +
+					"any which arrive while it's busy.\n\n" +
+					"   pulse-to-music | klctl stream",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "listen",
+						Usage: "Address to receive frames on over UDP, rather than reading standard input, e.g. :9000",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					// The stream runs until it ends or is interrupted, so only
+					// finding the lights, and each request, gets the timeout
+					setupCtx, cancel := context.WithTimeout(signalCtx, time.Duration(timeout)*time.Second)
+					devices, err := prepareDevices(setupCtx, lightAddrs.Value(), lightGroups.Value())
+					cancel()
+					if err != nil {
+						return err
+					}
+
+					ss := &StreamSync{
+						devices:        devices,
+						requestTimeout: time.Duration(timeout) * time.Second,
+					}
+
+					if listen := c.String("listen"); listen != "" {
+						conn, err := net.ListenPacket("udp", listen)
+						if err != nil {
+							return err
+						}
+						return ss.receive(signalCtx, conn)
+					}
+
+					return ss.read(signalCtx, os.Stdin)
+				},
+			},
+			{
+				Name:  "mqtt",
+				Usage: "Bridge the lights to an MQTT broker, with Home Assistant discovery",
+				Description: "Publishes the state of each light, and applies commands sent to it, using\n" +
+					"Home Assistant's JSON schema. Home Assistant discovers the lights as its own.\n" +
+					"The broker can also be set in the config file:\n\n" +
+					"   mqtt:\n" +
+					"     broker: tcp://homeassistant.local:1883\n" +
+					"     username: klctl\n" +
+					"     password: keyring:mqtt\n\n" +
+					"See klctl secret for keeping the password in the OS keychain.",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "broker",
+						Usage:   "URL of the MQTT broker, e.g. tcp://localhost:1883",
+						EnvVars: []string{"KLCTL_MQTT_BROKER"},
+					},
+					&cli.StringFlag{
+						Name:  "username",
+						Usage: "Username for the broker",
+					},
+					&cli.StringFlag{
+						Name:    "password",
+						Usage:   "Password for the broker",
+						EnvVars: []string{"KLCTL_MQTT_PASSWORD"},
+					},
+					&cli.StringFlag{
+						Name:  "client-id",
+						Usage: "Client ID to connect with (default: klctl-HOSTNAME)",
+					},
+					&cli.StringFlag{
+						Name:  "topic-prefix",
+						Usage: "Prefix of the lights' topics (default: " + defaultMQTTTopicPrefix + ")",
+					},
+					&cli.StringFlag{
+						Name:  "discovery-prefix",
+						Usage: "Home Assistant's discovery prefix (default: " + defaultMQTTDiscoveryPrefix + ")",
+					},
+					&cli.DurationFlag{
+						Name:  "interval",
+						Usage: "How often to check the lights for changes made elsewhere",
+						Value: defaultMQTTInterval,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.Duration("interval") <= 0 {
+						return invalidArgumentf("--interval must be positive")
+					}
+
+					cfg, err := loadActiveConfig()
+					if err != nil {
+						return err
+					}
+
+					mqttCfg := defaultMQTTConfig().merge(cfg.MQTT).merge(MQTTConfig{
+						Broker:          c.String("broker"),
+						Username:        c.String("username"),
+						Password:        c.String("password"),
+						ClientID:        c.String("client-id"),
+						TopicPrefix:     c.String("topic-prefix"),
+						DiscoveryPrefix: c.String("discovery-prefix"),
+					})
+					if err := mqttCfg.validate(); err != nil {
+						return err
+					}
+					if mqttCfg.Password, err = resolveSecret(mqttCfg.Password); err != nil {
+						return err
+					}
+
+					// The bridge runs until interrupted, so only setting up
+					// gets the timeout
+					requestTimeout := time.Duration(timeout) * time.Second
+					setupCtx, cancel := context.WithTimeout(signalCtx, requestTimeout)
+					devices, err := prepareDevices(setupCtx, lightAddrs.Value(), lightGroups.Value())
+					if err != nil {
+						cancel()
+						return err
+					}
+					bridge := newMQTTBridge(setupCtx, devices, mqttCfg, requestTimeout)
+					cancel()
+
+					client, err := connectMQTT(mqttCfg, requestTimeout, bridge.statusTopic(), func(client MQTTClient) {
+						bridge.connected(signalCtx, client)
+					})
+					if err != nil {
+						return err
+					}
+					defer client.disconnect(bridge.statusTopic(), []byte(mqttOffline))
+
+					bridge.run(signalCtx, c.Duration("interval"))
+					return nil
+				},
+			},
+			{
+				Name:      "tunnel",
+				Usage:     "Reach lights on a remote network through an SSH port forward",
+				ArgsUsage: "[USER@]HOST[:PORT] [COMMAND...]",
+				Description: "Forwards a local port to each --light, from HOST. With a command, runs it\n" +
+					"against the forwarded lights and then closes the tunnel. Without one, prints\n" +
+					"the local addresses and keeps the tunnel open until interrupted.\n\n" +
+					"   klctl tunnel --light 10.0.0.21 me@studio-gw on",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:     "light",
+						Usage:    "Address or configured name of a light, as seen from HOST (can be repeated)",
+						Required: true,
+					},
+					&cli.StringSliceFlag{
+						Name:    "identity",
+						Aliases: []string{"i"},
+						Usage:   "SSH private key to log in with (defaults to ssh-agent and ~/.ssh/id_*)",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					target := c.Args().First()
+					if target == "" {
+						return invalidArgumentf("tunnel needs a host to connect to")
+					}
+
+					cfg, err := loadActiveConfig()
+					if err != nil {
+						return err
+					}
+
+					dialCtx, cancel := context.WithTimeout(signalCtx, time.Duration(timeout)*time.Second)
+					client, err := dialSSH(dialCtx, target, c.StringSlice("identity"))
+					cancel()
+					if err != nil {
+						return err
+					}
+					defer client.Close()
+
+					tunnelCtx, cancel := context.WithCancel(signalCtx)
+					defer cancel()
+
+					tunnel := newTunnel(client.Dial)
+					for _, light := range c.StringSlice("light") {
+						addr, _ := cfg.resolveLight(light)
+						host, port, err := splitLightAddress(addr)
+						if err != nil {
+							return err
+						}
+
+						if _, err := tunnel.forward(tunnelCtx, light, net.JoinHostPort(host, strconv.Itoa(port))); err != nil {
+							return err
+						}
+					}
+
+					command := c.Args().Tail()
+					if len(command) == 0 {
+						for _, f := range tunnel.Forwards {
+							fmt.Printf("%s\t%s\n", f.Light, f.Local)
+						}
+						tunnelLog.Info("Tunnel open, interrupt to close it", "host", target)
+						<-signalCtx.Done()
+						return nil
+					}
+
+					args := append([]string{"--config", configPath}, tunnel.lightArgs()...)
+					return runKlctl(signalCtx, append(args, command...), noHistoryEnv+"=1")
+				},
+			},
+			{
+				Name:      "completion",
+				Usage:     "Print a shell completion script (bash, zsh or fish)",
+				ArgsUsage: "SHELL",
+				Action: func(c *cli.Context) error {
+					script, err := completionScript(c.Args().First())
+					if err != nil {
+						return err
+					}
+
+					fmt.Print(script)
+					return nil
+				},
+			},
+			{
+				Name:      "__complete",
+				Usage:     "List values --light can complete to, used by the completion scripts",
+				ArgsUsage: "[PREFIX]",
+				Hidden:    true,
+				Action: func(c *cli.Context) error {
+					cfg, err := loadActiveConfig()
+					if err != nil {
+						return err
+					}
+
+					discover := func(ctx context.Context) ([]DiscoveredDevice, error) {
+						discovery, err := keylight.NewDiscovery()
+						if err != nil {
+							return nil, err
+						}
+
+						return discoverCommand(ctx, systemClock{}, &DiscoveryWrapper{Discovery: discovery})
+					}
+
+					completions := lightCompletions(signalCtx, cfg, defaultDiscoveryCachePath(), c.Args().First(), discover)
+					for _, completion := range completions {
+						fmt.Printf("%s\t%s\n", completion.Value, completion.Description)
+					}
+					return nil
+				},
+			},
+			{
+				Name:            "at",
+				Usage:           "Run a command at a given time, e.g. at 21:30 off",
+				ArgsUsage:       "TIME COMMAND [ARGS...]",
+				SkipFlagParsing: true,
+				Action:          func(c *cli.Context) error { return atCommand(signalCtx, systemClock{}, c) },
+			},
+			{
+				Name:   "toggle",
+				Usage:  "Toggle lights on and off",
+				Action: func(c *cli.Context) error { return showResult(setLightState(ctx, lightList, LightToggle)) },
+			},
+			{
+				Name:   "on",
+				Usage:  "Turn lights on",
+				Flags:  []cli.Flag{afterFlag},
+				Action: func(c *cli.Context) error { return setLightStateAfter(signalCtx, ctx, c, lightList, LightOn) },
+			},
+			{
+				Name:   "off",
+				Usage:  "Turn lights off",
+				Flags:  []cli.Flag{afterFlag},
+				Action: func(c *cli.Context) error { return setLightStateAfter(signalCtx, ctx, c, lightList, LightOff) },
+			},
+			{
+				Name:  "set",
+				Usage: "Set power, brightness and temperature together, leaving out any to keep them as they are",
+				Flags: append([]cli.Flag{
+					&cli.BoolFlag{
+						Name:  "on",
+						Usage: "Turn the lights on",
+					},
+					&cli.BoolFlag{
+						Name:  "off",
+						Usage: "Turn the lights off",
+					},
+					&cli.StringFlag{
+						Name:  "brightness",
+						Usage: "Brightness, from 0 to 100, with or without a %",
+					},
+					&cli.StringFlag{
+						Name:  "temperature",
+						Usage: "Temperature, in mireds or with a unit such as 4500K",
+					},
+				}, guardFlags...),
+				Action: func(c *cli.Context) error {
+					settings, err := lightSettingsFromFlags(c)
+					if err != nil {
+						return err
+					}
+
+					guards, err := guardsFromFlags(c)
+					if err != nil {
+						return err
+					}
+
+					return showResult(setLightSettings(ctx, lightList, settings, guards...))
+				},
+			},
+			{
+				Name:  "claim",
+				Usage: "Claim the lights given with --light, so others sharing the claims file leave them alone",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "owner",
+						Usage: "Who to claim the lights for (defaults to you)",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					// Claiming everything discovery finds on a shared network
+					// is unlikely to be what anyone wants
+					if len(lightAddrs.Value()) == 0 && len(lightGroups.Value()) == 0 {
+						return invalidArgumentf("give the lights to claim with --light or --group")
+					}
+
+					owner := c.String("owner")
+					if owner == "" {
+						owner = currentOwner()
+					}
+
+					return updateClaims(ctx, lightList, owner)
+				},
+			},
+			{
+				Name:  "unclaim",
+				Usage: "Release your claim on lights",
+				Action: func(c *cli.Context) error {
+					return updateClaims(ctx, lightList, "")
+				},
+			},
+			{
+				Name:      "is-on",
+				Usage:     "Exit successfully if the lights are on",
+				ArgsUsage: " ",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "any",
+						Usage: "Succeed if any light is on, rather than requiring all of them to be",
+					},
+					&cli.BoolFlag{
+						Name:    "quiet",
+						Aliases: []string{"q"},
+						Usage:   "Don't print anything, only set the exit status",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					on, err := lightsOn(ctx, lightList, c.Bool("any"))
+					if err != nil {
+						return err
+					}
+
+					switch {
+					case c.Bool("quiet"):
+					case outputFormat == OutputJSON:
+						if err := writeJSON(os.Stdout, map[string]bool{"on": on}); err != nil {
+							return err
+						}
+					default:
+						fmt.Println(LightState(boolToInt(on)))
+					}
+
+					if !on {
+						return cli.Exit("", 1)
+					}
+					return nil
+				},
+			},
+			{
+				Name:        "brightness",
+				Usage:       "Control light brightness",
+				Subcommands: makeLightControlSubcommands(&ctx, &lightList, ControlBrightness),
+			},
+			{
+				Name:        "temperature",
+				Usage:       "Control light temperature",
+				Subcommands: makeLightControlSubcommands(&ctx, &lightList, ControlTemperature),
+			},
+			{
+				Name:      "wake",
+				Usage:     "Bring the lights up slowly from off, like a sunrise",
+				ArgsUsage: " ",
+				Description: "The lights start dim and warm, and end at --to. Running it again while a\n" +
+					"wake-up is under way, such as after it was interrupted, carries on from where\n" +
+					"it would have been. Changing the lights by hand stops it. To wake up on\n" +
+					"weekdays at 7:\n\n" +
+					"   klctl schedule add '0 7 * * mon-fri' wake --over 30m",
+				Flags: []cli.Flag{
+					&cli.DurationFlag{
+						Name:  "over",
+						Usage: "How long to take",
+						Value: defaultWakeDuration,
+					},
+					&cli.StringFlag{
+						Name:  "to",
+						Usage: "Where to end, e.g. brightness=60,temperature=5000K",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.Duration("over") <= 0 {
+						return invalidArgumentf("--over must be positive")
+					}
+
+					target, err := parseWakeTarget(c.String("to"))
+					if err != nil {
+						return invalidArgument(err)
+					}
+
+					// The wake-up runs for as long as it takes, so only finding
+					// the lights, and each request, gets the timeout
+					setupCtx, cancel := context.WithTimeout(signalCtx, time.Duration(timeout)*time.Second)
+					devices, err := prepareDevices(setupCtx, lightAddrs.Value(), lightGroups.Value())
+					cancel()
+					if err != nil {
+						return err
+					}
+
+					wake := &Wake{
+						devices:        devices,
+						clock:          systemClock{},
+						requestTimeout: time.Duration(timeout) * time.Second,
+						Target:         target,
+						Over:           c.Duration("over"),
+						progressPath:   defaultWakeProgressPath(),
+					}
+
+					return wake.run(signalCtx)
+				},
+			},
+			{
+				Name:  "identify",
+				Usage: "Flash lights, to tell which is which, then put them back as they were",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:  "times",
+						Usage: "How many times to flash",
+						Value: defaultIdentifyTimes,
+					},
+					&cli.DurationFlag{
+						Name:  "interval",
+						Usage: "How long each flash lasts",
+						Value: defaultIdentifyInterval,
+					},
+					&cli.BoolFlag{
+						Name:  "brightness",
+						Usage: "Flash between bright and dim, rather than turning the lights off and on",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.Int("times") < 1 {
+						return invalidArgumentf("--times must be at least 1")
+					}
+					if c.Duration("interval") <= 0 {
+						return invalidArgumentf("--interval must be positive")
+					}
+
+					flip := flipPower
+					if c.Bool("brightness") {
+						flip = flipBrightness
+					}
+
+					// Flashing takes as long as it takes, on top of the usual
+					// timeout
+					times, interval := c.Int("times"), c.Duration("interval")
+					flashCtx, cancel := context.WithTimeout(signalCtx, time.Duration(timeout)*time.Second+time.Duration(2*times)*interval)
+					defer cancel()
+
+					unlock, err := acquireDeviceLocks(flashCtx, lightList)
+					if err != nil {
+						return err
+					}
+					defer unlock()
+
+					return flashDevices(flashCtx, lightList, times, interval, flip)
+				},
+			},
+			{
+				Name:  "settings",
+				Usage: "Show or change how the lights behave when powered on and switched",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "get",
+						Usage: "Show each device's settings",
+						Action: func(c *cli.Context) error {
+							statuses, err := getDeviceSettings(ctx, lightList)
+							if err != nil {
+								return err
+							}
+
+							return renderDeviceSettings(os.Stdout, outputFormat, statuses, statusTemperatureUnit())
+						},
+					},
+					{
+						Name:  "set",
+						Usage: "Change settings, leaving out any to keep them as they are",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "restore-last-state",
+								Usage: "Come back on as the light was when it lost power, or with =false use the power-on brightness and temperature",
+							},
+							&cli.IntFlag{
+								Name:  "power-on-brightness",
+								Usage: "Brightness when the light gets power, from 3 to 100",
+							},
+							&cli.StringFlag{
+								Name:  "power-on-temperature",
+								Usage: "Temperature when the light gets power, in mireds or with a unit such as 4500K",
+							},
+							&cli.DurationFlag{
+								Name:  "switch-on-duration",
+								Usage: "How long the light takes to fade on",
+							},
+							&cli.DurationFlag{
+								Name:  "switch-off-duration",
+								Usage: "How long the light takes to fade off",
+							},
+							&cli.DurationFlag{
+								Name:  "color-change-duration",
+								Usage: "How long the light takes to change brightness or temperature",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							update, err := settingsUpdateFromFlags(c)
+							if err != nil {
+								return invalidArgument(err)
+							}
+
+							return showResult(setDeviceSettings(ctx, lightList, update))
+						},
+					},
+				},
+			},
+			{
+				Name:  "color",
+				Usage: "Control the colour of lights which have one, such as Light Strips",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "get",
+						Usage: "Get the hue and saturation of each light",
+						Action: func(c *cli.Context) error {
+							colors, err := getLightColors(ctx, lightList)
+							if err != nil {
+								return err
+							}
+
+							return renderLightColors(os.Stdout, outputFormat, colors)
+						},
+					},
+					{
+						Name:      "set",
+						Usage:     "Set the hue, in degrees, and saturation, in percent, of the lights",
+						ArgsUsage: "HUE SATURATION",
+						Action: func(c *cli.Context) error {
+							if c.NArg() != 2 {
+								return invalidArgumentf("expected a hue and a saturation, e.g. 240 100 for blue")
+							}
+
+							color, err := parseColor(c.Args().Get(0), c.Args().Get(1))
+							if err != nil {
+								return invalidArgument(err)
+							}
+
+							return showResult(setLightColors(ctx, lightList, color))
+						},
+					},
+				},
+			},
+			{
+				Name:      "nudge",
+				Usage:     "Adjust the lights live with the arrow keys",
+				ArgsUsage: " ",
+				Description: "Up and down change the brightness, and left and right the temperature.\n" +
+					"Enter keeps the new values, and Esc puts back the ones the lights started with.",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:  "brightness-step",
+						Usage: "Percentage to change the brightness by for each press",
+						Value: defaultNudgeBrightnessStep,
+					},
+					&cli.IntFlag{
+						Name:  "temperature-step",
+						Usage: "Kelvin to change the temperature by for each press",
+						Value: defaultNudgeTemperatureStep,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					steps := NudgeSteps{Brightness: c.Int("brightness-step"), Temperature: c.Int("temperature-step")}
+					if steps.Brightness <= 0 || steps.Temperature <= 0 {
+						return invalidArgumentf("steps must be positive")
+					}
+
+					fd := int(os.Stdin.Fd())
+					if !term.IsTerminal(fd) {
+						return fmt.Errorf("nudge needs a terminal")
+					}
+
+					state, err := term.MakeRaw(fd)
+					if err != nil {
+						return err
+					}
+					defer func() { _ = term.Restore(fd, state) }()
+
+					return runNudge(signalCtx, lightList, os.Stdin, os.Stdout, steps)
+				},
+			},
+			{
+				Name:      "tui",
+				Usage:     "Show every light, and change them with the keyboard",
+				ArgsUsage: " ",
+				Description: "Up and down change the selected light's brightness, left and right its temperature,\n" +
+					"and space turns it on or off. Tab selects the next light. Changes are made straight\n" +
+					"away. q or Esc quits.",
+				Flags: []cli.Flag{
+					&cli.DurationFlag{
+						Name:  "interval",
+						Usage: "How often to read the lights, to show changes made elsewhere",
+						Value: defaultTUIInterval,
+					},
+					&cli.IntFlag{
+						Name:  "brightness-step",
+						Usage: "Percentage to change the brightness by for each press",
+						Value: defaultNudgeBrightnessStep,
+					},
+					&cli.IntFlag{
+						Name:  "temperature-step",
+						Usage: "Kelvin to change the temperature by for each press",
+						Value: defaultNudgeTemperatureStep,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.Duration("interval") <= 0 {
+						return invalidArgumentf("--interval must be positive")
+					}
+					steps := NudgeSteps{Brightness: c.Int("brightness-step"), Temperature: c.Int("temperature-step")}
+					if steps.Brightness <= 0 || steps.Temperature <= 0 {
+						return invalidArgumentf("steps must be positive")
+					}
+
+					fd := int(os.Stdin.Fd())
+					if !term.IsTerminal(fd) {
+						return fmt.Errorf("tui needs a terminal")
+					}
+
+					// The tui runs until it's quit, so only finding the lights,
+					// and each request, gets the timeout
+					setupCtx, cancel := context.WithTimeout(signalCtx, time.Duration(timeout)*time.Second)
+					devices, err := prepareDevices(setupCtx, lightAddrs.Value(), lightGroups.Value())
+					cancel()
+					if err != nil {
+						return err
+					}
+
+					state, err := term.MakeRaw(fd)
+					if err != nil {
+						return err
+					}
+					defer func() { _ = term.Restore(fd, state) }()
+
+					return runTUI(signalCtx, devices, os.Stdin, os.Stdout, c.Duration("interval"), time.Duration(timeout)*time.Second, steps)
+				},
+			},
+			{
+				Name:  "test",
+				Usage: "Calibration helpers",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "sweep",
+						Usage:     "Step a field through a range of values, then restore the lights",
+						ArgsUsage: " ",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "field",
+								Usage: "Field to sweep (brightness or temperature)",
+								Value: ControlTemperature.String(),
+							},
+							&cli.StringFlag{
+								Name:     "from",
+								Usage:    "First value (temperatures may be given in Kelvin, e.g. 2900K)",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "to",
+								Usage:    "Last value",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "step",
+								Usage:    "Amount to change by on each step",
+								Required: true,
+							},
+							&cli.DurationFlag{
+								Name:  "dwell",
+								Usage: "How long to hold each value",
+								Value: 2 * time.Second,
+							},
+						},
+						Action: func(c *cli.Context) error {
+							sweep, err := parseSweep(c.String("field"), c.String("from"), c.String("to"), c.String("step"))
+							if err != nil {
+								return invalidArgument(err)
+							}
+
+							sweep.Dwell = c.Duration("dwell")
+
+							return runSweep(signalCtx, lightList, sweep)
+						},
+					},
+				},
+			},
+			{
+				Name:  "weather",
+				Usage: "Suggest a temperature to match the daylight outside, based on cloud cover",
+				Description: "The weather comes from Open-Meteo, unless the config file chooses another provider:\n\n" +
+					"   weather:\n" +
+					"     provider: openweathermap\n" +
+					"     api_key: keyring:weather\n\n" +
+					"Providers are open-meteo and openweathermap. url replaces the provider's usual address.",
+				Flags: []cli.Flag{
+					&cli.Float64Flag{
+						Name:     "latitude",
+						Usage:    "Latitude of the studio",
+						Required: true,
+					},
+					&cli.Float64Flag{
+						Name:     "longitude",
+						Usage:    "Longitude of the studio",
+						Required: true,
+					},
+					&cli.BoolFlag{
+						Name:  "apply",
+						Usage: "Set the lights to the suggested temperature, rather than only printing it",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					// Lights are only needed with --apply, so they're found
+					// here rather than before the command runs
+					weatherCtx, cancel := context.WithTimeout(signalCtx, time.Duration(timeout)*time.Second+fade)
+					defer cancel()
+
+					cfg, err := loadActiveConfig()
+					if err != nil {
+						return err
+					}
+					provider, err := cfg.Weather.provider(http.DefaultClient)
+					if err != nil {
+						return invalidArgument(err)
+					}
+
+					conditions, err := provider.Conditions(weatherCtx, c.Float64("latitude"), c.Float64("longitude"))
+					if err != nil {
+						return err
+					}
+
+					kelvin := suggestTemperature(conditions)
+					automationLog.Debug("Fetched weather",
+						"cloud_cover", conditions.CloudCover,
+						"daytime", conditions.Daytime)
+
+					if !c.Bool("apply") {
+						if outputFormat == OutputJSON {
+							return writeJSON(os.Stdout, map[string]any{
+								"cloud_cover":        conditions.CloudCover,
+								"daytime":            conditions.Daytime,
+								"temperature_kelvin": kelvin,
+							})
+						}
+
+						fmt.Printf("%dK\n", kelvin)
+						return nil
+					}
+
+					devices, err := prepareDevices(weatherCtx, lightAddrs.Value(), lightGroups.Value())
+					if err != nil {
+						return err
+					}
+
+					unlock, err := acquireDeviceLocks(weatherCtx, devices)
+					if err != nil {
+						return err
+					}
+					defer unlock()
+
+					mired := max(minTemperature, min(maxTemperature, kelvinToMired(kelvin)))
+					return showResult(setLightControlFieldWithValue(weatherCtx, devices, ControlTemperature, mired))
+				},
+			},
+			{
+				Name:      "sun",
+				Usage:     "Move the lights along with the sun through the day",
+				ArgsUsage: " ",
+				Description: "For example, to go warm in the evening as f.lux does, and brighter after dark:\n\n" +
+					"   klctl sun --warm-at-night --day-brightness 30 --night-brightness 60\n\n" +
+					"Without --latitude and --longitude, the location is looked up from this machine's IP address.",
+				Flags: append([]cli.Flag{
+					&cli.Float64Flag{
+						Name:  "latitude",
+						Usage: "Latitude of the studio",
+					},
+					&cli.Float64Flag{
+						Name:  "longitude",
+						Usage: "Longitude of the studio",
+					},
+					&cli.BoolFlag{
+						Name:  "warm-at-night",
+						Usage: "Move the temperature to --night-temperature as the sun sets",
+					},
+					&cli.StringFlag{
+						Name:  "day-temperature",
+						Usage: "Temperature while the sun is up",
+						Value: fmt.Sprintf("%dK", clearSkyKelvin),
+					},
+					&cli.StringFlag{
+						Name:  "night-temperature",
+						Usage: "Temperature after dark, with --warm-at-night",
+						Value: fmt.Sprintf("%dK", nightKelvin),
+					},
+					&cli.IntFlag{
+						Name:  "day-brightness",
+						Usage: "Brightness while the sun is up, with --night-brightness",
+					},
+					&cli.IntFlag{
+						Name:  "night-brightness",
+						Usage: "Brightness after dark, with --day-brightness",
+					},
+					&cli.DurationFlag{
+						Name:  "interval",
+						Usage: "How often to move the lights",
+						Value: defaultSunInterval,
+					},
+					&cli.BoolFlag{
+						Name:  "once",
+						Usage: "Set the lights for the sun now and exit, rather than following it",
+					},
+				}, guardFlags...),
+				Action: func(c *cli.Context) error {
+					settings := SunSettings{WarmAtNight: c.Bool("warm-at-night")}
+
+					for _, t := range []struct {
+						flag  string
+						value *int
+					}{
+						{"day-temperature", &settings.DayTemperature},
+						{"night-temperature", &settings.NightTemperature},
+					} {
+						temperature, err := parseTemperature(c.String(t.flag), temperatureUnit)
+						if err != nil {
+							return invalidArgumentf("invalid --%s: %w", t.flag, err)
+						}
+						*t.value = temperature
+					}
+
+					if c.IsSet("day-brightness") != c.IsSet("night-brightness") {
+						return invalidArgumentf("--day-brightness and --night-brightness must be used together")
+					}
+					if c.IsSet("day-brightness") {
+						for _, flag := range []string{"day-brightness", "night-brightness"} {
+							if b := c.Int(flag); b < 0 || b > 100 {
+								return invalidArgumentf("--%s must be between 0 and 100 (got %d)", flag, b)
+							}
+						}
+						day, night := c.Int("day-brightness"), c.Int("night-brightness")
+						settings.DayBrightness, settings.NightBrightness = &day, &night
+					}
+
+					if !settings.WarmAtNight && settings.DayBrightness == nil {
+						return invalidArgumentf("nothing to follow the sun with, give --warm-at-night or --day-brightness and --night-brightness")
+					}
+
+					if c.Duration("interval") <= 0 {
+						return invalidArgumentf("--interval must be positive")
+					}
+
+					guards, err := guardsFromFlags(c)
+					if err != nil {
+						return err
+					}
+
+					switch {
+					case c.IsSet("latitude") != c.IsSet("longitude"):
+						return invalidArgumentf("--latitude and --longitude must be used together")
+					case c.IsSet("latitude"):
+						settings.Latitude, settings.Longitude = c.Float64("latitude"), c.Float64("longitude")
+					default:
+						locator := &IPLocator{BaseURL: ipapiURL, Client: http.DefaultClient}
+						if settings.Latitude, settings.Longitude, err = locator.Locate(ctx); err != nil {
+							return err
+						}
+						automationLog.Info("Looked up location", "latitude", settings.Latitude, "longitude", settings.Longitude)
+					}
+					if math.Abs(settings.Latitude) > 90 || math.Abs(settings.Longitude) > 180 {
+						return invalidArgumentf("invalid location %g, %g", settings.Latitude, settings.Longitude)
+					}
+
+					return showResult(followSun(signalCtx, systemClock{}, lightList, settings, c.Duration("interval"), c.Bool("once"), guards...))
+				},
+			},
+			{
+				Name:  "scene",
+				Usage: "Save the state of the lights and restore it later",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "save",
+						Usage:     "Save the current state of the lights as a scene",
+						ArgsUsage: "NAME",
+						Action: func(c *cli.Context) error {
+							if c.NArg() != 1 {
+								return invalidArgumentf("usage: %s scene save NAME", c.App.Name)
+							}
+
+							scene, err := keylightctl.CaptureScene(ctx, c.Args().First(), lightList)
+							if err != nil {
+								return err
+							}
+
+							return saveScene(defaultSceneDir(), scene)
+						},
+					},
+					{
+						Name:      "apply",
+						Usage:     "Set the lights to a saved scene",
+						ArgsUsage: "NAME",
+						Action: func(c *cli.Context) error {
+							if c.NArg() != 1 {
+								return invalidArgumentf("usage: %s scene apply NAME", c.App.Name)
+							}
+
+							scene, err := loadScene(defaultSceneDir(), c.Args().First())
+							if err != nil {
+								return err
+							}
+
+							return showResult(applyScene(ctx, scene, lightList))
+						},
+					},
+					{
+						Name:  "list",
+						Usage: "List saved scenes",
+						Action: func(c *cli.Context) error {
+							names, err := listScenes(defaultSceneDir())
+							if err != nil {
+								return err
+							}
+
+							if outputFormat == OutputJSON {
+								return writeJSON(os.Stdout, names)
+							}
+
+							for _, name := range names {
+								fmt.Println(name)
+							}
+							return nil
+						},
+					},
+					{
+						Name:      "delete",
+						Usage:     "Delete a saved scene",
+						ArgsUsage: "NAME",
+						Action: func(c *cli.Context) error {
+							if c.NArg() != 1 {
+								return invalidArgumentf("usage: %s scene delete NAME", c.App.Name)
+							}
+
+							return deleteScene(defaultSceneDir(), c.Args().First())
+						},
+					},
+				},
+			},
+			{
+				Name:  "report",
+				Usage: "Print a JSON bundle of device diagnostics to attach to bug reports",
+				Action: func(c *cli.Context) error {
+					return writeJSON(os.Stdout, buildReport(ctx, lightList, !noRedact))
+				},
+			},
+			{
+				Name:  "resume",
+				Usage: "Let klctl serve's schedules change the lights again, after a change by hand held them off",
+				Action: func(c *cli.Context) error {
+					path := defaultSocketPath()
+					if _, err := os.Stat(path); err != nil {
+						return fmt.Errorf("klctl serve isn't running on this machine: %w", err)
+					}
+
+					return tellDaemon(ctx, path, http.MethodDelete, "/manual")
+				},
+			},
+			{
+				Name:  "selftest",
+				Usage: "Check lights work as klctl expects, by reading everything and making a small change which is put back",
+				Action: func(c *cli.Context) error {
+					// It's meant for checking particular hardware, not
+					// everything discovery happens to find
+					if len(lightAddrs.Value()) == 0 && len(lightGroups.Value()) == 0 {
+						return invalidArgumentf("give the lights to test with --light or --group")
+					}
+
+					results, err := selfTestDevices(ctx, lightList, time.Duration(timeout)*time.Second)
+					if err != nil {
+						return err
+					}
+
+					if err := renderSelfTest(os.Stdout, outputFormat, results); err != nil {
+						return err
+					}
+
+					var failed int
+					for _, result := range results {
+						if result.Failed() {
+							failed++
+						}
+					}
+					if failed > 0 {
+						return fmt.Errorf("%d of %d lights failed the self-test", failed, len(results))
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:      "api",
+				Usage:     "Send a request to the lights' HTTP API and print the response as it is, e.g. GET /elgato/lights",
+				ArgsUsage: "METHOD PATH [BODY|-]",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:    "include",
+						Aliases: []string{"i"},
+						Usage:   "Print the status and headers of the response too",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					req, err := parseAPIRequest(c.Args().Slice(), os.Stdin)
+					if err != nil {
+						return err
+					}
+
+					responses, sendErr := sendAPIRequest(ctx, lightList, req)
+					if err := renderAPIResponses(os.Stdout, outputFormat, lightList, responses, c.Bool("include")); err != nil {
+						return err
+					}
+
+					if req.Method != http.MethodGet && req.Method != http.MethodHead && slices.ContainsFunc(responses, func(resp *RawResponse) bool {
+						return resp != nil && resp.StatusCode == http.StatusOK
+					}) {
+						reportManualChange(ctx)
+					}
+
+					return errors.Join(sendErr, apiResponseError(lightList, responses))
+				},
+			},
+			{
+				Name:  "status",
+				Usage: "Get device information",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "strict",
+						Usage: "Fail if any part of a device's status can't be fetched, rather than showing the rest",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					statuses, err := fetchDeviceStatuses(ctx, lightList, c.Bool("strict"))
+					if err != nil {
+						return err
+					}
+
+					return renderStatuses(os.Stdout, outputFormat, statuses, statusTemperatureUnit(), colorOutput)
+				},
+			},
+		},
+	}
+
+	setUsageErrors(app.Commands)
+
+	err := app.Run(protectNegativeValues(os.Args))
+	if err != nil {
+		if err == context.Canceled {
+			slog.Info("Interrupted")
+			return
+		}
+		// Errors only carrying an exit code, like a command's, have already
+		// been reported
+		if err.Error() != "" {
+			slog.Error(err.Error())
+		}
+		os.Exit(exitCode(err))
+	}
+}
+
+// showResult renders the result of a mutating command, if there is one, and
+// passes its error through.
+func showResult(result *CommandResult, err error) error {
+	if result != nil && err == nil && confirmBlink {
+		confirmWithBlink(result)
+	}
+
+	if result != nil && result.Summary.Changed > 0 {
+		reportManualChange(context.Background())
+	}
+
+	if result != nil {
+		if renderErr := renderResult(os.Stdout, outputFormat, result.finish()); renderErr != nil && err == nil {
+			err = renderErr
+		}
+	}
+
+	return err
+}
+
+// DeviceLightGroup pairs a device with the state of its lights.
+type DeviceLightGroup struct {
+	Device     Device
+	LightGroup *keylight.LightGroup
+}
+
+// fetchLightGroups fetches the light group of every device concurrently. The
+// result is in the same order as lights.
+func fetchLightGroups(ctx context.Context, lights []Device) ([]DeviceLightGroup, error) {
+	lgs := make([]DeviceLightGroup, len(lights))
+
+	err := forEachDevice(ctx, lights, func(ctx context.Context, i int, device Device) error {
+		deviceLog.Debug("Fetching light group", "address", device.GetDNSAddr())
+		lg, err := device.FetchLightGroup(ctx)
+		if err != nil {
+			return err
+		}
+
+		lgs[i] = DeviceLightGroup{device, lg}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return lgs, nil
+}
+
+// fetchLightGroupsToChange fetches the light groups of the devices a command
+// is to change. With --continue-on-error, devices which can't be read are
+// recorded in result as failed and left out, so the rest are still changed.
+func fetchLightGroupsToChange(ctx context.Context, lights []Device, result *CommandResult) ([]DeviceLightGroup, error) {
+	if !continueOnError {
+		return fetchLightGroups(ctx, lights)
+	}
+
+	lgs := make([]DeviceLightGroup, len(lights))
+	failed := make([]*DeviceResult, len(lights))
+	err := forEachDevice(ctx, lights, func(ctx context.Context, i int, device Device) error {
+		start := time.Now()
+		lg, err := device.FetchLightGroup(ctx)
+		if err != nil {
+			dr := newDeviceResult(device, start, nil, err)
+			failed[i] = &dr
+			return err
+		}
+
+		lgs[i] = DeviceLightGroup{device, lg}
+		return nil
+	})
+
+	var reachable []DeviceLightGroup
+	for i, dlg := range lgs {
+		if failed[i] != nil {
+			deviceLog.Warn("Leaving out a device which can't be read", "address", lights[i].GetDNSAddr(), "error", failed[i].Error)
+			result.add(*failed[i])
+			continue
+		}
+		reachable = append(reachable, dlg)
+	}
+	result.leftOut = err
+
+	return reachable, nil
+}
+
+// afterFlag delays turning the lights on or off.
+var afterFlag = &cli.DurationFlag{
+	Name:  "after",
+	Usage: "Wait this long first, counting down. With --server, the server waits instead, and this returns at once",
+}
+
+// setLightStateAfter turns the lights on or off, after --after if it's given.
+// ctx is the context the lights were found with, whose timeout has already
+// started, so a delayed change gets a new one from signalCtx.
+func setLightStateAfter(signalCtx, ctx context.Context, c *cli.Context, lightList []Device, state LightState) error {
+	after := c.Duration("after")
+	if after <= 0 {
+		return showResult(setLightState(ctx, lightList, state))
+	}
+
+	if serverAddr != "" {
+		timer, err := scheduleOnServer(signalCtx, serverAddr, httpDefaults, lightList, state, after)
+		if err != nil {
+			return err
+		}
+
+		if outputFormat == OutputJSON {
+			return writeJSON(os.Stdout, timer)
+		}
+		fmt.Println(timer)
+		return nil
+	}
+
+	var display io.Writer
+	if term.IsTerminal(int(os.Stderr.Fd())) {
+		display = os.Stderr
+	} else {
+		automationLog.Info("Waiting to turn lights "+state.String(), "after", after)
+	}
+
+	label := fmt.Sprintf("Turning %s", state)
+	if err := countdown(signalCtx, systemClock{}, after, display, label); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(signalCtx, time.Duration(timeout)*time.Second+fade)
+	defer cancel()
+
+	return showResult(setLightState(ctx, lightList, state))
+}
+
+func setLightState(ctx context.Context, lightList []Device, state LightState) (*CommandResult, error) {
+	unlock, err := acquireDeviceLocks(ctx, lightList)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	result := newCommandResult()
+	lgs, err := fetchLightGroupsToChange(ctx, lightList, result)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := make([]deviceUpdate, 0, len(lgs))
+	var delay time.Duration
+	for _, dlg := range lgs {
+		device, lightGroup := dlg.Device, dlg.LightGroup
+
+		var changes []Change
+		turningOn := false
+		for i, light := range lightGroup.Lights {
+			old := light.On
+
+			switch state {
+			case LightToggle:
+				light.On = 1 - light.On
+			case LightOff:
+				light.On = 0
+			case LightOn:
+				light.On = 1
+			}
+
+			if light.On != old {
+				changes = append(changes, Change{Light: i, Field: "on", Old: old, New: light.On})
+				turningOn = turningOn || light.On == 1
+			}
+
+			deviceLog.Debug("Updating light", "address", device.GetDNSAddr(), "state", LightState(light.On))
+		}
+
+		// Devices are updated concurrently, so each device being powered on
+		// waits one more stagger than the last.
+		update := deviceUpdate{DeviceLightGroup: dlg, changes: changes}
+		if turningOn {
+			update.delay = delay
+			delay += stagger
+		}
+		updates = append(updates, update)
+	}
+
+	return result, result.failure(applyDeviceUpdates(ctx, result, updates))
+}
+
+// waitForStagger pauses before powering on a device, so that the inrush
+// current of several devices is spread out rather than drawn all at once.
+func waitForStagger(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+
+	deviceLog.Debug("Waiting before powering on the device", "delay", delay)
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// deviceUpdate is a pending change to the lights of one device.
+type deviceUpdate struct {
+	DeviceLightGroup
+
+	changes []Change
+
+	// delay is how long to wait before sending the update.
+	delay time.Duration
+}
+
+// applyDeviceUpdates sends the updates to their devices concurrently. The
+// outcomes are recorded in result in the same order as updates.
+func applyDeviceUpdates(ctx context.Context, result *CommandResult, updates []deviceUpdate) error {
+	devices := make([]Device, len(updates))
+	for i, u := range updates {
+		devices[i] = u.Device
+	}
+
+	results := make([]*DeviceResult, len(updates))
+	err := forEachDevice(ctx, devices, func(ctx context.Context, i int, device Device) error {
+		start := time.Now()
+		u := updates[i]
+
+		err := waitForStagger(ctx, u.delay)
+		if err == nil {
+			deviceLog.Debug("Updating light group", "address", device.GetDNSAddr())
+			if fade > 0 && len(u.changes) > 0 {
+				err = fadeLightGroup(ctx, systemClock{}, device, beforeChanges(u.LightGroup, u.changes), u.LightGroup, fade)
+			} else {
+				err = updateChangedLightGroup(ctx, device, u.LightGroup, u.changes)
+			}
+		}
+
+		dr := newDeviceResult(device, start, u.changes, err)
+		results[i] = &dr
+		return err
+	})
+
+	for _, dr := range results {
+		if dr != nil {
+			result.add(*dr)
+		}
+	}
+
+	return err
+}
+
+// updateChangedLightGroup sends the light group to the device, unless nothing
+// about it has changed.
+func updateChangedLightGroup(ctx context.Context, device Device, lightGroup *keylight.LightGroup, changes []Change) error {
+	if len(changes) == 0 {
+		deviceLog.Debug("Nothing to change", "address", device.GetDNSAddr())
+		return nil
+	}
+
+	_, err := device.UpdateLightGroup(ctx, lightGroup)
+	return err
+}
+
+// lightsOn reports whether every light is on or, if anyOn is set, whether at
+// least one of them is. No lights at all counts as off.
+func lightsOn(ctx context.Context, lightList []Device, anyOn bool) (bool, error) {
+	lgs, err := fetchLightGroups(ctx, lightList)
+	if err != nil {
+		return false, err
+	}
+
+	seen := false
+	for _, dlg := range lgs {
+		for _, light := range dlg.LightGroup.Lights {
+			seen = true
+			if light.On == 1 && anyOn {
+				return true, nil
+			}
+			if light.On == 0 && !anyOn {
+				return false, nil
+			}
+		}
+	}
+
+	return seen && !anyOn, nil
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// PeripheralCommand describes a command which runs other commands when a kind
+// of peripheral is connected or disconnected.
+type PeripheralCommand struct {
+	// Name is the command's name.
+	Name string
+
+	// Singular names one peripheral, for the filter flag; Plural names them
+	// in help.
+	Singular, Plural string
+
+	NewSource func() (PeripheralSource, error)
+
+	// Example finishes "For example, " in watch's help.
+	Example string
+}
+
+// makePeripheralSubcommands builds the list and watch subcommands for a kind
+// of peripheral. Watch runs until ctx is done.
+func makePeripheralSubcommands(ctx context.Context, pc PeripheralCommand) []*cli.Command {
+	return []*cli.Command{
+		{
+			Name:  "list",
+			Usage: "List the connected " + pc.Plural,
+			Action: func(c *cli.Context) error {
+				source, err := pc.NewSource()
+				if err != nil {
+					return err
+				}
+
+				names, err := source.Connected()
+				if err != nil {
+					return err
+				}
+
+				if outputFormat == OutputJSON {
+					return writeJSON(os.Stdout, names)
+				}
+				for _, name := range names {
+					fmt.Println(name)
+				}
+				return nil
+			},
+		},
+		{
+			Name:      "watch",
+			Usage:     "Watch for " + pc.Plural + " being connected or disconnected, and run a klctl command when they are",
+			ArgsUsage: " ",
+			Description: "For example, " + pc.Example + "\n\n" +
+				"Commands are split on spaces, and run with the global flags given to this one.",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "connect",
+					Usage: "klctl command to run when a " + pc.Singular + " is connected",
+				},
+				&cli.StringFlag{
+					Name:  "disconnect",
+					Usage: "klctl command to run when a " + pc.Singular + " is disconnected",
+				},
+				&cli.StringFlag{
+					Name:  pc.Singular,
+					Usage: "Only act on " + pc.Plural + " whose names contain this, as shown by " + pc.Name + " list",
+				},
+				&cli.DurationFlag{
+					Name:  "settle",
+					Usage: "How long to wait for the " + pc.Plural + " to settle after a change",
+					Value: defaultPeripheralSettle,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				connect, disconnect := strings.Fields(c.String("connect")), strings.Fields(c.String("disconnect"))
+				if len(connect) == 0 && len(disconnect) == 0 {
+					return invalidArgumentf("give a command to run with --connect or --disconnect")
+				}
+				for _, command := range [][]string{connect, disconnect} {
+					if len(command) > 0 && c.App.Command(command[0]) == nil {
+						return invalidArgumentf("unknown command %s", command[0])
+					}
+				}
+
+				source, err := pc.NewSource()
+				if err != nil {
+					return err
+				}
+
+				globalArgs := globalArgsBefore(pc.Name)
+
+				run := func(ctx context.Context, command []string) {
+					if len(command) == 0 {
+						return
+					}
+
+					automationLog.Info("Running command", "command", strings.Join(command, " "))
+					if err := runKlctl(ctx, append(append([]string{}, globalArgs...), command...)); err != nil {
+						automationLog.Error("Command failed", "command", strings.Join(command, " "), "error", err)
+					}
+				}
+
+				filter := c.String(pc.Singular)
+				trigger := &PeripheralTrigger{
+					Kind:   pc.Name,
+					Source: source,
+					Clock:  systemClock{},
+					Settle: c.Duration("settle"),
+					OnChange: func(ctx context.Context, change PeripheralChange) {
+						// Leaving one desk for another should end up with the
+						// new desk's command having run last
+						if len(matchPeripherals(change.Disconnected, filter)) > 0 {
+							run(ctx, disconnect)
+						}
+						if len(matchPeripherals(change.Connected, filter)) > 0 {
+							run(ctx, connect)
+						}
+					},
+				}
+
+				return trigger.run(ctx)
+			},
+		},
+	}
+}
+
+// The amount step-up and step-down change a field by, by default.
+const defaultStep = 10
+
+var stepFlag = &cli.IntFlag{
+	Name:  "step",
+	Usage: "How much to change the value by, with temperatures in --temperature-unit",
+	Value: defaultStep,
+}
+
+// presetFlag sets a temperature from a camera's white balance preset.
+var presetFlag = &cli.StringFlag{
+	Name:  "match",
+	Usage: "Use the temperature closest to a camera white balance preset, e.g. sony-5600",
+}
+
+// makeLightControlSubcommands builds the subcommands for a field. The commands
+// are constructed before the devices are set up, so they take pointers to the
+// context and light list which are filled in by the app's Before hook.
+func makeLightControlSubcommands(ctx *context.Context, lightList *[]Device, controlField LightControlField) []*cli.Command {
+	setFlags := guardFlags
+	if controlField == ControlTemperature {
+		setFlags = append([]cli.Flag{presetFlag}, setFlags...)
+	}
+
+	getFlags := []cli.Flag{
+		&cli.StringFlag{
+			Name:  "aggregate",
+			Usage: "How to combine values from several lights: first (the first light in the order status lists them), min, max, avg or list",
+			Value: AggregateFirst.String(),
+		},
+		&cli.BoolFlag{
+			Name:  "per-light",
+			Usage: "Show each light's value separately, with its device and index",
+		},
+		indexFlag,
+	}
+	if controlField == ControlTemperature {
+		getFlags = append(getFlags, &cli.BoolFlag{
+			Name:  "kelvin",
+			Usage: "Show the temperature in Kelvin, rather than the light's own units",
+		})
+	}
+
+	return []*cli.Command{
+		{
+			Name:  "step-up",
+			Usage: "Increase brightness or temperature",
+			Flags: []cli.Flag{stepFlag},
+			Action: func(c *cli.Context) error {
+				adjust, err := stepBy(controlField, c.Int("step"))
+				if err != nil {
+					return invalidArgument(err)
+				}
+
+				return showResult(adjustLightControlField(*ctx, *lightList, controlField, adjust))
+			},
+		},
+		{
+			Name:  "step-down",
+			Usage: "Decrease brightness or temperature",
+			Flags: []cli.Flag{stepFlag},
+			Action: func(c *cli.Context) error {
+				adjust, err := stepBy(controlField, -c.Int("step"))
+				if err != nil {
+					return invalidArgument(err)
+				}
+
+				return showResult(adjustLightControlField(*ctx, *lightList, controlField, adjust))
+			},
+		},
+		{
+			Name:  "get",
+			Usage: "Get brightness or temperature",
+			Flags: getFlags,
+			Action: func(c *cli.Context) error {
+				aggregation, err := parseAggregation(c.String("aggregate"))
+				if err != nil {
+					return invalidArgument(err)
+				}
+
+				guards, err := indexGuards(c)
+				if err != nil {
+					return err
+				}
 
-func setupDevices(ctx context.Context, lightAddrs []string, discoverer Discovery) ([]Device, error) {
-	var devices []Device
+				lights, err := getLightValues(*ctx, *lightList, controlField, guards...)
+				if err != nil {
+					return err
+				}
 
-	for _, lightAddr := range lightAddrs {
-		host, port, err := net.SplitHostPort(lightAddr)
-		if err != nil {
-			host = lightAddr
-			port = defaultPort
-		}
+				var unit TemperatureUnit
+				if controlField == ControlTemperature {
+					unit = UnitMired
+					if temperatureUnit != "" {
+						unit = temperatureUnit
+					}
+					if c.Bool("kelvin") {
+						unit = UnitKelvin
+					}
 
-		p, err := strconv.Atoi(port)
-		if err != nil || p < 1 || p > 65535 {
-			return nil, fmt.Errorf("port must be a number between 1 and 65535 (got %s)", port)
-		}
+					for i := range lights {
+						lights[i].Value = unit.Convert(lights[i].Value)
+					}
+				}
+
+				if c.Bool("per-light") {
+					return renderLightValues(os.Stdout, outputFormat, controlField, unit, lights)
+				}
+
+				var values []int
+				for _, light := range lights {
+					values = append(values, light.Value)
+				}
 
-		device := KeylightDevice{
-			&keylight.Device{
-				DNSAddr: host,
-				Port:    p,
+				return renderValues(os.Stdout, outputFormat, controlField, unit, aggregation, aggregateValues(values, aggregation))
 			},
-		}
-		devices = append(devices, device)
+		},
+		{
+			Name:      "set",
+			Usage:     "Set brightness or temperature, or change it with e.g. +15 or -20",
+			ArgsUsage: "VALUE",
+			Flags:     setFlags,
+			Action: func(c *cli.Context) error {
+				return showResult(setLightControlField(*ctx, c, *lightList, controlField))
+			},
+		},
 	}
+}
 
-	if len(devices) == 0 {
-		logrus.Debug("No lights provided, running discovery")
-		return Discover(ctx, discoverer)
+// updateClaims claims the devices for owner, or releases them if owner is
+// empty, and saves the claims.
+func updateClaims(ctx context.Context, devices []Device, owner string) error {
+	claims, err := readClaims(claimsPath)
+	if err != nil {
+		return err
 	}
-	return devices, nil
-}
 
-func main() {
-	lightList := []Device{}
+	claims, err = claimDevices(ctx, claims, devices, owner)
+	if err != nil {
+		return err
+	}
 
-	lightAddrs := cli.NewStringSlice()
+	return writeClaims(claimsPath, claims)
+}
 
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
-	defer stop()
-	var cancel context.CancelFunc
+// isRelativeValue reports whether a value for set is a change to the current
+// one, such as +15 or -20, rather than a new value.
+func isRelativeValue(s string) bool {
+	return strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-")
+}
 
-	app := &cli.App{
-		Flags: []cli.Flag{
-			&cli.StringSliceFlag{
-				Name:        "light",
-				Usage:       "Light to control (host:port)",
-				Destination: lightAddrs,
-			},
-			&cli.StringFlag{
-				Name:        "log-level",
-				Usage:       "Level of logging",
-				Value:       "info",
-				Destination: &logLevel,
-			},
-			&cli.IntFlag{
-				Name:        "timeout",
-				Usage:       "Timeout in seconds for operations",
-				Value:       10,
-				Destination: &timeout,
-			},
-		},
+// parseRelativeChange parses a change such as +15 or -500K to a field, and
+// returns a function which applies it to a value in the light's units.
+func parseRelativeChange(s string, controlField LightControlField) (func(int) int, error) {
+	if controlField == ControlTemperature {
+		return parseTemperatureChange(s, temperatureUnit)
+	}
 
-		Before: func(c *cli.Context) error {
-			level, err := logrus.ParseLevel(logLevel)
-			if err != nil {
-				return err
-			}
+	change, err := parseChange(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s change %q", controlField, s)
+	}
 
-			logrus.SetLevel(level)
+	return func(value int) int { return value + change }, nil
+}
 
-			if c.NArg() == 0 {
-				return nil
-			}
+// stepBy returns a function which changes a field by step, in the unit the
+// field is given in, so with --temperature-unit kelvin temperatures step in
+// Kelvin.
+func stepBy(controlField LightControlField, step int) (func(int) int, error) {
+	return parseRelativeChange(fmt.Sprintf("%+d", step), controlField)
+}
 
-			ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+// protectNegativeValues puts "--" before a negative value given to set, such
+// as "brightness set -20" or "brightness set --if-on -20", which would
+// otherwise be taken for a flag. set's flags, and the values of those which
+// take one, are passed over to find the value.
+func protectNegativeValues(args []string) []string {
+	valueFlags := flagsTakingValues(append([]cli.Flag{presetFlag}, guardFlags...))
 
-			discovery, err := keylight.NewDiscovery()
-			if err != nil {
-				return fmt.Errorf("failed to create discovery client: %w", err)
+	protected := make([]string, 0, len(args)+1)
+	for i := 0; i < len(args); i++ {
+		protected = append(protected, args[i])
+		if i == 0 || args[i] != "set" {
+			continue
+		}
+
+		for i+1 < len(args) {
+			arg := args[i+1]
+			if isNegativeNumber(arg) {
+				protected = append(protected, "--")
+				break
+			}
+			if arg == "--" || !strings.HasPrefix(arg, "-") {
+				break
 			}
 
-			lightList, err = setupDevices(ctx, lightAddrs.Value(), &DiscoveryWrapper{discovery})
-			if err != nil {
-				cancel()
-				return err
+			i++
+			protected = append(protected, arg)
+			if name := strings.TrimLeft(arg, "-"); valueFlags[name] && i+1 < len(args) {
+				i++
+				protected = append(protected, args[i])
 			}
+		}
+	}
 
-			return nil
-		},
+	return protected
+}
 
-		After: func(c *cli.Context) error {
-			if cancel != nil {
-				cancel()
-			}
-			return nil
-		},
+// isNegativeNumber reports whether an argument is a negative number, such as
+// -20 or -500K, rather than a flag.
+func isNegativeNumber(arg string) bool {
+	return strings.HasPrefix(arg, "-") && len(arg) > 1 && arg[1] >= '0' && arg[1] <= '9'
+}
 
-		Commands: []*cli.Command{
-			{
-				Name:   "toggle",
-				Usage:  "Toggle lights on and off",
-				Action: func(c *cli.Context) error { return setLightState(ctx, lightList, LightToggle) },
-			},
-			{
-				Name:   "on",
-				Usage:  "Turn lights on",
-				Action: func(c *cli.Context) error { return setLightState(ctx, lightList, LightOn) },
-			},
-			{
-				Name:   "off",
-				Usage:  "Turn lights off",
-				Action: func(c *cli.Context) error { return setLightState(ctx, lightList, LightOff) },
-			},
-			{
-				Name:        "brightness",
-				Usage:       "Control light brightness",
-				Subcommands: makeLightControlSubcommands(ctx, lightList, ControlBrightness),
-			},
-			{
-				Name:        "temperature",
-				Usage:       "Control light temperature",
-				Subcommands: makeLightControlSubcommands(ctx, lightList, ControlTemperature),
-			},
-			{
-				Name:  "status",
-				Usage: "Get device information",
-				Action: func(c *cli.Context) error {
-					status, err := getDeviceStatus(ctx, lightList)
-					if err != nil {
-						return err
-					}
+// flagsTakingValues returns the names of the flags which take a value, and so
+// are followed by one unless it's given with =.
+func flagsTakingValues(flags []cli.Flag) map[string]bool {
+	names := map[string]bool{}
+	for _, flag := range flags {
+		if f, ok := flag.(cli.DocGenerationFlag); ok && f.TakesValue() {
+			for _, name := range f.Names() {
+				names[name] = true
+			}
+		}
+	}
 
-					fmt.Println(status)
+	return names
+}
 
-					return nil
-				},
-			},
-		},
+// adjustLightControlField changes the field of each light the guards allow
+// from that light's own current value, clamping the result to the field's
+// range. The lights are fetched once, and the same snapshot is both read and
+// updated.
+func adjustLightControlField(
+	ctx context.Context,
+	lightList []Device,
+	controlField LightControlField,
+	adjust func(int) int,
+	guards ...LightGuard,
+) (*CommandResult, error) {
+	unlock, err := acquireDeviceLocks(ctx, lightList)
+	if err != nil {
+		return nil, err
 	}
+	defer unlock()
 
-	err := app.Run(os.Args)
+	result := newCommandResult()
+	lgs, err := fetchLightGroupsToChange(ctx, lightList, result)
 	if err != nil {
-		if err == context.Canceled {
-			logrus.Info("Interrupted")
-			return
-		}
-		logrus.Fatal(err)
+		return nil, err
 	}
+
+	return changeLightControlField(ctx, result, lgs, controlField, adjust, guards...)
 }
 
-func fetchLightGroups(ctx context.Context, lights []Device) (map[Device]*keylight.LightGroup, error) {
-	lgs := make(map[Device]*keylight.LightGroup)
+// clampLightControlField limits a value to the field's range.
+func clampLightControlField(controlField LightControlField, value int) int {
+	switch controlField {
+	case ControlBrightness:
+		return max(0, min(100, value))
+	case ControlTemperature:
+		return max(minTemperature, min(maxTemperature, value))
+	}
 
-	for _, device := range lights {
-		logrus.WithField("address", device.GetDNSAddr()).Debug("Fetching light group")
-		lg, err := device.FetchLightGroup(ctx)
+	return value
+}
+
+func setLightControlField(ctx context.Context, c *cli.Context, lightList []Device, controlField LightControlField) (*CommandResult, error) {
+	if arg := c.Args().First(); !c.IsSet("match") && isRelativeValue(arg) {
+		adjust, err := parseRelativeChange(arg, controlField)
+		if err != nil {
+			return nil, invalidArgument(err)
+		}
+
+		guards, err := guardsFromFlags(c)
 		if err != nil {
 			return nil, err
 		}
 
-		lgs[device] = lg
+		return adjustLightControlField(ctx, lightList, controlField, adjust, guards...)
 	}
 
-	return lgs, nil
+	var value int
+	var err error
+	switch {
+	case c.IsSet("match"):
+		value, err = matchCameraPreset(c.String("match"))
+	case controlField == ControlTemperature:
+		value, err = parseLightTemperature(c.Args().First(), temperatureUnit)
+	default:
+		value, err = parseBrightness(c.Args().First())
+	}
+	if err != nil {
+		return nil, invalidArgument(err)
+	}
+
+	guards, err := guardsFromFlags(c)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := acquireDeviceLocks(ctx, lightList)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return setLightControlFieldWithValue(ctx, lightList, controlField, value, guards...)
 }
 
-func setLightState(ctx context.Context, lightList []Device, state LightState) error {
-	lgs, err := fetchLightGroups(ctx, lightList)
+// setLightControlFieldWithValue sets the field on every light the guards allow.
+func setLightControlFieldWithValue(
+	ctx context.Context,
+	lightList []Device,
+	controlField LightControlField,
+	value int,
+	guards ...LightGuard,
+) (*CommandResult, error) {
+	result := newCommandResult()
+	lgs, err := fetchLightGroupsToChange(ctx, lightList, result)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	for device, lightGroup := range lgs {
-		for _, light := range lightGroup.Lights {
-			switch state {
-			case LightToggle:
-				light.On = 1 - light.On
-			case LightOff:
-				light.On = 0
-			case LightOn:
-				light.On = 1
+	return applyLightControlField(ctx, result, lgs, controlField, value, guards...)
+}
+
+// applyLightControlField sets the field on every light of an already fetched
+// snapshot which the guards allow, recording what happened in result. The
+// snapshot is updated to match, so it can be used again for a later change.
+func applyLightControlField(
+	ctx context.Context,
+	result *CommandResult,
+	lgs []DeviceLightGroup,
+	controlField LightControlField,
+	value int,
+	guards ...LightGuard,
+) (*CommandResult, error) {
+	return changeLightControlField(ctx, result, lgs, controlField, func(int) int { return value }, guards...)
+}
+
+// changeLightControlField is applyLightControlField with each light's new
+// value worked out from its current one by adjust.
+func changeLightControlField(
+	ctx context.Context,
+	result *CommandResult,
+	lgs []DeviceLightGroup,
+	controlField LightControlField,
+	adjust func(int) int,
+	guards ...LightGuard,
+) (*CommandResult, error) {
+	updates := make([]deviceUpdate, 0, len(lgs))
+	for _, dlg := range lgs {
+		device, lightGroup := dlg.Device, dlg.LightGroup
+
+		var changes []Change
+		for i, light := range lightGroup.Lights {
+			if !guardsAllow(device, i, light, guards) {
+				deviceLog.Debug("Guard not met, leaving light alone", "address", device.GetDNSAddr(), "light", i)
+				continue
 			}
 
-			logrus.WithFields(logrus.Fields{
-				"address": device.GetDNSAddr(),
-				"state":   LightState(light.On),
-			}).Debug("Updating light")
-		}
+			var field *int
+			switch controlField {
+			case ControlBrightness:
+				field = &light.Brightness
+			case ControlTemperature:
+				field = &light.Temperature
+			}
 
-		_, err = device.UpdateLightGroup(ctx, lightGroup)
-		if err != nil {
-			return err
+			if value := clampLightControlField(controlField, adjust(*field)); *field != value {
+				changes = append(changes, Change{Light: i, Field: controlField.String(), Old: *field, New: value})
+				*field = value
+			}
 		}
+
+		updates = append(updates, deviceUpdate{DeviceLightGroup: dlg, changes: changes})
 	}
 
-	return nil
+	return result, result.failure(applyDeviceUpdates(ctx, result, updates))
 }
 
-func makeLightControlSubcommands(ctx context.Context, lightList []Device, controlField LightControlField) []*cli.Command {
-	return []*cli.Command{
-		{
-			Name:   "step-up",
-			Usage:  "Increase brightness or temperature",
-			Action: func(c *cli.Context) error { return adjustLightControlField(ctx, lightList, controlField, 10) },
-		},
-		{
-			Name:   "step-down",
-			Usage:  "Decrease brightness or temperature",
-			Action: func(c *cli.Context) error { return adjustLightControlField(ctx, lightList, controlField, -10) },
-		},
-		{
-			Name:  "get",
-			Usage: "Get brightness or temperature",
-			Action: func(c *cli.Context) error {
-				val, err := getLightControlField(c.Context, lightList, controlField)
-				if err != nil {
-					return err
-				}
+// LightSettings is a state to put lights into. Fields which are nil are left
+// as they are.
+type LightSettings = keylightctl.Settings
 
-				fmt.Printf("%d\n", val)
-				return nil
-			},
-		},
-		{
-			Name:   "set",
-			Usage:  "Set brightness or temperature",
-			Action: func(c *cli.Context) error { return setLightControlField(c, lightList, controlField) },
-		},
+// lightSettingsFromFlags reads the settings for the set command.
+func lightSettingsFromFlags(c *cli.Context) (LightSettings, error) {
+	var settings LightSettings
+
+	if c.Bool("on") && c.Bool("off") {
+		return settings, invalidArgumentf("--on and --off can't be used together")
+	}
+	if c.Bool("on") || c.Bool("off") {
+		on := boolToInt(c.Bool("on"))
+		settings.On = &on
 	}
-}
 
-func adjustLightControlField(ctx context.Context, lightList []Device, controlField LightControlField, change int) error {
-	value, err := getLightControlField(ctx, lightList, controlField)
-	if err != nil {
-		return err
+	if c.IsSet("brightness") {
+		brightness, err := parseBrightness(c.String("brightness"))
+		if err != nil {
+			return settings, invalidArgument(err)
+		}
+		settings.Brightness = &brightness
+	}
+
+	if c.IsSet("temperature") {
+		temperature, err := parseLightTemperature(c.String("temperature"), temperatureUnit)
+		if err != nil {
+			return settings, invalidArgument(err)
+		}
+		settings.Temperature = &temperature
 	}
 
-	value += change
-	if value > 100 {
-		value = 100
-	} else if value < 0 {
-		value = 0
+	if settings.IsZero() {
+		return settings, invalidArgumentf("nothing to set, give at least one of --brightness, --temperature, --on or --off")
 	}
 
-	return setLightControlFieldWithValue(ctx, lightList, controlField, value)
+	return settings, nil
 }
 
-func setLightControlField(c *cli.Context, lightList []Device, controlField LightControlField) error {
-	value, err := strconv.Atoi(c.Args().First())
+// setLightSettings applies the settings to every light the guards allow, with
+// one update per device.
+func setLightSettings(ctx context.Context, lightList []Device, settings LightSettings, guards ...LightGuard) (*CommandResult, error) {
+	unlock, err := acquireDeviceLocks(ctx, lightList)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer unlock()
 
-	return setLightControlFieldWithValue(c.Context, lightList, controlField, value)
-}
-
-func setLightControlFieldWithValue(ctx context.Context, lightList []Device, controlField LightControlField, value int) error {
-	lgs, err := fetchLightGroups(ctx, lightList)
+	result := newCommandResult()
+	lgs, err := fetchLightGroupsToChange(ctx, lightList, result)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	updates := make([]deviceUpdate, 0, len(lgs))
+	var delay time.Duration
+	for _, dlg := range lgs {
+		device, lightGroup := dlg.Device, dlg.LightGroup
+
+		var changes []Change
+		turningOn := false
+		for i, light := range lightGroup.Lights {
+			if !guardsAllow(device, i, light, guards) {
+				deviceLog.Debug("Guard not met, leaving light alone", "address", device.GetDNSAddr(), "light", i)
+				continue
+			}
+
+			wasOn := light.On
+			changes = append(changes, settings.Apply(i, light)...)
+			turningOn = turningOn || (light.On != wasOn && light.On == 1)
+		}
+
+		update := deviceUpdate{DeviceLightGroup: dlg, changes: changes}
+		if turningOn {
+			update.delay = delay
+			delay += stagger
+		}
+		updates = append(updates, update)
 	}
 
-	for device, lightGroup := range lgs {
-		for _, light := range lightGroup.Lights {
+	return result, result.failure(applyDeviceUpdates(ctx, result, updates))
+}
+
+// firstLightValue returns the value of the field for the first light in the
+// snapshot, or 0 if there are no lights.
+func firstLightValue(lgs []DeviceLightGroup, controlField LightControlField) int {
+	for _, dlg := range lgs {
+		for _, light := range dlg.LightGroup.Lights {
 			switch controlField {
 			case ControlBrightness:
-				light.Brightness = value
+				return light.Brightness
 			case ControlTemperature:
-				light.Temperature = value
+				return light.Temperature
 			}
 		}
-
-		logrus.Debug("Updating light group for ", device.GetDNSAddr())
-		_, err = device.UpdateLightGroup(ctx, lightGroup)
-		if err != nil {
-			return err
-		}
 	}
 
-	return nil
+	return 0
 }
 
-func getLightControlField(ctx context.Context, lightList []Device, controlField LightControlField) (int, error) {
+// getLightValues returns the value of the field for every light the guards
+// allow, along with where the light is, in the order the devices were given.
+func getLightValues(ctx context.Context, lightList []Device, controlField LightControlField, guards ...LightGuard) ([]LightValue, error) {
 	lgs, err := fetchLightGroups(ctx, lightList)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	for _, lightGroup := range lgs {
-		for _, light := range lightGroup.Lights {
+	lights := []LightValue{}
+	for _, dlg := range lgs {
+		for i, light := range dlg.LightGroup.Lights {
+			if !guardsAllow(dlg.Device, i, light, guards) {
+				continue
+			}
+
+			lv := LightValue{Device: deviceAddress(dlg.Device), Index: i, Name: indexNames.of(dlg.Device)[i]}
 			switch controlField {
 			case ControlBrightness:
-				return light.Brightness, nil
+				lv.Value = light.Brightness
 			case ControlTemperature:
-				return light.Temperature, nil
+				lv.Value = light.Temperature
 			}
+
+			lights = append(lights, lv)
 		}
 	}
 
-	return 0, nil
+	return lights, nil
 }
 
-func getDeviceStatus(ctx context.Context, lightList []Device) (string, error) {
-	var sb strings.Builder
+// fetchDeviceStatuses fetches the status of every device concurrently. The
+// result is in the same order as lightList. Unless strict is set, a section of
+// a device's status which can't be fetched is left out and its error recorded
+// in the status; only a device with nothing fetched at all is an error.
+func fetchDeviceStatuses(ctx context.Context, lightList []Device, strict bool) ([]DeviceStatus, error) {
+	statuses := make([]DeviceStatus, len(lightList))
+
+	err := forEachDevice(ctx, lightList, func(ctx context.Context, i int, device Device) error {
+		sectionErrors := map[string]string{}
+		var errs []error
+
+		// failed records a section which couldn't be fetched. With strict,
+		// that's the end of it.
+		failed := func(section string, err error) error {
+			deviceLog.Debug("Failed to fetch section", "address", device.GetDNSAddr(), "section", section, "error", err)
+			sectionErrors[section] = err.Error()
+			errs = append(errs, err)
+
+			if strict {
+				return err
+			}
+			return nil
+		}
 
-	for _, device := range lightList {
-		logrus.Debug("Fetching device info for ", device.GetDNSAddr())
+		deviceLog.Debug("Fetching device info", "address", device.GetDNSAddr())
 		deviceInfo, err := device.FetchDeviceInfo(ctx)
 		if err != nil {
-			return "", err
+			deviceInfo = nil
+			if err := failed(SectionInfo, err); err != nil {
+				return err
+			}
 		}
 
-		logrus.Debug("Fetching device settings for ", device.GetDNSAddr())
+		deviceLog.Debug("Fetching device settings", "address", device.GetDNSAddr())
 		deviceSettings, err := device.FetchSettings(ctx)
 		if err != nil {
-			return "", err
+			deviceSettings = nil
+			if err := failed(SectionSettings, err); err != nil {
+				return err
+			}
 		}
 
-		logrus.Debug("Fetching light group for ", device.GetDNSAddr())
+		deviceLog.Debug("Fetching light group", "address", device.GetDNSAddr())
 		lightGroup, err := device.FetchLightGroup(ctx)
 		if err != nil {
-			return "", err
+			lightGroup = nil
+			if err := failed(SectionLights, err); err != nil {
+				return err
+			}
+		}
+
+		// Nothing at all could be fetched, so there's nothing to show
+		if len(errs) == 3 {
+			return errors.Join(errs...)
+		}
+
+		statuses[i] = newDeviceStatus(device, deviceInfo, deviceSettings, lightGroup)
+		if len(sectionErrors) > 0 {
+			statuses[i].Errors = sectionErrors
+		}
+
+		// Not every firmware reports its Wi-Fi, so it isn't a section and
+		// failing to fetch it isn't an error
+		wifi, err := device.FetchWifiInfo(ctx)
+		if err != nil {
+			deviceLog.Debug("Failed to fetch Wi-Fi info", "address", device.GetDNSAddr(), "error", err)
+		}
+		statuses[i].Wifi = wifi
+
+		if keylightctl.SupportsColor(deviceInfo) {
+			colors, err := device.FetchColors(ctx)
+			if err != nil {
+				deviceLog.Debug("Failed to fetch colours", "address", device.GetDNSAddr(), "error", err)
+				return nil
+			}
+			for j, color := range colors.Lights[:min(len(colors.Lights), len(statuses[i].Lights))] {
+				statuses[i].Lights[j].Color = color
+			}
 		}
 
-		sb.WriteString(DeviceString(device, *deviceInfo, *deviceSettings, *lightGroup))
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return sb.String(), nil
+	return statuses, nil
 }
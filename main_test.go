@@ -1,30 +1,57 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"flag"
+	"net"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/endocrimes/keylight-go"
+	"github.com/iainlane/klctl/pkg/keylightctl"
 	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
 )
 
 type FakeDevice struct {
+	Name                     string
 	DNSAddr                  string
+	Port                     int
 	DeviceInfo               *keylight.DeviceInfo
 	DeviceSet                *keylight.DeviceSettings
 	LightGrp                 *keylight.LightGroup
+	Wifi                     *WifiInfo
+	Colors                   *ColorGroup
 	FetchDeviceInfoError     error
 	FetchDeviceSettingsError error
+	UpdateSettingsError      error
 	FetchLightGroupError     error
 	UpdateLightGroupError    error
+
+	// LightGroupFetches counts the calls to FetchLightGroup.
+	LightGroupFetches int
+
+	// Raw is the response to every raw request, which are recorded in
+	// RawRequests as "METHOD path body".
+	Raw         *RawResponse
+	RawRequests []string
+}
+
+func (f *FakeDevice) GetName() string {
+	return f.Name
 }
 
 func (f *FakeDevice) GetDNSAddr() string {
 	return f.DNSAddr
 }
 
+func (f *FakeDevice) GetPort() int {
+	return f.Port
+}
+
 func (f *FakeDevice) FetchDeviceInfo(ctx context.Context) (*keylight.DeviceInfo, error) {
 	return f.DeviceInfo, f.FetchDeviceInfoError
 }
@@ -33,12 +60,53 @@ func (f *FakeDevice) FetchSettings(ctx context.Context) (*keylight.DeviceSetting
 	return f.DeviceSet, f.FetchDeviceSettingsError
 }
 
+func (f *FakeDevice) UpdateSettings(ctx context.Context, settings *keylight.DeviceSettings) (*keylight.DeviceSettings, error) {
+	if f.UpdateSettingsError != nil {
+		return nil, f.UpdateSettingsError
+	}
+
+	f.DeviceSet = settings
+	return f.DeviceSet, nil
+}
+
 func (f *FakeDevice) FetchLightGroup(ctx context.Context) (*keylight.LightGroup, error) {
+	f.LightGroupFetches++
 	return f.LightGrp, f.FetchLightGroupError
 }
 
+func (f *FakeDevice) FetchWifiInfo(ctx context.Context) (*WifiInfo, error) {
+	return f.Wifi, nil
+}
+
+func (f *FakeDevice) FetchColors(ctx context.Context) (*ColorGroup, error) {
+	if f.Colors == nil {
+		return nil, errors.New("no colour")
+	}
+
+	return f.Colors.Copy(), nil
+}
+
+func (f *FakeDevice) UpdateColors(ctx context.Context, cg *ColorGroup) (*ColorGroup, error) {
+	f.Colors = cg.Copy()
+	return cg, nil
+}
+
+func (f *FakeDevice) RawRequest(ctx context.Context, method, path string, body []byte) (*RawResponse, error) {
+	f.RawRequests = append(f.RawRequests, method+" "+path+" "+string(body))
+	if f.Raw == nil {
+		return nil, errors.New("no raw response")
+	}
+
+	return f.Raw, nil
+}
+
 func (f *FakeDevice) UpdateLightGroup(ctx context.Context, lg *keylight.LightGroup) (*keylight.LightGroup, error) {
-	return f.LightGrp, f.UpdateLightGroupError
+	if f.UpdateLightGroupError != nil {
+		return nil, f.UpdateLightGroupError
+	}
+
+	f.LightGrp = lg
+	return f.LightGrp, nil
 }
 
 // FakeDiscoverer implements keylight.Discovery
@@ -70,6 +138,36 @@ func (fd *FakeDiscoverer) ResultsCh() <-chan Device {
 	return fd.deviceCh
 }
 
+// setupDevicesWithFakeClock runs setupDevices with a fake clock, which is moved
+// on once the discoverer has handed over all of its devices, so discovery
+// finishes without waiting for its quiet period in real time.
+func setupDevicesWithFakeClock(t *testing.T, ctx context.Context, cfg *Config, lightAddrs []string, discoverer *FakeDiscoverer) ([]Device, error) {
+	t.Helper()
+
+	type result struct {
+		devices []Device
+		err     error
+	}
+
+	clock := newFakeClock()
+	done := make(chan result, 1)
+	go func() {
+		devices, err := setupDevices(ctx, clock, cfg, lightAddrs, discoverer)
+		done <- result{devices, err}
+	}()
+
+	for {
+		select {
+		case r := <-done:
+			return r.devices, r.err
+		case <-time.After(time.Millisecond):
+			if len(discoverer.ResultsCh()) == 0 {
+				clock.Advance(keylightctl.DiscoveryQuietPeriod)
+			}
+		}
+	}
+}
+
 func TestSetupDevices(t *testing.T) {
 	ctx := context.Background()
 
@@ -83,7 +181,7 @@ func TestSetupDevices(t *testing.T) {
 
 	// Use provided light addresses
 	lightAddrs := []string{"192.168.1.1:9123"}
-	devices, err := setupDevices(ctx, lightAddrs, discoverer)
+	devices, err := setupDevicesWithFakeClock(t, ctx, &Config{}, lightAddrs, discoverer)
 	require.NoError(t, err)
 	require.Len(t, devices, 1)
 	require.Equal(t, devices[0].GetDNSAddr(), "192.168.1.1")
@@ -91,20 +189,30 @@ func TestSetupDevices(t *testing.T) {
 	ctx = context.Background()
 
 	// Discover lights when none provided
-	devices, err = setupDevices(ctx, []string{}, discoverer)
+	devices, err = setupDevicesWithFakeClock(t, ctx, &Config{}, []string{}, discoverer)
 	require.NoError(t, err)
 	require.Len(t, devices, 1)
 	require.Equal(t, devices[0].GetDNSAddr(), "1.2.3.4")
 
+	// Names from the config
+	cfg := &Config{Lights: map[string]LightConfig{
+		"desk": {Address: "192.168.1.5:9124"},
+	}}
+	devices, err = setupDevicesWithFakeClock(t, ctx, cfg, []string{"desk"}, discoverer)
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+	require.Equal(t, "desk", devices[0].GetName())
+	require.Equal(t, "192.168.1.5", devices[0].GetDNSAddr())
+
 	// No lights
-	devices, err = setupDevices(ctx, []string{}, &FakeDiscoverer{})
+	devices, err = setupDevicesWithFakeClock(t, ctx, &Config{}, []string{}, &FakeDiscoverer{})
 	require.NoError(t, err)
 	require.Len(t, devices, 0)
 
 	// Timed out context
 	ctx, cancel := context.WithTimeout(context.Background(), 0)
-	devices, err = setupDevices(ctx, []string{}, discoverer)
-	require.ErrorIs(t, err, &discoveryTimeoutError{})
+	devices, err = setupDevices(ctx, newFakeClock(), &Config{}, []string{}, discoverer)
+	require.ErrorIs(t, err, &keylightctl.DiscoveryTimeoutError{})
 	require.Len(t, devices, 0)
 	cancel()
 
@@ -112,7 +220,7 @@ func TestSetupDevices(t *testing.T) {
 	ctx, cancel = context.WithCancel(context.Background())
 	cancel()
 
-	devices, err = setupDevices(ctx, []string{}, discoverer)
+	devices, err = setupDevices(ctx, newFakeClock(), &Config{}, []string{}, discoverer)
 	require.Equal(t, err, context.Canceled)
 	require.Len(t, devices, 0)
 
@@ -122,7 +230,7 @@ func TestSetupDevices(t *testing.T) {
 	discoverer = &FakeDiscoverer{
 		Error: discoveryError,
 	}
-	devices, err = setupDevices(ctx, []string{}, discoverer)
+	devices, err = setupDevices(ctx, newFakeClock(), &Config{}, []string{}, discoverer)
 	require.Equal(t, err, discoveryError)
 	require.Len(t, devices, 0)
 }
@@ -138,65 +246,81 @@ func TestFetchLightGroups(t *testing.T) {
 	}
 	lights, err := fetchLightGroups(ctx, []Device{device})
 	require.NoError(t, err)
-	require.NotNil(t, lights[device])
-	require.Len(t, lights[device].Lights, 1)
+	require.Len(t, lights, 1)
+	require.Equal(t, device, lights[0].Device)
+	require.Len(t, lights[0].LightGroup.Lights, 1)
 }
 
 func TestGetDeviceStatus(t *testing.T) {
-	for _, test := range []struct {
-		name          string
-		device        *FakeDevice
-		expectedError bool
-	}{
-		{
-			name: "fetch device info ok",
-			device: &FakeDevice{
-				DNSAddr:    "192.168.1.2",
-				DeviceInfo: &keylight.DeviceInfo{ProductName: "Key Light"},
-				DeviceSet: &keylight.DeviceSettings{
-					PowerOnBrightness: 100,
-				},
-				LightGrp: &keylight.LightGroup{
-					Lights: []*keylight.Light{
-						{
-							On: 1,
-						},
+	newDevice := func() *FakeDevice {
+		return &FakeDevice{
+			DNSAddr:    "192.168.1.2",
+			DeviceInfo: &keylight.DeviceInfo{ProductName: "Key Light"},
+			DeviceSet: &keylight.DeviceSettings{
+				PowerOnBrightness: 100,
+			},
+			LightGrp: &keylight.LightGroup{
+				Lights: []*keylight.Light{
+					{
+						On: 1,
 					},
 				},
 			},
+		}
+	}
+
+	getDeviceStatus := func(ctx context.Context, devices []Device, strict bool) (string, error) {
+		statuses, err := fetchDeviceStatuses(ctx, devices, strict)
+		if err != nil {
+			return "", err
+		}
+
+		var buf bytes.Buffer
+		err = renderStatuses(&buf, OutputText, statuses, UnitKelvin, false)
+		return buf.String(), err
+	}
+
+	for _, test := range []struct {
+		name        string
+		breakDevice func(*FakeDevice)
+		unavailable string
+	}{
+		{
+			name:        "fetch device info ok",
+			breakDevice: func(*FakeDevice) {},
 		},
 		{
-			name: "fetch device info error",
-			device: &FakeDevice{
-				DNSAddr:              "192.168.1.2",
-				FetchDeviceInfoError: errors.New("fetch error"),
-			},
-			expectedError: true,
+			name:        "fetch device info error",
+			breakDevice: func(d *FakeDevice) { d.FetchDeviceInfoError = errors.New("fetch error") },
+			unavailable: "192.168.1.2: info unavailable (fetch error)\n",
 		},
 		{
-			name: "fetch device info error",
-			device: &FakeDevice{
-				DNSAddr:                  "192.168.1.2",
-				FetchDeviceSettingsError: errors.New("fetch error"),
-			},
-			expectedError: true,
+			name:        "fetch device settings error",
+			breakDevice: func(d *FakeDevice) { d.FetchDeviceSettingsError = errors.New("fetch error") },
+			unavailable: "192.168.1.2: settings unavailable (fetch error)\n",
 		},
 		{
-			name: "fetch device info error",
-			device: &FakeDevice{
-				DNSAddr:              "192.168.1.2",
-				FetchLightGroupError: errors.New("fetch error"),
-			},
-			expectedError: true,
+			name:        "fetch light group error",
+			breakDevice: func(d *FakeDevice) { d.FetchLightGroupError = errors.New("fetch error") },
+			unavailable: "192.168.1.2: lights unavailable (fetch error)\n",
 		},
 	} {
 		t.Run(test.name, func(t *testing.T) {
 			ctx := context.Background()
+			device := newDevice()
+			test.breakDevice(device)
+
+			// Without --strict, whatever could be fetched is shown
+			info, err := getDeviceStatus(ctx, []Device{device}, false)
+			require.NoError(t, err)
+			require.Contains(t, info, "\n192.168.1.2  ")
+			if test.unavailable != "" {
+				require.Contains(t, info, test.unavailable)
+			}
 
-			info, err := getDeviceStatus(ctx, []Device{test.device})
-			if test.expectedError {
-				require.Error(t, err)
-				require.Contains(t, err.Error(), "fetch error")
+			info, err = getDeviceStatus(ctx, []Device{device}, true)
+			if test.unavailable != "" {
+				require.ErrorContains(t, err, "fetch error")
 				require.Equal(t, "", info)
 			} else {
 				require.NoError(t, err)
@@ -204,6 +328,35 @@ func TestGetDeviceStatus(t *testing.T) {
 			}
 		})
 	}
+
+	t.Run("nothing fetched", func(t *testing.T) {
+		device := &FakeDevice{
+			DNSAddr:                  "192.168.1.2",
+			FetchDeviceInfoError:     errors.New("fetch error"),
+			FetchDeviceSettingsError: errors.New("fetch error"),
+			FetchLightGroupError:     errors.New("fetch error"),
+		}
+
+		_, err := getDeviceStatus(context.Background(), []Device{device}, false)
+		require.ErrorContains(t, err, "fetch error")
+	})
+}
+
+func TestFetchDeviceStatusesErrors(t *testing.T) {
+	device := &FakeDevice{
+		DNSAddr:                  "192.168.1.2",
+		DeviceInfo:               &keylight.DeviceInfo{ProductName: "Key Light"},
+		FetchDeviceSettingsError: errors.New("connection refused"),
+		LightGrp:                 &keylight.LightGroup{Lights: []*keylight.Light{{On: 1}}},
+	}
+
+	statuses, err := fetchDeviceStatuses(context.Background(), []Device{device}, false)
+	require.NoError(t, err)
+	require.Len(t, statuses, 1)
+	require.NotNil(t, statuses[0].Info)
+	require.Nil(t, statuses[0].Settings)
+	require.Len(t, statuses[0].Lights, 1)
+	require.Equal(t, map[string]string{SectionSettings: "connection refused"}, statuses[0].Errors)
 }
 
 func TestSetLightState(t *testing.T) {
@@ -215,12 +368,389 @@ func TestSetLightState(t *testing.T) {
 			{On: 1, Brightness: 50, Temperature: 3000},
 		}},
 	}
-	err := setLightState(ctx, []Device{device}, LightToggle)
+	result, err := setLightState(ctx, []Device{device}, LightToggle)
+	require.NoError(t, err)
+	require.Equal(t, ResultSummary{Touched: 1, Changed: 1}, result.Summary)
+	require.Equal(t, []Change{{Light: 0, Field: "on", Old: 1, New: 0}}, result.Devices[0].Changes)
+
+	// Already off, so nothing is sent
+	result, err = setLightState(ctx, []Device{device}, LightOff)
+	require.NoError(t, err)
+	require.Equal(t, ResultSummary{Touched: 1, Skipped: 1}, result.Summary)
+
+	result, err = setLightState(ctx, []Device{device}, LightOn)
+	require.NoError(t, err)
+	require.Equal(t, ResultSummary{Touched: 1, Changed: 1}, result.Summary)
+
+	device.UpdateLightGroupError = errors.New("update error")
+	result, err = setLightState(ctx, []Device{device}, LightToggle)
+	require.Error(t, err)
+	require.Equal(t, ResultSummary{Touched: 1, Failed: 1}, result.Summary)
+	require.Equal(t, "update error", result.Devices[0].Error)
+}
+
+func TestSetLightControlFieldWithValue(t *testing.T) {
+	ctx := context.Background()
+
+	device := &FakeDevice{
+		DNSAddr: "192.168.1.1",
+		LightGrp: &keylight.LightGroup{Lights: []*keylight.Light{
+			{On: 1, Brightness: 50, Temperature: 200},
+			{On: 1, Brightness: 40, Temperature: 200},
+		}},
+	}
+
+	result, err := setLightControlFieldWithValue(ctx, []Device{device}, ControlBrightness, 40)
 	require.NoError(t, err)
+	require.Equal(t, ResultSummary{Touched: 1, Changed: 1}, result.Summary)
+	require.Equal(t, []Change{{Light: 0, Field: "brightness", Old: 50, New: 40}}, result.Devices[0].Changes)
 
-	err = setLightState(ctx, []Device{device}, LightOff)
+	result, err = setLightControlFieldWithValue(ctx, []Device{device}, ControlTemperature, 200)
 	require.NoError(t, err)
+	require.Equal(t, ResultSummary{Touched: 1, Skipped: 1}, result.Summary)
+}
 
-	err = setLightState(ctx, []Device{device}, LightOn)
+func TestSetLightSettings(t *testing.T) {
+	ctx := context.Background()
+
+	device := &recordingDevice{FakeDevice: &FakeDevice{
+		DNSAddr: "192.168.1.1",
+		LightGrp: &keylight.LightGroup{Lights: []*keylight.Light{
+			{On: 0, Brightness: 50, Temperature: 200},
+		}},
+	}}
+
+	on, brightness := 1, 40
+	result, err := setLightSettings(ctx, []Device{device}, LightSettings{On: &on, Brightness: &brightness})
+	require.NoError(t, err)
+	require.Equal(t, []Change{
+		{Light: 0, Field: "on", Old: 0, New: 1},
+		{Light: 0, Field: "brightness", Old: 50, New: 40},
+	}, result.Devices[0].Changes)
+	require.Len(t, device.updates, 1)
+	require.Equal(t, keylight.Light{On: 1, Brightness: 40, Temperature: 200}, *device.LightGrp.Lights[0])
+
+	// Nothing changes, so nothing is sent
+	result, err = setLightSettings(ctx, []Device{device}, LightSettings{Brightness: &brightness})
 	require.NoError(t, err)
+	require.Equal(t, ResultSummary{Touched: 1, Skipped: 1}, result.Summary)
+	require.Len(t, device.updates, 1)
+}
+
+func TestLightSettingsFromFlags(t *testing.T) {
+	parse := func(args ...string) (LightSettings, error) {
+		var settings LightSettings
+		var err error
+
+		app := &cli.App{Commands: []*cli.Command{{
+			Name: "set",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{Name: "on"},
+				&cli.BoolFlag{Name: "off"},
+				&cli.StringFlag{Name: "brightness"},
+				&cli.StringFlag{Name: "temperature"},
+			},
+			Action: func(c *cli.Context) error {
+				settings, err = lightSettingsFromFlags(c)
+				return nil
+			},
+		}}}
+		require.NoError(t, app.Run(append([]string{"klctl", "set"}, args...)))
+
+		return settings, err
+	}
+
+	settings, err := parse("--off", "--temperature", "5000K")
+	require.NoError(t, err)
+	require.Equal(t, 0, *settings.On)
+	require.Equal(t, 200, *settings.Temperature)
+	require.Nil(t, settings.Brightness)
+
+	settings, err = parse("--brightness", "60%")
+	require.NoError(t, err)
+	require.Equal(t, 60, *settings.Brightness)
+
+	for _, args := range [][]string{
+		{},
+		{"--on", "--off"},
+		{"--brightness", "101"},
+		{"--brightness", "bright"},
+		{"--temperature", "9000K"},
+	} {
+		_, err := parse(args...)
+		require.Error(t, err, args)
+	}
+}
+
+func mustStepBy(t *testing.T, controlField LightControlField, step int) func(int) int {
+	t.Helper()
+
+	adjust, err := stepBy(controlField, step)
+	require.NoError(t, err)
+	return adjust
+}
+
+func TestStepByKelvin(t *testing.T) {
+	ctx := context.Background()
+
+	temperatureUnit = UnitKelvin
+	t.Cleanup(func() { temperatureUnit = "" })
+
+	device := &FakeDevice{
+		DNSAddr: "192.168.1.1",
+		LightGrp: &keylight.LightGroup{Lights: []*keylight.Light{
+			{On: 1, Temperature: 160},
+			{On: 1, Temperature: 260},
+		}},
+	}
+
+	// 6250K and 3846K each go up by 500K, so cooler, and fewer mireds
+	_, err := adjustLightControlField(ctx, []Device{device}, ControlTemperature, mustStepBy(t, ControlTemperature, 500))
+	require.NoError(t, err)
+	require.Equal(t, 148, device.LightGrp.Lights[0].Temperature)
+	require.Equal(t, 230, device.LightGrp.Lights[1].Temperature)
+
+	_, err = adjustLightControlField(ctx, []Device{device}, ControlTemperature, mustStepBy(t, ControlTemperature, -500))
+	require.NoError(t, err)
+	require.Equal(t, 160, device.LightGrp.Lights[0].Temperature)
+	require.Equal(t, 260, device.LightGrp.Lights[1].Temperature)
+}
+
+func TestAdjustLightControlField(t *testing.T) {
+	ctx := context.Background()
+
+	device := &FakeDevice{
+		DNSAddr: "192.168.1.1",
+		LightGrp: &keylight.LightGroup{Lights: []*keylight.Light{
+			{On: 1, Brightness: 50, Temperature: 200},
+		}},
+	}
+
+	_, err := adjustLightControlField(ctx, []Device{device}, ControlBrightness, mustStepBy(t, ControlBrightness, 15))
+	require.NoError(t, err)
+	require.Equal(t, 65, device.LightGrp.Lights[0].Brightness)
+	require.Equal(t, 1, device.LightGroupFetches, "the lights are read and changed from one fetch")
+
+	adjust, err := parseRelativeChange("-80", ControlBrightness)
+	require.NoError(t, err)
+	_, err = adjustLightControlField(ctx, []Device{device}, ControlBrightness, adjust)
+	require.NoError(t, err)
+	require.Equal(t, 0, device.LightGrp.Lights[0].Brightness)
+
+	_, err = adjustLightControlField(ctx, []Device{device}, ControlTemperature, mustStepBy(t, ControlTemperature, 500))
+	require.NoError(t, err)
+	require.Equal(t, maxTemperature, device.LightGrp.Lights[0].Temperature)
+
+	_, err = parseRelativeChange("+lots", ControlBrightness)
+	require.Error(t, err)
+}
+
+func TestAdjustLightControlFieldPerLight(t *testing.T) {
+	ctx := context.Background()
+
+	device := &FakeDevice{
+		DNSAddr: "192.168.1.1",
+		LightGrp: &keylight.LightGroup{Lights: []*keylight.Light{
+			{On: 0, Brightness: 20},
+			{On: 1, Brightness: 50},
+		}},
+	}
+
+	// Each light moves from its own value
+	result, err := adjustLightControlField(ctx, []Device{device}, ControlBrightness, mustStepBy(t, ControlBrightness, 10))
+	require.NoError(t, err)
+	require.Equal(t, []Change{
+		{Light: 0, Field: "brightness", Old: 20, New: 30},
+		{Light: 1, Field: "brightness", Old: 50, New: 60},
+	}, result.Devices[0].Changes)
+
+	// Lights the guards leave alone aren't used as the base either
+	ifOn := func(_ Device, _ int, light *keylight.Light) bool { return light.On == 1 }
+	result, err = adjustLightControlField(ctx, []Device{device}, ControlBrightness, mustStepBy(t, ControlBrightness, 45), ifOn)
+	require.NoError(t, err)
+	require.Equal(t, []Change{{Light: 1, Field: "brightness", Old: 60, New: 100}}, result.Devices[0].Changes)
+	require.Equal(t, 30, device.LightGrp.Lights[0].Brightness)
+}
+
+func TestProtectNegativeValues(t *testing.T) {
+	require.Equal(t,
+		[]string{"klctl", "brightness", "set", "--", "-20"},
+		protectNegativeValues([]string{"klctl", "brightness", "set", "-20"}))
+	require.Equal(t,
+		[]string{"klctl", "brightness", "set", "+15"},
+		protectNegativeValues([]string{"klctl", "brightness", "set", "+15"}))
+	require.Equal(t,
+		[]string{"klctl", "brightness", "set", "--if-on", "20"},
+		protectNegativeValues([]string{"klctl", "brightness", "set", "--if-on", "20"}))
+	require.Equal(t,
+		[]string{"klctl", "brightness", "set", "--if-on", "--", "-20"},
+		protectNegativeValues([]string{"klctl", "brightness", "set", "--if-on", "-20"}))
+	require.Equal(t,
+		[]string{"klctl", "brightness", "set", "--index", "1", "--if-brightness-below=50", "--", "-20"},
+		protectNegativeValues([]string{"klctl", "brightness", "set", "--index", "1", "--if-brightness-below=50", "-20"}))
+	require.Equal(t,
+		[]string{"klctl", "temperature", "set", "--match", "sony-5600"},
+		protectNegativeValues([]string{"klctl", "temperature", "set", "--match", "sony-5600"}))
+	require.Equal(t,
+		[]string{"klctl", "brightness", "set", "--", "-20"},
+		protectNegativeValues([]string{"klctl", "brightness", "set", "--", "-20"}))
+}
+
+func TestOnlyShowsHelp(t *testing.T) {
+	app := &cli.App{Commands: []*cli.Command{
+		{Name: "on"},
+		{Name: "brightness", Subcommands: []*cli.Command{{Name: "get"}}},
+	}}
+
+	for _, tc := range []struct {
+		args []string
+		want bool
+	}{
+		{[]string{"on"}, false},
+		{[]string{"brightness", "get"}, false},
+		{[]string{"brightness"}, true},
+		{[]string{"help", "on"}, true},
+		{[]string{"on", "--help"}, true},
+		{[]string{"brightness", "get", "-h"}, true},
+	} {
+		set := flag.NewFlagSet("klctl", flag.ContinueOnError)
+		require.NoError(t, set.Parse(tc.args))
+
+		require.Equal(t, tc.want, onlyShowsHelp(app, cli.NewContext(app, set, nil).Args()), tc.args)
+	}
+}
+
+func TestGetLightValues(t *testing.T) {
+	ctx := context.Background()
+
+	devices := []Device{
+		&FakeDevice{
+			DNSAddr: "192.168.1.1",
+			LightGrp: &keylight.LightGroup{Lights: []*keylight.Light{
+				{On: 1, Brightness: 50, Temperature: 200},
+			}},
+		},
+		&FakeDevice{
+			DNSAddr: "192.168.1.2",
+			LightGrp: &keylight.LightGroup{Lights: []*keylight.Light{
+				{On: 1, Brightness: 20, Temperature: 300},
+				{On: 1, Brightness: 30, Temperature: 250},
+			}},
+		},
+	}
+
+	lights, err := getLightValues(ctx, devices, ControlBrightness)
+	require.NoError(t, err)
+	require.Equal(t, []LightValue{
+		{Device: "192.168.1.1", Index: 0, Value: 50},
+		{Device: "192.168.1.2", Index: 0, Value: 20},
+		{Device: "192.168.1.2", Index: 1, Value: 30},
+	}, lights)
+
+	lights, err = getLightValues(ctx, devices, ControlTemperature)
+	require.NoError(t, err)
+	require.Equal(t, []LightValue{
+		{Device: "192.168.1.1", Index: 0, Value: 200},
+		{Device: "192.168.1.2", Index: 0, Value: 300},
+		{Device: "192.168.1.2", Index: 1, Value: 250},
+	}, lights)
+
+	onlySecond := func(_ Device, index int, _ *keylight.Light) bool { return index == 1 }
+	lights, err = getLightValues(ctx, devices, ControlBrightness, onlySecond)
+	require.NoError(t, err)
+	require.Equal(t, []LightValue{{Device: "192.168.1.2", Index: 1, Value: 30}}, lights)
+}
+
+func TestLightsOn(t *testing.T) {
+	ctx := context.Background()
+
+	on := &FakeDevice{
+		DNSAddr:  "192.168.1.1",
+		LightGrp: &keylight.LightGroup{Lights: []*keylight.Light{{On: 1}}},
+	}
+	off := &FakeDevice{
+		DNSAddr:  "192.168.1.2",
+		LightGrp: &keylight.LightGroup{Lights: []*keylight.Light{{On: 0}}},
+	}
+
+	for _, test := range []struct {
+		name     string
+		devices  []Device
+		anyOn    bool
+		expected bool
+	}{
+		{"all on", []Device{on}, false, true},
+		{"all, one off", []Device{on, off}, false, false},
+		{"any, one on", []Device{off, on}, true, true},
+		{"any, all off", []Device{off}, true, false},
+		{"no lights", nil, false, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := lightsOn(ctx, test.devices, test.anyOn)
+			require.NoError(t, err)
+			require.Equal(t, test.expected, result)
+		})
+	}
+}
+
+func TestSetLightStateStagger(t *testing.T) {
+	ctx := context.Background()
+
+	stagger = 50 * time.Millisecond
+	defer func() { stagger = 0 }()
+
+	newDevice := func(addr string, on int) *FakeDevice {
+		return &FakeDevice{
+			DNSAddr:  addr,
+			LightGrp: &keylight.LightGroup{Lights: []*keylight.Light{{On: on}}},
+		}
+	}
+
+	// Only the gaps between devices being powered on are staggered
+	start := time.Now()
+	_, err := setLightState(ctx, []Device{newDevice("192.168.1.1", 0), newDevice("192.168.1.2", 1), newDevice("192.168.1.3", 0)}, LightOn)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, time.Since(start), stagger)
+	require.Less(t, time.Since(start), 2*stagger)
+
+	start = time.Now()
+	_, err = setLightState(ctx, []Device{newDevice("192.168.1.1", 1), newDevice("192.168.1.2", 1)}, LightOff)
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), stagger)
+}
+
+func TestSetLightStateContinueOnError(t *testing.T) {
+	ctx := context.Background()
+
+	newDevices := func() []*FakeDevice {
+		return []*FakeDevice{
+			{DNSAddr: "192.168.1.1", LightGrp: &keylight.LightGroup{Lights: []*keylight.Light{{On: 0}}}},
+			{DNSAddr: "192.168.1.2", FetchLightGroupError: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}},
+			{DNSAddr: "192.168.1.3", LightGrp: &keylight.LightGroup{Lights: []*keylight.Light{{On: 0}}}},
+		}
+	}
+
+	// By default, nothing is changed if any light can't be read
+	fakes := newDevices()
+	result, err := setLightState(ctx, []Device{fakes[0], fakes[1], fakes[2]}, LightOn)
+	require.Error(t, err)
+	require.Nil(t, result)
+	require.Equal(t, 0, fakes[0].LightGrp.Lights[0].On)
+
+	continueOnError = true
+	defer func() { continueOnError = false }()
+
+	fakes = newDevices()
+	result, err = setLightState(ctx, []Device{fakes[0], fakes[1], fakes[2]}, LightOn)
+	require.ErrorContains(t, err, "192.168.1.2")
+	require.Equal(t, exitPartialFailure, exitCode(err))
+	require.Equal(t, 1, fakes[0].LightGrp.Lights[0].On)
+	require.Equal(t, 1, fakes[2].LightGrp.Lights[0].On)
+	require.Equal(t, ResultSummary{Touched: 3, Changed: 2, Failed: 1}, result.Summary)
+
+	// With none of them reachable, it isn't a partial failure
+	fakes = newDevices()
+	result, err = setLightState(ctx, []Device{fakes[1]}, LightOn)
+	require.Equal(t, exitUnreachable, exitCode(err))
+	require.Equal(t, ResultSummary{Touched: 1, Failed: 1}, result.Summary)
 }
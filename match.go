@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// DeviceMatch picks out lights by what they call themselves or by serial
+// number, so one of several discovered lights can be chosen without knowing
+// its address.
+type DeviceMatch struct {
+	// Names are patterns, as path.Match takes, such as "Elgato Key Light
+	// Air*". Case is ignored.
+	Names []string
+
+	// Serials are serial numbers. Case is ignored.
+	Serials []string
+}
+
+// isZero reports whether the match picks out every light.
+func (m DeviceMatch) isZero() bool {
+	return len(m.Names) == 0 && len(m.Serials) == 0
+}
+
+func (m DeviceMatch) validate() error {
+	for _, pattern := range m.Names {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return invalidArgumentf("invalid --match pattern %q: %w", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// matches reports whether a light with name and serial is picked out. A
+// light must match one of the names, if any are given, and one of the
+// serials, if any are given.
+func (m DeviceMatch) matches(name, serial string) bool {
+	if len(m.Names) > 0 {
+		matched := false
+		for _, pattern := range m.Names {
+			if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(name)); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if len(m.Serials) > 0 {
+		matched := false
+		for _, s := range m.Serials {
+			if serial != "" && strings.EqualFold(s, serial) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// String describes the match, for errors.
+func (m DeviceMatch) String() string {
+	var parts []string
+	for _, pattern := range m.Names {
+		parts = append(parts, fmt.Sprintf("--match %q", pattern))
+	}
+	for _, serial := range m.Serials {
+		parts = append(parts, "--serial "+serial)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// noMatch is the error when no light is picked out, listing the ones which
+// weren't, so the right name or serial can be given. Serial numbers are
+// redacted unless --no-redact was given, as errors are logged.
+func (m DeviceMatch) noMatch(names, serials []string) error {
+	found := make([]string, len(names))
+	for i, name := range names {
+		found[i] = fmt.Sprintf("%q", name)
+		if serial := serials[i]; serial != "" {
+			if !noRedact {
+				serial = redactSerial(serial)
+			}
+			found[i] += " (serial " + serial + ")"
+		}
+	}
+
+	return &categorizedError{fmt.Errorf("no lights match %s, found %s", m, strings.Join(found, ", ")), exitNoDevices}
+}
+
+// apply keeps the devices the match picks out. Serial numbers are taken from
+// known, by address, such as from the discovery cache, and fetched from the
+// lights for the rest, only if they're needed. Lights whose serial number
+// can't be fetched are left out.
+func (m DeviceMatch) apply(ctx context.Context, devices []Device, known map[string]string) ([]Device, error) {
+	if m.isZero() {
+		return devices, nil
+	}
+
+	names := make([]string, len(devices))
+	serials := make([]string, len(devices))
+	for i, device := range devices {
+		names[i] = device.GetName()
+		serials[i] = known[strings.TrimSuffix(device.GetDNSAddr(), ".")]
+	}
+
+	if len(m.Serials) > 0 {
+		// Failing to fetch a serial only leaves the light out
+		_ = forEachDevice(ctx, devices, func(ctx context.Context, i int, device Device) error {
+			if serials[i] != "" {
+				return nil
+			}
+
+			info, err := device.FetchDeviceInfo(ctx)
+			if err != nil {
+				deviceLog.Warn("Leaving out a light whose serial number can't be read", "device", deviceLabel(device), "error", err)
+				return nil
+			}
+
+			serials[i] = info.SerialNumber
+			return nil
+		})
+	}
+
+	var matched []Device
+	for i, device := range devices {
+		if m.matches(names[i], serials[i]) {
+			matched = append(matched, device)
+		} else {
+			deviceLog.Debug("Leaving out a light which doesn't match", "device", deviceLabel(device), "serial", serials[i])
+		}
+	}
+
+	if len(matched) == 0 && len(devices) > 0 {
+		return nil, m.noMatch(names, serials)
+	}
+
+	return matched, nil
+}
+
+// filterDiscovered keeps the discovered devices the match picks out.
+func (m DeviceMatch) filterDiscovered(devices []DiscoveredDevice) ([]DiscoveredDevice, error) {
+	if m.isZero() {
+		return devices, nil
+	}
+
+	var names, serials []string
+	matched := []DiscoveredDevice{}
+	for _, d := range devices {
+		names = append(names, d.Name)
+		serials = append(serials, d.Serial)
+		if m.matches(d.Name, d.Serial) {
+			matched = append(matched, d)
+		}
+	}
+
+	if len(matched) == 0 && len(devices) > 0 {
+		return nil, m.noMatch(names, serials)
+	}
+
+	return matched, nil
+}
+
+// knownSerials returns the serial numbers in the discovery cache, by address.
+func knownSerials(cache *DiscoveryCache) map[string]string {
+	serials := map[string]string{}
+	for _, cd := range cache.Devices {
+		if cd.Serial != "" {
+			serials[cd.Address] = cd.Serial
+		}
+	}
+
+	return serials
+}
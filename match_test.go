@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+func matchTestDevices() []Device {
+	return []Device{
+		&FakeDevice{Name: "Elgato Key Light Air 1A2B", DNSAddr: "air-1a2b.local.", DeviceInfo: &keylight.DeviceInfo{SerialNumber: "BW33J1A02345"}},
+		&FakeDevice{Name: "Elgato Key Light Air 3C4D", DNSAddr: "air-3c4d.local.", DeviceInfo: &keylight.DeviceInfo{SerialNumber: "BW33J1A06789"}},
+		&FakeDevice{Name: "Elgato Key Light 5E6F", DNSAddr: "kl-5e6f.local.", DeviceInfo: &keylight.DeviceInfo{SerialNumber: "CW20K1A00001"}},
+	}
+}
+
+func TestDeviceMatch(t *testing.T) {
+	ctx := context.Background()
+
+	matched, err := DeviceMatch{Names: []string{"elgato key light air*"}}.apply(ctx, matchTestDevices(), nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"air-1a2b.local.", "air-3c4d.local."}, addresses(matched))
+
+	matched, err = DeviceMatch{Serials: []string{"bw33j1a06789", "CW20K1A00001"}}.apply(ctx, matchTestDevices(), nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"air-3c4d.local.", "kl-5e6f.local."}, addresses(matched))
+
+	// Both must match
+	matched, err = DeviceMatch{Names: []string{"*Air*"}, Serials: []string{"CW20K1A00001", "BW33J1A02345"}}.apply(ctx, matchTestDevices(), nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"air-1a2b.local."}, addresses(matched))
+
+	// Known serials aren't fetched again, and lights which can't be read are
+	// left out
+	devices := matchTestDevices()
+	devices[0].(*FakeDevice).FetchDeviceInfoError = errors.New("unreachable")
+	devices[1].(*FakeDevice).FetchDeviceInfoError = errors.New("unreachable")
+	matched, err = DeviceMatch{Serials: []string{"BW33J1A02345", "BW33J1A06789", "CW20K1A00001"}}.apply(ctx, devices, map[string]string{"air-1a2b.local": "BW33J1A02345"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"air-1a2b.local.", "kl-5e6f.local."}, addresses(matched))
+
+	// Nothing is fetched without --serial
+	devices = matchTestDevices()
+	devices[2].(*FakeDevice).FetchDeviceInfoError = errors.New("unreachable")
+	matched, err = DeviceMatch{Names: []string{"*5E6F"}}.apply(ctx, devices, nil)
+	require.NoError(t, err)
+	require.Len(t, matched, 1)
+
+	_, err = DeviceMatch{Names: []string{"Ring Light*"}}.apply(ctx, matchTestDevices(), nil)
+	require.ErrorContains(t, err, `no lights match --match "Ring Light*", found "Elgato Key Light Air 1A2B", "Elgato Key Light Air 3C4D", "Elgato Key Light 5E6F"`)
+	require.Equal(t, exitNoDevices, exitCode(err))
+
+	matched, err = DeviceMatch{}.apply(ctx, matchTestDevices(), nil)
+	require.NoError(t, err)
+	require.Len(t, matched, 3)
+
+	require.Error(t, DeviceMatch{Names: []string{"[Elgato"}}.validate())
+	require.NoError(t, DeviceMatch{Names: []string{"Elgato*"}}.validate())
+}
+
+func TestDeviceMatchDiscovered(t *testing.T) {
+	devices := []DiscoveredDevice{
+		{Name: "Elgato Key Light Air 1A2B", Serial: "BW33J1A02345"},
+		{Name: "Elgato Key Light 5E6F", Serial: "CW20K1A00001"},
+	}
+
+	matched, err := DeviceMatch{Serials: []string{"CW20K1A00001"}}.filterDiscovered(devices)
+	require.NoError(t, err)
+	require.Equal(t, devices[1:], matched)
+
+	_, err = DeviceMatch{Serials: []string{"XX"}}.filterDiscovered(devices)
+	require.ErrorContains(t, err, `found "Elgato Key Light Air 1A2B" (serial BW33********), "Elgato Key Light 5E6F" (serial CW20********)`)
+
+	noRedact = true
+	t.Cleanup(func() { noRedact = false })
+	_, err = DeviceMatch{Serials: []string{"XX"}}.filterDiscovered(devices)
+	require.ErrorContains(t, err, `(serial BW33J1A02345)`)
+
+	matched, err = DeviceMatch{}.filterDiscovered(devices)
+	require.NoError(t, err)
+	require.Equal(t, devices, matched)
+}
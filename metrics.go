@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+)
+
+// How often serve polls the lights for /metrics by default.
+const defaultMetricsInterval = 15 * time.Second
+
+// Upper bounds, in seconds, of the buckets request latencies are counted in.
+var latencyBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// histogram counts observations into latencyBuckets.
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBuckets))
+	}
+
+	for i, bound := range latencyBuckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// deviceReading is what the last poll of a device found.
+type deviceReading struct {
+	up     bool
+	lights []keylight.Light
+}
+
+// latencyKey identifies a latency histogram.
+type latencyKey struct {
+	address string
+	call    string
+}
+
+// Metrics collects the state of the lights, by polling them, and the latency
+// of every request made to them. It's served in the Prometheus text format.
+type Metrics struct {
+	devices []Device
+
+	mu        sync.Mutex
+	readings  map[string]deviceReading
+	latencies map[latencyKey]*histogram
+}
+
+func newMetrics() *Metrics {
+	return &Metrics{
+		readings:  map[string]deviceReading{},
+		latencies: map[latencyKey]*histogram{},
+	}
+}
+
+// MetricsDevice wraps a Device, timing every call made to it.
+type MetricsDevice struct {
+	Device
+	metrics *Metrics
+}
+
+// wrap wraps the devices so their requests are timed, and remembers them to
+// be polled.
+func (m *Metrics) wrap(devices []Device) []Device {
+	wrapped := make([]Device, 0, len(devices))
+	for _, device := range devices {
+		wrapped = append(wrapped, &MetricsDevice{device, m})
+	}
+	m.devices = wrapped
+
+	return wrapped
+}
+
+func (m *Metrics) observe(address, call string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := latencyKey{address, call}
+	h, ok := m.latencies[key]
+	if !ok {
+		h = &histogram{}
+		m.latencies[key] = h
+	}
+	h.observe(d.Seconds())
+}
+
+func (md *MetricsDevice) time(call string, start time.Time) {
+	md.metrics.observe(md.GetDNSAddr(), call, time.Since(start))
+}
+
+func (md *MetricsDevice) FetchDeviceInfo(ctx context.Context) (*keylight.DeviceInfo, error) {
+	defer md.time("FetchDeviceInfo", time.Now())
+	return md.Device.FetchDeviceInfo(ctx)
+}
+
+func (md *MetricsDevice) FetchSettings(ctx context.Context) (*keylight.DeviceSettings, error) {
+	defer md.time("FetchSettings", time.Now())
+	return md.Device.FetchSettings(ctx)
+}
+
+func (md *MetricsDevice) FetchLightGroup(ctx context.Context) (*keylight.LightGroup, error) {
+	defer md.time("FetchLightGroup", time.Now())
+	return md.Device.FetchLightGroup(ctx)
+}
+
+func (md *MetricsDevice) UpdateLightGroup(ctx context.Context, lg *keylight.LightGroup) (*keylight.LightGroup, error) {
+	defer md.time("UpdateLightGroup", time.Now())
+	return md.Device.UpdateLightGroup(ctx, lg)
+}
+
+var _ Device = &MetricsDevice{}
+
+// poll reads every device once. A device which can't be read is recorded as
+// down.
+func (m *Metrics) poll(ctx context.Context, requestTimeout time.Duration) {
+	readings := make([]deviceReading, len(m.devices))
+
+	_ = forEachDevice(ctx, m.devices, func(ctx context.Context, i int, device Device) error {
+		ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+		defer cancel()
+
+		lg, err := device.FetchLightGroup(ctx)
+		if err != nil {
+			apiLog.Debug("Failed to poll device", "address", device.GetDNSAddr(), "error", err)
+			return nil
+		}
+
+		readings[i] = deviceReading{up: true, lights: lightGroupValues(lg)}
+		return nil
+	})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, device := range m.devices {
+		m.readings[device.GetDNSAddr()] = readings[i]
+	}
+}
+
+// run polls every interval until ctx is done.
+func (m *Metrics) run(ctx context.Context, interval, requestTimeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		m.poll(ctx, requestTimeout)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// labels renders Prometheus labels from name and value pairs.
+func labels(pairs ...string) string {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, pairs[i], escape.Replace(pairs[i+1])))
+	}
+
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// write writes the metrics in the Prometheus text format.
+func (m *Metrics) write(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sb strings.Builder
+
+	gauge := func(name, help string, values func(emit func(labels string, v float64))) {
+		fmt.Fprintf(&sb, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		values(func(labels string, v float64) {
+			fmt.Fprintf(&sb, "%s%s %g\n", name, labels, v)
+		})
+	}
+
+	gauge("klctl_device_up", "Whether the device answered the last poll.", func(emit func(string, float64)) {
+		for _, device := range m.devices {
+			reading := m.readings[device.GetDNSAddr()]
+			emit(labels("address", device.GetDNSAddr(), "name", device.GetName()), float64(boolToInt(reading.up)))
+		}
+	})
+
+	lightGauge := func(name, help string, value func(keylight.Light) float64) {
+		gauge(name, help, func(emit func(string, float64)) {
+			for _, device := range m.devices {
+				for i, light := range m.readings[device.GetDNSAddr()].lights {
+					emit(labels("address", device.GetDNSAddr(), "name", device.GetName(), "light", fmt.Sprint(i)), value(light))
+				}
+			}
+		})
+	}
+
+	lightGauge("klctl_light_on", "Whether the light is on.", func(l keylight.Light) float64 {
+		return float64(l.On)
+	})
+	lightGauge("klctl_light_brightness_percent", "The light's brightness.", func(l keylight.Light) float64 {
+		return float64(l.Brightness)
+	})
+	lightGauge("klctl_light_temperature_kelvin", "The light's colour temperature.", func(l keylight.Light) float64 {
+		return float64(miredToKelvin(l.Temperature))
+	})
+
+	const latency = "klctl_device_request_duration_seconds"
+	fmt.Fprintf(&sb, "# HELP %s How long requests to the devices took.\n# TYPE %s histogram\n", latency, latency)
+
+	keys := make([]latencyKey, 0, len(m.latencies))
+	for key := range m.latencies {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].address != keys[j].address {
+			return keys[i].address < keys[j].address
+		}
+		return keys[i].call < keys[j].call
+	})
+
+	for _, key := range keys {
+		h := m.latencies[key]
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(&sb, "%s_bucket%s %d\n", latency, labels("address", key.address, "call", key.call, "le", fmt.Sprint(bound)), h.counts[i])
+		}
+		fmt.Fprintf(&sb, "%s_bucket%s %d\n", latency, labels("address", key.address, "call", key.call, "le", "+Inf"), h.count)
+		fmt.Fprintf(&sb, "%s_sum%s %g\n", latency, labels("address", key.address, "call", key.call), h.sum)
+		fmt.Fprintf(&sb, "%s_count%s %d\n", latency, labels("address", key.address, "call", key.call), h.count)
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := m.write(w); err != nil {
+		apiLog.Debug("Failed to write metrics", "error", err)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+
+	metrics := newMetrics()
+	devices := metrics.wrap([]Device{
+		&FakeDevice{
+			Name:     `key "left"`,
+			DNSAddr:  "192.168.1.1",
+			LightGrp: &keylight.LightGroup{Lights: []*keylight.Light{{On: 1, Brightness: 40, Temperature: 200}}},
+		},
+		&FakeDevice{
+			DNSAddr:              "192.168.1.2",
+			FetchLightGroupError: errors.New("connection refused"),
+		},
+	})
+	metrics.poll(ctx, time.Second)
+
+	_, err := devices[0].UpdateLightGroup(ctx, &keylight.LightGroup{})
+	require.NoError(t, err)
+
+	var sb strings.Builder
+	require.NoError(t, metrics.write(&sb))
+	out := sb.String()
+
+	for _, line := range []string{
+		`klctl_device_up{address="192.168.1.1",name="key \"left\""} 1`,
+		`klctl_device_up{address="192.168.1.2",name=""} 0`,
+		`klctl_light_on{address="192.168.1.1",name="key \"left\"",light="0"} 1`,
+		`klctl_light_brightness_percent{address="192.168.1.1",name="key \"left\"",light="0"} 40`,
+		`klctl_light_temperature_kelvin{address="192.168.1.1",name="key \"left\"",light="0"} 5000`,
+		`klctl_device_request_duration_seconds_bucket{address="192.168.1.1",call="FetchLightGroup",le="+Inf"} 1`,
+		`klctl_device_request_duration_seconds_count{address="192.168.1.1",call="UpdateLightGroup"} 1`,
+		`klctl_device_request_duration_seconds_count{address="192.168.1.2",call="FetchLightGroup"} 1`,
+	} {
+		require.Contains(t, out, line+"\n")
+	}
+	require.NotContains(t, out, `klctl_light_on{address="192.168.1.2"`)
+}
+
+func TestHistogram(t *testing.T) {
+	var h histogram
+	h.observe(0.02)
+	h.observe(0.3)
+	h.observe(20)
+
+	require.Equal(t, uint64(3), h.count)
+	require.Equal(t, []uint64{0, 1, 1, 1, 1, 2, 2, 2, 2, 2}, h.counts)
+}
+
+func TestAPIServerMetrics(t *testing.T) {
+	server, _ := newTestAPIServer()
+
+	status, _ := doRequest(t, server, http.MethodGet, "/metrics", "")
+	require.Equal(t, http.StatusNotFound, status)
+
+	server.metrics = newMetrics()
+	server.devices = server.metrics.wrap(server.devices)
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.True(t, strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain"))
+	require.Contains(t, rec.Body.String(), `klctl_device_up{address="192.168.1.1",name="key-left"} 0`)
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://localhost/metrics", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/endocrimes/keylight-go"
+)
+
+// Defaults for the MQTT bridge.
+const (
+	defaultMQTTTopicPrefix     = "klctl"
+	defaultMQTTDiscoveryPrefix = "homeassistant"
+	defaultMQTTInterval        = 10 * time.Second
+)
+
+// MQTTConfig is how to reach an MQTT broker. It's read from the mqtt section
+// of the config file, and the mqtt command's flags override it.
+type MQTTConfig struct {
+	// Broker is the broker's URL, such as tcp://localhost:1883.
+	Broker   string `yaml:"broker"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	ClientID string `yaml:"client_id"`
+
+	// TopicPrefix is where the lights' state and command topics live.
+	TopicPrefix string `yaml:"topic_prefix"`
+
+	// DiscoveryPrefix is where Home Assistant looks for discovery messages.
+	DiscoveryPrefix string `yaml:"discovery_prefix"`
+}
+
+// defaultMQTTConfig is the configuration the config file and flags build on.
+func defaultMQTTConfig() MQTTConfig {
+	clientID := "klctl"
+	if hostname, err := os.Hostname(); err == nil {
+		clientID += "-" + hostname
+	}
+
+	return MQTTConfig{
+		ClientID:        clientID,
+		TopicPrefix:     defaultMQTTTopicPrefix,
+		DiscoveryPrefix: defaultMQTTDiscoveryPrefix,
+	}
+}
+
+// merge returns c with the fields set in override replacing its own.
+func (c MQTTConfig) merge(override MQTTConfig) MQTTConfig {
+	for _, f := range []struct{ dst, src *string }{
+		{&c.Broker, &override.Broker},
+		{&c.Username, &override.Username},
+		{&c.Password, &override.Password},
+		{&c.ClientID, &override.ClientID},
+		{&c.TopicPrefix, &override.TopicPrefix},
+		{&c.DiscoveryPrefix, &override.DiscoveryPrefix},
+	} {
+		if *f.src != "" {
+			*f.dst = *f.src
+		}
+	}
+
+	return c
+}
+
+func (c MQTTConfig) validate() error {
+	if c.Broker == "" {
+		return errors.New("no MQTT broker given, use --broker or set mqtt.broker in the config")
+	}
+
+	if strings.ContainsAny(c.TopicPrefix, "+#") || strings.ContainsAny(c.DiscoveryPrefix, "+#") {
+		return errors.New("MQTT topic prefixes can't contain wildcards")
+	}
+
+	return nil
+}
+
+// MQTTClient is the part of an MQTT client the bridge uses.
+type MQTTClient interface {
+	Publish(topic string, retained bool, payload []byte) error
+	Subscribe(topic string, handle func(topic string, payload []byte)) error
+}
+
+// pahoClient is an MQTTClient connected to a real broker.
+type pahoClient struct {
+	client  mqtt.Client
+	timeout time.Duration
+}
+
+// connectMQTT connects to the broker. will is published for the bridge if it
+// disconnects without saying goodbye, and onConnect is called every time the
+// connection is made, including after reconnecting.
+func connectMQTT(cfg MQTTConfig, timeout time.Duration, will string, onConnect func(MQTTClient)) (*pahoClient, error) {
+	pc := &pahoClient{timeout: timeout}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetConnectTimeout(timeout).
+		SetAutoReconnect(true).
+		SetOrderMatters(false).
+		SetWill(will, mqttOffline, 1, true).
+		SetOnConnectHandler(func(mqtt.Client) {
+			mqttLog.Info("Connected to MQTT broker", "broker", cfg.Broker)
+			onConnect(pc)
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			mqttLog.Warn("Lost connection to MQTT broker", "error", err)
+		})
+
+	pc.client = mqtt.NewClient(opts)
+	if err := pc.wait(pc.client.Connect()); err != nil {
+		return nil, fmt.Errorf("failed to connect to MQTT broker %s: %w", cfg.Broker, err)
+	}
+
+	return pc, nil
+}
+
+func (pc *pahoClient) wait(token mqtt.Token) error {
+	if !token.WaitTimeout(pc.timeout) {
+		return errors.New("timed out waiting for the MQTT broker")
+	}
+
+	return token.Error()
+}
+
+func (pc *pahoClient) Publish(topic string, retained bool, payload []byte) error {
+	return pc.wait(pc.client.Publish(topic, 1, retained, payload))
+}
+
+func (pc *pahoClient) Subscribe(topic string, handle func(topic string, payload []byte)) error {
+	return pc.wait(pc.client.Subscribe(topic, 1, func(_ mqtt.Client, msg mqtt.Message) {
+		handle(msg.Topic(), msg.Payload())
+	}))
+}
+
+// disconnect says goodbye, after publishing payload to topic.
+func (pc *pahoClient) disconnect(topic string, payload []byte) {
+	if err := pc.Publish(topic, true, payload); err != nil {
+		mqttLog.Debug("Failed to publish before disconnecting", "error", err)
+	}
+
+	pc.client.Disconnect(uint(pc.timeout.Milliseconds()))
+}
+
+// Availability payloads, as Home Assistant expects by default.
+const (
+	mqttOnline  = "online"
+	mqttOffline = "offline"
+)
+
+// MQTTLightState is a light's state in Home Assistant's JSON schema, which is
+// both published and accepted as a command. Brightness is a percentage and
+// ColorTemp is in mireds, the lights' own units.
+type MQTTLightState struct {
+	State      string `json:"state,omitempty"`
+	Brightness *int   `json:"brightness,omitempty"`
+	ColorTemp  *int   `json:"color_temp,omitempty"`
+	ColorMode  string `json:"color_mode,omitempty"`
+}
+
+func mqttLightState(light keylight.Light) MQTTLightState {
+	state := "OFF"
+	if light.On == 1 {
+		state = "ON"
+	}
+
+	return MQTTLightState{
+		State:      state,
+		Brightness: &light.Brightness,
+		ColorTemp:  &light.Temperature,
+		ColorMode:  "color_temp",
+	}
+}
+
+// apply changes the light as the command asks.
+func (s MQTTLightState) apply(light *keylight.Light) error {
+	switch strings.ToUpper(s.State) {
+	case "":
+	case "ON":
+		light.On = 1
+	case "OFF":
+		light.On = 0
+	default:
+		return fmt.Errorf("unknown state %q, must be ON or OFF", s.State)
+	}
+
+	if s.Brightness != nil {
+		light.Brightness = max(0, min(100, *s.Brightness))
+	}
+
+	if s.ColorTemp != nil {
+		light.Temperature = max(minTemperature, min(maxTemperature, *s.ColorTemp))
+	}
+
+	return nil
+}
+
+// unsafeTopicChars are the characters which can't be used in topic levels or
+// Home Assistant's object IDs.
+var unsafeTopicChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// mqttDeviceID is how a device is named in topics: its serial number if it
+// has one, or its address.
+func mqttDeviceID(device Device, info *keylight.DeviceInfo) string {
+	id := device.GetDNSAddr()
+	if info != nil && info.SerialNumber != "" {
+		id = info.SerialNumber
+	}
+
+	return unsafeTopicChars.ReplaceAllString(id, "_")
+}
+
+// MQTTBridge publishes the state of the lights to MQTT, with Home Assistant
+// discovery messages so they appear as lights there, and applies the commands
+// sent to them.
+//
+//	{prefix}/status                  the bridge's availability
+//	{prefix}/{device}/availability   whether the device is reachable
+//	{prefix}/{device}/{light}/state  the light's state, as MQTTLightState
+//	{prefix}/{device}/{light}/set    commands for the light, likewise
+//
+// {device} is the device's serial number and {light} counts from 0.
+type MQTTBridge struct {
+	client          MQTTClient
+	devices         []Device
+	infos           []*keylight.DeviceInfo
+	ids             []string
+	topicPrefix     string
+	discoveryPrefix string
+
+	// requestTimeout bounds each call to a device.
+	requestTimeout time.Duration
+
+	// mu guards published, which is the last payload sent to each topic, so
+	// that only changes are published.
+	mu        sync.Mutex
+	published map[string]string
+}
+
+// newMQTTBridge fetches what the bridge needs to know about each device. A
+// device which doesn't answer is named by its address.
+func newMQTTBridge(ctx context.Context, devices []Device, cfg MQTTConfig, requestTimeout time.Duration) *MQTTBridge {
+	b := &MQTTBridge{
+		devices:         devices,
+		infos:           make([]*keylight.DeviceInfo, len(devices)),
+		ids:             make([]string, len(devices)),
+		topicPrefix:     cfg.TopicPrefix,
+		discoveryPrefix: cfg.DiscoveryPrefix,
+		requestTimeout:  requestTimeout,
+		published:       map[string]string{},
+	}
+
+	_ = forEachDevice(ctx, devices, func(ctx context.Context, i int, device Device) error {
+		ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+		defer cancel()
+
+		info, err := device.FetchDeviceInfo(ctx)
+		if err != nil {
+			mqttLog.Warn("Failed to fetch device info", "address", device.GetDNSAddr(), "error", err)
+		}
+		b.infos[i] = info
+		return nil
+	})
+
+	for i, device := range devices {
+		b.ids[i] = mqttDeviceID(device, b.infos[i])
+	}
+
+	return b
+}
+
+func (b *MQTTBridge) statusTopic() string {
+	return b.topicPrefix + "/status"
+}
+
+func (b *MQTTBridge) lightTopic(device, light int, leaf string) string {
+	return fmt.Sprintf("%s/%s/%d/%s", b.topicPrefix, b.ids[device], light, leaf)
+}
+
+// publish sends payload to topic, unless it's what was sent last time.
+func (b *MQTTBridge) publish(topic string, payload []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.published[topic] == string(payload) {
+		return nil
+	}
+
+	if err := b.client.Publish(topic, true, payload); err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", topic, err)
+	}
+	b.published[topic] = string(payload)
+
+	return nil
+}
+
+func (b *MQTTBridge) publishJSON(topic string, v any) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return b.publish(topic, payload)
+}
+
+// discoveryConfig is the Home Assistant discovery message for a light.
+func (b *MQTTBridge) discoveryConfig(device, light, lights int) map[string]any {
+	d := b.devices[device]
+	info := b.infos[device]
+
+	deviceName := d.GetName()
+	hassDevice := map[string]any{
+		"identifiers":  []string{"klctl_" + b.ids[device]},
+		"manufacturer": "Elgato",
+	}
+	if info != nil {
+		hassDevice["model"] = info.ProductName
+		hassDevice["sw_version"] = info.FirmwareVersion
+		if deviceName == "" {
+			deviceName = info.DisplayName
+		}
+	}
+	if deviceName == "" {
+		deviceName = d.GetDNSAddr()
+	}
+	hassDevice["name"] = deviceName
+
+	// A device with one light is just that light, so the entity takes the
+	// device's name
+	var name any
+	if lights > 1 {
+		name = fmt.Sprintf("Light %d", light+1)
+	}
+
+	return map[string]any{
+		"name":          name,
+		"unique_id":     fmt.Sprintf("klctl_%s_%d", b.ids[device], light),
+		"schema":        "json",
+		"state_topic":   b.lightTopic(device, light, "state"),
+		"command_topic": b.lightTopic(device, light, "set"),
+		"availability": []map[string]string{
+			{"topic": b.statusTopic()},
+			{"topic": fmt.Sprintf("%s/%s/availability", b.topicPrefix, b.ids[device])},
+		},
+		"availability_mode":     "all",
+		"brightness":            true,
+		"brightness_scale":      100,
+		"supported_color_modes": []string{"color_temp"},
+		"min_mireds":            minTemperature,
+		"max_mireds":            maxTemperature,
+		"device":                hassDevice,
+	}
+}
+
+// connected is called each time the bridge connects to the broker. Everything
+// is published afresh, since the broker may have lost it.
+func (b *MQTTBridge) connected(ctx context.Context, client MQTTClient) {
+	b.mu.Lock()
+	b.client = client
+	b.published = map[string]string{}
+	b.mu.Unlock()
+
+	err := client.Subscribe(b.topicPrefix+"/+/+/set", func(topic string, payload []byte) {
+		if err := b.command(ctx, topic, payload); err != nil {
+			mqttLog.Error("Failed to apply command", "topic", topic, "error", err)
+		}
+	})
+	if err != nil {
+		mqttLog.Error("Failed to subscribe to commands", "error", err)
+	}
+
+	if err := b.publish(b.statusTopic(), []byte(mqttOnline)); err != nil {
+		mqttLog.Error("Failed to publish availability", "error", err)
+	}
+
+	b.reconcile(ctx)
+}
+
+// reconcile reads every device and publishes whatever has changed: its
+// availability, its lights' state and, for lights not seen before, their
+// discovery messages.
+func (b *MQTTBridge) reconcile(ctx context.Context) {
+	_ = forEachDevice(ctx, b.devices, func(ctx context.Context, i int, device Device) error {
+		ctx, cancel := context.WithTimeout(ctx, b.requestTimeout)
+		defer cancel()
+
+		availability := fmt.Sprintf("%s/%s/availability", b.topicPrefix, b.ids[i])
+
+		lg, err := device.FetchLightGroup(ctx)
+		if err != nil {
+			mqttLog.Debug("Failed to poll device", "address", device.GetDNSAddr(), "error", err)
+			if err := b.publish(availability, []byte(mqttOffline)); err != nil {
+				mqttLog.Error("Failed to publish availability", "error", err)
+			}
+			return nil
+		}
+
+		if err := b.publishLightGroup(i, lg); err != nil {
+			mqttLog.Error("Failed to publish state", "address", device.GetDNSAddr(), "error", err)
+		}
+		if err := b.publish(availability, []byte(mqttOnline)); err != nil {
+			mqttLog.Error("Failed to publish availability", "error", err)
+		}
+
+		return nil
+	})
+}
+
+// publishLightGroup publishes the discovery message and state of each light.
+// The discovery message comes first, so Home Assistant is listening for the
+// state.
+func (b *MQTTBridge) publishLightGroup(device int, lg *keylight.LightGroup) error {
+	for i, light := range lg.Lights {
+		topic := fmt.Sprintf("%s/light/klctl/%s_%d/config", b.discoveryPrefix, b.ids[device], i)
+		if err := b.publishJSON(topic, b.discoveryConfig(device, i, len(lg.Lights))); err != nil {
+			return err
+		}
+
+		if err := b.publishJSON(b.lightTopic(device, i, "state"), mqttLightState(*light)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// command applies a command sent to a light's set topic, and publishes the
+// light's new state.
+func (b *MQTTBridge) command(ctx context.Context, topic string, payload []byte) error {
+	parts := strings.Split(strings.TrimPrefix(topic, b.topicPrefix+"/"), "/")
+	if len(parts) != 3 || parts[2] != "set" {
+		return fmt.Errorf("unexpected command topic %s", topic)
+	}
+
+	device := -1
+	for i, id := range b.ids {
+		if id == parts[0] {
+			device = i
+		}
+	}
+	if device < 0 {
+		return fmt.Errorf("no device %s", parts[0])
+	}
+
+	light, err := parseLightIndex(parts[1])
+	if err != nil {
+		return err
+	}
+
+	var cmd MQTTLightState
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		return fmt.Errorf("invalid command: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, b.requestTimeout)
+	defer cancel()
+
+	d := b.devices[device]
+	mqttLog.Debug("Applying command", "address", d.GetDNSAddr(), "light", light, "command", string(payload))
+
+	lg, err := d.FetchLightGroup(ctx)
+	if err != nil {
+		return err
+	}
+	if light < 0 || light >= len(lg.Lights) {
+		return fmt.Errorf("%s has no light %d", d.GetDNSAddr(), light)
+	}
+
+	if err := cmd.apply(lg.Lights[light]); err != nil {
+		return err
+	}
+
+	updated, err := d.UpdateLightGroup(ctx, lg)
+	if err != nil {
+		return err
+	}
+
+	return b.publishLightGroup(device, updated)
+}
+
+// run keeps the published state in step with the lights, polling them every
+// interval until ctx is done.
+func (b *MQTTBridge) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		b.mu.Lock()
+		connected := b.client != nil
+		b.mu.Unlock()
+
+		if connected {
+			b.reconcile(ctx)
+		}
+	}
+}
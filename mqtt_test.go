@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeMQTTClient remembers what's published, and lets messages be delivered to
+// subscribers.
+type fakeMQTTClient struct {
+	mu        sync.Mutex
+	retained  map[string]string
+	published int
+	handlers  map[string]func(topic string, payload []byte)
+}
+
+func newFakeMQTTClient() *fakeMQTTClient {
+	return &fakeMQTTClient{
+		retained: map[string]string{},
+		handlers: map[string]func(string, []byte){},
+	}
+}
+
+func (c *fakeMQTTClient) Publish(topic string, retained bool, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.published++
+	if retained {
+		c.retained[topic] = string(payload)
+	}
+
+	return nil
+}
+
+func (c *fakeMQTTClient) Subscribe(topic string, handle func(topic string, payload []byte)) error {
+	c.handlers[topic] = handle
+	return nil
+}
+
+func (c *fakeMQTTClient) deliver(filter, topic, payload string) {
+	c.handlers[filter](topic, []byte(payload))
+}
+
+func TestMQTTBridge(t *testing.T) {
+	ctx := context.Background()
+
+	light := &FakeDevice{
+		Name:       "key-left",
+		DNSAddr:    "192.168.1.1",
+		DeviceInfo: &keylight.DeviceInfo{ProductName: "Elgato Key Light", SerialNumber: "CW12AB345678", FirmwareVersion: "1.0.3"},
+		LightGrp:   &keylight.LightGroup{Lights: []*keylight.Light{{On: 0, Brightness: 20, Temperature: 200}}},
+	}
+	unreachable := &FakeDevice{
+		DNSAddr:              "192.168.1.2",
+		FetchDeviceInfoError: errors.New("connection refused"),
+		FetchLightGroupError: errors.New("connection refused"),
+	}
+
+	cfg := MQTTConfig{TopicPrefix: "klctl", DiscoveryPrefix: "homeassistant"}
+	bridge := newMQTTBridge(ctx, []Device{light, unreachable}, cfg, time.Second)
+	require.Equal(t, []string{"CW12AB345678", "192_168_1_2"}, bridge.ids)
+
+	client := newFakeMQTTClient()
+	bridge.connected(ctx, client)
+
+	require.Equal(t, mqttOnline, client.retained["klctl/status"])
+	require.Equal(t, mqttOnline, client.retained["klctl/CW12AB345678/availability"])
+	require.Equal(t, mqttOffline, client.retained["klctl/192_168_1_2/availability"])
+	require.JSONEq(t,
+		`{"state":"OFF","brightness":20,"color_temp":200,"color_mode":"color_temp"}`,
+		client.retained["klctl/CW12AB345678/0/state"])
+
+	var discovery map[string]any
+	require.NoError(t, json.Unmarshal([]byte(client.retained["homeassistant/light/klctl/CW12AB345678_0/config"]), &discovery))
+	require.Equal(t, "klctl_CW12AB345678_0", discovery["unique_id"])
+	require.Equal(t, "klctl/CW12AB345678/0/set", discovery["command_topic"])
+	require.Nil(t, discovery["name"])
+	require.Equal(t, "key-left", discovery["device"].(map[string]any)["name"])
+
+	// Nothing is published again if nothing has changed
+	published := client.published
+	bridge.reconcile(ctx)
+	require.Equal(t, published, client.published)
+
+	// Changes made elsewhere are picked up
+	light.LightGrp.Lights[0].Brightness = 60
+	bridge.reconcile(ctx)
+	require.Contains(t, client.retained["klctl/CW12AB345678/0/state"], `"brightness":60`)
+
+	client.deliver("klctl/+/+/set", "klctl/CW12AB345678/0/set", `{"state":"ON","color_temp":500}`)
+	require.Equal(t, 1, light.LightGrp.Lights[0].On)
+	require.Equal(t, maxTemperature, light.LightGrp.Lights[0].Temperature)
+	require.Contains(t, client.retained["klctl/CW12AB345678/0/state"], `"state":"ON"`)
+}
+
+func TestMQTTBridgeCommandErrors(t *testing.T) {
+	ctx := context.Background()
+
+	light := &FakeDevice{
+		DNSAddr:    "192.168.1.1",
+		DeviceInfo: &keylight.DeviceInfo{SerialNumber: "CW12AB345678"},
+		LightGrp:   &keylight.LightGroup{Lights: []*keylight.Light{{}}},
+	}
+	bridge := newMQTTBridge(ctx, []Device{light}, MQTTConfig{TopicPrefix: "klctl"}, time.Second)
+	bridge.client = newFakeMQTTClient()
+
+	for topic, payload := range map[string]string{
+		"klctl/CW00/0/set":         `{"state":"ON"}`,
+		"klctl/CW12AB345678/1/set": `{"state":"ON"}`,
+		"klctl/CW12AB345678/x/set": `{"state":"ON"}`,
+		"klctl/CW12AB345678/0/set": `{"state":"DIM"}`,
+		"klctl/CW12AB345678/set":   `{"state":"ON"}`,
+	} {
+		require.Error(t, bridge.command(ctx, topic, []byte(payload)), topic)
+	}
+	require.Equal(t, 0, light.LightGrp.Lights[0].On)
+}
+
+func TestMQTTConfigMerge(t *testing.T) {
+	cfg := MQTTConfig{Broker: "tcp://a:1883", TopicPrefix: "klctl"}.
+		merge(MQTTConfig{Broker: "tcp://b:1883", Username: "me"})
+
+	require.Equal(t, MQTTConfig{Broker: "tcp://b:1883", Username: "me", TopicPrefix: "klctl"}, cfg)
+	require.NoError(t, cfg.validate())
+
+	require.Error(t, MQTTConfig{}.validate())
+	require.Error(t, MQTTConfig{Broker: "tcp://b:1883", TopicPrefix: "klctl/#"}.validate())
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Default steps for each key press in nudge.
+const (
+	defaultNudgeBrightnessStep  = 5
+	defaultNudgeTemperatureStep = 100
+)
+
+// nudgeKey is a key nudge understands.
+type nudgeKey int
+
+const (
+	keyUp nudgeKey = iota
+	keyDown
+	keyLeft
+	keyRight
+	keyEnter
+	keyEscape
+	keySpace
+	keyTab
+	keyBackTab
+	keyQuit
+)
+
+// parseNudgeKeys turns what was read from a raw terminal into keys. Anything
+// else, such as other escape sequences, is ignored. An escape on its own is
+// the Esc key; followed by [ or O, it starts an arrow key, or Shift-Tab.
+func parseNudgeKeys(b []byte) []nudgeKey {
+	arrows := map[byte]nudgeKey{'A': keyUp, 'B': keyDown, 'C': keyRight, 'D': keyLeft, 'Z': keyBackTab}
+
+	var keys []nudgeKey
+	for i := 0; i < len(b); i++ {
+		switch b[i] {
+		case '\r', '\n':
+			keys = append(keys, keyEnter)
+		case 0x03: // Ctrl-C, which doesn't send a signal in raw mode
+			keys = append(keys, keyEscape)
+		case ' ':
+			keys = append(keys, keySpace)
+		case '\t':
+			keys = append(keys, keyTab)
+		case 'q':
+			keys = append(keys, keyQuit)
+		case 0x1b:
+			if i+2 < len(b) && (b[i+1] == '[' || b[i+1] == 'O') {
+				if key, ok := arrows[b[i+2]]; ok {
+					keys = append(keys, key)
+				}
+				i += 2
+				continue
+			}
+			keys = append(keys, keyEscape)
+		}
+	}
+
+	return keys
+}
+
+// readNudgeKeys reads keys until r fails, or done is closed. The error r
+// fails with is sent once, after every key before it.
+func readNudgeKeys(r io.Reader, done <-chan struct{}) (<-chan []nudgeKey, <-chan error) {
+	pressed := make(chan []nudgeKey)
+	readErr := make(chan error, 1)
+
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			k, err := r.Read(buf)
+			if k > 0 {
+				select {
+				case pressed <- parseNudgeKeys(buf[:k]):
+				case <-done:
+					return
+				}
+			}
+			if err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	return pressed, readErr
+}
+
+// NudgeSteps are how far each key press moves the lights.
+type NudgeSteps struct {
+	Brightness int
+	// Temperature is in Kelvin, so steps look even to people.
+	Temperature int
+}
+
+// nudger holds what the lights are being nudged to.
+type nudger struct {
+	steps       NudgeSteps
+	brightness  int
+	temperature int
+}
+
+// press applies a key. It reports whether the key changed the values.
+func (n *nudger) press(key nudgeKey) bool {
+	brightness, temperature := n.brightness, n.temperature
+
+	switch key {
+	case keyUp:
+		n.brightness = min(100, n.brightness+n.steps.Brightness)
+	case keyDown:
+		n.brightness = max(0, n.brightness-n.steps.Brightness)
+	case keyRight:
+		n.temperature = kelvinToMired(miredToKelvin(n.temperature) + n.steps.Temperature)
+	case keyLeft:
+		n.temperature = kelvinToMired(max(1, miredToKelvin(n.temperature)-n.steps.Temperature))
+	}
+	n.temperature = max(minTemperature, min(maxTemperature, n.temperature))
+
+	return n.brightness != brightness || n.temperature != temperature
+}
+
+func (n *nudger) show(w io.Writer) {
+	fmt.Fprintf(w, "\r\x1b[Kbrightness %d%%  temperature %s",
+		n.brightness, temperatureString(n.temperature, statusTemperatureUnit(), colorOutput))
+}
+
+// writeNudge sets every light of the snapshot to the brightness and
+// temperature, in one request per device.
+func writeNudge(ctx context.Context, lgs []DeviceLightGroup, brightness, temperature int) error {
+	devices := make([]Device, 0, len(lgs))
+	for _, dlg := range lgs {
+		devices = append(devices, dlg.Device)
+	}
+
+	return forEachDevice(ctx, devices, func(ctx context.Context, i int, device Device) error {
+		lg := lgs[i].LightGroup
+		for _, light := range lg.Lights {
+			light.Brightness = brightness
+			light.Temperature = temperature
+		}
+
+		deviceLog.Debug("Nudging light group", "address", device.GetDNSAddr(), "brightness", brightness, "temperature", temperature)
+		_, err := device.UpdateLightGroup(ctx, lg)
+		return err
+	})
+}
+
+// runNudge adjusts the lights live as keys are read: up and down change the
+// brightness, and left and right the temperature. Enter keeps the new values,
+// and Esc puts back the ones the lights started with. Only one write is made
+// at a time; presses which arrive while one is in flight are sent together in
+// the next.
+func runNudge(ctx context.Context, lightList []Device, keys io.Reader, display io.Writer, steps NudgeSteps) error {
+	unlock, err := acquireDeviceLocks(ctx, lightList)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	fetchCtx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	original, err := fetchLightGroups(fetchCtx, lightList)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	current := make([]DeviceLightGroup, len(original))
+	for i, dlg := range original {
+		current[i] = DeviceLightGroup{dlg.Device, dlg.LightGroup.Copy()}
+	}
+
+	n := &nudger{
+		steps:       steps,
+		brightness:  firstLightValue(original, ControlBrightness),
+		temperature: firstLightValue(original, ControlTemperature),
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	pressed, readErr := readNudgeKeys(keys, done)
+
+	fmt.Fprint(display, "Up/Down: brightness, Left/Right: temperature, Enter: keep, Esc: restore\r\n")
+	n.show(display)
+	defer fmt.Fprint(display, "\r\n")
+
+	var (
+		ctxDone   = ctx.Done()
+		wrote     = make(chan error, 1)
+		writing   bool
+		dirty     bool
+		confirmed bool
+		cancelled bool
+		failure   error
+	)
+
+	for {
+		select {
+		case <-ctxDone:
+			ctxDone = nil
+			cancelled, failure = true, ctx.Err()
+
+		case err := <-readErr:
+			// With nothing left to read, there's no way to confirm
+			if !confirmed && !cancelled {
+				cancelled = true
+				if !errors.Is(err, io.EOF) {
+					failure = err
+				}
+			}
+
+		case pressedKeys := <-pressed:
+			for _, key := range pressedKeys {
+				if confirmed || cancelled {
+					break
+				}
+
+				switch key {
+				case keyEnter:
+					confirmed = true
+				case keyEscape:
+					cancelled = true
+				default:
+					if n.press(key) {
+						dirty = true
+						n.show(display)
+					}
+				}
+			}
+
+		case err := <-wrote:
+			writing = false
+			if err != nil && !cancelled {
+				cancelled, failure = true, err
+			}
+		}
+
+		if dirty && !writing && !cancelled {
+			writing, dirty = true, false
+			brightness, temperature := n.brightness, n.temperature
+			go func() {
+				writeCtx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+				defer cancel()
+				wrote <- writeNudge(writeCtx, current, brightness, temperature)
+			}()
+		}
+
+		if writing || !(confirmed || cancelled) {
+			continue
+		}
+
+		if cancelled {
+			automationLog.Debug("Restoring lights after nudge")
+			restoreLightGroups(context.WithoutCancel(ctx), original)
+		}
+
+		return failure
+	}
+}
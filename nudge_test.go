@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNudgeKeys(t *testing.T) {
+	require.Equal(t,
+		[]nudgeKey{keyUp, keyDown, keyRight, keyLeft, keyUp, keyEnter},
+		parseNudgeKeys([]byte("\x1b[A\x1b[B\x1b[C\x1b[D\x1bOA\r")))
+	require.Equal(t, []nudgeKey{keyEscape}, parseNudgeKeys([]byte("\x1b")))
+	require.Equal(t, []nudgeKey{keyEscape, keyUp}, parseNudgeKeys([]byte("\x1b\x1b[A")))
+	require.Equal(t, []nudgeKey{keyEscape}, parseNudgeKeys([]byte{0x03}))
+	require.Equal(t, []nudgeKey{keySpace, keyTab, keyBackTab, keyQuit}, parseNudgeKeys([]byte(" \t\x1b[Zq")))
+
+	// Other keys and escape sequences are ignored
+	require.Empty(t, parseNudgeKeys([]byte("x\x1b[H")))
+}
+
+func TestNudgerPress(t *testing.T) {
+	n := &nudger{steps: NudgeSteps{Brightness: 5, Temperature: 100}, brightness: 98, temperature: 200}
+
+	require.True(t, n.press(keyUp))
+	require.Equal(t, 100, n.brightness)
+	require.False(t, n.press(keyUp))
+
+	require.True(t, n.press(keyRight))
+	require.Equal(t, kelvinToMired(5100), n.temperature)
+	require.True(t, n.press(keyLeft))
+	require.Equal(t, 200, n.temperature)
+
+	n.temperature = maxTemperature
+	require.False(t, n.press(keyLeft))
+}
+
+func newNudgeDevice() *recordingDevice {
+	return &recordingDevice{FakeDevice: &FakeDevice{
+		DNSAddr:  "192.168.1.1",
+		LightGrp: &keylight.LightGroup{Lights: []*keylight.Light{{On: 1, Brightness: 40, Temperature: 200}}},
+	}}
+}
+
+func TestRunNudgeCoalescesPresses(t *testing.T) {
+	device := newNudgeDevice()
+
+	var display bytes.Buffer
+	keys := strings.NewReader("\x1b[A\x1b[A\x1b[A\x1b[C\r")
+	require.NoError(t, runNudge(context.Background(), []Device{device}, keys, &display, NudgeSteps{5, 100}))
+
+	// All the presses were read together, so they're sent in one write
+	require.Len(t, device.updates, 1)
+	require.Equal(t, 55, device.LightGrp.Lights[0].Brightness)
+	require.Equal(t, kelvinToMired(5100), device.LightGrp.Lights[0].Temperature)
+	require.Contains(t, display.String(), "brightness 55%")
+}
+
+func TestRunNudgeEscapeRestores(t *testing.T) {
+	device := newNudgeDevice()
+
+	keys := strings.NewReader("\x1b[B\x1b[B\x1b")
+	require.NoError(t, runNudge(context.Background(), []Device{device}, keys, &bytes.Buffer{}, NudgeSteps{5, 100}))
+	require.Equal(t, 40, device.LightGrp.Lights[0].Brightness)
+	require.Equal(t, 200, device.LightGrp.Lights[0].Temperature)
+}
+
+func TestRunNudgeEndOfInputRestores(t *testing.T) {
+	device := newNudgeDevice()
+
+	keys := strings.NewReader("\x1b[B")
+	require.NoError(t, runNudge(context.Background(), []Device{device}, keys, &bytes.Buffer{}, NudgeSteps{5, 100}))
+	require.Equal(t, 40, device.LightGrp.Lights[0].Brightness)
+}
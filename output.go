@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+
+	// These are only for status. Its text output is the same as table.
+	OutputTable = "table"
+	OutputWide  = "wide"
+	OutputYAML  = "yaml"
+)
+
+// validateOutputFormat checks the format can be used by the command.
+func validateOutputFormat(format, command string) error {
+	switch format {
+	case OutputText, OutputJSON:
+		return nil
+	case OutputTable, OutputWide, OutputYAML:
+		if command == "status" {
+			return nil
+		}
+		return fmt.Errorf("output %s is only supported by status", format)
+	}
+
+	return fmt.Errorf("output must be one of %s or %s (got %s)", OutputText, OutputJSON, format)
+}
+
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// writeYAML writes v as YAML with the same keys, in the same order, as
+// writeJSON. Most of what we output only has JSON tags, including the types
+// from keylight-go, so it goes through JSON first.
+func writeYAML(w io.Writer, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	// JSON is YAML, but in flow style, so it's parsed and restyled
+	var node yaml.Node
+	if err := yaml.Unmarshal(b, &node); err != nil {
+		return err
+	}
+	blockStyle(&node)
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return err
+	}
+
+	return enc.Close()
+}
+
+// blockStyle clears the flow style of a node and everything under it, and
+// the quotes around strings. The encoder puts quotes back on strings which
+// would otherwise be read as something else, such as "true".
+func blockStyle(node *yaml.Node) {
+	node.Style &^= yaml.FlowStyle
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!str" {
+		node.Style = 0
+	}
+
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+// renderResult writes the result of a mutating command. Text output stays
+// quiet so hotkey bindings don't print anything, and the summary is only
+// logged.
+func renderResult(w io.Writer, format string, result *CommandResult) error {
+	if format == OutputJSON {
+		return writeJSON(w, result)
+	}
+
+	slog.Debug("Command finished",
+		"touched", result.Summary.Touched,
+		"changed", result.Summary.Changed,
+		"skipped", result.Summary.Skipped,
+		"failed", result.Summary.Failed,
+		"duration", result.DurationMS)
+
+	return nil
+}
+
+// FieldValues is the JSON form of the values read by a get command.
+type FieldValues struct {
+	Field     string          `json:"field"`
+	Unit      TemperatureUnit `json:"unit,omitempty"`
+	Aggregate string          `json:"aggregate"`
+	Values    []int           `json:"values"`
+}
+
+func renderValues(w io.Writer, format string, field LightControlField, unit TemperatureUnit, aggregation Aggregation, values []int) error {
+	if format == OutputJSON {
+		if values == nil {
+			values = []int{}
+		}
+
+		return writeJSON(w, FieldValues{field.String(), unit, aggregation.String(), values})
+	}
+
+	for _, v := range values {
+		if _, err := fmt.Fprintf(w, "%d\n", v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// LightValue is the value of a field of one light.
+type LightValue struct {
+	Device string `json:"device"`
+	Index  int    `json:"index"`
+	Name   string `json:"name,omitempty"`
+	Value  int    `json:"value"`
+}
+
+// LightValues is the JSON form of the values read by get --per-light.
+type LightValues struct {
+	Field  string          `json:"field"`
+	Unit   TemperatureUnit `json:"unit,omitempty"`
+	Lights []LightValue    `json:"lights"`
+}
+
+func renderLightValues(w io.Writer, format string, field LightControlField, unit TemperatureUnit, lights []LightValue) error {
+	if format == OutputJSON {
+		return writeJSON(w, LightValues{field.String(), unit, lights})
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, lv := range lights {
+		fmt.Fprintf(tw, "%s\t[%s]\t%d\n", lv.Device, lightLabel(lv.Index, lv.Name), lv.Value)
+	}
+
+	return tw.Flush()
+}
+
+func renderDiscovered(w io.Writer, format string, devices []DiscoveredDevice) error {
+	if format == OutputJSON {
+		return writeJSON(w, devices)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tADDRESS\tIP\tPORT\tSERIAL")
+	for _, d := range devices {
+		serial := d.Serial
+		if d.Error != "" {
+			serial = "unavailable"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", d.Name, d.Address, strings.Join(d.IPs, ","), d.Port, serial)
+	}
+
+	return tw.Flush()
+}
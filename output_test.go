@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderValues(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, renderValues(&buf, OutputText, ControlBrightness, "", AggregateList, []int{10, 20}))
+	require.Equal(t, "10\n20\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, renderValues(&buf, OutputJSON, ControlTemperature, UnitMired, AggregateAvg, []int{200}))
+	require.JSONEq(t, `{"field":"temperature","unit":"mired","aggregate":"avg","values":[200]}`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, renderValues(&buf, OutputJSON, ControlTemperature, UnitMired, AggregateAvg, nil))
+	require.JSONEq(t, `{"field":"temperature","unit":"mired","aggregate":"avg","values":[]}`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, renderValues(&buf, OutputJSON, ControlBrightness, "", AggregateMax, []int{50}))
+	require.JSONEq(t, `{"field":"brightness","aggregate":"max","values":[50]}`, buf.String())
+}
+
+func TestRenderLightValues(t *testing.T) {
+	lights := []LightValue{
+		{Device: "192.168.1.1", Index: 0, Value: 50},
+		{Device: "192.168.1.10", Index: 1, Value: 30},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, renderLightValues(&buf, OutputText, ControlBrightness, "", lights))
+	require.Equal(t, "192.168.1.1   [0]  50\n192.168.1.10  [1]  30\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, renderLightValues(&buf, OutputJSON, ControlTemperature, UnitKelvin, lights[:1]))
+	require.JSONEq(t, `{"field":"temperature","unit":"kelvin","lights":[{"device":"192.168.1.1","index":0,"value":50}]}`, buf.String())
+}
+
+func TestRenderResultJSON(t *testing.T) {
+	result := newCommandResult()
+	result.addDevice(&FakeDevice{DNSAddr: "192.168.1.1"}, result.start, []Change{{Field: "on", Old: 0, New: 1}}, nil)
+
+	var buf bytes.Buffer
+	require.NoError(t, renderResult(&buf, OutputJSON, result.finish()))
+
+	var decoded CommandResult
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	require.Equal(t, ResultSummary{Touched: 1, Changed: 1}, decoded.Summary)
+	require.Equal(t, ResultChanged, decoded.Devices[0].Status)
+}
+
+func TestFetchDeviceStatusesJSON(t *testing.T) {
+	device := &FakeDevice{
+		Name:       "Key Light",
+		DNSAddr:    "192.168.1.2",
+		DeviceInfo: &keylight.DeviceInfo{ProductName: "Elgato Key Light"},
+		DeviceSet:  &keylight.DeviceSettings{PowerOnBrightness: 20},
+		LightGrp: &keylight.LightGroup{Lights: []*keylight.Light{
+			{On: 1, Brightness: 40, Temperature: 200},
+		}},
+	}
+
+	statuses, err := fetchDeviceStatuses(context.Background(), []Device{device}, false)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, writeJSON(&buf, statuses))
+
+	var decoded []map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	require.Len(t, decoded, 1)
+	require.Equal(t, "192.168.1.2", decoded[0]["address"])
+	require.Equal(t, "Key Light", decoded[0]["name"])
+	require.Equal(t, []any{map[string]any{
+		"index":              float64(0),
+		"on":                 true,
+		"brightness":         float64(40),
+		"temperature":        float64(200),
+		"temperature_kelvin": float64(5000),
+	}}, decoded[0]["lights"])
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// forEachDevice runs fn for every device concurrently. Each call gets its own
+// context, which is cancelled as soon as any device fails, since by then the
+// command as a whole has failed. With --continue-on-error, the others carry on
+// instead. fn is given the device's index so that it can store its results in
+// order, keeping output deterministic.
+//
+// Every device's error is returned, joined in the order of devices, apart from
+// those which only failed because another device failing cancelled them.
+// Devices which couldn't be reached are marked as unreachable, and if any
+// device succeeded the whole is marked as a partial failure.
+func forEachDevice(ctx context.Context, devices []Device, fn func(ctx context.Context, i int, device Device) error) error {
+	g, gctx := errgroup.WithContext(ctx)
+	if continueOnError {
+		g, gctx = &errgroup.Group{}, ctx
+	}
+	errs := make([]error, len(devices))
+	succeeded := make([]bool, len(devices))
+
+	for i, device := range devices {
+		i, device := i, device
+
+		g.Go(func() error {
+			deviceCtx, cancel := context.WithCancel(gctx)
+			defer cancel()
+
+			if err := fn(deviceCtx, i, device); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", device.GetDNSAddr(), err)
+				if isUnreachable(err) {
+					errs[i] = unreachable(errs[i])
+				}
+				return errs[i]
+			}
+
+			succeeded[i] = true
+			return nil
+		})
+	}
+
+	if g.Wait() == nil {
+		return nil
+	}
+
+	for i, err := range errs {
+		if errors.Is(err, context.Canceled) && ctx.Err() == nil {
+			errs[i] = nil
+		}
+	}
+
+	err := errors.Join(errs...)
+	if err != nil && slices.Contains(succeeded, true) {
+		return partialFailure(err)
+	}
+
+	return err
+}
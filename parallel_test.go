@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestForEachDevice(t *testing.T) {
+	ctx := context.Background()
+	devices := []Device{
+		&FakeDevice{DNSAddr: "a.local"},
+		&FakeDevice{DNSAddr: "b.local"},
+		&FakeDevice{DNSAddr: "c.local"},
+	}
+
+	t.Run("concurrent", func(t *testing.T) {
+		var running, maxRunning int32
+		addrs := make([]string, len(devices))
+
+		err := forEachDevice(ctx, devices, func(ctx context.Context, i int, device Device) error {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+
+			time.Sleep(20 * time.Millisecond)
+			addrs[i] = device.GetDNSAddr()
+			return nil
+		})
+
+		require.NoError(t, err)
+		require.Equal(t, []string{"a.local", "b.local", "c.local"}, addrs)
+		require.Greater(t, maxRunning, int32(1))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		errBroken := errors.New("broken")
+
+		err := forEachDevice(ctx, devices, func(ctx context.Context, i int, device Device) error {
+			if i == 1 {
+				return errBroken
+			}
+
+			// The others are cancelled by the failure, and that isn't reported
+			<-ctx.Done()
+			return ctx.Err()
+		})
+
+		require.ErrorIs(t, err, errBroken)
+		require.EqualError(t, err, "b.local: broken")
+		require.Equal(t, exitFailure, exitCode(err))
+	})
+
+	t.Run("partial failure", func(t *testing.T) {
+		errBroken := errors.New("broken")
+
+		err := forEachDevice(ctx, devices, func(ctx context.Context, i int, device Device) error {
+			if i == 1 {
+				return errBroken
+			}
+			return nil
+		})
+
+		require.ErrorIs(t, err, errBroken)
+		require.Equal(t, exitPartialFailure, exitCode(err))
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		err := forEachDevice(ctx, devices, func(ctx context.Context, i int, device Device) error {
+			return &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+		})
+
+		require.Equal(t, exitUnreachable, exitCode(err))
+	})
+}
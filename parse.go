@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The parsers here are for values typed by people, whether on the command
+// line, in the config file or sent to the API. Everything goes through them,
+// so nonsense is turned away the same way everywhere. They're fuzzed in
+// parse_test.go.
+
+// maxNumber bounds every number klctl takes. Nothing meaningful comes close,
+// and it keeps arithmetic on the values well clear of overflowing.
+const maxNumber = 1_000_000
+
+// parseIntInRange parses a whole number between lo and hi.
+func parseIntInRange(s string, lo, hi int) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || n < lo || n > hi {
+		return 0, fmt.Errorf("must be a whole number between %d and %d (got %q)", lo, hi, s)
+	}
+
+	return n, nil
+}
+
+// parseFloatInRange parses a number between lo and hi. NaN isn't in any
+// range.
+func parseFloatInRange(s string, lo, hi float64) (float64, error) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || math.IsNaN(f) || f < lo || f > hi {
+		return 0, fmt.Errorf("must be a number between %g and %g (got %q)", lo, hi, s)
+	}
+
+	return f, nil
+}
+
+// parseBrightness parses a brightness percentage, with or without a % sign.
+func parseBrightness(s string) (int, error) {
+	n, err := parseIntInRange(strings.TrimSuffix(strings.TrimSpace(s), "%"), 0, 100)
+	if err != nil {
+		return 0, fmt.Errorf("invalid brightness %q, must be between 0 and 100", s)
+	}
+
+	return n, nil
+}
+
+// parsePositiveNumber parses a number greater than zero, such as a
+// temperature before it's converted to mireds.
+func parsePositiveNumber(s string) (float64, error) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || math.IsNaN(f) || f <= 0 || f > maxNumber {
+		return 0, fmt.Errorf("must be a number between 0 and %d (got %q)", maxNumber, s)
+	}
+
+	return f, nil
+}
+
+// parseLightTemperature parses a temperature, as parseTemperature does, and
+// checks the lights support it.
+func parseLightTemperature(s string, unit TemperatureUnit) (int, error) {
+	mired, err := parseTemperature(s, unit)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := validateTemperature(mired); err != nil {
+		return 0, err
+	}
+
+	return mired, nil
+}
+
+// parseChange parses a relative change, such as +15 or -20. The sign is
+// required, so a change can't be mistaken for a value.
+func parseChange(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if !isRelativeValue(s) {
+		return 0, fmt.Errorf("a change must start with + or - (got %q)", s)
+	}
+
+	return parseIntInRange(s, -maxNumber, maxNumber)
+}
+
+// parsePort parses a TCP port.
+func parsePort(s string) (int, error) {
+	p, err := parseIntInRange(s, 1, 65535)
+	if err != nil {
+		return 0, fmt.Errorf("port must be a number between 1 and 65535 (got %s)", s)
+	}
+
+	return p, nil
+}
+
+// parseHostPort splits an address into its host and port. The port is
+// optional, and defaults to defaultPort.
+func parseHostPort(addr, defaultPort string) (string, int, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+		port = defaultPort
+	}
+
+	if !validHost(host) {
+		return "", 0, fmt.Errorf("invalid host in address %q", addr)
+	}
+
+	p, err := parsePort(port)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return host, p, nil
+}
+
+// hostnameChars are the characters hostnames are made of. Underscores aren't
+// strictly allowed, but turn up in mDNS names.
+var hostnameChars = regexp.MustCompile(`^[A-Za-z0-9_-]+(\.[A-Za-z0-9_-]+)*\.?$`)
+
+// validHost reports whether host is an IP address or a hostname.
+func validHost(host string) bool {
+	ip, _, _ := strings.Cut(host, "%")
+	return net.ParseIP(ip) != nil || hostnameChars.MatchString(host)
+}
+
+// parseFraction parses a number from 0 to 1, such as a probability.
+func parseFraction(s string) (float64, error) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || math.IsNaN(f) || f < 0 || f > 1 {
+		return 0, fmt.Errorf("must be between 0 and 1 (got %q)", s)
+	}
+
+	return f, nil
+}
+
+// parseNonNegativeDuration parses a duration such as 500ms, which can't be
+// negative.
+func parseNonNegativeDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("duration can't be negative (got %s)", s)
+	}
+
+	return d, nil
+}
+
+// parseLightIndex parses the index of one of a device's lights.
+func parseLightIndex(s string) (int, error) {
+	i, err := parseIntInRange(s, 0, maxNumber)
+	if err != nil {
+		return 0, fmt.Errorf("invalid light index %q", s)
+	}
+
+	return i, nil
+}
+
+// selectsLight reports whether a selector given by the user, such as a --light
+// value or an API path, picks out the light with this name, address and port.
+// A light can be selected by its name, its address, with or without the
+// trailing dot of a fully qualified name, or its address and port.
+func selectsLight(selector, name, address string, port int) bool {
+	if selector == "" {
+		return false
+	}
+
+	address = strings.TrimSuffix(address, ".")
+	return selector == name ||
+		strings.TrimSuffix(selector, ".") == address ||
+		(port != 0 && selector == net.JoinHostPort(address, strconv.Itoa(port)))
+}
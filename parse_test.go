@@ -0,0 +1,173 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBrightness(t *testing.T) {
+	for in, want := range map[string]int{"0": 0, "40": 40, " 75 ": 75, "100%": 100} {
+		got, err := parseBrightness(in)
+		require.NoError(t, err, in)
+		require.Equal(t, want, got, in)
+	}
+
+	for _, in := range []string{"", "-5", "101", "4O", "50.5", "0x10", "%"} {
+		_, err := parseBrightness(in)
+		require.Error(t, err, in)
+	}
+}
+
+func TestParseLightTemperature(t *testing.T) {
+	mired, err := parseLightTemperature("5000K", "")
+	require.NoError(t, err)
+	require.Equal(t, 200, mired)
+
+	for _, in := range []string{"-5000K", "0", "NaN", "Inf", "1e300", "100000K", "warm"} {
+		_, err := parseLightTemperature(in, UnitKelvin)
+		require.Error(t, err, in)
+	}
+}
+
+func TestParseChange(t *testing.T) {
+	change, err := parseChange("+15")
+	require.NoError(t, err)
+	require.Equal(t, 15, change)
+
+	change, err = parseChange("-20")
+	require.NoError(t, err)
+	require.Equal(t, -20, change)
+
+	for _, in := range []string{"15", "+", "+-3", "+99999999999999999999"} {
+		_, err := parseChange(in)
+		require.Error(t, err, in)
+	}
+}
+
+func TestParseHostPort(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		host string
+		port int
+	}{
+		{"192.168.1.2", "192.168.1.2", 9123},
+		{"192.168.1.2:9124", "192.168.1.2", 9124},
+		{"[fe80::1]:80", "fe80::1", 80},
+		{"key.local.", "key.local.", 9123},
+	} {
+		host, port, err := parseHostPort(test.in, defaultPort)
+		require.NoError(t, err, test.in)
+		require.Equal(t, test.host, host, test.in)
+		require.Equal(t, test.port, port, test.in)
+	}
+
+	for _, in := range []string{"", ":9123", "192.168.1.2:0", "192.168.1.2:http", "192.168.1.2:70000", "a:b:c", "]0", "key light"} {
+		_, _, err := parseHostPort(in, defaultPort)
+		require.Error(t, err, in)
+	}
+}
+
+func TestParseFractionAndDuration(t *testing.T) {
+	for _, in := range []string{"NaN", "-0.1", "1.5"} {
+		_, err := parseFraction(in)
+		require.Error(t, err, in)
+	}
+
+	d, err := parseNonNegativeDuration("500ms")
+	require.NoError(t, err)
+	require.Equal(t, 500*time.Millisecond, d)
+
+	_, err = parseNonNegativeDuration("-1s")
+	require.Error(t, err)
+}
+
+func TestSelectsLight(t *testing.T) {
+	require.True(t, selectsLight("desk", "desk", "192.168.1.2", 9123))
+	require.True(t, selectsLight("key.local", "", "key.local.", 9123))
+	require.True(t, selectsLight("key.local.", "", "key.local", 9123))
+	require.True(t, selectsLight("192.168.1.2:9123", "", "192.168.1.2", 9123))
+	require.False(t, selectsLight("192.168.1.2:9124", "", "192.168.1.2", 9123))
+	require.False(t, selectsLight("", "", "192.168.1.2", 9123))
+}
+
+func FuzzParseBrightness(f *testing.F) {
+	for _, seed := range []string{"0", "50", "100%", "-1", "101", " 7 ", "1e2"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		b, err := parseBrightness(s)
+		if err != nil {
+			return
+		}
+
+		require.GreaterOrEqual(t, b, 0)
+		require.LessOrEqual(t, b, 100)
+	})
+}
+
+func FuzzParseLightTemperature(f *testing.F) {
+	for _, seed := range []string{"5000K", "200", "200 mired", "-5000k", "NaN", "Inf", "1e308", "0.0001K"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		for _, unit := range []TemperatureUnit{"", UnitKelvin, UnitMired} {
+			mired, err := parseLightTemperature(s, unit)
+			if err != nil {
+				continue
+			}
+
+			require.GreaterOrEqual(t, mired, minTemperature)
+			require.LessOrEqual(t, mired, maxTemperature)
+		}
+	})
+}
+
+func FuzzParseChange(f *testing.F) {
+	for _, seed := range []string{"+15", "-20", "15", "+500K", "-9223372036854775808"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		change, err := parseChange(s)
+		if err != nil {
+			return
+		}
+
+		require.LessOrEqual(t, change, maxNumber)
+		require.GreaterOrEqual(t, change, -maxNumber)
+
+		// Changes to temperatures never leave a light without one
+		if apply, err := parseTemperatureChange(s+"K", UnitKelvin); err == nil {
+			require.Positive(t, apply(200))
+		}
+	})
+}
+
+func FuzzParseHostPort(f *testing.F) {
+	for _, seed := range []string{"192.168.1.2", "192.168.1.2:9124", "[::1]:80", ":80", "host:", "a:b:c"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		host, port, err := parseHostPort(s, defaultPort)
+		if err != nil {
+			return
+		}
+
+		require.NotEmpty(t, host)
+		require.GreaterOrEqual(t, port, 1)
+		require.LessOrEqual(t, port, 65535)
+
+		// What's parsed can be put back together and parsed the same
+		host2, port2, err := parseHostPort(net.JoinHostPort(host, strconv.Itoa(port)), defaultPort)
+		require.NoError(t, err)
+		require.Equal(t, host, host2)
+		require.Equal(t, port, port2)
+	})
+}
@@ -0,0 +1,156 @@
+package keylightctl
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/endocrimes/keylight-go"
+	"golang.org/x/sync/errgroup"
+)
+
+// Client controls a set of lights. Devices are talked to concurrently.
+type Client struct {
+	Devices []Device
+}
+
+// NewClient returns a client for the devices.
+func NewClient(devices ...Device) *Client {
+	return &Client{Devices: devices}
+}
+
+// DiscoverClient returns a client for the lights found on the network with
+// mDNS. ctx bounds how long discovery can take.
+func DiscoverClient(ctx context.Context) (*Client, error) {
+	discovery, err := keylight.NewDiscovery()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start discovery: %w", err)
+	}
+
+	devices, err := Discover(ctx, SystemClock{}, &DiscoveryWrapper{discovery})
+	if err != nil {
+		return nil, err
+	}
+
+	return NewClient(devices...), nil
+}
+
+// Light is the state of one light. Devices such as the Key Light Air have one
+// light, but others can have more.
+type Light struct {
+	Device Device
+
+	// Index is the light's position in its device's light group.
+	Index int
+
+	On         bool
+	Brightness int
+	// Temperature is in mireds, as the lights use. Kelvin is a million
+	// divided by it.
+	Temperature int
+}
+
+// Lights returns the state of every light, in the order of the devices.
+func (c *Client) Lights(ctx context.Context) ([]Light, error) {
+	groups := make([]*keylight.LightGroup, len(c.Devices))
+
+	err := c.forEachDevice(ctx, func(ctx context.Context, i int, device Device) error {
+		lg, err := device.FetchLightGroup(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to fetch light group for %s: %w", device.GetDNSAddr(), err)
+		}
+
+		groups[i] = lg
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var lights []Light
+	for i, lg := range groups {
+		for index, light := range lg.Lights {
+			lights = append(lights, Light{
+				Device:      c.Devices[i],
+				Index:       index,
+				On:          light.On == 1,
+				Brightness:  light.Brightness,
+				Temperature: light.Temperature,
+			})
+		}
+	}
+
+	return lights, nil
+}
+
+// Set puts every light into the state given by settings. Devices which are
+// already in that state aren't sent anything.
+func (c *Client) Set(ctx context.Context, settings Settings) error {
+	return c.forEachDevice(ctx, func(ctx context.Context, _ int, device Device) error {
+		return update(ctx, device, func(int) Settings { return settings })
+	})
+}
+
+// CaptureScene records the current state of the lights as a scene.
+func (c *Client) CaptureScene(ctx context.Context, name string) (*Scene, error) {
+	return CaptureScene(ctx, name, c.Devices)
+}
+
+// ApplyScene puts each light back into its state in the scene. Devices are
+// matched by serial number; those which aren't in the scene are left alone.
+func (c *Client) ApplyScene(ctx context.Context, scene *Scene) error {
+	return c.forEachDevice(ctx, func(ctx context.Context, _ int, device Device) error {
+		info, err := device.FetchDeviceInfo(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to fetch device info for %s: %w", device.GetDNSAddr(), err)
+		}
+
+		sd, ok := scene.Device(info.SerialNumber)
+		if !ok {
+			return nil
+		}
+
+		return update(ctx, device, func(i int) Settings {
+			if i >= len(sd.Lights) {
+				return Settings{}
+			}
+			return SettingsOf(sd.Lights[i])
+		})
+	})
+}
+
+// update applies the settings for each of the device's lights, sending them
+// to it if anything changed.
+func update(ctx context.Context, device Device, settingsFor func(i int) Settings) error {
+	lg, err := device.FetchLightGroup(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch light group for %s: %w", device.GetDNSAddr(), err)
+	}
+
+	changed := false
+	for i, light := range lg.Lights {
+		if len(settingsFor(i).Apply(i, light)) > 0 {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	if _, err := device.UpdateLightGroup(ctx, lg); err != nil {
+		return fmt.Errorf("failed to update %s: %w", device.GetDNSAddr(), err)
+	}
+
+	return nil
+}
+
+// forEachDevice calls fn for each device concurrently, cancelling the rest
+// after the first error, which it returns.
+func (c *Client) forEachDevice(ctx context.Context, fn func(ctx context.Context, i int, device Device) error) error {
+	g, ctx := errgroup.WithContext(ctx)
+	for i, device := range c.Devices {
+		i, device := i, device
+		g.Go(func() error { return fn(ctx, i, device) })
+	}
+
+	return g.Wait()
+}
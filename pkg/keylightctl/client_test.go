@@ -0,0 +1,136 @@
+package keylightctl
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDevice is a Device which keeps its lights in memory.
+type fakeDevice struct {
+	mu      sync.Mutex
+	serial  string
+	lights  []keylight.Light
+	updates int
+	err     error
+}
+
+func newFakeDevice(serial string, lights ...keylight.Light) *fakeDevice {
+	return &fakeDevice{serial: serial, lights: lights}
+}
+
+func (f *fakeDevice) GetName() string    { return f.serial }
+func (f *fakeDevice) GetDNSAddr() string { return f.serial + ".local" }
+func (f *fakeDevice) GetPort() int       { return 9123 }
+
+func (f *fakeDevice) FetchDeviceInfo(ctx context.Context) (*keylight.DeviceInfo, error) {
+	return &keylight.DeviceInfo{SerialNumber: f.serial}, nil
+}
+
+func (f *fakeDevice) FetchSettings(ctx context.Context) (*keylight.DeviceSettings, error) {
+	return &keylight.DeviceSettings{}, nil
+}
+
+func (f *fakeDevice) UpdateSettings(ctx context.Context, settings *keylight.DeviceSettings) (*keylight.DeviceSettings, error) {
+	return settings, nil
+}
+
+func (f *fakeDevice) FetchLightGroup(ctx context.Context) (*keylight.LightGroup, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	lg := &keylight.LightGroup{}
+	for _, light := range f.lights {
+		light := light
+		lg.Lights = append(lg.Lights, &light)
+	}
+	return lg, nil
+}
+
+func (f *fakeDevice) UpdateLightGroup(ctx context.Context, lg *keylight.LightGroup) (*keylight.LightGroup, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.updates++
+	for i, light := range lg.Lights {
+		f.lights[i] = *light
+	}
+	return lg, nil
+}
+
+func (f *fakeDevice) FetchWifiInfo(ctx context.Context) (*WifiInfo, error) {
+	return nil, nil
+}
+
+func (f *fakeDevice) FetchColors(ctx context.Context) (*ColorGroup, error) {
+	return nil, errors.New("no colour")
+}
+
+func (f *fakeDevice) UpdateColors(ctx context.Context, cg *ColorGroup) (*ColorGroup, error) {
+	return nil, errors.New("no colour")
+}
+
+func (f *fakeDevice) RawRequest(ctx context.Context, method, path string, body []byte) (*RawResponse, error) {
+	return nil, errors.New("no raw requests")
+}
+
+func TestClientLights(t *testing.T) {
+	a := newFakeDevice("A", keylight.Light{On: 1, Brightness: 20, Temperature: 200})
+	b := newFakeDevice("B", keylight.Light{Brightness: 50, Temperature: 300}, keylight.Light{On: 1, Brightness: 60, Temperature: 150})
+
+	lights, err := NewClient(a, b).Lights(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []Light{
+		{Device: a, Index: 0, On: true, Brightness: 20, Temperature: 200},
+		{Device: b, Index: 0, On: false, Brightness: 50, Temperature: 300},
+		{Device: b, Index: 1, On: true, Brightness: 60, Temperature: 150},
+	}, lights)
+
+	b.err = errors.New("unplugged")
+	_, err = NewClient(a, b).Lights(context.Background())
+	require.ErrorContains(t, err, "unplugged")
+}
+
+func TestClientSet(t *testing.T) {
+	a := newFakeDevice("A", keylight.Light{On: 1, Brightness: 20, Temperature: 200})
+	b := newFakeDevice("B", keylight.Light{On: 1, Brightness: 40, Temperature: 200})
+
+	brightness := 40
+	require.NoError(t, NewClient(a, b).Set(context.Background(), Settings{Brightness: &brightness}))
+
+	require.Equal(t, keylight.Light{On: 1, Brightness: 40, Temperature: 200}, a.lights[0])
+	require.Equal(t, 1, a.updates)
+	// Already there, so left alone
+	require.Equal(t, 0, b.updates)
+}
+
+func TestClientScenes(t *testing.T) {
+	a := newFakeDevice("A", keylight.Light{On: 1, Brightness: 20, Temperature: 200})
+	b := newFakeDevice("B", keylight.Light{On: 0, Brightness: 40, Temperature: 300})
+	client := NewClient(a, b)
+
+	scene, err := client.CaptureScene(context.Background(), "desk")
+	require.NoError(t, err)
+	require.Equal(t, "desk", scene.Name)
+	require.Len(t, scene.Devices, 2)
+
+	on, off := 1, 0
+	require.NoError(t, client.Set(context.Background(), Settings{On: &off}))
+	require.NoError(t, NewClient(b).Set(context.Background(), Settings{On: &on}))
+
+	// Devices which aren't in the scene are left alone
+	c := newFakeDevice("C", keylight.Light{On: 1, Brightness: 10, Temperature: 250})
+	require.NoError(t, NewClient(a, b, c).ApplyScene(context.Background(), scene))
+
+	require.Equal(t, keylight.Light{On: 1, Brightness: 20, Temperature: 200}, a.lights[0])
+	require.Equal(t, keylight.Light{On: 0, Brightness: 40, Temperature: 300}, b.lights[0])
+	require.Equal(t, 0, c.updates)
+}
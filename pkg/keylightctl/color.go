@@ -0,0 +1,52 @@
+package keylightctl
+
+import (
+	"strings"
+
+	"github.com/endocrimes/keylight-go"
+)
+
+// The fields of a colour light which can be changed, as named in a Change.
+const (
+	FieldHue        = "hue"
+	FieldSaturation = "saturation"
+)
+
+// The range of hues, in degrees, and saturations, in percent.
+const (
+	MaxHue        = 360
+	MaxSaturation = 100
+)
+
+// Color is the colour of a light which can show one, such as a Light Strip.
+// keylight.Light only has white light, so colour is read and written
+// separately.
+type Color struct {
+	Hue        float64 `json:"hue"`
+	Saturation float64 `json:"saturation"`
+}
+
+// ColorGroup is the colour of each of a device's lights, in the same shape as
+// keylight.LightGroup so it can be sent to the same endpoint.
+type ColorGroup struct {
+	Count  int      `json:"numberOfLights"`
+	Lights []*Color `json:"lights"`
+}
+
+// Copy returns a deep copy of the group.
+func (cg *ColorGroup) Copy() *ColorGroup {
+	c := &ColorGroup{Count: cg.Count, Lights: make([]*Color, 0, len(cg.Lights))}
+	for _, color := range cg.Lights {
+		color := *color
+		c.Lights = append(c.Lights, &color)
+	}
+
+	return c
+}
+
+// SupportsColor reports whether a device can show colours, rather than only
+// shades of white. Elgato don't list it among a device's features, so it's
+// known by the product.
+func SupportsColor(info *keylight.DeviceInfo) bool {
+	return info != nil && strings.Contains(info.ProductName, "Light Strip")
+}
@@ -0,0 +1,180 @@
+package keylightctl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/endocrimes/keylight-go"
+)
+
+// Device is a Key Light, or anything which acts like one.
+type Device interface {
+	GetName() string
+	GetDNSAddr() string
+	GetPort() int
+	FetchDeviceInfo(ctx context.Context) (*keylight.DeviceInfo, error)
+	FetchSettings(ctx context.Context) (*keylight.DeviceSettings, error)
+	UpdateSettings(ctx context.Context, settings *keylight.DeviceSettings) (*keylight.DeviceSettings, error)
+	FetchLightGroup(ctx context.Context) (*keylight.LightGroup, error)
+	UpdateLightGroup(ctx context.Context, lg *keylight.LightGroup) (*keylight.LightGroup, error)
+
+	// FetchWifiInfo fetches the device's Wi-Fi connection. It's nil, without
+	// an error, for firmware which doesn't report it.
+	FetchWifiInfo(ctx context.Context) (*WifiInfo, error)
+
+	// FetchColors and UpdateColors read and set the colour of each light,
+	// for devices which have colour. See SupportsColor.
+	FetchColors(ctx context.Context) (*ColorGroup, error)
+	UpdateColors(ctx context.Context, cg *ColorGroup) (*ColorGroup, error)
+
+	// RawRequest sends a request to any path of the device's HTTP API, such
+	// as elgato/lights, with body sent as it is. Responses which aren't 200
+	// OK aren't errors, so they can be looked at.
+	RawRequest(ctx context.Context, method, path string, body []byte) (*RawResponse, error)
+}
+
+// RawResponse is a response from a device's HTTP API, as it was sent.
+type RawResponse struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	Body       []byte
+}
+
+// ReadRawResponse reads resp into a RawResponse, and closes its body.
+func ReadRawResponse(resp *http.Response) (*RawResponse, error) {
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RawResponse{StatusCode: resp.StatusCode, Status: resp.Status, Header: resp.Header, Body: body}, nil
+}
+
+// NewRawRequest makes a request to path on the HTTP API at base, as
+// RawRequest sends.
+func NewRawRequest(ctx context.Context, base, method, path string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, base+"/"+strings.TrimPrefix(path, "/"), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > 0 {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return req, nil
+}
+
+// WifiInfo is the Wi-Fi connection reported in a device's accessory info.
+// keylight.DeviceInfo doesn't include it.
+type WifiInfo struct {
+	SSID         string `json:"ssid"`
+	FrequencyMHz int    `json:"frequencyMHz"`
+	RSSI         int    `json:"rssi"`
+}
+
+// KeylightDevice is a wrapper around keylight.Device that implements the
+// interface above. This allows us to use the upstream keylight.Device directly,
+// but also to mock it out in tests, including property accessors.
+type KeylightDevice struct {
+	*keylight.Device
+}
+
+// NewDevice returns the light at an address, such as one which discovery
+// wouldn't find.
+func NewDevice(name, host string, port int) KeylightDevice {
+	return KeylightDevice{&keylight.Device{Name: name, DNSAddr: host, Port: port}}
+}
+
+func (device KeylightDevice) GetName() string {
+	return device.Name
+}
+
+func (device KeylightDevice) GetDNSAddr() string {
+	return device.DNSAddr
+}
+
+func (device KeylightDevice) GetPort() int {
+	return device.Port
+}
+
+// do makes a request which keylight.Device can't.
+func (device KeylightDevice) do(ctx context.Context, method, path string, body, target any) error {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	url := "http://" + net.JoinHostPort(device.DNSAddr, strconv.Itoa(device.Port)) + "/" + path
+	req, err := http.NewRequestWithContext(ctx, method, url, &reqBody)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s", method, url, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+func (device KeylightDevice) UpdateSettings(ctx context.Context, settings *keylight.DeviceSettings) (*keylight.DeviceSettings, error) {
+	updated := &keylight.DeviceSettings{}
+	err := device.do(ctx, http.MethodPut, "elgato/lights/settings", settings, updated)
+	return updated, err
+}
+
+func (device KeylightDevice) FetchWifiInfo(ctx context.Context) (*WifiInfo, error) {
+	var info struct {
+		Wifi *WifiInfo `json:"wifi-info"`
+	}
+	err := device.do(ctx, http.MethodGet, "elgato/accessory-info", nil, &info)
+	return info.Wifi, err
+}
+
+func (device KeylightDevice) FetchColors(ctx context.Context) (*ColorGroup, error) {
+	cg := &ColorGroup{Lights: []*Color{}}
+	err := device.do(ctx, http.MethodGet, "elgato/lights", nil, cg)
+	return cg, err
+}
+
+func (device KeylightDevice) UpdateColors(ctx context.Context, cg *ColorGroup) (*ColorGroup, error) {
+	updated := &ColorGroup{Lights: []*Color{}}
+	err := device.do(ctx, http.MethodPut, "elgato/lights", cg, updated)
+	return updated, err
+}
+
+// Make sure the upstream keylight.Device implements this interface.
+func (device KeylightDevice) RawRequest(ctx context.Context, method, path string, body []byte) (*RawResponse, error) {
+	base := "http://" + net.JoinHostPort(device.DNSAddr, strconv.Itoa(device.Port))
+	req, err := NewRawRequest(ctx, base, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return ReadRawResponse(resp)
+}
+
+var _ Device = &KeylightDevice{}
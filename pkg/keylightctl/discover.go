@@ -0,0 +1,92 @@
+package keylightctl
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+)
+
+// Discovery finds devices on the network.
+type Discovery interface {
+	Run(ctx context.Context) error
+	ResultsCh() <-chan Device
+}
+
+// DiscoveryWrapper is a Discovery which finds Key Lights with keylight-go's
+// mDNS discovery.
+type DiscoveryWrapper struct {
+	Discovery keylight.Discovery
+}
+
+func (w *DiscoveryWrapper) Run(ctx context.Context) error {
+	return w.Discovery.Run(ctx)
+}
+
+func (w *DiscoveryWrapper) ResultsCh() <-chan Device {
+	outCh := make(chan Device)
+
+	go func() {
+		for device := range w.Discovery.ResultsCh() {
+			outCh <- KeylightDevice{device}
+		}
+		close(outCh)
+	}()
+
+	return outCh
+}
+
+// DiscoveryTimeoutError is returned when ctx times out before discovery has
+// finished.
+type DiscoveryTimeoutError struct{}
+
+func (te *DiscoveryTimeoutError) Error() string {
+	return "timed out while discovering devices"
+}
+
+// ExitCode is the status a command should exit with after the timeout: 2,
+// as no devices were found.
+func (te *DiscoveryTimeoutError) ExitCode() int {
+	return 2
+}
+
+// DiscoveryQuietPeriod is how long discovery carries on after the last device
+// was found.
+const DiscoveryQuietPeriod = time.Second
+
+// Discover returns the devices found by discoverer, once it has found no more
+// for DiscoveryQuietPeriod.
+func Discover(ctx context.Context, clock Clock, discoverer Discovery) ([]Device, error) {
+	// make sure the discovery is stopped when we return from this function
+	subCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(fmt.Errorf("finished discovering devices"))
+
+	var devices []Device
+	errCh := make(chan error)
+	go func() {
+		errCh <- discoverer.Run(subCtx)
+	}()
+
+	// keep trying until it's been a second since the last device was found or
+	// we hit the global timeout, then return
+	discoveryTimeout := clock.NewTimer(DiscoveryQuietPeriod)
+	defer discoveryTimeout.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, &DiscoveryTimeoutError{}
+			}
+			return nil, ctx.Err()
+		case device := <-discoverer.ResultsCh():
+			devices = append(devices, device)
+			discoveryTimeout.Reset(DiscoveryQuietPeriod)
+		case <-discoveryTimeout.C():
+			return devices, nil
+		case err := <-errCh:
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,18 @@
+// Package keylightctl is the part of klctl which other Go programs can use to
+// control Elgato Key Lights. It builds on keylight-go with discovery which
+// knows when to stop, changes which only touch what they need to, and scenes.
+//
+// A Client controls a set of lights, found on the network or given by
+// address:
+//
+//	client, err := keylightctl.DiscoverClient(ctx)
+//	if err != nil {
+//		return err
+//	}
+//
+//	brightness := 40
+//	err = client.Set(ctx, keylightctl.Settings{Brightness: &brightness})
+//
+// Anything which implements Device can be controlled, so lights can be
+// wrapped, for example to add retries, or faked in tests.
+package keylightctl
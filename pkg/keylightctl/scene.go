@@ -0,0 +1,72 @@
+package keylightctl
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+)
+
+// Scene is a saved snapshot of the state of some lights, which can be applied
+// again later.
+type Scene struct {
+	Name    string        `json:"name"`
+	SavedAt time.Time     `json:"saved_at"`
+	Devices []SceneDevice `json:"devices"`
+}
+
+// SceneDevice is the saved state of one device. Devices are matched by serial
+// number when a scene is applied, so scenes survive lights changing address.
+type SceneDevice struct {
+	Serial  string           `json:"serial"`
+	Name    string           `json:"name,omitempty"`
+	Address string           `json:"address"`
+	Lights  []keylight.Light `json:"lights"`
+}
+
+// Device returns the saved state of the device with a serial number.
+func (s *Scene) Device(serial string) (SceneDevice, bool) {
+	for _, sd := range s.Devices {
+		if sd.Serial == serial {
+			return sd, true
+		}
+	}
+
+	return SceneDevice{}, false
+}
+
+// CaptureScene records the current state of the devices as a scene.
+func CaptureScene(ctx context.Context, name string, devices []Device) (*Scene, error) {
+	scene := &Scene{
+		Name:    name,
+		SavedAt: time.Now().UTC(),
+		Devices: []SceneDevice{},
+	}
+
+	for _, device := range devices {
+		info, err := device.FetchDeviceInfo(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch device info for %s: %w", device.GetDNSAddr(), err)
+		}
+
+		lightGroup, err := device.FetchLightGroup(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch light group for %s: %w", device.GetDNSAddr(), err)
+		}
+
+		sd := SceneDevice{
+			Serial:  info.SerialNumber,
+			Name:    device.GetName(),
+			Address: device.GetDNSAddr(),
+			Lights:  make([]keylight.Light, len(lightGroup.Lights)),
+		}
+		for i, light := range lightGroup.Lights {
+			sd.Lights[i] = *light
+		}
+
+		scene.Devices = append(scene.Devices, sd)
+	}
+
+	return scene, nil
+}
@@ -0,0 +1,62 @@
+package keylightctl
+
+import "github.com/endocrimes/keylight-go"
+
+// The fields of a light which can be changed, as named in a Change.
+const (
+	FieldOn          = "on"
+	FieldBrightness  = "brightness"
+	FieldTemperature = "temperature"
+)
+
+// Settings is a state to put lights into. Fields which are nil are left as
+// they are.
+type Settings struct {
+	On          *int
+	Brightness  *int
+	Temperature *int
+}
+
+// Change is one field of a light being changed.
+type Change struct {
+	Light int    `json:"light"`
+	Field string `json:"field"`
+	Old   int    `json:"old"`
+	New   int    `json:"new"`
+}
+
+// SettingsOf returns settings which put a light into the same state as light.
+func SettingsOf(light keylight.Light) Settings {
+	return Settings{On: &light.On, Brightness: &light.Brightness, Temperature: &light.Temperature}
+}
+
+// Apply sets the fields of light, the index'th of its device, to the settings.
+// It returns what changed, which is nothing if the light was already in that
+// state.
+func (s Settings) Apply(index int, light *keylight.Light) []Change {
+	var changes []Change
+
+	for _, f := range []struct {
+		name    string
+		value   *int
+		current *int
+	}{
+		{FieldOn, s.On, &light.On},
+		{FieldBrightness, s.Brightness, &light.Brightness},
+		{FieldTemperature, s.Temperature, &light.Temperature},
+	} {
+		if f.value == nil || *f.current == *f.value {
+			continue
+		}
+
+		changes = append(changes, Change{Light: index, Field: f.name, Old: *f.current, New: *f.value})
+		*f.current = *f.value
+	}
+
+	return changes
+}
+
+// IsZero reports whether the settings leave everything as it is.
+func (s Settings) IsZero() bool {
+	return s.On == nil && s.Brightness == nil && s.Temperature == nil
+}
@@ -0,0 +1,31 @@
+package keylightctl
+
+import (
+	"testing"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSettingsApply(t *testing.T) {
+	light := &keylight.Light{On: 0, Brightness: 20, Temperature: 200}
+
+	on, brightness, temperature := 1, 20, 250
+	changes := Settings{On: &on, Brightness: &brightness, Temperature: &temperature}.Apply(2, light)
+	require.Equal(t, []Change{
+		{Light: 2, Field: FieldOn, Old: 0, New: 1},
+		{Light: 2, Field: FieldTemperature, Old: 200, New: 250},
+	}, changes)
+	require.Equal(t, &keylight.Light{On: 1, Brightness: 20, Temperature: 250}, light)
+
+	require.Empty(t, Settings{}.Apply(0, light))
+	require.True(t, Settings{}.IsZero())
+}
+
+func TestSettingsOf(t *testing.T) {
+	saved := keylight.Light{On: 1, Brightness: 35, Temperature: 180}
+	light := &keylight.Light{}
+
+	SettingsOf(saved).Apply(0, light)
+	require.Equal(t, saved, *light)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// cameraPresets maps the white balance presets of common cameras to their
+// colour temperature in Kelvin, so lights can be matched to a camera without
+// looking the number up.
+var cameraPresets = map[string]int{
+	"tungsten":        3200,
+	"fluorescent":     4000,
+	"daylight":        5600,
+	"cloudy":          6500,
+	"shade":           7000,
+	"blackmagic-3200": 3200,
+	"blackmagic-5600": 5600,
+	"canon-3200":      3200,
+	"canon-5200":      5200,
+	"lumix-3200":      3200,
+	"lumix-5500":      5500,
+	"sony-3200":       3200,
+	"sony-5600":       5600,
+}
+
+func cameraPresetNames() []string {
+	names := make([]string, 0, len(cameraPresets))
+	for name := range cameraPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// matchCameraPreset returns the temperature, in the units the light uses,
+// which comes closest to the named preset. Presets outside the range the
+// lights support are clamped to the nearest end.
+func matchCameraPreset(name string) (int, error) {
+	kelvin, ok := cameraPresets[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown camera preset %s (known presets: %s)", name, strings.Join(cameraPresetNames(), ", "))
+	}
+
+	mired := max(minTemperature, min(maxTemperature, kelvinToMired(kelvin)))
+	achieved := miredToKelvin(mired)
+
+	slog.Info("Matched camera preset",
+		"preset", name,
+		"target", fmt.Sprintf("%dK", kelvin),
+		"achieved", fmt.Sprintf("%dK", achieved),
+		"delta", fmt.Sprintf("%+dK", achieved-kelvin))
+
+	return mired, nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchCameraPreset(t *testing.T) {
+	mired, err := matchCameraPreset("sony-5600")
+	require.NoError(t, err)
+	require.Equal(t, 179, mired)
+
+	// Case doesn't matter
+	_, err = matchCameraPreset("Sony-5600")
+	require.NoError(t, err)
+
+	mired, err = matchCameraPreset("tungsten")
+	require.NoError(t, err)
+	require.Equal(t, 313, mired)
+
+	_, err = matchCameraPreset("nikon-9000")
+	require.ErrorContains(t, err, "sony-5600")
+}
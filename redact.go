@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// Number of leading characters of a serial number which are kept when it's
+// redacted. They identify the product line; the rest identifies the unit.
+const serialPrefixLength = 4
+
+func redactSerial(serial string) string {
+	if len(serial) <= serialPrefixLength {
+		return strings.Repeat("*", len(serial))
+	}
+
+	return serial[:serialPrefixLength] + strings.Repeat("*", len(serial)-serialPrefixLength)
+}
+
+// redactHost replaces a hostname or IP address with a token derived from it,
+// so the same host can still be recognised throughout a log or report without
+// saying what it is.
+func redactHost(host string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSuffix(host, "."))))
+	return "host-" + hex.EncodeToString(sum[:3])
+}
+
+// redactAddress redacts the host in an address, keeping any port.
+func redactAddress(addr string) string {
+	if addr == "" {
+		return ""
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return redactHost(addr)
+	}
+
+	return net.JoinHostPort(redactHost(host), port)
+}
+
+// Candidates for addresses in free text: anything that might be an IP
+// address, which is checked properly before being redacted, and mDNS names.
+var (
+	ipCandidate   = regexp.MustCompile(`[0-9A-Fa-f]*:[0-9A-Fa-f:.]*[0-9A-Fa-f]|\d{1,3}(?:\.\d{1,3}){3}`)
+	mdnsHostnames = regexp.MustCompile(`(?i)[a-z0-9-]+(?:\.[a-z0-9-]+)*\.local\b\.?`)
+)
+
+// redactText redacts the IP addresses and .local hostnames in s, such as in
+// an error message.
+func redactText(s string) string {
+	s = ipCandidate.ReplaceAllStringFunc(s, func(candidate string) string {
+		if net.ParseIP(candidate) == nil {
+			return candidate
+		}
+
+		return redactHost(candidate)
+	})
+
+	return mdnsHostnames.ReplaceAllStringFunc(s, redactHost)
+}
+
+// redactedKeys are log attributes holding addresses, serial numbers or network
+// names, and how to redact them.
+var redactedKeys = map[string]func(string) string{
+	"address": redactAddress,
+	"remote":  redactAddress,
+	"local":   redactAddress,
+	"host":    redactAddress,
+	"serial":  redactSerial,
+	"ssid":    func(string) string { return "<redacted>" },
+}
+
+// redactAttr is a slog ReplaceAttr function which redacts log attributes.
+// Attributes known to hold something identifying are redacted outright, and
+// any other text, including errors, has addresses taken out of it.
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	value := a.Value.Resolve()
+
+	if redact, ok := redactedKeys[strings.ToLower(a.Key)]; ok && value.Kind() == slog.KindString {
+		return slog.String(a.Key, redact(value.String()))
+	}
+
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, redactText(value.String()))
+	case slog.KindAny:
+		if err, ok := value.Any().(error); ok {
+			return slog.String(a.Key, redactText(err.Error()))
+		}
+	}
+
+	return a
+}
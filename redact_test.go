@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactSerial(t *testing.T) {
+	require.Equal(t, "CW12********", redactSerial("CW12AB345678"))
+	require.Equal(t, "***", redactSerial("CW1"))
+	require.Equal(t, "", redactSerial(""))
+}
+
+func TestRedactAddress(t *testing.T) {
+	token := redactHost("192.168.1.2")
+	require.Regexp(t, `^host-[0-9a-f]{6}$`, token)
+	require.Equal(t, token+":9123", redactAddress("192.168.1.2:9123"))
+	require.Equal(t, token, redactAddress("192.168.1.2"))
+	require.Equal(t, redactHost("key.local"), redactAddress("Key.local."))
+	require.Equal(t, "", redactAddress(""))
+}
+
+func TestRedactText(t *testing.T) {
+	require.Equal(t,
+		"Get http://"+redactHost("192.168.1.2")+":9123/elgato/lights: timeout",
+		redactText("Get http://192.168.1.2:9123/elgato/lights: timeout"))
+	require.Equal(t,
+		"dial "+redactHost("fe80::1")+" failed",
+		redactText("dial fe80::1 failed"))
+	require.Equal(t,
+		redactHost("elgato-key-light-a1b2.local")+" not found",
+		redactText("elgato-key-light-a1b2.local not found"))
+
+	// Things which only look like addresses are left alone
+	require.Equal(t, "waited until 12:30:45 for v1.2", redactText("waited until 12:30:45 for v1.2"))
+}
+
+func TestRedactedLogging(t *testing.T) {
+	previous := slog.Default()
+	defer slog.SetDefault(previous)
+
+	var buf bytes.Buffer
+	require.NoError(t, setupLogging(&buf, LogFormatJSON, "debug", true))
+
+	deviceLog.Debug("Failed to fetch light group",
+		"address", "192.168.1.2",
+		"serial", "CW12AB345678",
+		"ssid", "Office WiFi",
+		"error", errors.New("dial tcp 192.168.1.2:9123: i/o timeout"))
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	require.Equal(t, redactHost("192.168.1.2"), record["address"])
+	require.Equal(t, "CW12********", record["serial"])
+	require.Equal(t, "<redacted>", record["ssid"])
+	require.Equal(t, "dial tcp "+redactHost("192.168.1.2")+":9123: i/o timeout", record["error"])
+	require.Equal(t, SubsystemDevice, record["subsystem"])
+}
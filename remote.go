@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/oleksandr/bonjour"
+)
+
+// The mDNS service klctl serve announces itself as.
+const serverService = "_klctl._tcp"
+
+// serverAuto is the --server value which finds the server with mDNS.
+const serverAuto = "auto"
+
+// serverInstance is the name this machine's server is announced as.
+func serverInstance() string {
+	if hostname, err := os.Hostname(); err == nil {
+		return "klctl on " + hostname
+	}
+
+	return "klctl"
+}
+
+// isLoopback reports whether a server listening on addr can only be reached
+// from this machine.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+
+	ip := net.ParseIP(host)
+	return host == "localhost" || (ip != nil && ip.IsLoopback())
+}
+
+// announceServer advertises the API server listening on addr over mDNS, with
+// the given TXT records, until the returned function is called.
+func announceServer(addr string, text []string) (func(), error) {
+	return announce(serverInstance(), serverService, addr, text)
+}
+
+// announce advertises a service listening on addr over mDNS, until the
+// returned function is called. Services listening on loopback can't be
+// reached by anyone else, so aren't announced.
+func announce(instance, service, addr string, text []string) (func(), error) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if isLoopback(addr) {
+		apiLog.Debug("Not announcing a service listening on loopback", "service", service, "address", addr)
+		return func() {}, nil
+	}
+
+	p, err := parsePort(port)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in %s: %w", addr, err)
+	}
+
+	server, err := bonjour.Register(instance, service, "", p, text, nil)
+	if err != nil {
+		return nil, fmt.Errorf("announcing %s: %w", service, err)
+	}
+
+	apiLog.Info("Announcing", "service", service, "instance", instance)
+
+	return server.Shutdown, nil
+}
+
+// browseServers calls found with each klctl server announced on the local
+// network, until it returns true or ctx is done.
+func browseServers(ctx context.Context, found func(entry *bonjour.ServiceEntry) bool) error {
+	resolver, err := bonjour.NewResolver(nil)
+	if err != nil {
+		return fmt.Errorf("failed to create discovery client: %w", err)
+	}
+	defer func() { resolver.Exit <- true }()
+
+	entries := make(chan *bonjour.ServiceEntry)
+	if err := resolver.Browse(serverService, "", entries); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case entry := <-entries:
+			if found(entry) {
+				return nil
+			}
+		}
+	}
+}
+
+// findServer looks for an announced klctl server on the local network, and
+// returns the address of the first one found.
+func findServer(ctx context.Context) (string, error) {
+	var addr string
+
+	err := browseServers(ctx, func(entry *bonjour.ServiceEntry) bool {
+		host := strings.TrimSuffix(entry.HostName, ".")
+		if entry.AddrIPv4 != nil {
+			host = entry.AddrIPv4.String()
+		}
+		if host == "" {
+			return false
+		}
+
+		addr = net.JoinHostPort(host, strconv.Itoa(entry.Port))
+		discoveryLog.Debug("Found server", "instance", entry.Instance, "address", addr)
+
+		return true
+	})
+	if err != nil {
+		return "", fmt.Errorf("no klctl server found: %w", err)
+	}
+
+	return addr, nil
+}
+
+// serverURL returns the base URL of a klctl server given with --server,
+// finding it first if it's auto.
+func serverURL(ctx context.Context, server string) (string, error) {
+	if server == serverAuto {
+		var err error
+		if server, err = findServer(ctx); err != nil {
+			return "", err
+		}
+	}
+
+	if strings.Contains(server, "://") {
+		return strings.TrimSuffix(server, "/"), nil
+	}
+
+	return "http://" + server, nil
+}
+
+// serverDevices returns the devices a klctl server controls, as devices which
+// make their requests through it. When lights are given, only the devices
+// they name are returned.
+func serverDevices(ctx context.Context, server string, settings HTTPSettings, lights []string) ([]Device, error) {
+	base, err := serverURL(ctx, server)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := settings.client()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/devices", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("listing devices on %s: %s", server, resp.Status)
+	}
+
+	var served []ServedDevice
+	if err := json.NewDecoder(resp.Body).Decode(&served); err != nil {
+		return nil, fmt.Errorf("listing devices on %s: %w", server, err)
+	}
+
+	served, err = selectServedDevices(served, lights)
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make([]Device, 0, len(served))
+	for _, sd := range served {
+		devices = append(devices, &HTTPDevice{
+			Device:         KeylightDevice{Device: &keylight.Device{Name: sd.Name, DNSAddr: sd.Address, Port: sd.Port}},
+			client:         client,
+			requestTimeout: settings.RequestTimeout,
+			baseURL:        base + "/devices/" + url.PathEscape(sd.Address),
+		})
+	}
+
+	return devices, nil
+}
+
+// selectServedDevices picks out the devices named by lights, by name or
+// address, keeping them all when there are no lights.
+func selectServedDevices(served []ServedDevice, lights []string) ([]ServedDevice, error) {
+	if len(lights) == 0 {
+		return served, nil
+	}
+
+	var selected []ServedDevice
+	for _, light := range lights {
+		found := false
+		for _, sd := range served {
+			if selectsLight(light, sd.Name, sd.Address, sd.Port) {
+				selected = append(selected, sd)
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("the server has no light %q", light)
+		}
+	}
+
+	return selected, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerDevices(t *testing.T) {
+	server, fakes := newTestAPIServer()
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	ctx := context.Background()
+	devices, err := serverDevices(ctx, srv.Listener.Addr().String(), HTTPSettings{}, nil)
+	require.NoError(t, err)
+	require.Len(t, devices, 2)
+	require.Equal(t, "key-left", devices[0].GetName())
+	require.Equal(t, "192.168.1.2", devices[1].GetDNSAddr())
+
+	_, err = devices[0].FetchDeviceInfo(ctx)
+	require.NoError(t, err)
+	_, err = devices[0].FetchSettings(ctx)
+	require.NoError(t, err)
+
+	lg, err := devices[1].FetchLightGroup(ctx)
+	require.NoError(t, err)
+	lg.Lights[0].On = 1
+	lg.Lights[0].Brightness = 70
+
+	updated, err := devices[1].UpdateLightGroup(ctx, lg)
+	require.NoError(t, err)
+	require.Equal(t, 70, updated.Lights[0].Brightness)
+	require.Equal(t, 1, fakes[1].LightGrp.Lights[0].On)
+	require.Equal(t, 0, fakes[0].LightGrp.Lights[0].On)
+
+	// Lights can be picked out by name or address
+	devices, err = serverDevices(ctx, srv.URL, HTTPSettings{}, []string{"key-right", "192.168.1.1"})
+	require.NoError(t, err)
+	require.Equal(t, "key-right", devices[0].GetName())
+	require.Equal(t, "key-left", devices[1].GetName())
+
+	_, err = serverDevices(ctx, srv.URL, HTTPSettings{}, []string{"key-middle"})
+	require.ErrorContains(t, err, `no light "key-middle"`)
+}
+
+func TestAPIServerDevicePassthrough(t *testing.T) {
+	server, _ := newTestAPIServer()
+
+	status, _ := doRequest(t, server, http.MethodPost, "/devices/key-left/elgato/lights", "")
+	require.Equal(t, http.StatusMethodNotAllowed, status)
+
+	status, _ = doRequest(t, server, http.MethodPut, "/devices/key-left/elgato/lights", "nope")
+	require.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = doRequest(t, server, http.MethodGet, "/devices/all/elgato/lights", "")
+	require.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = doRequest(t, server, http.MethodGet, "/devices/key-left/elgato/other", "")
+	require.Equal(t, http.StatusNotFound, status)
+}
+
+func TestAnnounceServerLoopback(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1:9124", "localhost:9124", "[::1]:9124"} {
+		stop, err := announceServer(addr, nil)
+		require.NoError(t, err, addr)
+		stop()
+	}
+
+	_, err := announceServer("9124", nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+)
+
+// Report is a bundle of diagnostic information about the targeted devices,
+// meant to be attached to bug reports.
+type Report struct {
+	GeneratedAt time.Time      `json:"generated_at"`
+	GoVersion   string         `json:"go_version"`
+	Platform    string         `json:"platform"`
+	Devices     []DeviceReport `json:"devices"`
+}
+
+// DeviceReport holds whatever could be fetched from a single device, along
+// with the errors hit while fetching the rest.
+type DeviceReport struct {
+	Address  string                   `json:"address"`
+	Info     *keylight.DeviceInfo     `json:"info,omitempty"`
+	Settings *keylight.DeviceSettings `json:"settings,omitempty"`
+	Lights   *keylight.LightGroup     `json:"lights,omitempty"`
+	Errors   []string                 `json:"errors,omitempty"`
+}
+
+// redact masks anything which identifies the device or the network it's on.
+func (dr *DeviceReport) redact() {
+	dr.Address = redactAddress(dr.Address)
+
+	if dr.Info != nil {
+		dr.Info.SerialNumber = redactSerial(dr.Info.SerialNumber)
+	}
+
+	for i, err := range dr.Errors {
+		dr.Errors[i] = redactText(err)
+	}
+}
+
+// buildReport gathers diagnostics from each device. Failures are recorded in
+// the report rather than returned, since a report about a misbehaving device
+// is exactly when they're wanted. With redact, serial numbers and addresses
+// are masked, so the report can be shared publicly.
+func buildReport(ctx context.Context, lightList []Device, redact bool) *Report {
+	report := &Report{
+		GeneratedAt: time.Now().UTC(),
+		GoVersion:   runtime.Version(),
+		Platform:    runtime.GOOS + "/" + runtime.GOARCH,
+		Devices:     []DeviceReport{},
+	}
+
+	for _, device := range lightList {
+		dr := DeviceReport{Address: device.GetDNSAddr()}
+		log := deviceLog.With("address", device.GetDNSAddr())
+
+		info, err := device.FetchDeviceInfo(ctx)
+		if err != nil {
+			log.Debug("Failed to fetch device info", "error", err)
+			dr.Errors = append(dr.Errors, "device info: "+err.Error())
+		} else {
+			dr.Info = info
+		}
+
+		settings, err := device.FetchSettings(ctx)
+		if err != nil {
+			log.Debug("Failed to fetch settings", "error", err)
+			dr.Errors = append(dr.Errors, "settings: "+err.Error())
+		} else {
+			dr.Settings = settings
+		}
+
+		lights, err := device.FetchLightGroup(ctx)
+		if err != nil {
+			log.Debug("Failed to fetch light group", "error", err)
+			dr.Errors = append(dr.Errors, "lights: "+err.Error())
+		} else {
+			dr.Lights = lights
+		}
+
+		if redact {
+			dr.redact()
+		}
+
+		report.Devices = append(report.Devices, dr)
+	}
+
+	return report
+}
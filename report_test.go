@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildReport(t *testing.T) {
+	device := &FakeDevice{
+		DNSAddr:                  "192.168.1.2",
+		DeviceInfo:               &keylight.DeviceInfo{ProductName: "Key Light", SerialNumber: "CW12AB345678"},
+		LightGrp:                 &keylight.LightGroup{Lights: []*keylight.Light{{On: 1}}},
+		FetchDeviceSettingsError: errors.New("dial tcp 192.168.1.2:9123: connection refused"),
+	}
+
+	report := buildReport(context.Background(), []Device{device}, true)
+	require.Len(t, report.Devices, 1)
+
+	dr := report.Devices[0]
+	require.Equal(t, redactHost("192.168.1.2"), dr.Address)
+	require.Equal(t, "CW12********", dr.Info.SerialNumber)
+	require.Nil(t, dr.Settings)
+	require.NotNil(t, dr.Lights)
+	require.Equal(t, []string{"settings: dial tcp " + redactHost("192.168.1.2") + ":9123: connection refused"}, dr.Errors)
+
+	device.DeviceInfo.SerialNumber = "CW12AB345678"
+	report = buildReport(context.Background(), []Device{device}, false)
+	require.Equal(t, "192.168.1.2", report.Devices[0].Address)
+	require.Equal(t, "CW12AB345678", report.Devices[0].Info.SerialNumber)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/iainlane/klctl/pkg/keylightctl"
+)
+
+// ResultStatus is the outcome of a command for a single device.
+type ResultStatus string
+
+const (
+	ResultChanged ResultStatus = "changed"
+	ResultSkipped ResultStatus = "skipped"
+	ResultFailed  ResultStatus = "failed"
+)
+
+// Change records a single field of a single light being altered.
+type Change = keylightctl.Change
+
+// DeviceResult describes what a command did to one device.
+type DeviceResult struct {
+	Device     string       `json:"device"`
+	Status     ResultStatus `json:"status"`
+	Changes    []Change     `json:"changes,omitempty"`
+	Error      string       `json:"error,omitempty"`
+	DurationMS float64      `json:"duration_ms"`
+
+	device Device
+}
+
+// ResultSummary counts the devices in a CommandResult by outcome.
+type ResultSummary struct {
+	Touched int `json:"touched"`
+	Changed int `json:"changed"`
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+}
+
+// CommandResult is produced by every command which changes lights. It is
+// rendered by the output layer, so scripts can see exactly what happened.
+type CommandResult struct {
+	Devices    []DeviceResult `json:"devices"`
+	Summary    ResultSummary  `json:"summary"`
+	DurationMS float64        `json:"duration_ms"`
+
+	start time.Time
+
+	// leftOut is the error of devices left out of the command, with
+	// --continue-on-error, as they couldn't be read.
+	leftOut error
+}
+
+func newCommandResult() *CommandResult {
+	return &CommandResult{
+		Devices: []DeviceResult{},
+		start:   time.Now(),
+	}
+}
+
+func durationMS(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// newDeviceResult describes the outcome for a device which was started at
+// start. A device with no changes and no error was skipped.
+func newDeviceResult(device Device, start time.Time, changes []Change, err error) DeviceResult {
+	dr := DeviceResult{
+		Device:     deviceAddress(device),
+		Status:     ResultChanged,
+		Changes:    changes,
+		DurationMS: durationMS(time.Since(start)),
+		device:     device,
+	}
+
+	switch {
+	case err != nil:
+		dr.Status = ResultFailed
+		dr.Error = err.Error()
+	case len(changes) == 0:
+		dr.Status = ResultSkipped
+	}
+
+	return dr
+}
+
+// add records the outcome for a device and counts it in the summary.
+func (r *CommandResult) add(dr DeviceResult) {
+	switch dr.Status {
+	case ResultFailed:
+		r.Summary.Failed++
+	case ResultSkipped:
+		r.Summary.Skipped++
+	case ResultChanged:
+		r.Summary.Changed++
+	}
+
+	r.Summary.Touched++
+	r.Devices = append(r.Devices, dr)
+}
+
+// addDevice records the outcome for a device which was started at start.
+func (r *CommandResult) addDevice(device Device, start time.Time, changes []Change, err error) {
+	r.add(newDeviceResult(device, start, changes, err))
+}
+
+// failure returns the error the command finishes with: err, along with that of
+// any devices left out, as a partial failure if some devices didn't fail.
+func (r *CommandResult) failure(err error) error {
+	if r.leftOut != nil {
+		err = errors.Join(r.leftOut, err)
+	}
+	if err != nil && r.Summary.Failed < r.Summary.Touched {
+		return partialFailure(err)
+	}
+
+	return err
+}
+
+// finish stamps the total duration of the command onto the result.
+func (r *CommandResult) finish() *CommandResult {
+	r.DurationMS = durationMS(time.Since(r.start))
+	return r
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+)
+
+// Defaults for retrying calls to lights. Key Lights often drop the first
+// request after they've been asleep, so one failure shouldn't end a command.
+const (
+	defaultRetries    = 2
+	defaultRetryDelay = 250 * time.Millisecond
+)
+
+// RetryConfig controls how RetryDevice retries failed calls.
+type RetryConfig struct {
+	// Retries is how many times a failed call is tried again.
+	Retries int
+	// Delay is the wait before the first retry. It doubles for each retry
+	// after that.
+	Delay time.Duration
+}
+
+// RetryDevice wraps a Device, retrying calls to its lights with exponential
+// backoff.
+type RetryDevice struct {
+	Device
+	config RetryConfig
+}
+
+func withRetries(devices []Device, cfg RetryConfig) []Device {
+	if cfg.Retries <= 0 {
+		return devices
+	}
+
+	wrapped := make([]Device, 0, len(devices))
+	for _, device := range devices {
+		wrapped = append(wrapped, &RetryDevice{device, cfg})
+	}
+
+	return wrapped
+}
+
+// retry calls fn until it succeeds, the retries run out or ctx is done. The
+// last error is returned.
+func (rd *RetryDevice) retry(ctx context.Context, call string, fn func() error) error {
+	delay := rd.config.Delay
+
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt == rd.config.Retries || ctx.Err() != nil {
+			return err
+		}
+
+		deviceLog.Debug("Retrying failed call",
+			"address", rd.GetDNSAddr(),
+			"call", call,
+			"attempt", attempt+1,
+			"delay", delay,
+			"error", err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+	}
+}
+
+func (rd *RetryDevice) FetchLightGroup(ctx context.Context) (*keylight.LightGroup, error) {
+	var lg *keylight.LightGroup
+
+	err := rd.retry(ctx, "FetchLightGroup", func() error {
+		var err error
+		lg, err = rd.Device.FetchLightGroup(ctx)
+		return err
+	})
+
+	return lg, err
+}
+
+func (rd *RetryDevice) UpdateLightGroup(ctx context.Context, lg *keylight.LightGroup) (*keylight.LightGroup, error) {
+	var updated *keylight.LightGroup
+
+	err := rd.retry(ctx, "UpdateLightGroup", func() error {
+		var err error
+		updated, err = rd.Device.UpdateLightGroup(ctx, lg)
+		return err
+	})
+
+	return updated, err
+}
+
+var _ Device = &RetryDevice{}
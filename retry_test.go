@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyDevice fails the first failures calls to its lights.
+type flakyDevice struct {
+	*FakeDevice
+	failures int
+	calls    int
+}
+
+var errFlaky = errors.New("connection reset by peer")
+
+func (d *flakyDevice) FetchLightGroup(ctx context.Context) (*keylight.LightGroup, error) {
+	d.calls++
+	if d.calls <= d.failures {
+		return nil, errFlaky
+	}
+
+	return d.FakeDevice.FetchLightGroup(ctx)
+}
+
+func (d *flakyDevice) UpdateLightGroup(ctx context.Context, lg *keylight.LightGroup) (*keylight.LightGroup, error) {
+	d.calls++
+	if d.calls <= d.failures {
+		return nil, errFlaky
+	}
+
+	return d.FakeDevice.UpdateLightGroup(ctx, lg)
+}
+
+func TestRetryDevice(t *testing.T) {
+	ctx := context.Background()
+	cfg := RetryConfig{Retries: 2, Delay: time.Millisecond}
+
+	flaky := &flakyDevice{FakeDevice: &FakeDevice{LightGrp: &keylight.LightGroup{}}, failures: 2}
+	devices := withRetries([]Device{flaky}, cfg)
+	lg, err := devices[0].FetchLightGroup(ctx)
+	require.NoError(t, err)
+	require.Same(t, flaky.LightGrp, lg)
+	require.Equal(t, 3, flaky.calls)
+
+	flaky = &flakyDevice{FakeDevice: &FakeDevice{LightGrp: &keylight.LightGroup{}}, failures: 3}
+	devices = withRetries([]Device{flaky}, cfg)
+	_, err = devices[0].UpdateLightGroup(ctx, &keylight.LightGroup{})
+	require.ErrorIs(t, err, errFlaky)
+	require.Equal(t, 3, flaky.calls)
+
+	// No retries means no wrapping at all
+	devices = withRetries([]Device{flaky}, RetryConfig{})
+	require.Same(t, flaky, devices[0])
+}
+
+func TestRetryDeviceCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	flaky := &flakyDevice{FakeDevice: &FakeDevice{LightGrp: &keylight.LightGroup{}}, failures: 10}
+	devices := withRetries([]Device{flaky}, RetryConfig{Retries: 5, Delay: time.Hour})
+
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, err := devices[0].FetchLightGroup(ctx)
+	require.ErrorIs(t, err, errFlaky)
+	require.Equal(t, 1, flaky.calls)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/iainlane/klctl/pkg/keylightctl"
+)
+
+const sceneExtension = ".json"
+
+// Scene and SceneDevice live in keylightctl, so other programs can use them
+// too.
+type (
+	Scene       = keylightctl.Scene
+	SceneDevice = keylightctl.SceneDevice
+)
+
+// defaultSceneDir returns ~/.config/klctl/scenes, respecting $XDG_CONFIG_HOME.
+func defaultSceneDir() string {
+	path := defaultConfigPath()
+	if path == "" {
+		return ""
+	}
+
+	return filepath.Join(filepath.Dir(path), "scenes")
+}
+
+func scenePath(dir, name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid scene name %q", name)
+	}
+
+	return filepath.Join(dir, name+sceneExtension), nil
+}
+
+// saveScene writes the scene into dir, replacing any scene with the same name.
+func saveScene(dir string, scene *Scene) error {
+	path, err := scenePath(dir, scene.Name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create scene directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(scene, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("failed to save scene %s: %w", scene.Name, err)
+	}
+
+	return nil
+}
+
+func loadScene(dir, name string) (*Scene, error) {
+	path, err := scenePath(dir, name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("no scene called %s", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scene %s: %w", name, err)
+	}
+
+	scene := &Scene{}
+	if err := json.Unmarshal(data, scene); err != nil {
+		return nil, fmt.Errorf("failed to parse scene %s: %w", name, err)
+	}
+
+	return scene, nil
+}
+
+// listScenes returns the names of the saved scenes, sorted.
+func listScenes(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list scenes: %w", err)
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), sceneExtension) {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(entry.Name(), sceneExtension))
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func deleteScene(dir, name string) error {
+	path, err := scenePath(dir, name)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("no scene called %s", name)
+	}
+
+	return err
+}
+
+// applyScene sets each light to its state in the scene. Devices are matched by
+// serial number; targeted devices which aren't in the scene are skipped.
+func applyScene(ctx context.Context, scene *Scene, lightList []Device) (*CommandResult, error) {
+	unlock, err := acquireDeviceLocks(ctx, lightList)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	result := newCommandResult()
+	for _, device := range lightList {
+		start := time.Now()
+		log := automationLog.With("address", device.GetDNSAddr())
+
+		info, err := device.FetchDeviceInfo(ctx)
+		if err != nil {
+			err = fmt.Errorf("failed to fetch device info: %w", err)
+			result.addDevice(device, start, nil, err)
+			return result, err
+		}
+
+		sd, ok := scene.Device(info.SerialNumber)
+		if !ok {
+			log.Warn("Device isn't part of the scene", "scene", scene.Name)
+			result.addDevice(device, start, nil, nil)
+			continue
+		}
+
+		lightGroup, err := device.FetchLightGroup(ctx)
+		if err != nil {
+			err = fmt.Errorf("failed to fetch light group: %w", err)
+			result.addDevice(device, start, nil, err)
+			return result, err
+		}
+
+		var changes []Change
+		for i, light := range lightGroup.Lights {
+			if i >= len(sd.Lights) {
+				break
+			}
+			changes = append(changes, keylightctl.SettingsOf(sd.Lights[i]).Apply(i, light)...)
+		}
+
+		err = updateChangedLightGroup(ctx, device, lightGroup, changes)
+		result.addDevice(device, start, changes, err)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
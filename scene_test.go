@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/iainlane/klctl/pkg/keylightctl"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSceneStore(t *testing.T) {
+	dir := t.TempDir()
+
+	names, err := listScenes(dir)
+	require.NoError(t, err)
+	require.Empty(t, names)
+
+	for _, name := range []string{"work", "evening"} {
+		require.NoError(t, saveScene(dir, &Scene{Name: name}))
+	}
+
+	names, err = listScenes(dir)
+	require.NoError(t, err)
+	require.Equal(t, []string{"evening", "work"}, names)
+
+	scene, err := loadScene(dir, "work")
+	require.NoError(t, err)
+	require.Equal(t, "work", scene.Name)
+
+	require.NoError(t, deleteScene(dir, "work"))
+	_, err = loadScene(dir, "work")
+	require.Error(t, err)
+	require.Error(t, deleteScene(dir, "work"))
+
+	for _, name := range []string{"", "../escape", ".hidden"} {
+		require.Error(t, saveScene(dir, &Scene{Name: name}), name)
+	}
+}
+
+func TestSceneCaptureAndApply(t *testing.T) {
+	ctx := context.Background()
+
+	newDevice := func(addr, serial string, on, brightness int) *FakeDevice {
+		return &FakeDevice{
+			DNSAddr:    addr,
+			DeviceInfo: &keylight.DeviceInfo{SerialNumber: serial},
+			LightGrp: &keylight.LightGroup{Count: 1, Lights: []*keylight.Light{
+				{On: on, Brightness: brightness, Temperature: 200},
+			}},
+		}
+	}
+
+	a := newDevice("a.local", "AAAA1", 1, 40)
+	b := newDevice("b.local", "BBBB1", 0, 10)
+
+	scene, err := keylightctl.CaptureScene(ctx, "desk", []Device{a, b})
+	require.NoError(t, err)
+	require.Len(t, scene.Devices, 2)
+
+	// The lights change address, and change state
+	a2 := newDevice("a2.local", "AAAA1", 0, 80)
+	b2 := newDevice("b2.local", "BBBB1", 0, 10)
+	c := newDevice("c.local", "CCCC1", 1, 50)
+
+	result, err := applyScene(ctx, scene, []Device{a2, b2, c})
+	require.NoError(t, err)
+
+	require.Equal(t, ResultSummary{Touched: 3, Changed: 1, Skipped: 2}, result.Summary)
+	require.Equal(t, []Change{
+		{Light: 0, Field: "on", Old: 0, New: 1},
+		{Light: 0, Field: "brightness", Old: 80, New: 40},
+	}, result.Devices[0].Changes)
+	require.Equal(t, keylight.Light{On: 1, Brightness: 40, Temperature: 200}, *a2.LightGrp.Lights[0])
+	require.Equal(t, keylight.Light{On: 1, Brightness: 50, Temperature: 200}, *c.LightGrp.Lights[0])
+}
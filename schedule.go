@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ScheduleEntry is a klctl command run whenever its cron expression matches.
+type ScheduleEntry struct {
+	ID      int       `yaml:"id" json:"id"`
+	Cron    string    `yaml:"cron" json:"cron"`
+	Command []string  `yaml:"command" json:"command"`
+	Created time.Time `yaml:"created" json:"created"`
+}
+
+// Schedules are the commands klctl serve runs on a schedule.
+type Schedules []ScheduleEntry
+
+// defaultSchedulesPath returns schedules.yaml next to the config file.
+func defaultSchedulesPath() string {
+	path := defaultConfigPath()
+	if path == "" {
+		return ""
+	}
+
+	return filepath.Join(filepath.Dir(path), "schedules.yaml")
+}
+
+// readSchedules reads the schedules at path. A missing file has none.
+func readSchedules(path string) (Schedules, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schedules: %w", err)
+	}
+
+	var schedules Schedules
+	if err := yaml.Unmarshal(data, &schedules); err != nil {
+		return nil, fmt.Errorf("failed to parse schedules %s: %w", path, err)
+	}
+
+	return schedules, nil
+}
+
+// writeSchedules replaces the schedules at path.
+func writeSchedules(path string, schedules Schedules) error {
+	if path == "" {
+		return errors.New("no schedules file to write to")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(schedules)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o644)
+}
+
+// add returns the schedules with a new entry, numbered after the highest so
+// far.
+func (s Schedules) add(cron string, command []string, now time.Time) (Schedules, ScheduleEntry, error) {
+	if _, err := parseCron(cron); err != nil {
+		return nil, ScheduleEntry{}, err
+	}
+
+	id := 1
+	for _, entry := range s {
+		id = max(id, entry.ID+1)
+	}
+
+	entry := ScheduleEntry{ID: id, Cron: cron, Command: command, Created: now}
+	return append(s, entry), entry, nil
+}
+
+// remove returns the schedules without the entry with the ID.
+func (s Schedules) remove(id int) (Schedules, error) {
+	for i, entry := range s {
+		if entry.ID == id {
+			return append(s[:i:i], s[i+1:]...), nil
+		}
+	}
+
+	return nil, fmt.Errorf("no schedule %d", id)
+}
+
+// ScheduleListing is a schedule entry with when it next runs, as listed by
+// schedule list.
+type ScheduleListing struct {
+	ScheduleEntry
+	Next time.Time `json:"next"`
+}
+
+func renderSchedules(w io.Writer, format string, schedules Schedules, now time.Time) error {
+	listings := make([]ScheduleListing, 0, len(schedules))
+	for _, entry := range schedules {
+		listing := ScheduleListing{ScheduleEntry: entry}
+		if spec, err := parseCron(entry.Cron); err == nil {
+			listing.Next = spec.Next(now)
+		}
+		listings = append(listings, listing)
+	}
+
+	if format == OutputJSON {
+		return writeJSON(w, listings)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSCHEDULE\tNEXT\tCOMMAND")
+	for _, listing := range listings {
+		next := "never"
+		if !listing.Next.IsZero() {
+			next = listing.Next.Local().Format("Mon 2006-01-02 15:04")
+		}
+
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", listing.ID, listing.Cron, next, strings.Join(listing.Command, " "))
+	}
+
+	return tw.Flush()
+}
+
+// Scheduler runs the schedules at path for klctl serve. The file is read
+// again every minute, so schedules added or removed while serve is running
+// take effect without restarting it.
+type Scheduler struct {
+	path  string
+	clock Clock
+
+	// isLeader says whether this server should run the schedules. Only the
+	// leader does, so several servers on a network don't all run them.
+	isLeader func() bool
+
+	// arbiter, if set, holds the schedules off after changes by hand.
+	arbiter *Arbiter
+
+	// exec runs a command.
+	exec func(ctx context.Context, command []string) error
+}
+
+// run runs the schedules until ctx is done.
+func (s *Scheduler) run(ctx context.Context) {
+	for {
+		now := s.clock.Now()
+		next := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute()+1, 0, 0, now.Location())
+		if err := waitUntil(ctx, s.clock, next); err != nil {
+			return
+		}
+
+		s.tick(ctx, next)
+	}
+}
+
+// tick starts every command scheduled for the minute at.
+func (s *Scheduler) tick(ctx context.Context, at time.Time) {
+	if !s.isLeader() {
+		return
+	}
+	if s.arbiter != nil && !s.arbiter.allowed() {
+		automationLog.Debug("Not running schedules, as the lights were changed by hand", "at", at)
+		return
+	}
+
+	schedules, err := readSchedules(s.path)
+	if err != nil {
+		automationLog.Error("Failed to read schedules", "error", err)
+		return
+	}
+
+	for _, entry := range schedules {
+		spec, err := parseCron(entry.Cron)
+		if err != nil {
+			automationLog.Error("Invalid schedule", "id", entry.ID, "error", err)
+			continue
+		}
+
+		if !spec.Matches(at) {
+			continue
+		}
+
+		automationLog.Info("Running scheduled command", "id", entry.ID, "command", strings.Join(entry.Command, " "))
+		go func(entry ScheduleEntry) {
+			if err := s.exec(ctx, entry.Command); err != nil {
+				automationLog.Error("Scheduled command failed", "id", entry.ID, "error", err)
+			}
+		}(entry)
+	}
+}
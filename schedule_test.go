@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchedulesAddRemove(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 9, 30, 0, 0, time.UTC)
+
+	var schedules Schedules
+	schedules, first, err := schedules.add("0 9 * * *", []string{"on"}, now)
+	require.NoError(t, err)
+	require.Equal(t, 1, first.ID)
+
+	schedules, second, err := schedules.add("0 18 * * *", []string{"off"}, now)
+	require.NoError(t, err)
+	require.Equal(t, 2, second.ID)
+
+	_, _, err = schedules.add("0 25 * * *", []string{"on"}, now)
+	require.Error(t, err)
+
+	schedules, err = schedules.remove(1)
+	require.NoError(t, err)
+	require.Equal(t, Schedules{second}, schedules)
+
+	_, err = schedules.remove(1)
+	require.EqualError(t, err, "no schedule 1")
+
+	// IDs aren't reused while later ones remain
+	schedules, third, err := schedules.add("@hourly", []string{"toggle"}, now)
+	require.NoError(t, err)
+	require.Equal(t, 3, third.ID)
+	require.Len(t, schedules, 2)
+}
+
+func TestSchedulesReadWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "klctl", "schedules.yaml")
+
+	schedules, err := readSchedules(path)
+	require.NoError(t, err)
+	require.Empty(t, schedules)
+
+	now := time.Date(2024, time.March, 1, 9, 30, 0, 0, time.UTC)
+	schedules, _, err = schedules.add("0 9 * * 1-5", []string{"on", "--brightness", "50"}, now)
+	require.NoError(t, err)
+	require.NoError(t, writeSchedules(path, schedules))
+
+	read, err := readSchedules(path)
+	require.NoError(t, err)
+	require.Equal(t, schedules, read)
+}
+
+func TestRenderSchedules(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 9, 30, 0, 0, time.UTC)
+	schedules := Schedules{
+		{ID: 1, Cron: "0 9 * * 1-5", Command: []string{"on", "--brightness", "50"}, Created: now},
+		{ID: 2, Cron: "0 0 30 2 *", Command: []string{"off"}, Created: now},
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, renderSchedules(&out, OutputText, schedules, now))
+
+	next := time.Date(2024, time.March, 4, 9, 0, 0, 0, time.UTC).Local().Format("Mon 2006-01-02 15:04")
+	require.Contains(t, out.String(), "1   0 9 * * 1-5  "+next)
+	require.Contains(t, out.String(), "on --brightness 50")
+	require.Contains(t, out.String(), "never")
+}
+
+func TestScheduler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schedules.yaml")
+	clock := newFakeClock()
+
+	schedules, _, err := Schedules(nil).add("31 9 * * *", []string{"on"}, clock.Now())
+	require.NoError(t, err)
+	schedules, _, err = schedules.add("32 9 * * *", []string{"off"}, clock.Now())
+	require.NoError(t, err)
+	require.NoError(t, writeSchedules(path, schedules))
+
+	var (
+		leader atomic.Bool
+		mu     sync.Mutex
+		ran    []string
+	)
+	leader.Store(true)
+
+	scheduler := &Scheduler{
+		path:     path,
+		clock:    clock,
+		isLeader: leader.Load,
+		exec: func(_ context.Context, command []string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			ran = append(ran, command...)
+			return nil
+		},
+	}
+	commands := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), ran...)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		scheduler.run(ctx)
+		close(done)
+	}()
+
+	clock.WaitForTimers(t, 1)
+	clock.Advance(time.Minute)
+	require.Eventually(t, func() bool { return len(commands()) == 1 }, time.Second, time.Millisecond)
+	require.Equal(t, []string{"on"}, commands())
+
+	// The lights were changed by hand, so the schedules are held off
+	scheduler.arbiter = newArbiter(ConflictPolicy{Name: PolicyManual}, clock)
+	scheduler.arbiter.manualChange()
+	clock.WaitForTimers(t, 1)
+	clock.Advance(time.Minute)
+	clock.WaitForTimers(t, 1)
+	require.Equal(t, []string{"on"}, commands())
+	scheduler.arbiter = nil
+
+	// Another server is leading, so this one leaves it to them
+	leader.Store(false)
+	clock.WaitForTimers(t, 1)
+	clock.Advance(time.Minute)
+	clock.WaitForTimers(t, 1)
+	require.Equal(t, []string{"on"}, commands())
+
+	cancel()
+	<-done
+}
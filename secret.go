@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/zalando/go-keyring"
+	"golang.org/x/term"
+)
+
+// Secrets are kept in the OS keychain under this service, rather than in the
+// config file.
+const secretService = "klctl"
+
+// secretPrefix marks a config value as the name of a secret in the keychain,
+// such as "password: keyring:mqtt".
+const secretPrefix = "keyring:"
+
+// resolveSecret returns a config value, looking it up in the keychain if it
+// names a secret there. It's done only when the value is needed, as some
+// keychains ask permission for each look up.
+func resolveSecret(value string) (string, error) {
+	name, ok := strings.CutPrefix(value, secretPrefix)
+	if !ok {
+		return value, nil
+	}
+
+	secret, err := keyring.Get(secretService, name)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return "", fmt.Errorf("there's no secret %q in the keychain, set it with klctl secret set %s", name, name)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret %q from the keychain: %w", name, err)
+	}
+
+	return secret, nil
+}
+
+// readSecret reads a secret to store. From a terminal it's prompted for
+// without being echoed; otherwise it's the first line of in, so it can be
+// piped.
+func readSecret(in *os.File, prompt io.Writer, name string) (string, error) {
+	if term.IsTerminal(int(in.Fd())) {
+		fmt.Fprintf(prompt, "Secret for %s: ", name)
+		secret, err := term.ReadPassword(int(in.Fd()))
+		fmt.Fprintln(prompt)
+		if err != nil {
+			return "", err
+		}
+		return string(secret), nil
+	}
+
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// setSecret stores a secret in the keychain, replacing any with the same
+// name.
+func setSecret(name, secret string) error {
+	if secret == "" {
+		return errors.New("the secret is empty")
+	}
+
+	if err := keyring.Set(secretService, name, secret); err != nil {
+		return fmt.Errorf("failed to store secret %q in the keychain: %w", name, err)
+	}
+
+	return nil
+}
+
+// deleteSecret removes a secret from the keychain.
+func deleteSecret(name string) error {
+	err := keyring.Delete(secretService, name)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return fmt.Errorf("there's no secret %q in the keychain", name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete secret %q from the keychain: %w", name, err)
+	}
+
+	return nil
+}
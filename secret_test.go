@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zalando/go-keyring"
+)
+
+func TestSecrets(t *testing.T) {
+	keyring.MockInit()
+
+	// Values which aren't secrets are used as they are
+	value, err := resolveSecret("hunter2")
+	require.NoError(t, err)
+	require.Equal(t, "hunter2", value)
+
+	_, err = resolveSecret("keyring:mqtt")
+	require.ErrorContains(t, err, "klctl secret set mqtt")
+
+	require.NoError(t, setSecret("mqtt", "s3cret"))
+	value, err = resolveSecret("keyring:mqtt")
+	require.NoError(t, err)
+	require.Equal(t, "s3cret", value)
+
+	require.Error(t, setSecret("empty", ""))
+
+	require.NoError(t, deleteSecret("mqtt"))
+	require.Error(t, deleteSecret("mqtt"))
+	_, err = resolveSecret("keyring:mqtt")
+	require.Error(t, err)
+}
+
+func TestReadSecretPiped(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(path, []byte("s3cret\nignored\n"), 0o600))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	secret, err := readSecret(f, nil, "mqtt")
+	require.NoError(t, err)
+	require.Equal(t, "s3cret", secret)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/iainlane/klctl/pkg/keylightctl"
+)
+
+// SelfTestStatus is the outcome of one check of a self-test.
+type SelfTestStatus string
+
+const (
+	SelfTestPassed SelfTestStatus = "pass"
+	// SelfTestWarning is something odd about the device, such as firmware
+	// which reports values out of range, which klctl copes with.
+	SelfTestWarning SelfTestStatus = "warn"
+	SelfTestFailed  SelfTestStatus = "fail"
+)
+
+// SelfTestCheck is one check of a self-test.
+type SelfTestCheck struct {
+	Name   string         `json:"name"`
+	Status SelfTestStatus `json:"status"`
+	Detail string         `json:"detail,omitempty"`
+}
+
+// SelfTestResult is the checks made of one device.
+type SelfTestResult struct {
+	Device string          `json:"device"`
+	Checks []SelfTestCheck `json:"checks"`
+}
+
+func (r *SelfTestResult) check(name string, status SelfTestStatus, format string, a ...any) {
+	r.Checks = append(r.Checks, SelfTestCheck{Name: name, Status: status, Detail: fmt.Sprintf(format, a...)})
+}
+
+// Failed reports whether any check failed.
+func (r *SelfTestResult) Failed() bool {
+	for _, check := range r.Checks {
+		if check.Status == SelfTestFailed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// selfTest checks that a device does what klctl expects of it: everything is
+// read, then the brightness of each light is changed by 1%, which is too
+// little to notice, and put back. The lights are put back even if ctx is
+// cancelled part way through.
+func selfTest(ctx context.Context, device Device, requestTimeout time.Duration) SelfTestResult {
+	result := SelfTestResult{Device: device.GetDNSAddr(), Checks: []SelfTestCheck{}}
+
+	info, err := device.FetchDeviceInfo(ctx)
+	switch {
+	case err != nil:
+		result.check("info", SelfTestFailed, "%v", err)
+	case info.FirmwareVersion == "":
+		result.check("info", SelfTestWarning, "%s reports no firmware version", info.ProductName)
+	default:
+		result.check("info", SelfTestPassed, "%s, firmware %s (build %d)", info.ProductName, info.FirmwareVersion, info.FirmwareBuildNumber)
+	}
+
+	settings, err := device.FetchSettings(ctx)
+	switch {
+	case err != nil:
+		result.check("settings", SelfTestFailed, "%v", err)
+	case settings.PowerOnBrightness < 0 || settings.PowerOnBrightness > 100:
+		result.check("settings", SelfTestWarning, "power-on brightness %d%% is out of range", settings.PowerOnBrightness)
+	case validateTemperature(settings.PowerOnTemperature) != nil:
+		result.check("settings", SelfTestWarning, "power-on temperature %d is out of range", settings.PowerOnTemperature)
+	default:
+		result.check("settings", SelfTestPassed, "")
+	}
+
+	wifi, err := device.FetchWifiInfo(ctx)
+	switch {
+	case err != nil:
+		result.check("wifi", SelfTestFailed, "%v", err)
+	case wifi == nil:
+		result.check("wifi", SelfTestWarning, "the firmware doesn't report its Wi-Fi")
+	default:
+		result.check("wifi", SelfTestPassed, "")
+	}
+
+	if info != nil && keylightctl.SupportsColor(info) {
+		cg, err := device.FetchColors(ctx)
+		switch {
+		case err != nil:
+			result.check("colors", SelfTestFailed, "%v", err)
+		case cg.Count != len(cg.Lights):
+			result.check("colors", SelfTestWarning, "reports %d lights but gives %d colours", cg.Count, len(cg.Lights))
+		default:
+			result.check("colors", SelfTestPassed, "")
+		}
+	}
+
+	lg, err := device.FetchLightGroup(ctx)
+	switch {
+	case err != nil:
+		result.check("lights", SelfTestFailed, "%v", err)
+		return result
+	case len(lg.Lights) == 0:
+		result.check("lights", SelfTestFailed, "no lights reported")
+		return result
+	case lg.Count != len(lg.Lights):
+		result.check("lights", SelfTestWarning, "reports %d lights but gives %d", lg.Count, len(lg.Lights))
+	default:
+		if odd := oddLights(lg); odd != "" {
+			result.check("lights", SelfTestWarning, "%s", odd)
+		} else {
+			result.check("lights", SelfTestPassed, "%d lights", len(lg.Lights))
+		}
+	}
+
+	original := lg.Copy()
+	selfTestChange(ctx, device, lg.Copy(), &result)
+	selfTestRestore(ctx, device, original, requestTimeout, &result)
+
+	return result
+}
+
+// selfTestChange changes the brightness of each light by 1%, and checks the
+// change is made.
+func selfTestChange(ctx context.Context, device Device, changed *keylight.LightGroup, result *SelfTestResult) {
+	for _, light := range changed.Lights {
+		if light.Brightness < 100 {
+			light.Brightness++
+		} else {
+			light.Brightness--
+		}
+	}
+
+	updated, err := device.UpdateLightGroup(ctx, changed.Copy())
+	if err != nil {
+		result.check("change", SelfTestFailed, "%v", err)
+		return
+	}
+
+	readBack, err := device.FetchLightGroup(ctx)
+	switch {
+	case err != nil:
+		result.check("change", SelfTestFailed, "reading the change back: %v", err)
+	case !sameLights(readBack, changed):
+		result.check("change", SelfTestFailed, "set %s but read back %s", describeLights(changed), describeLights(readBack))
+	case updated == nil || !sameLights(updated, changed):
+		result.check("change", SelfTestWarning, "the change was made, but the response says %s", describeLights(updated))
+	default:
+		result.check("change", SelfTestPassed, "brightness changed by 1%%")
+	}
+}
+
+// selfTestRestore puts the lights back as they were before the self-test, and
+// checks they are.
+func selfTestRestore(ctx context.Context, device Device, original *keylight.LightGroup, requestTimeout time.Duration, result *SelfTestResult) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), requestTimeout)
+	defer cancel()
+
+	if _, err := device.UpdateLightGroup(ctx, original.Copy()); err != nil {
+		result.check("restore", SelfTestFailed, "%v", err)
+		return
+	}
+
+	current, err := device.FetchLightGroup(ctx)
+	switch {
+	case err != nil:
+		result.check("restore", SelfTestFailed, "reading the lights back: %v", err)
+	case !sameLights(current, original):
+		result.check("restore", SelfTestFailed, "set %s but read back %s", describeLights(original), describeLights(current))
+	default:
+		result.check("restore", SelfTestPassed, "")
+	}
+}
+
+// oddLights describes any lights whose state is out of the range the lights
+// take, or returns "".
+func oddLights(lg *keylight.LightGroup) string {
+	var odd []string
+	for i, light := range lg.Lights {
+		if light.On != 0 && light.On != 1 {
+			odd = append(odd, fmt.Sprintf("light %d is neither on nor off (%d)", i, light.On))
+		}
+		if light.Brightness < 0 || light.Brightness > 100 {
+			odd = append(odd, fmt.Sprintf("light %d brightness %d%% is out of range", i, light.Brightness))
+		}
+		if validateTemperature(light.Temperature) != nil {
+			odd = append(odd, fmt.Sprintf("light %d temperature %d is out of range", i, light.Temperature))
+		}
+	}
+
+	return strings.Join(odd, ", ")
+}
+
+// describeLights renders lights' state, for saying how they differ.
+func describeLights(lg *keylight.LightGroup) string {
+	if lg == nil {
+		return "nothing"
+	}
+
+	lights := make([]string, len(lg.Lights))
+	for i, light := range lg.Lights {
+		lights[i] = fmt.Sprintf("%s %d%% %d", LightState(light.On), light.Brightness, light.Temperature)
+	}
+
+	return "[" + strings.Join(lights, ", ") + "]"
+}
+
+// selfTestDevices runs selfTest on every device at once.
+func selfTestDevices(ctx context.Context, devices []Device, requestTimeout time.Duration) ([]SelfTestResult, error) {
+	unlock, err := acquireDeviceLocks(ctx, devices)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// Failures are in the results, so one device failing doesn't stop the
+	// others being tested
+	results := make([]SelfTestResult, len(devices))
+	err = forEachDevice(ctx, devices, func(ctx context.Context, i int, device Device) error {
+		results[i] = selfTest(ctx, device, requestTimeout)
+		return nil
+	})
+
+	return results, err
+}
+
+func renderSelfTest(w io.Writer, format string, results []SelfTestResult) error {
+	if format == OutputJSON {
+		return writeJSON(w, results)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, result := range results {
+		for _, check := range result.Checks {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", result.Device, check.Name, strings.ToUpper(string(check.Status)), check.Detail)
+		}
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,39 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/iainlane/klctl/pkg/keylightctl"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSelfTestHardware runs the self-test against the real light at
+// $KLCTL_TEST_LIGHT, such as 192.168.1.20:9123:
+//
+//	KLCTL_TEST_LIGHT=192.168.1.20 go test -tags integration -run Hardware .
+//
+// Its brightness is changed by 1% and put back.
+func TestSelfTestHardware(t *testing.T) {
+	addr := os.Getenv("KLCTL_TEST_LIGHT")
+	if addr == "" {
+		t.Skip("set KLCTL_TEST_LIGHT to the address of a light to test against")
+	}
+
+	host, port, err := splitLightAddress(addr)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result := selfTest(ctx, keylightctl.NewDevice("", host, port), 5*time.Second)
+	for _, check := range result.Checks {
+		t.Logf("%s: %s %s", check.Name, check.Status, check.Detail)
+	}
+
+	require.False(t, result.Failed())
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+// checkStatuses returns the status of each check, by name.
+func checkStatuses(result SelfTestResult) map[string]SelfTestStatus {
+	statuses := map[string]SelfTestStatus{}
+	for _, check := range result.Checks {
+		statuses[check.Name] = check.Status
+	}
+
+	return statuses
+}
+
+func TestSelfTest(t *testing.T) {
+	ctx := context.Background()
+
+	newDevice := func() *FakeDevice {
+		return &FakeDevice{
+			DNSAddr:    "192.168.1.1",
+			DeviceInfo: &keylight.DeviceInfo{ProductName: "Elgato Key Light", FirmwareVersion: "1.0.3", FirmwareBuildNumber: 218},
+			DeviceSet:  &keylight.DeviceSettings{PowerOnBrightness: 20, PowerOnTemperature: 213},
+			Wifi:       &WifiInfo{SSID: "home"},
+			LightGrp: &keylight.LightGroup{Count: 1, Lights: []*keylight.Light{
+				{On: 1, Brightness: 100, Temperature: 200},
+			}},
+		}
+	}
+
+	t.Run("passes and puts the lights back", func(t *testing.T) {
+		device := newDevice()
+
+		result := selfTest(ctx, device, time.Second)
+
+		require.False(t, result.Failed())
+		require.Equal(t, map[string]SelfTestStatus{
+			"info":     SelfTestPassed,
+			"settings": SelfTestPassed,
+			"wifi":     SelfTestPassed,
+			"lights":   SelfTestPassed,
+			"change":   SelfTestPassed,
+			"restore":  SelfTestPassed,
+		}, checkStatuses(result))
+		require.Equal(t, []*keylight.Light{{On: 1, Brightness: 100, Temperature: 200}}, device.LightGrp.Lights)
+	})
+
+	t.Run("firmware oddities are warnings", func(t *testing.T) {
+		device := newDevice()
+		device.Wifi = nil
+		device.LightGrp.Count = 2
+		device.DeviceSet.PowerOnTemperature = 1000
+
+		result := selfTest(ctx, device, time.Second)
+
+		require.False(t, result.Failed())
+		statuses := checkStatuses(result)
+		require.Equal(t, SelfTestWarning, statuses["wifi"])
+		require.Equal(t, SelfTestWarning, statuses["lights"])
+		require.Equal(t, SelfTestWarning, statuses["settings"])
+	})
+
+	t.Run("failing to change the lights fails", func(t *testing.T) {
+		device := newDevice()
+		device.UpdateLightGroupError = errors.New("refused")
+
+		result := selfTest(ctx, device, time.Second)
+
+		require.True(t, result.Failed())
+		statuses := checkStatuses(result)
+		require.Equal(t, SelfTestFailed, statuses["change"])
+		require.Equal(t, SelfTestFailed, statuses["restore"])
+	})
+
+	t.Run("unreadable lights stop the test", func(t *testing.T) {
+		device := newDevice()
+		device.FetchLightGroupError = errors.New("refused")
+
+		result := selfTest(ctx, device, time.Second)
+
+		require.True(t, result.Failed())
+		require.NotContains(t, checkStatuses(result), "change")
+	})
+}
+
+func TestRenderSelfTest(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, renderSelfTest(&buf, OutputText, []SelfTestResult{{
+		Device: "192.168.1.1",
+		Checks: []SelfTestCheck{
+			{Name: "info", Status: SelfTestPassed, Detail: "Elgato Key Light, firmware 1.0.3 (build 218)"},
+			{Name: "wifi", Status: SelfTestWarning, Detail: "the firmware doesn't report its Wi-Fi"},
+		},
+	}}))
+
+	require.Equal(t,
+		"192.168.1.1  info  PASS  Elgato Key Light, firmware 1.0.3 (build 218)\n"+
+			"192.168.1.1  wifi  WARN  the firmware doesn't report its Wi-Fi\n",
+		buf.String())
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+)
+
+// The address serve listens on by default. It's loopback only, since the API
+// has no authentication.
+const defaultListenAddress = "127.0.0.1:9124"
+
+// How long in-flight requests get to finish when the server is shut down.
+const shutdownTimeout = 5 * time.Second
+
+// apiClientHeader marks changes from klctl itself, or from scripts, which
+// have no JSON body to send. Web pages can't send it without the browser
+// asking the server first.
+const apiClientHeader = "X-Klctl-Client"
+
+// APIServer exposes the lights over a local REST API. The devices are found
+// once, when the server starts, so requests don't wait for discovery.
+//
+//	GET  /lights                     status of every light
+//	GET  /lights/{id}                status of one light
+//	POST /lights/{id}/on             also off and toggle
+//	GET  /lights/{id}/brightness     also temperature
+//	PUT  /lights/{id}/brightness     body {"value": 50}; also temperature
+//
+// {id} is a light's name, its address, or "all".
+//
+// The lights' own API is also passed through, for klctl --server:
+//
+//	GET  /devices                          every device's name, address and port
+//	GET  /devices/{id}/elgato/...          also PUT elgato/lights and elgato/lights/settings
+//
+// GET /leader shows which server leads, when several run on the network,
+// GET /metrics serves Prometheus metrics, if they're collected, and GET /ws is
+// a WebSocket which sends a JSON event for each change to a light.
+//
+//	GET  /timers                     timers which haven't fired yet
+//	POST /timers                     body {"state": "off", "after": "30m", "lights": [...]}
+//
+// Requests must be addressed to localhost, an IP address or one of the
+// machine's own names. Changes must be sent as application/json or with the X-Klctl-Client header,
+// and not from another origin, so web pages can't make them.
+//
+// Changes made through the API, and those POSTed to /manual by klctl on the
+// same machine, are changes by hand, which can hold the schedules off
+// depending on the conflict policy. GET /manual shows whether they are, and
+// DELETE /manual lets them run again.
+type APIServer struct {
+	devices []Device
+
+	// election decides whether this server leads the others on the network.
+	election *Election
+
+	// metrics, if set, is served at /metrics.
+	metrics *Metrics
+
+	// events, if set, pushes changes to the lights to WebSocket clients at
+	// /ws.
+	events *EventHub
+
+	// timers, if set, runs the timers scheduled at /timers.
+	timers *Timers
+
+	// arbiter, if set, is told about changes by hand.
+	arbiter *Arbiter
+
+	// timeout bounds the device calls made for each request.
+	timeout time.Duration
+
+	// hosts are the names, besides localhost and IP addresses, which
+	// requests may be addressed to.
+	hosts []string
+}
+
+func newAPIServer(devices []Device, timeout time.Duration) *APIServer {
+	return &APIServer{
+		devices:  devices,
+		election: newElection(Peer{Instance: serverInstance(), Role: RoleAuto}, nil),
+		timeout:  timeout,
+		hosts:    ownHostnames(),
+	}
+}
+
+// ownHostnames returns this machine's names, as other machines on the
+// network reach it by.
+func ownHostnames() []string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return nil
+	}
+
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, ".local"))
+	return []string{hostname, hostname + ".local"}
+}
+
+// allowHost lets requests be addressed to the host addr listens on, if it's
+// a name.
+func (s *APIServer) allowHost(addr string) {
+	host, _, err := net.SplitHostPort(addr)
+	if err == nil && host != "" && !isIPAddress(host) {
+		s.hosts = append(s.hosts, strings.ToLower(strings.TrimSuffix(host, ".")))
+	}
+}
+
+// checkHost refuses requests addressed to names the server doesn't go by.
+// A web page on a domain the attacker points at 127.0.0.1, known as DNS
+// rebinding, is otherwise treated by the browser as its own origin, so can
+// use the API as it likes. Such requests always carry the attacker's domain
+// as their Host, never an IP address.
+func (s *APIServer) checkHost(r *http.Request) error {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(strings.Trim(host, "[]"), "."))
+
+	if host == "localhost" || isIPAddress(host) || slices.Contains(s.hosts, host) {
+		return nil
+	}
+
+	return apiErrorf(http.StatusForbidden, "requests for %s aren't allowed", r.Host)
+}
+
+// apiError is an error with the HTTP status it should be reported with.
+type apiError struct {
+	status int
+	err    error
+}
+
+func (e *apiError) Error() string {
+	return e.err.Error()
+}
+
+func apiErrorf(status int, format string, args ...any) error {
+	return &apiError{status, fmt.Errorf(format, args...)}
+}
+
+func (s *APIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hostErr := s.checkHost(r)
+	if hostErr == nil && r.URL.Path == "/metrics" && s.metrics != nil {
+		s.metrics.ServeHTTP(w, r)
+		return
+	}
+	if hostErr == nil && r.URL.Path == "/ws" && s.events != nil {
+		s.events.ServeHTTP(w, r)
+		return
+	}
+
+	start := time.Now()
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+
+	var body any
+	err := hostErr
+	if err == nil {
+		body, err = s.route(ctx, r)
+	}
+
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusBadGateway
+
+		var ae *apiError
+		if errors.As(err, &ae) {
+			status = ae.status
+		}
+
+		body = map[string]string{"error": err.Error()}
+	}
+
+	if s.events != nil && err == nil && r.Method != http.MethodGet {
+		s.events.changed()
+	}
+	if s.arbiter != nil && err == nil && r.Method != http.MethodGet && changesLights(r.URL.Path) {
+		s.arbiter.manualChange()
+	}
+
+	apiLog.Info("Handled request",
+		"method", r.Method,
+		"path", r.URL.Path,
+		"status", status,
+		"duration", time.Since(start))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := writeJSON(w, body); err != nil {
+		apiLog.Debug("Failed to write response", "error", err)
+	}
+}
+
+// checkCrossSite refuses changes a web page could have made. Browsers send
+// form posts and other simple requests to any site without asking it first,
+// so any page the user visits could otherwise change their lights.
+func checkCrossSite(r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return nil
+	}
+
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || !strings.EqualFold(u.Host, r.Host) {
+			return apiErrorf(http.StatusForbidden, "changes from %s aren't allowed", origin)
+		}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" && r.Header.Get(apiClientHeader) == "" {
+		return apiErrorf(http.StatusUnsupportedMediaType, "changes must be sent as application/json, or with the %s header", apiClientHeader)
+	}
+
+	return nil
+}
+
+func (s *APIServer) route(ctx context.Context, r *http.Request) (any, error) {
+	if err := checkCrossSite(r); err != nil {
+		return nil, err
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch parts[0] {
+	case "lights":
+		return s.routeLights(ctx, r, parts)
+	case "devices":
+		return s.routeDevices(ctx, r, parts)
+	case "leader":
+		if len(parts) == 1 {
+			return s.routeLeader(r)
+		}
+	case "timers":
+		if len(parts) == 1 {
+			return s.routeTimers(r)
+		}
+	case "manual":
+		if len(parts) == 1 {
+			return s.routeManual(r)
+		}
+	}
+
+	return nil, apiErrorf(http.StatusNotFound, "no such endpoint %s", r.URL.Path)
+}
+
+func (s *APIServer) routeLights(ctx context.Context, r *http.Request, parts []string) (any, error) {
+	if len(parts) > 3 {
+		return nil, apiErrorf(http.StatusNotFound, "no such endpoint %s", r.URL.Path)
+	}
+
+	if len(parts) == 1 {
+		if err := requireMethod(r, http.MethodGet); err != nil {
+			return nil, err
+		}
+
+		return fetchDeviceStatuses(ctx, s.devices, false)
+	}
+
+	devices, err := s.lookup(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(parts) == 2 {
+		if err := requireMethod(r, http.MethodGet); err != nil {
+			return nil, err
+		}
+
+		return fetchDeviceStatuses(ctx, devices, false)
+	}
+
+	switch action := parts[2]; action {
+	case "on", "off", "toggle":
+		if err := requireMethod(r, http.MethodPost, http.MethodPut); err != nil {
+			return nil, err
+		}
+
+		state := map[string]LightState{"on": LightOn, "off": LightOff, "toggle": LightToggle}[action]
+		return withResult(setLightState(ctx, devices, state))
+
+	case ControlBrightness.String(), ControlTemperature.String():
+		field, _ := parseLightControlField(action)
+
+		if r.Method == http.MethodGet {
+			lights, err := getLightValues(ctx, devices, field)
+			if err != nil {
+				return nil, err
+			}
+
+			return LightValues{Field: field.String(), Lights: lights}, nil
+		}
+
+		if err := requireMethod(r, http.MethodPut, http.MethodPost); err != nil {
+			return nil, err
+		}
+
+		value, err := parseFieldValue(r, field)
+		if err != nil {
+			return nil, err
+		}
+
+		return withResult(setLightControlFieldWithValue(ctx, devices, field, value))
+	}
+
+	return nil, apiErrorf(http.StatusNotFound, "no such endpoint %s", r.URL.Path)
+}
+
+// ServedDevice is a device as listed by the API, for clients to find it under
+// /devices.
+type ServedDevice struct {
+	Name    string `json:"name,omitempty"`
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+}
+
+func (s *APIServer) routeDevices(ctx context.Context, r *http.Request, parts []string) (any, error) {
+	if len(parts) == 1 {
+		if err := requireMethod(r, http.MethodGet); err != nil {
+			return nil, err
+		}
+
+		served := make([]ServedDevice, 0, len(s.devices))
+		for _, device := range s.devices {
+			served = append(served, ServedDevice{Name: device.GetName(), Address: device.GetDNSAddr(), Port: device.GetPort()})
+		}
+
+		return served, nil
+	}
+
+	devices, err := s.lookup(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	if len(devices) != 1 {
+		return nil, apiErrorf(http.StatusBadRequest, "%s is more than one device", parts[1])
+	}
+	device := devices[0]
+
+	switch path := strings.Join(parts[2:], "/"); path {
+	case "elgato/accessory-info":
+		if err := requireMethod(r, http.MethodGet); err != nil {
+			return nil, err
+		}
+
+		return device.FetchDeviceInfo(ctx)
+
+	case "elgato/lights/settings":
+		if r.Method == http.MethodGet {
+			return device.FetchSettings(ctx)
+		}
+
+		if err := requireMethod(r, http.MethodPut); err != nil {
+			return nil, err
+		}
+
+		settings := &keylight.DeviceSettings{}
+		if err := json.NewDecoder(r.Body).Decode(settings); err != nil {
+			return nil, apiErrorf(http.StatusBadRequest, "invalid settings: %v", err)
+		}
+
+		return device.UpdateSettings(ctx, settings)
+
+	case "elgato/lights":
+		if r.Method == http.MethodGet {
+			return device.FetchLightGroup(ctx)
+		}
+
+		if err := requireMethod(r, http.MethodPut); err != nil {
+			return nil, err
+		}
+
+		lg := &keylight.LightGroup{}
+		if err := json.NewDecoder(r.Body).Decode(lg); err != nil {
+			return nil, apiErrorf(http.StatusBadRequest, "invalid light group: %v", err)
+		}
+
+		return device.UpdateLightGroup(ctx, lg)
+	}
+
+	return nil, apiErrorf(http.StatusNotFound, "no such endpoint %s", r.URL.Path)
+}
+
+func requireMethod(r *http.Request, methods ...string) error {
+	for _, method := range methods {
+		if r.Method == method {
+			return nil
+		}
+	}
+
+	return apiErrorf(http.StatusMethodNotAllowed, "method %s not allowed, use %s", r.Method, strings.Join(methods, " or "))
+}
+
+// withResult finishes a command result, so it can be returned as a response.
+func withResult(result *CommandResult, err error) (any, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return result.finish(), nil
+}
+
+// lookup finds the devices a light id refers to.
+func (s *APIServer) lookup(id string) ([]Device, error) {
+	if id == "all" {
+		return s.devices, nil
+	}
+
+	for _, device := range s.devices {
+		if selectsLight(id, device.GetName(), device.GetDNSAddr(), device.GetPort()) {
+			return []Device{device}, nil
+		}
+	}
+
+	return nil, apiErrorf(http.StatusNotFound, "no light %q", id)
+}
+
+// parseFieldValue reads the value to set a field to from a request body of
+// the form {"value": 50}. Temperatures may also be strings such as "5000K".
+func parseFieldValue(r *http.Request, field LightControlField) (int, error) {
+	var body struct {
+		Value json.RawMessage `json:"value"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Value == nil {
+		return 0, apiErrorf(http.StatusBadRequest, `expected a body like {"value": 50}`)
+	}
+
+	raw := strings.Trim(string(body.Value), `"`)
+
+	parse := parseBrightness
+	if field == ControlTemperature {
+		parse = func(s string) (int, error) { return parseLightTemperature(s, temperatureUnit) }
+	}
+
+	value, err := parse(raw)
+	if err != nil {
+		return 0, &apiError{http.StatusBadRequest, err}
+	}
+
+	return value, nil
+}
+
+// serve runs the API server until ctx is cancelled, then shuts it down
+// gracefully.
+func serve(ctx context.Context, addr string, server *APIServer) error {
+	apiLog.Info("Serving", "address", addr, "lights", len(server.devices))
+	return serveHTTP(ctx, addr, server)
+}
+
+// serveHTTP serves handler on addr until ctx is cancelled, then shuts it down
+// gracefully.
+func serveHTTP(ctx context.Context, addr string, handler http.Handler) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	apiLog.Info("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
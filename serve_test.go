@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAPIServer() (*APIServer, []*FakeDevice) {
+	fakes := []*FakeDevice{
+		{
+			Name:       "key-left",
+			DNSAddr:    "192.168.1.1",
+			DeviceInfo: &keylight.DeviceInfo{},
+			DeviceSet:  &keylight.DeviceSettings{},
+			LightGrp:   &keylight.LightGroup{Lights: []*keylight.Light{{On: 0, Brightness: 20, Temperature: 200}}},
+		},
+		{
+			Name:       "key-right",
+			DNSAddr:    "192.168.1.2",
+			DeviceInfo: &keylight.DeviceInfo{},
+			DeviceSet:  &keylight.DeviceSettings{},
+			LightGrp:   &keylight.LightGroup{Lights: []*keylight.Light{{On: 0, Brightness: 20, Temperature: 200}}},
+		},
+	}
+
+	devices := []Device{fakes[0], fakes[1]}
+	return newAPIServer(devices, time.Second), fakes
+}
+
+func doRequest(t *testing.T, server http.Handler, method, path, body string) (int, map[string]any) {
+	t.Helper()
+
+	req := httptest.NewRequest(method, "http://localhost"+path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var decoded map[string]any
+	if strings.HasPrefix(strings.TrimSpace(rec.Body.String()), "{") {
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &decoded))
+	}
+
+	return rec.Code, decoded
+}
+
+func TestAPIServer(t *testing.T) {
+	server, fakes := newTestAPIServer()
+
+	t.Run("list", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/lights", nil)
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var statuses []DeviceStatus
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &statuses))
+		require.Len(t, statuses, 2)
+	})
+
+	t.Run("on", func(t *testing.T) {
+		code, body := doRequest(t, server, http.MethodPost, "/lights/key-left/on", "")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, map[string]any{"touched": 1.0, "changed": 1.0, "skipped": 0.0, "failed": 0.0}, body["summary"])
+		require.Equal(t, 1, fakes[0].LightGrp.Lights[0].On)
+		require.Equal(t, 0, fakes[1].LightGrp.Lights[0].On)
+	})
+
+	t.Run("brightness", func(t *testing.T) {
+		code, _ := doRequest(t, server, http.MethodPut, "/lights/all/brightness", `{"value": 60}`)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, 60, fakes[0].LightGrp.Lights[0].Brightness)
+		require.Equal(t, 60, fakes[1].LightGrp.Lights[0].Brightness)
+
+		code, body := doRequest(t, server, http.MethodGet, "/lights/192.168.1.2/brightness", "")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, []any{map[string]any{"device": "192.168.1.2", "index": 0.0, "value": 60.0}}, body["lights"])
+	})
+
+	t.Run("temperature", func(t *testing.T) {
+		code, _ := doRequest(t, server, http.MethodPut, "/lights/key-right/temperature", `{"value": "5000K"}`)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, 200, fakes[1].LightGrp.Lights[0].Temperature)
+
+		code, _ = doRequest(t, server, http.MethodPut, "/lights/key-right/temperature", `{"value": "10000K"}`)
+		require.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for _, tt := range []struct {
+			method, path, body string
+			status             int
+		}{
+			{http.MethodGet, "/lights/nope", "", http.StatusNotFound},
+			{http.MethodGet, "/bulbs", "", http.StatusNotFound},
+			{http.MethodGet, "/lights/all/on", "", http.StatusMethodNotAllowed},
+			{http.MethodPut, "/lights/all/brightness", `{"value": 150}`, http.StatusBadRequest},
+			{http.MethodPut, "/lights/all/brightness", `nonsense`, http.StatusBadRequest},
+		} {
+			code, body := doRequest(t, server, tt.method, tt.path, tt.body)
+			require.Equal(t, tt.status, code, tt.path)
+			require.NotEmpty(t, body["error"], tt.path)
+		}
+	})
+}
+
+func TestAPIServerRefusesCrossSiteChanges(t *testing.T) {
+	server, fakes := newTestAPIServer()
+
+	post := func(headers map[string]string) int {
+		req := httptest.NewRequest(http.MethodPost, "http://127.0.0.1:9124/lights/all/on", strings.NewReader("on=1"))
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// A form posted from another site
+	require.Equal(t, http.StatusForbidden, post(map[string]string{
+		"Content-Type": "application/x-www-form-urlencoded",
+		"Origin":       "https://evil.example",
+	}))
+	require.Equal(t, http.StatusForbidden, post(map[string]string{
+		apiClientHeader: "script",
+		"Origin":        "null",
+	}))
+	require.Equal(t, http.StatusUnsupportedMediaType, post(map[string]string{
+		"Content-Type": "text/plain",
+	}))
+	for _, fake := range fakes {
+		require.Equal(t, 0, fake.LightGrp.Lights[0].On)
+	}
+
+	require.Equal(t, http.StatusOK, post(map[string]string{apiClientHeader: "script"}))
+	require.Equal(t, http.StatusOK, post(map[string]string{
+		"Content-Type": "application/json; charset=utf-8",
+		"Origin":       "http://127.0.0.1:9124",
+	}))
+	require.Equal(t, 1, fakes[0].LightGrp.Lights[0].On)
+
+	// Reading is allowed from anywhere
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/lights", nil)
+	req.Header.Set("Origin", "https://evil.example")
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestAPIServerRefusesOtherHosts(t *testing.T) {
+	server, fakes := newTestAPIServer()
+	server.allowHost("studio.lan:9124")
+
+	request := func(method, host string) int {
+		req := httptest.NewRequest(method, "http://"+host+"/lights/all/on", nil)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Origin", "http://"+host)
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// A page on a domain rebound to 127.0.0.1 sends its own Origin and Host
+	require.Equal(t, http.StatusForbidden, request(http.MethodPost, "rebind.evil.example:9124"))
+	require.Equal(t, http.StatusForbidden, request(http.MethodGet, "rebind.evil.example:9124"))
+	require.Equal(t, 0, fakes[0].LightGrp.Lights[0].On)
+
+	for _, host := range []string{"localhost:9124", "127.0.0.1:9124", "[::1]:9124", "192.168.1.10:9124", "studio.lan:9124", "STUDIO.LAN."} {
+		require.Equal(t, http.StatusOK, request(http.MethodPost, host), host)
+	}
+	require.Equal(t, 1, fakes[0].LightGrp.Lights[0].On)
+}
+
+func TestServeShutsDown(t *testing.T) {
+	server, _ := newTestAPIServer()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- serve(ctx, "127.0.0.1:0", server)
+	}()
+
+	cancel()
+
+	select {
+	case err := <-errCh:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server didn't shut down")
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+)
+
+// How often log samples the lights by default.
+const defaultStateLogInterval = time.Minute
+
+// stateLogHeader is the first row of a state log.
+var stateLogHeader = []string{"time", "name", "address", "light", "on", "brightness", "temperature_kelvin", "error"}
+
+// stateLogPath returns the file to log to at a time. With rotate, each day has
+// its own file, with the date before the extension, such as state-2024-03-01.csv.
+func stateLogPath(path string, at time.Time, rotate bool) string {
+	if !rotate {
+		return path
+	}
+
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + at.Format(time.DateOnly) + ext
+}
+
+// openStateLog opens a state log to append to. A new or empty file gets the
+// header; an existing one must already have it, so resuming doesn't mix up
+// columns.
+func openStateLog(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state log: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if info.Size() == 0 {
+		w := csv.NewWriter(f)
+		if err := w.Write(stateLogHeader); err != nil {
+			f.Close()
+			return nil, err
+		}
+		w.Flush()
+		return f, w.Error()
+	}
+
+	header, err := csv.NewReader(bufio.NewReader(f)).Read()
+	if err != nil || !slices.Equal(header, stateLogHeader) {
+		f.Close()
+		return nil, fmt.Errorf("%s isn't a klctl state log, so it can't be added to", path)
+	}
+
+	return f, nil
+}
+
+// StateLogger appends the state of the lights to a CSV file every so often,
+// for lining up with recordings afterwards.
+type StateLogger struct {
+	devices []Device
+	clock   Clock
+
+	// requestTimeout bounds each sample of a device.
+	requestTimeout time.Duration
+
+	path   string
+	rotate bool
+
+	// file is the log being written, at filePath.
+	file     *os.File
+	filePath string
+}
+
+// sample reads every device, returning a row for each light, or one with the
+// error for a device which couldn't be read.
+func (sl *StateLogger) sample(ctx context.Context, at time.Time) [][]string {
+	groups := make([]*keylight.LightGroup, len(sl.devices))
+	errs := make([]error, len(sl.devices))
+
+	// Each device's error is kept, so the rest aren't cancelled
+	_ = forEachDevice(ctx, sl.devices, func(ctx context.Context, i int, device Device) error {
+		ctx, cancel := context.WithTimeout(ctx, sl.requestTimeout)
+		defer cancel()
+
+		groups[i], errs[i] = device.FetchLightGroup(ctx)
+		return nil
+	})
+
+	timestamp := at.Format(time.RFC3339)
+
+	var rows [][]string
+	for i, device := range sl.devices {
+		if errs[i] != nil {
+			rows = append(rows, []string{timestamp, device.GetName(), device.GetDNSAddr(), "", "", "", "", errs[i].Error()})
+			continue
+		}
+
+		for index, light := range groups[i].Lights {
+			rows = append(rows, []string{
+				timestamp,
+				device.GetName(),
+				device.GetDNSAddr(),
+				strconv.Itoa(index),
+				strconv.Itoa(light.On),
+				strconv.Itoa(light.Brightness),
+				strconv.Itoa(miredToKelvin(light.Temperature)),
+				"",
+			})
+		}
+	}
+
+	return rows
+}
+
+// write appends rows to the log for at, moving on to a new file when the
+// day changes.
+func (sl *StateLogger) write(at time.Time, rows [][]string) error {
+	path := stateLogPath(sl.path, at, sl.rotate)
+	if sl.file == nil || path != sl.filePath {
+		sl.close()
+
+		f, err := openStateLog(path)
+		if err != nil {
+			return err
+		}
+		sl.file, sl.filePath = f, path
+		automationLog.Info("Logging light state", "path", path)
+	}
+
+	// Flushed every time, so an interrupted log loses nothing
+	w := csv.NewWriter(sl.file)
+	if err := w.WriteAll(rows); err != nil {
+		return fmt.Errorf("failed to write state log: %w", err)
+	}
+
+	return nil
+}
+
+func (sl *StateLogger) close() {
+	if sl.file != nil {
+		sl.file.Close()
+		sl.file = nil
+	}
+}
+
+// run samples the lights every interval until ctx is done.
+func (sl *StateLogger) run(ctx context.Context, interval time.Duration) error {
+	defer sl.close()
+
+	for {
+		at := sl.clock.Now()
+		if err := sl.write(at, sl.sample(ctx, at)); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-sl.clock.After(interval):
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateLogPath(t *testing.T) {
+	at := time.Date(2024, time.March, 1, 9, 30, 0, 0, time.UTC)
+
+	require.Equal(t, "logs/state.csv", stateLogPath("logs/state.csv", at, false))
+	require.Equal(t, "logs/state-2024-03-01.csv", stateLogPath("logs/state.csv", at, true))
+	require.Equal(t, "state-2024-03-01", stateLogPath("state", at, true))
+}
+
+func TestOpenStateLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.csv")
+
+	f, err := openStateLog(path)
+	require.NoError(t, err)
+	_, err = f.WriteString("2024-03-01T09:30:00Z,key,key.local,0,1,20,5000,\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// Resuming adds to the end, without another header
+	f, err = openStateLog(path)
+	require.NoError(t, err)
+	_, err = f.WriteString("2024-03-01T09:31:00Z,key,key.local,0,1,25,5000,\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "time,name,address,light,on,brightness,temperature_kelvin,error\n"+
+		"2024-03-01T09:30:00Z,key,key.local,0,1,20,5000,\n"+
+		"2024-03-01T09:31:00Z,key,key.local,0,1,25,5000,\n", string(data))
+
+	other := filepath.Join(t.TempDir(), "other.csv")
+	require.NoError(t, os.WriteFile(other, []byte("a,b,c\n1,2,3\n"), 0o644))
+	_, err = openStateLog(other)
+	require.ErrorContains(t, err, "isn't a klctl state log")
+}
+
+func TestStateLoggerRun(t *testing.T) {
+	dir := t.TempDir()
+	clock := newFakeClock()
+
+	key := &FakeDevice{
+		Name:     "key",
+		DNSAddr:  "key.local",
+		LightGrp: &keylight.LightGroup{Lights: []*keylight.Light{{On: 1, Brightness: 20, Temperature: 200}}},
+	}
+	broken := &FakeDevice{
+		DNSAddr:              "broken.local",
+		FetchLightGroupError: errors.New("unreachable"),
+	}
+
+	logger := &StateLogger{
+		devices:        []Device{key, broken},
+		clock:          clock,
+		requestTimeout: time.Second,
+		path:           filepath.Join(dir, "state.csv"),
+		rotate:         true,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- logger.run(ctx, 15*time.Hour) }()
+
+	// The next sample is on the next day, so goes to a new file
+	clock.WaitForTimers(t, 1)
+	key.LightGrp.Lights[0].Brightness = 50
+	clock.Advance(15 * time.Hour)
+	clock.WaitForTimers(t, 1)
+
+	cancel()
+	require.NoError(t, <-done)
+
+	first, err := os.ReadFile(filepath.Join(dir, "state-2024-03-01.csv"))
+	require.NoError(t, err)
+	require.Equal(t, "time,name,address,light,on,brightness,temperature_kelvin,error\n"+
+		"2024-03-01T09:30:00Z,key,key.local,0,1,20,5000,\n"+
+		"2024-03-01T09:30:00Z,,broken.local,,,,,unreachable\n", string(first))
+
+	second, err := os.ReadFile(filepath.Join(dir, "state-2024-03-02.csv"))
+	require.NoError(t, err)
+	require.Equal(t, "time,name,address,light,on,brightness,temperature_kelvin,error\n"+
+		"2024-03-02T00:30:00Z,key,key.local,0,1,50,5000,\n"+
+		"2024-03-02T00:30:00Z,,broken.local,,,,,unreachable\n", string(second))
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// statusColumn is a column of the status table. Each row of the table is one
+// light, so the device's details are repeated for devices with several.
+type statusColumn struct {
+	heading string
+	value   func(status DeviceStatus, light *LightStatus) string
+}
+
+// noValue fills cells with nothing to show, such as the details of a light
+// which couldn't be fetched.
+const noValue = "-"
+
+// statusColumns returns the columns of the table. wide adds the address,
+// product and network, and hue adds the colour of lights which have one.
+func statusColumns(wide bool, unit TemperatureUnit, color, hue bool) []statusColumn {
+	info := func(field func(status DeviceStatus) string) func(DeviceStatus, *LightStatus) string {
+		return func(status DeviceStatus, _ *LightStatus) string {
+			if status.Info == nil {
+				return noValue
+			}
+			return orNoValue(field(status))
+		}
+	}
+	light := func(field func(light *LightStatus) string) func(DeviceStatus, *LightStatus) string {
+		return func(_ DeviceStatus, light *LightStatus) string {
+			if light == nil {
+				return noValue
+			}
+			return field(light)
+		}
+	}
+	wifi := func(field func(wifi *WifiInfo) string) func(DeviceStatus, *LightStatus) string {
+		return func(status DeviceStatus, _ *LightStatus) string {
+			if status.Wifi == nil {
+				return noValue
+			}
+			return orNoValue(field(status.Wifi))
+		}
+	}
+
+	columns := []statusColumn{
+		{"NAME", func(status DeviceStatus, _ *LightStatus) string { return statusName(status) }},
+	}
+	if wide {
+		columns = append(columns,
+			statusColumn{"ADDRESS", func(status DeviceStatus, _ *LightStatus) string { return hostPort(status.Address, status.Port) }},
+			statusColumn{"PRODUCT", info(func(status DeviceStatus) string { return status.Info.ProductName })},
+		)
+	}
+	columns = append(columns,
+		statusColumn{"SERIAL", info(func(status DeviceStatus) string { return status.Info.SerialNumber })},
+		statusColumn{"FIRMWARE", info(func(status DeviceStatus) string { return status.Info.FirmwareVersion })},
+		statusColumn{"LIGHT", light(func(light *LightStatus) string { return lightLabel(light.Index, light.Name) })},
+		statusColumn{"POWER", light(func(light *LightStatus) string { return LightState(boolToInt(light.On)).String() })},
+		statusColumn{"BRIGHTNESS", light(func(light *LightStatus) string { return fmt.Sprintf("%d%%", light.Brightness) })},
+		statusColumn{"TEMPERATURE", light(func(light *LightStatus) string { return temperatureString(light.Temperature, unit, color) })},
+	)
+	if hue {
+		columns = append(columns,
+			statusColumn{"COLOR", light(func(light *LightStatus) string {
+				if light.Color == nil {
+					return noValue
+				}
+				return formatColor(*light.Color)
+			})},
+		)
+	}
+	columns = append(columns,
+		statusColumn{"WIFI", wifi(func(wifi *WifiInfo) string { return fmt.Sprintf("%d dBm", wifi.RSSI) })},
+	)
+	if wide {
+		columns = append(columns,
+			statusColumn{"SSID", wifi(func(wifi *WifiInfo) string { return wifi.SSID })},
+		)
+	}
+
+	return columns
+}
+
+// statusName is what a device is called in the table: the name it was
+// discovered or configured with, then the name it gives itself, then its
+// address and port.
+func statusName(status DeviceStatus) string {
+	switch {
+	case status.Name != "":
+		return status.Name
+	case status.Info != nil && status.Info.DisplayName != "":
+		return status.Info.DisplayName
+	}
+
+	return hostPort(status.Address, status.Port)
+}
+
+func orNoValue(s string) string {
+	if s == "" {
+		return noValue
+	}
+
+	return s
+}
+
+// renderStatuses writes the status of the devices. Text output is a table with
+// a row for each light, followed by anything which couldn't be fetched.
+func renderStatuses(w io.Writer, format string, statuses []DeviceStatus, unit TemperatureUnit, color bool) error {
+	switch format {
+	case OutputJSON:
+		return writeJSON(w, statuses)
+	case OutputYAML:
+		return writeYAML(w, statuses)
+	}
+
+	columns := statusColumns(format == OutputWide, unit, color, anyColor(statuses))
+
+	headings := make([]string, 0, len(columns))
+	for _, column := range columns {
+		headings = append(headings, column.heading)
+	}
+	rows := [][]string{headings}
+
+	row := func(status DeviceStatus, light *LightStatus) {
+		cells := make([]string, 0, len(columns))
+		for _, column := range columns {
+			cells = append(cells, column.value(status, light))
+		}
+		rows = append(rows, cells)
+	}
+
+	for _, status := range statuses {
+		if len(status.Lights) == 0 {
+			row(status, nil)
+		}
+		for i := range status.Lights {
+			row(status, &status.Lights[i])
+		}
+	}
+
+	if err := writeTable(w, rows); err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		sections := make([]string, 0, len(status.Errors))
+		for section := range status.Errors {
+			sections = append(sections, section)
+		}
+		sort.Strings(sections)
+
+		for _, section := range sections {
+			if _, err := fmt.Fprintf(w, "%s: %s unavailable (%s)\n", statusName(status), section, status.Errors[section]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ansiEscape matches the escapes colour is written with.
+var ansiEscape = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// anyColor reports whether any light has a colour, so the table needs a
+// column for it.
+func anyColor(statuses []DeviceStatus) bool {
+	for _, status := range statuses {
+		for _, light := range status.Lights {
+			if light.Color != nil {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// visibleWidth is how many cells s takes up in a terminal.
+func visibleWidth(s string) int {
+	return utf8.RuneCountInString(ansiEscape.ReplaceAllString(s, ""))
+}
+
+// writeTable writes rows with their columns aligned. It's used rather than
+// tabwriter, which counts colour escapes as part of a cell's width.
+func writeTable(w io.Writer, rows [][]string) error {
+	var widths []int
+	for _, row := range rows {
+		for i, cell := range row {
+			if i == len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[i] = max(widths[i], visibleWidth(cell))
+		}
+	}
+
+	var sb strings.Builder
+	for _, row := range rows {
+		for i, cell := range row {
+			sb.WriteString(cell)
+			if i < len(row)-1 {
+				sb.WriteString(strings.Repeat(" ", widths[i]-visibleWidth(cell)+2))
+			}
+		}
+		sb.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderStatuses(t *testing.T) {
+	device := &FakeDevice{Name: "key-left", DNSAddr: "192.168.1.2"}
+	lightGroup := &keylight.LightGroup{
+		Count: 2,
+		Lights: []*keylight.Light{
+			{On: 1, Brightness: 20, Temperature: 200},
+			{On: 0, Brightness: 30, Temperature: 250},
+		},
+	}
+
+	status := newDeviceStatus(device, &keylight.DeviceInfo{SerialNumber: "CW12AB345678", FirmwareVersion: "1.0.3"}, &keylight.DeviceSettings{}, lightGroup)
+	status.Wifi = &WifiInfo{SSID: "studio", RSSI: -61}
+
+	var buf bytes.Buffer
+	require.NoError(t, renderStatuses(&buf, OutputTable, []DeviceStatus{status}, UnitKelvin, false))
+	require.Equal(t, ""+
+		"NAME      SERIAL        FIRMWARE  LIGHT  POWER  BRIGHTNESS  TEMPERATURE  WIFI\n"+
+		"key-left  CW12AB345678  1.0.3     0      on     20%         5000K        -61 dBm\n"+
+		"key-left  CW12AB345678  1.0.3     1      off    30%         4000K        -61 dBm\n",
+		buf.String())
+
+	status = newDeviceStatus(&FakeDevice{DNSAddr: "192.168.1.3"}, nil, nil, nil)
+	status.Errors = map[string]string{SectionInfo: "timeout", SectionLights: "timeout"}
+
+	buf.Reset()
+	require.NoError(t, renderStatuses(&buf, OutputWide, []DeviceStatus{status}, UnitKelvin, false))
+	require.Equal(t, ""+
+		"NAME         ADDRESS      PRODUCT  SERIAL  FIRMWARE  LIGHT  POWER  BRIGHTNESS  TEMPERATURE  WIFI  SSID\n"+
+		"192.168.1.3  192.168.1.3  -        -       -         -      -      -           -            -     -\n"+
+		"192.168.1.3: info unavailable (timeout)\n"+
+		"192.168.1.3: lights unavailable (timeout)\n",
+		buf.String())
+}
+
+func TestRenderStatusesSharedHost(t *testing.T) {
+	lightGroup := &keylight.LightGroup{Lights: []*keylight.Light{{On: 1, Brightness: 50, Temperature: 200}}}
+	first := newDeviceStatus(&FakeDevice{DNSAddr: "emulator.local.", Port: 9123}, nil, nil, lightGroup)
+	second := newDeviceStatus(&FakeDevice{DNSAddr: "emulator.local.", Port: 9124}, nil, nil, lightGroup)
+
+	var buf bytes.Buffer
+	require.NoError(t, renderStatuses(&buf, OutputTable, []DeviceStatus{first, second}, UnitKelvin, false))
+	require.Equal(t, ""+
+		"NAME                 SERIAL  FIRMWARE  LIGHT  POWER  BRIGHTNESS  TEMPERATURE  WIFI\n"+
+		"emulator.local:9123  -       -         0      on     50%         5000K        -\n"+
+		"emulator.local:9124  -       -         0      on     50%         5000K        -\n",
+		buf.String())
+}
+
+func TestRenderStatusesLightNames(t *testing.T) {
+	cfg := &Config{Lights: map[string]LightConfig{"desk": {Address: "desk.local", Names: map[int]string{1: "rear"}}}}
+	indexNames = cfg.indexNames()
+	t.Cleanup(func() { indexNames = IndexNames{} })
+
+	lightGroup := &keylight.LightGroup{Lights: []*keylight.Light{{On: 1, Brightness: 50, Temperature: 200}, {Brightness: 50, Temperature: 200}}}
+	status := newDeviceStatus(&FakeDevice{Name: "desk"}, nil, nil, lightGroup)
+
+	var buf bytes.Buffer
+	require.NoError(t, renderStatuses(&buf, OutputTable, []DeviceStatus{status}, UnitKelvin, false))
+	require.Equal(t, ""+
+		"NAME  SERIAL  FIRMWARE  LIGHT   POWER  BRIGHTNESS  TEMPERATURE  WIFI\n"+
+		"desk  -       -         0       on     50%         5000K        -\n"+
+		"desk  -       -         1 rear  off    50%         5000K        -\n",
+		buf.String())
+}
+
+func TestRenderStatusesHue(t *testing.T) {
+	lightGroup := &keylight.LightGroup{Lights: []*keylight.Light{{On: 1, Brightness: 50, Temperature: 200}}}
+	strip := newDeviceStatus(&FakeDevice{Name: "strip"}, nil, nil, lightGroup)
+	strip.Lights[0].Color = &Color{Hue: 240, Saturation: 100}
+	key := newDeviceStatus(&FakeDevice{Name: "key"}, nil, nil, lightGroup)
+
+	var buf bytes.Buffer
+	require.NoError(t, renderStatuses(&buf, OutputTable, []DeviceStatus{strip, key}, UnitKelvin, false))
+	require.Equal(t, ""+
+		"NAME   SERIAL  FIRMWARE  LIGHT  POWER  BRIGHTNESS  TEMPERATURE  COLOR      WIFI\n"+
+		"strip  -       -         0      on     50%         5000K        240° 100%  -\n"+
+		"key    -       -         0      on     50%         5000K        -          -\n",
+		buf.String())
+}
+
+func TestRenderStatusesColor(t *testing.T) {
+	statuses := []DeviceStatus{
+		newDeviceStatus(&FakeDevice{Name: "a"}, nil, nil, &keylight.LightGroup{Lights: []*keylight.Light{{Temperature: 143}}}),
+		newDeviceStatus(&FakeDevice{Name: "b"}, nil, nil, &keylight.LightGroup{Lights: []*keylight.Light{{Temperature: 1000}}}),
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, renderStatuses(&buf, OutputTable, statuses, UnitKelvin, true))
+
+	// The swatches differ in length, but the columns after them still line up
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	require.Len(t, lines, 3)
+	require.NotEqual(t, len(lines[1]), len(lines[2]))
+	require.Equal(t, visibleWidth(string(lines[1])), visibleWidth(string(lines[2])))
+}
+
+func TestValidateOutputFormat(t *testing.T) {
+	require.NoError(t, validateOutputFormat(OutputJSON, "get"))
+	require.NoError(t, validateOutputFormat(OutputWide, "status"))
+	require.NoError(t, validateOutputFormat(OutputYAML, "status"))
+	require.ErrorContains(t, validateOutputFormat(OutputYAML, "get"), "only supported by status")
+	require.Error(t, validateOutputFormat("xml", "status"))
+}
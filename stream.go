@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// The largest UDP packet stream reads. Frames are a handful of numbers, so this
+// is plenty.
+const maxStreamPacket = 1500
+
+// parseStreamFrame parses a frame of brightness values, from 0 to 100,
+// separated by spaces or commas.
+func parseStreamFrame(s string) ([]int, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\r' || r == '\n'
+	})
+	if len(fields) == 0 {
+		return nil, errors.New("empty frame")
+	}
+
+	values := make([]int, len(fields))
+	for i, field := range fields {
+		v, err := parseBrightness(field)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+
+	return values, nil
+}
+
+// StreamSync sets the brightness of the lights from a fast stream of frames,
+// as sent by something reacting to music or to what's on screen. Each device
+// is sent the newest frame as fast as it takes them, so a slow light skips
+// frames rather than falling behind.
+//
+// A frame with one value sets every light. Otherwise the values go to the
+// lights in order, through each device's lights in turn, and lights past the
+// end of the frame are left as they are. A brightness of 0 turns a light off.
+type StreamSync struct {
+	devices []Device
+
+	// requestTimeout bounds each request to a device.
+	requestTimeout time.Duration
+
+	lgs     []DeviceLightGroup
+	writers []*coalescingWriter
+}
+
+// start reads the lights and starts writing to them, until ctx is done or
+// stop is called.
+func (ss *StreamSync) start(ctx context.Context) error {
+	setupCtx, cancel := context.WithTimeout(ctx, ss.requestTimeout)
+	lgs, err := fetchLightGroups(setupCtx, ss.devices)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	ss.lgs = lgs
+	ss.writers = make([]*coalescingWriter, len(ss.devices))
+	for i, device := range ss.devices {
+		ss.writers[i] = newCoalescingWriter(device, ss.requestTimeout)
+		go ss.writers[i].run(ctx)
+	}
+
+	return nil
+}
+
+// stop waits for the last frame to be written.
+func (ss *StreamSync) stop() {
+	for _, w := range ss.writers {
+		w.flush()
+	}
+}
+
+// apply sets the lights from a frame.
+func (ss *StreamSync) apply(values []int) {
+	n := 0
+	for i, dlg := range ss.lgs {
+		next := dlg.LightGroup.Copy()
+		for _, light := range next.Lights {
+			v := values[0]
+			if len(values) > 1 {
+				if n >= len(values) {
+					break
+				}
+				v = values[n]
+			}
+			n++
+
+			light.On = 0
+			if v > 0 {
+				light.On = 1
+				light.Brightness = max(minBrightness, v)
+			}
+		}
+
+		if !sameLights(dlg.LightGroup, next) {
+			ss.lgs[i].LightGroup = next
+			ss.writers[i].set(next.Copy())
+		}
+	}
+}
+
+// applyFrame parses and applies a frame, skipping one which can't be parsed
+// so that a glitch doesn't end the stream.
+func (ss *StreamSync) applyFrame(frame string) {
+	values, err := parseStreamFrame(frame)
+	if err != nil {
+		automationLog.Debug("Ignoring frame", "error", err)
+		return
+	}
+
+	ss.apply(values)
+}
+
+// read applies a frame from each line of r until it ends or ctx is done.
+func (ss *StreamSync) read(ctx context.Context, r io.Reader) error {
+	if err := ss.start(ctx); err != nil {
+		return err
+	}
+
+	lines := make(chan string)
+	errs := make(chan error, 1)
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+		errs <- scanner.Err()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errs:
+			ss.stop()
+			return err
+		case line := <-lines:
+			ss.applyFrame(line)
+		}
+	}
+}
+
+// receive applies a frame from each packet received on conn until ctx is
+// done.
+func (ss *StreamSync) receive(ctx context.Context, conn net.PacketConn) error {
+	if err := ss.start(ctx); err != nil {
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buf := make([]byte, maxStreamPacket)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to receive frame: %w", err)
+		}
+
+		ss.applyFrame(string(buf[:n]))
+	}
+}
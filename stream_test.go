@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStreamFrame(t *testing.T) {
+	for _, tc := range []struct {
+		frame  string
+		values []int
+		err    bool
+	}{
+		{frame: "50", values: []int{50}},
+		{frame: "0 100\n", values: []int{0, 100}},
+		{frame: "10,20, 30", values: []int{10, 20, 30}},
+		{frame: "", err: true},
+		{frame: "101", err: true},
+		{frame: "-1", err: true},
+		{frame: "loud", err: true},
+	} {
+		values, err := parseStreamFrame(tc.frame)
+		if tc.err {
+			require.Error(t, err, tc.frame)
+			continue
+		}
+		require.NoError(t, err, tc.frame)
+		require.Equal(t, tc.values, values)
+	}
+}
+
+func TestStreamSyncRead(t *testing.T) {
+	a := &FakeDevice{DNSAddr: "a.local", LightGrp: &keylight.LightGroup{Count: 2, Lights: []*keylight.Light{
+		{On: 0, Brightness: 20, Temperature: 200},
+		{On: 0, Brightness: 20, Temperature: 200},
+	}}}
+	b := &FakeDevice{DNSAddr: "b.local", LightGrp: &keylight.LightGroup{Count: 1, Lights: []*keylight.Light{
+		{On: 1, Brightness: 20, Temperature: 250},
+	}}}
+
+	ss := &StreamSync{devices: []Device{a, b}, requestTimeout: time.Minute}
+
+	// The last frame is written once the stream ends, however fast it came
+	frames := "100\nnot a frame\n1 60 0\n"
+	require.NoError(t, ss.read(context.Background(), strings.NewReader(frames)))
+
+	require.Equal(t, []*keylight.Light{
+		{On: 1, Brightness: minBrightness, Temperature: 200},
+		{On: 1, Brightness: 60, Temperature: 200},
+	}, a.LightGrp.Lights)
+	require.Equal(t, []*keylight.Light{
+		{On: 0, Brightness: 100, Temperature: 250},
+	}, b.LightGrp.Lights)
+
+	// Lights past the end of a frame are left alone
+	ss = &StreamSync{devices: []Device{a, b}, requestTimeout: time.Minute}
+	require.NoError(t, ss.read(context.Background(), strings.NewReader("40\n")))
+	require.NoError(t, ss.start(context.Background()))
+	ss.apply([]int{70, 80})
+	ss.stop()
+
+	require.Equal(t, 70, a.LightGrp.Lights[0].Brightness)
+	require.Equal(t, 80, a.LightGrp.Lights[1].Brightness)
+	require.Equal(t, 40, b.LightGrp.Lights[0].Brightness)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+const ipapiURL = "https://ipapi.co/json/"
+
+// How often the lights are moved along with the sun, by default.
+const defaultSunInterval = time.Minute
+
+// The lights are fully at their night values once the sun is this far below
+// the horizon, at the end of civil twilight, and fully at their day values
+// once it's this far above. In between they move smoothly from one to the
+// other, as the light outside does.
+const (
+	sunNightElevation = -6.0
+	sunDayElevation   = 6.0
+)
+
+// solarElevation returns the height of the sun above the horizon, in degrees,
+// at a place and time. It uses the Astronomical Almanac's low precision
+// formulas, which are good to about a hundredth of a degree this century.
+func solarElevation(t time.Time, latitude, longitude float64) float64 {
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	// Days since noon on 1 January 2000
+	n := float64(t.UTC().UnixNano())/float64(24*time.Hour) - 10957.5
+
+	meanLongitude := math.Mod(280.460+0.9856474*n, 360)
+	meanAnomaly := rad(math.Mod(357.528+0.9856003*n, 360))
+	eclipticLongitude := rad(meanLongitude + 1.915*math.Sin(meanAnomaly) + 0.020*math.Sin(2*meanAnomaly))
+	obliquity := rad(23.439 - 0.0000004*n)
+
+	declination := math.Asin(math.Sin(obliquity) * math.Sin(eclipticLongitude))
+	rightAscension := math.Atan2(math.Cos(obliquity)*math.Sin(eclipticLongitude), math.Cos(eclipticLongitude))
+
+	siderealTime := rad(math.Mod(280.46061837+360.98564736629*n, 360) + longitude)
+	hourAngle := siderealTime - rightAscension
+
+	lat := rad(latitude)
+	elevation := math.Asin(math.Sin(lat)*math.Sin(declination) + math.Cos(lat)*math.Cos(declination)*math.Cos(hourAngle))
+
+	return elevation * 180 / math.Pi
+}
+
+// daylight returns how far into the day it is, from 0 at night to 1 in the
+// day, for the sun at an elevation.
+func daylight(elevation float64) float64 {
+	t := max(0, min(1, (elevation-sunNightElevation)/(sunDayElevation-sunNightElevation)))
+
+	// Ease in and out, so there's no sudden start or stop to the change
+	return t * t * (3 - 2*t)
+}
+
+// SunSettings says how the lights follow the sun.
+type SunSettings struct {
+	Latitude, Longitude float64
+
+	// WarmAtNight moves the temperature from DayTemperature to
+	// NightTemperature as the sun sets, and back as it rises.
+	WarmAtNight bool
+	// DayTemperature and NightTemperature are in mireds.
+	DayTemperature, NightTemperature int
+
+	// DayBrightness and NightBrightness, when set, move the brightness in the
+	// same way.
+	DayBrightness, NightBrightness *int
+}
+
+// target returns what the lights should be set to at t.
+func (s SunSettings) target(t time.Time) (LightSettings, float64) {
+	elevation := solarElevation(t, s.Latitude, s.Longitude)
+	day := daylight(elevation)
+
+	var settings LightSettings
+	if s.WarmAtNight {
+		// Moving evenly in Kelvin looks even to people
+		kelvin := lerp(miredToKelvin(s.NightTemperature), miredToKelvin(s.DayTemperature), day)
+		temperature := max(minTemperature, min(maxTemperature, kelvinToMired(kelvin)))
+		settings.Temperature = &temperature
+	}
+
+	if s.DayBrightness != nil && s.NightBrightness != nil {
+		brightness := lerp(*s.NightBrightness, *s.DayBrightness, day)
+		settings.Brightness = &brightness
+	}
+
+	return settings, elevation
+}
+
+// followSun moves the lights along with the sun every interval until ctx is
+// done. When once is set, it stops after the first move. Failures are logged
+// and tried again next time, as lights drop off the network now and then.
+func followSun(ctx context.Context, clock Clock, lightList []Device, settings SunSettings, interval time.Duration, once bool, guards ...LightGuard) (*CommandResult, error) {
+	for {
+		target, elevation := settings.target(clock.Now())
+
+		attrs := []any{"elevation", math.Round(elevation*10) / 10}
+		if target.Temperature != nil {
+			attrs = append(attrs, "temperature", miredToKelvin(*target.Temperature))
+		}
+		if target.Brightness != nil {
+			attrs = append(attrs, "brightness", *target.Brightness)
+		}
+		automationLog.Info("Following the sun", attrs...)
+
+		stepCtx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second+fade)
+		result, err := setLightSettings(stepCtx, lightList, target, guards...)
+		cancel()
+
+		if once {
+			return result, err
+		}
+		if err != nil {
+			automationLog.Error("Failed to follow the sun", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-clock.After(interval):
+		}
+	}
+}
+
+// IPLocator finds roughly where this machine is from its public IP address,
+// with https://ipapi.co, which needs no API key.
+type IPLocator struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+func (il *IPLocator) Locate(ctx context.Context) (latitude, longitude float64, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, il.BaseURL, http.NoBody)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	resp, err := il.Client.Do(req)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to look up location: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, fmt.Errorf("failed to look up location: %s", resp.Status)
+	}
+
+	var body struct {
+		Latitude  *float64 `json:"latitude"`
+		Longitude *float64 `json:"longitude"`
+		Reason    string   `json:"reason"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, 0, fmt.Errorf("failed to decode location: %w", err)
+	}
+
+	if body.Latitude == nil || body.Longitude == nil {
+		if body.Reason == "" {
+			body.Reason = "no coordinates in the response"
+		}
+		return 0, 0, fmt.Errorf("failed to look up location: %s", body.Reason)
+	}
+
+	return *body.Latitude, *body.Longitude, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSolarElevation(t *testing.T) {
+	for _, tc := range []struct {
+		name                string
+		at                  time.Time
+		latitude, longitude float64
+		want                float64
+	}{
+		{"London, midsummer noon", time.Date(2024, time.June, 21, 12, 2, 0, 0, time.UTC), 51.5, -0.13, 61.9},
+		{"London, midsummer midnight", time.Date(2024, time.June, 21, 0, 2, 0, 0, time.UTC), 51.5, -0.13, -15.1},
+		{"Sydney, midsummer noon", time.Date(2024, time.December, 21, 1, 54, 0, 0, time.UTC), -33.87, 151.21, 79.6},
+		{"equator, equinox sunset", time.Date(2024, time.March, 20, 18, 7, 0, 0, time.UTC), 0, 0, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.InDelta(t, tc.want, solarElevation(tc.at, tc.latitude, tc.longitude), 0.5)
+		})
+	}
+}
+
+func TestDaylight(t *testing.T) {
+	require.Equal(t, 0.0, daylight(-30))
+	require.Equal(t, 0.0, daylight(sunNightElevation))
+	require.Equal(t, 0.5, daylight(0))
+	require.Equal(t, 1.0, daylight(sunDayElevation))
+	require.Equal(t, 1.0, daylight(60))
+	require.Less(t, daylight(-5), 1.0/12)
+}
+
+func TestSunTarget(t *testing.T) {
+	day, night := 40, 80
+	settings := SunSettings{
+		Latitude:         51.5,
+		Longitude:        -0.13,
+		WarmAtNight:      true,
+		DayTemperature:   kelvinToMired(5500),
+		NightTemperature: kelvinToMired(3200),
+		DayBrightness:    &day,
+		NightBrightness:  &night,
+	}
+
+	target, _ := settings.target(time.Date(2024, time.June, 21, 12, 0, 0, 0, time.UTC))
+	require.Equal(t, kelvinToMired(5500), *target.Temperature)
+	require.Equal(t, 40, *target.Brightness)
+
+	target, _ = settings.target(time.Date(2024, time.June, 21, 0, 0, 0, 0, time.UTC))
+	require.Equal(t, kelvinToMired(3200), *target.Temperature)
+	require.Equal(t, 80, *target.Brightness)
+
+	settings.WarmAtNight = false
+	settings.DayBrightness, settings.NightBrightness = nil, nil
+	target, _ = settings.target(time.Date(2024, time.June, 21, 0, 0, 0, 0, time.UTC))
+	require.Equal(t, LightSettings{}, target)
+}
+
+func TestFollowSun(t *testing.T) {
+	fake := &FakeDevice{
+		DNSAddr:  "key.local",
+		LightGrp: &keylight.LightGroup{Lights: []*keylight.Light{{On: 1, Brightness: 20, Temperature: 200}}},
+	}
+	clock := newFakeClock()
+
+	// At 09:30 UTC, it's evening on the other side of the world
+	settings := SunSettings{
+		Latitude:         0,
+		Longitude:        180,
+		WarmAtNight:      true,
+		DayTemperature:   kelvinToMired(5500),
+		NightTemperature: kelvinToMired(3200),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := followSun(ctx, clock, []Device{fake}, settings, 12*time.Hour, false)
+		done <- err
+	}()
+
+	clock.WaitForTimers(t, 1)
+	require.Equal(t, kelvinToMired(3200), fake.LightGrp.Lights[0].Temperature)
+
+	clock.Advance(12 * time.Hour)
+	clock.WaitForTimers(t, 1)
+	require.Equal(t, kelvinToMired(5500), fake.LightGrp.Lights[0].Temperature)
+	require.Equal(t, 20, fake.LightGrp.Lights[0].Brightness)
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestIPLocatorLocate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"city": "London", "latitude": 51.5, "longitude": -0.12}`))
+	}))
+	defer server.Close()
+
+	il := &IPLocator{BaseURL: server.URL, Client: server.Client()}
+	latitude, longitude, err := il.Locate(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 51.5, latitude)
+	require.Equal(t, -0.12, longitude)
+}
+
+func TestIPLocatorLocateError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"error": true, "reason": "RateLimited"}`))
+	}))
+	defer server.Close()
+
+	il := &IPLocator{BaseURL: server.URL, Client: server.Client()}
+	_, _, err := il.Locate(context.Background())
+	require.EqualError(t, err, "failed to look up location: RateLimited")
+}
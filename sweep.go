@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Sweep describes a calibration run which steps one field of the lights
+// through a range of values.
+type Sweep struct {
+	Field LightControlField
+	From  int
+	To    int
+	Step  int
+	Dwell time.Duration
+
+	// Kelvin is set when From, To and Step are in Kelvin rather than the
+	// units the light uses. Stepping happens in Kelvin so that the steps are
+	// evenly spaced from the user's point of view.
+	Kelvin bool
+}
+
+// parseSweepValue parses a number, which may have a K suffix to say it is a
+// temperature in Kelvin.
+func parseSweepValue(s string) (value int, kelvin bool, err error) {
+	trimmed := strings.TrimSuffix(strings.TrimSuffix(s, "K"), "k")
+	kelvin = trimmed != s
+
+	value, err = parseIntInRange(trimmed, 0, maxNumber)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid value %q", s)
+	}
+
+	return value, kelvin, nil
+}
+
+func parseSweep(field, from, to, step string) (*Sweep, error) {
+	cf, err := parseLightControlField(field)
+	if err != nil {
+		return nil, err
+	}
+
+	sweep := &Sweep{Field: cf}
+
+	var units []bool
+	for _, v := range []struct {
+		s    string
+		dest *int
+	}{{from, &sweep.From}, {to, &sweep.To}, {step, &sweep.Step}} {
+		value, kelvin, err := parseSweepValue(v.s)
+		if err != nil {
+			return nil, err
+		}
+
+		*v.dest = value
+		units = append(units, kelvin)
+	}
+
+	if units[0] != units[1] || units[1] != units[2] {
+		return nil, fmt.Errorf("from, to and step must all use the same units")
+	}
+	sweep.Kelvin = units[0]
+
+	if sweep.Kelvin && cf != ControlTemperature {
+		return nil, fmt.Errorf("only temperatures can be given in Kelvin")
+	}
+
+	if sweep.Step <= 0 {
+		return nil, fmt.Errorf("step must be positive (got %s)", step)
+	}
+
+	return sweep, nil
+}
+
+// Values returns the device values to set, in order. The last value is always
+// To, even if the range isn't a multiple of Step.
+func (s *Sweep) Values() []int {
+	step := s.Step
+	if s.To < s.From {
+		step = -step
+	}
+
+	var values []int
+	for v := s.From; (step > 0 && v < s.To) || (step < 0 && v > s.To); v += step {
+		values = append(values, v)
+	}
+	values = append(values, s.To)
+
+	if s.Kelvin {
+		for i, v := range values {
+			values[i] = kelvinToMired(v)
+		}
+	}
+
+	return values
+}
+
+// runSweep steps the lights through the sweep, holding each value for the
+// dwell time. The original state of the lights is restored afterwards, even if
+// the sweep is interrupted. The lights are only fetched once, at the start;
+// each step is worked out from the state left by the one before.
+func runSweep(ctx context.Context, lightList []Device, sweep *Sweep) error {
+	unlock, err := acquireDeviceLocks(ctx, lightList)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	fetchCtx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	original, err := fetchLightGroups(fetchCtx, lightList)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	current := make([]DeviceLightGroup, len(original))
+	for i, dlg := range original {
+		current[i] = DeviceLightGroup{dlg.Device, dlg.LightGroup.Copy()}
+	}
+	defer restoreLightGroups(context.WithoutCancel(ctx), original)
+
+	for _, value := range sweep.Values() {
+		automationLog.Info("Sweeping", "field", sweep.Field, "value", value)
+
+		stepCtx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		_, err := applyLightControlField(stepCtx, newCommandResult(), current, sweep.Field, value)
+		cancel()
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(sweep.Dwell):
+		}
+	}
+
+	return nil
+}
+
+func restoreLightGroups(ctx context.Context, lgs []DeviceLightGroup) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	for _, dlg := range lgs {
+		automationLog.Debug("Restoring light group", "address", dlg.Device.GetDNSAddr())
+		if _, err := dlg.Device.UpdateLightGroup(ctx, dlg.LightGroup); err != nil {
+			automationLog.Error("Failed to restore light group", "address", dlg.Device.GetDNSAddr(), "error", err)
+		}
+	}
+}
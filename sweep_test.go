@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSweep(t *testing.T) {
+	sweep, err := parseSweep("temperature", "2900K", "7000K", "200K")
+	require.NoError(t, err)
+	require.Equal(t, &Sweep{Field: ControlTemperature, From: 2900, To: 7000, Step: 200, Kelvin: true}, sweep)
+
+	sweep, err = parseSweep("brightness", "100", "0", "25")
+	require.NoError(t, err)
+	require.Equal(t, []int{100, 75, 50, 25, 0}, sweep.Values())
+
+	for _, args := range [][]string{
+		{"colour", "1", "2", "1"},
+		{"temperature", "2900K", "344", "10"},
+		{"brightness", "10K", "20K", "1K"},
+		{"brightness", "10", "20", "0"},
+		{"brightness", "ten", "20", "1"},
+	} {
+		_, err := parseSweep(args[0], args[1], args[2], args[3])
+		require.Error(t, err, args)
+	}
+}
+
+func TestSweepValues(t *testing.T) {
+	sweep := &Sweep{Field: ControlTemperature, From: 5000, To: 5500, Step: 200, Kelvin: true}
+	require.Equal(t, []int{200, 192, 185, 182}, sweep.Values())
+}
+
+func TestRunSweep(t *testing.T) {
+	device := &FakeDevice{
+		DNSAddr: "192.168.1.1",
+		LightGrp: &keylight.LightGroup{Lights: []*keylight.Light{
+			{On: 1, Brightness: 50, Temperature: 200},
+		}},
+	}
+
+	sweep := &Sweep{Field: ControlBrightness, From: 10, To: 30, Step: 10}
+	require.NoError(t, runSweep(context.Background(), []Device{device}, sweep))
+
+	// The original state is put back afterwards
+	require.Equal(t, 50, device.LightGrp.Lights[0].Brightness)
+	require.Equal(t, 1, device.LightGroupFetches)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	sweep.Dwell = time.Hour
+	require.ErrorIs(t, runSweep(ctx, []Device{device}, sweep), context.Canceled)
+	require.Equal(t, 50, device.LightGrp.Lights[0].Brightness)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// TemperatureUnit is the unit temperatures are shown and given in.
+//
+// Lights work in mireds, so converting to Kelvin and back is a reciprocal
+// which can't be exact: kelvinToMired and miredToKelvin both round to the
+// nearest whole unit, with halves rounded away from zero. A mired is about
+// 25K at the cool end of the range and 8K at the warm end, so a Kelvin value
+// may not survive the round trip. Mireds are what the light actually uses, so
+// they always do.
+type TemperatureUnit string
+
+const (
+	UnitKelvin TemperatureUnit = "kelvin"
+	UnitMired  TemperatureUnit = "mired"
+)
+
+// The suffixes which mark a temperature as being in a particular unit,
+// whatever the --temperature-unit. Longer suffixes come first, since "mired"
+// would otherwise be mistaken for a number followed by "d".
+var temperatureSuffixes = []struct {
+	suffix string
+	unit   TemperatureUnit
+}{
+	{"mireds", UnitMired},
+	{"mired", UnitMired},
+	{"k", UnitKelvin},
+}
+
+func parseTemperatureUnit(s string) (TemperatureUnit, error) {
+	switch u := TemperatureUnit(strings.ToLower(s)); u {
+	case UnitKelvin, UnitMired:
+		return u, nil
+	}
+
+	return "", fmt.Errorf("invalid temperature unit %q, must be one of kelvin or mired", s)
+}
+
+// Format renders a temperature reported by a light in the unit.
+func (u TemperatureUnit) Format(mired int) string {
+	if u == UnitMired {
+		return fmt.Sprintf("%d mired", mired)
+	}
+
+	return fmt.Sprintf("%dK", miredToKelvin(mired))
+}
+
+// Convert converts a temperature reported by a light into the unit.
+func (u TemperatureUnit) Convert(mired int) int {
+	if u == UnitKelvin {
+		return miredToKelvin(mired)
+	}
+
+	return mired
+}
+
+// parseTemperature parses a temperature for a light, returning it in mireds.
+// A "K" or "mired" suffix says which unit the value is in; otherwise it is in
+// unit, or in the light's own units (mireds) if unit is empty.
+func parseTemperature(s string, unit TemperatureUnit) (int, error) {
+	value, unit := splitTemperatureUnit(s, unit)
+
+	f, err := parsePositiveNumber(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid temperature %q", s)
+	}
+
+	if unit == UnitKelvin {
+		return int(math.Round(miredsPerKelvin / f)), nil
+	}
+
+	return int(math.Round(f)), nil
+}
+
+// splitTemperatureUnit separates a temperature's number from its unit suffix,
+// if it has one. Without one, the unit is the one given.
+func splitTemperatureUnit(s string, unit TemperatureUnit) (string, TemperatureUnit) {
+	value := strings.ToLower(strings.TrimSpace(s))
+	for _, ts := range temperatureSuffixes {
+		if trimmed := strings.TrimSuffix(value, ts.suffix); trimmed != value {
+			return strings.TrimSpace(trimmed), ts.unit
+		}
+	}
+
+	return value, unit
+}
+
+// parseTemperatureChange parses a relative temperature such as "+500K" or
+// "-20", returning a function which applies it to a temperature in mireds.
+// Changes are in the same units as parseTemperature takes.
+func parseTemperatureChange(s string, unit TemperatureUnit) (func(mired int) int, error) {
+	value, unit := splitTemperatureUnit(s, unit)
+
+	change, err := parseChange(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid temperature change %q", s)
+	}
+
+	if unit == UnitKelvin {
+		return func(mired int) int { return kelvinToMired(max(1, miredToKelvin(mired)+change)) }, nil
+	}
+
+	return func(mired int) int { return mired + change }, nil
+}
+
+// statusTemperatureUnit is the unit status shows temperatures in.
+func statusTemperatureUnit() TemperatureUnit {
+	if temperatureUnit == "" {
+		return UnitKelvin
+	}
+
+	return temperatureUnit
+}
+
+// validateTemperature checks that a temperature, in mireds, is one the lights
+// support.
+func validateTemperature(mired int) error {
+	if mired < minTemperature || mired > maxTemperature {
+		return fmt.Errorf("temperature %s is out of range, it must be between %s and %s (%d to %d mired)",
+			UnitKelvin.Format(mired), UnitKelvin.Format(maxTemperature), UnitKelvin.Format(minTemperature),
+			minTemperature, maxTemperature)
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTemperature(t *testing.T) {
+	tests := []struct {
+		in      string
+		unit    TemperatureUnit
+		want    int
+		wantErr bool
+	}{
+		{in: "200", want: 200},
+		{in: "200", unit: UnitMired, want: 200},
+		{in: "5000", unit: UnitKelvin, want: 200},
+		{in: "200mired", unit: UnitKelvin, want: 200},
+		{in: "200 mireds", want: 200},
+		{in: "199.5", want: 200},
+		{in: "3200", unit: UnitKelvin, want: 313},
+		{in: "5000K", want: 200},
+		{in: "5000 k", unit: UnitMired, want: 200},
+		{in: "warm", wantErr: true},
+		{in: "-5", wantErr: true},
+		{in: "mired", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseTemperature(tt.in, tt.unit)
+		if tt.wantErr {
+			require.Error(t, err, tt.in)
+			continue
+		}
+
+		require.NoError(t, err, tt.in)
+		require.Equal(t, tt.want, got, tt.in)
+	}
+}
+
+func TestTemperatureUnit(t *testing.T) {
+	u, err := parseTemperatureUnit("Mired")
+	require.NoError(t, err)
+	require.Equal(t, UnitMired, u)
+
+	_, err = parseTemperatureUnit("celsius")
+	require.Error(t, err)
+
+	require.Equal(t, "200 mired", UnitMired.Format(200))
+	require.Equal(t, "5000K", UnitKelvin.Format(200))
+	require.Equal(t, 5000, UnitKelvin.Convert(200))
+	require.Equal(t, 200, UnitMired.Convert(200))
+}
+
+func TestValidateTemperature(t *testing.T) {
+	require.NoError(t, validateTemperature(minTemperature))
+	require.NoError(t, validateTemperature(maxTemperature))
+	require.EqualError(t, validateTemperature(100),
+		"temperature 10000K is out of range, it must be between 2907K and 6993K (143 to 344 mired)")
+	require.Error(t, validateTemperature(345))
+}
+
+func TestParseTemperatureChange(t *testing.T) {
+	change, err := parseTemperatureChange("+20", "")
+	require.NoError(t, err)
+	require.Equal(t, 220, change(200))
+
+	// 5000K less 500K is 4500K, or 222 mired
+	change, err = parseTemperatureChange("-500K", UnitMired)
+	require.NoError(t, err)
+	require.Equal(t, 222, change(200))
+
+	change, err = parseTemperatureChange("+500", UnitKelvin)
+	require.NoError(t, err)
+	require.Equal(t, 182, change(200))
+
+	_, err = parseTemperatureChange("+warmer", "")
+	require.Error(t, err)
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// countdown waits for after to pass. When w is set, the time left is shown on
+// it, updated every second.
+func countdown(ctx context.Context, clock Clock, after time.Duration, w io.Writer, label string) error {
+	at := clock.Now().Add(after)
+
+	for {
+		remaining := at.Sub(clock.Now())
+		if remaining <= 0 {
+			if w != nil {
+				fmt.Fprint(w, "\r\x1b[K")
+			}
+			return nil
+		}
+
+		if w != nil {
+			fmt.Fprintf(w, "\r\x1b[K%s in %s", label, remaining.Round(time.Second))
+		}
+
+		select {
+		case <-ctx.Done():
+			if w != nil {
+				fmt.Fprintln(w)
+			}
+			return ctx.Err()
+		case <-clock.After(min(remaining, time.Second)):
+		}
+	}
+}
+
+// TimerRequest asks a server to turn lights on or off after a delay.
+type TimerRequest struct {
+	State string `json:"state"`
+	After string `json:"after"`
+
+	// Lights are names or addresses of lights. All of them are used when
+	// there are none.
+	Lights []string `json:"lights,omitempty"`
+}
+
+// ScheduledTimer is a timer a server is waiting on.
+type ScheduledTimer struct {
+	ID     int       `json:"id"`
+	State  string    `json:"state"`
+	At     time.Time `json:"at"`
+	Lights []string  `json:"lights"`
+}
+
+func (st ScheduledTimer) String() string {
+	return fmt.Sprintf("Turning %s %s at %s", strings.Join(st.Lights, ", "), st.State, st.At.Local().Format(time.TimeOnly))
+}
+
+// Timers runs the timers scheduled on a server. They're only kept in memory,
+// so they're lost if the server stops.
+type Timers struct {
+	ctx   context.Context
+	clock Clock
+
+	// timeout bounds the device calls made when a timer fires.
+	timeout time.Duration
+
+	mu      sync.Mutex
+	nextID  int
+	pending map[int]ScheduledTimer
+}
+
+// newTimers returns Timers whose timers run until ctx is done.
+func newTimers(ctx context.Context, clock Clock, timeout time.Duration) *Timers {
+	return &Timers{
+		ctx:     ctx,
+		clock:   clock,
+		timeout: timeout,
+		pending: map[int]ScheduledTimer{},
+	}
+}
+
+// schedule sets the power of the devices to state once after has passed.
+func (t *Timers) schedule(devices []Device, state LightState, after time.Duration) ScheduledTimer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.nextID++
+	timer := ScheduledTimer{
+		ID:    t.nextID,
+		State: state.String(),
+		At:    t.clock.Now().Add(after),
+	}
+	for _, device := range devices {
+		timer.Lights = append(timer.Lights, deviceLabel(device))
+	}
+	t.pending[timer.ID] = timer
+
+	go func() {
+		defer func() {
+			t.mu.Lock()
+			delete(t.pending, timer.ID)
+			t.mu.Unlock()
+		}()
+
+		if err := waitUntil(t.ctx, t.clock, timer.At); err != nil {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(t.ctx, t.timeout)
+		defer cancel()
+
+		apiLog.Info("Timer fired", "id", timer.ID, "state", timer.State, "lights", len(devices))
+		if _, err := setLightState(ctx, devices, state); err != nil {
+			apiLog.Error("Timer failed", "id", timer.ID, "error", err)
+		}
+	}()
+
+	return timer
+}
+
+// list returns the timers which haven't fired yet, soonest first.
+func (t *Timers) list() []ScheduledTimer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	timers := make([]ScheduledTimer, 0, len(t.pending))
+	for _, timer := range t.pending {
+		timers = append(timers, timer)
+	}
+	sort.Slice(timers, func(i, j int) bool {
+		if !timers[i].At.Equal(timers[j].At) {
+			return timers[i].At.Before(timers[j].At)
+		}
+		return timers[i].ID < timers[j].ID
+	})
+
+	return timers
+}
+
+// deviceLabel is how a device is named to people: its name, or its address
+// if it has none.
+func deviceLabel(device Device) string {
+	if device.GetName() != "" {
+		return device.GetName()
+	}
+
+	return device.GetDNSAddr()
+}
+
+// parseTimerRequest checks a request to schedule a timer, returning the state
+// and delay it asks for.
+func parseTimerRequest(req TimerRequest) (LightState, time.Duration, error) {
+	var state LightState
+	switch req.State {
+	case LightOn.String():
+		state = LightOn
+	case LightOff.String():
+		state = LightOff
+	default:
+		return 0, 0, fmt.Errorf("state must be %s or %s (got %q)", LightOn, LightOff, req.State)
+	}
+
+	after, err := parseNonNegativeDuration(req.After)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid delay: %w", err)
+	}
+
+	return state, after, nil
+}
+
+func (s *APIServer) routeTimers(r *http.Request) (any, error) {
+	if s.timers == nil {
+		return nil, apiErrorf(http.StatusNotFound, "timers aren't enabled")
+	}
+
+	if r.Method == http.MethodGet {
+		return s.timers.list(), nil
+	}
+
+	if err := requireMethod(r, http.MethodPost); err != nil {
+		return nil, err
+	}
+
+	var req TimerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, apiErrorf(http.StatusBadRequest, "invalid timer: %v", err)
+	}
+
+	state, after, err := parseTimerRequest(req)
+	if err != nil {
+		return nil, apiErrorf(http.StatusBadRequest, "%v", err)
+	}
+
+	devices := s.devices
+	if len(req.Lights) > 0 {
+		devices = nil
+		for _, light := range req.Lights {
+			found, err := s.lookup(light)
+			if err != nil {
+				return nil, err
+			}
+			devices = append(devices, found...)
+		}
+	}
+
+	timer := s.timers.schedule(devices, state, after)
+	apiLog.Info("Scheduled timer", "id", timer.ID, "state", timer.State, "at", timer.At)
+
+	return timer, nil
+}
+
+// scheduleOnServer asks a klctl server to turn the devices on or off after a
+// delay, rather than waiting here.
+func scheduleOnServer(ctx context.Context, server string, settings HTTPSettings, devices []Device, state LightState, after time.Duration) (*ScheduledTimer, error) {
+	base, err := serverURL(ctx, server)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := settings.client()
+	if err != nil {
+		return nil, err
+	}
+
+	req := TimerRequest{State: state.String(), After: after.String()}
+	for _, device := range devices {
+		req.Lights = append(req.Lights, device.GetDNSAddr())
+	}
+
+	hd := &HTTPDevice{client: client, requestTimeout: settings.RequestTimeout, baseURL: base}
+	timer := &ScheduledTimer{}
+	if err := hd.do(ctx, http.MethodPost, "timers", req, timer); err != nil {
+		return nil, fmt.Errorf("scheduling on %s: %w", server, err)
+	}
+
+	return timer, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCountdown(t *testing.T) {
+	clock := newFakeClock()
+
+	var out bytes.Buffer
+	done := make(chan error)
+	go func() { done <- countdown(context.Background(), clock, 2500*time.Millisecond, &out, "Turning off") }()
+
+	for i := 0; i < 3; i++ {
+		clock.WaitForTimers(t, 1)
+		clock.Advance(time.Second)
+	}
+	require.NoError(t, <-done)
+
+	require.Equal(t, "\r\x1b[KTurning off in 3s\r\x1b[KTurning off in 2s\r\x1b[KTurning off in 1s\r\x1b[K", out.String())
+}
+
+func TestCountdownCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	require.ErrorIs(t, countdown(ctx, newFakeClock(), time.Hour, nil, "Turning off"), context.Canceled)
+}
+
+func TestAPIServerTimers(t *testing.T) {
+	server, fakes := newTestAPIServer()
+
+	status, _ := doRequest(t, server, http.MethodGet, "/timers", "")
+	require.Equal(t, http.StatusNotFound, status)
+
+	clock := newFakeClock()
+	server.timers = newTimers(context.Background(), clock, time.Second)
+
+	status, body := doRequest(t, server, http.MethodPost, "/timers", `{"state": "on", "after": "30m", "lights": ["key-left"]}`)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, float64(1), body["id"])
+	require.Equal(t, []any{"key-left"}, body["lights"])
+	require.Equal(t, clock.Now().Add(30*time.Minute).Format(time.RFC3339), body["at"])
+
+	require.Len(t, server.timers.list(), 1)
+
+	clock.WaitForTimers(t, 1)
+	clock.Advance(30 * time.Minute)
+	require.Eventually(t, func() bool { return len(server.timers.list()) == 0 }, time.Second, time.Millisecond)
+
+	require.Equal(t, 1, fakes[0].LightGrp.Lights[0].On)
+	require.Equal(t, 0, fakes[1].LightGrp.Lights[0].On)
+
+	for _, test := range []struct {
+		body   string
+		status int
+	}{
+		{`{"state": "toggle", "after": "1m"}`, http.StatusBadRequest},
+		{`{"state": "off", "after": "soon"}`, http.StatusBadRequest},
+		{`{"state": "off", "after": "-1m"}`, http.StatusBadRequest},
+		{`{"state": "off", "after": "1m", "lights": ["key-middle"]}`, http.StatusNotFound},
+		{`nope`, http.StatusBadRequest},
+	} {
+		status, _ := doRequest(t, server, http.MethodPost, "/timers", test.body)
+		require.Equal(t, test.status, status, test.body)
+	}
+
+	status, _ = doRequest(t, server, http.MethodDelete, "/timers", "")
+	require.Equal(t, http.StatusMethodNotAllowed, status)
+}
+
+func TestScheduleOnServer(t *testing.T) {
+	server, _ := newTestAPIServer()
+	clock := newFakeClock()
+	server.timers = newTimers(context.Background(), clock, time.Second)
+
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	ctx := context.Background()
+	devices, err := serverDevices(ctx, srv.URL, HTTPSettings{}, nil)
+	require.NoError(t, err)
+
+	timer, err := scheduleOnServer(ctx, srv.URL, HTTPSettings{}, devices, LightOff, time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, "off", timer.State)
+	require.Equal(t, []string{"key-left", "key-right"}, timer.Lights)
+	require.True(t, timer.At.Equal(clock.Now().Add(time.Hour)))
+	require.Equal(t, "Turning key-left, key-right off at "+timer.At.Local().Format(time.TimeOnly), timer.String())
+}
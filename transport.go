@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/iainlane/klctl/pkg/keylightctl"
+)
+
+// HTTPSettings tune how klctl talks to a light, for networks where the
+// defaults don't suit, such as lights across Wi-Fi mesh hops or a VPN. Zero
+// values leave Go's defaults alone.
+type HTTPSettings struct {
+	// ConnectTimeout bounds establishing the TCP connection.
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+
+	// RequestTimeout bounds each HTTP request, including reading the
+	// response. It applies on top of the command's --timeout.
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+
+	// KeepAlive is the TCP keepalive period. Negative disables keepalives.
+	KeepAlive time.Duration `yaml:"keepalive"`
+
+	// Proxy is the URL of a proxy to reach the light through, e.g.
+	// socks5://localhost:1080 for an SSH tunnel made with ssh -D. http,
+	// https and socks5 proxies are supported.
+	Proxy string `yaml:"proxy"`
+
+	// UserAgent replaces Go's User-Agent in requests.
+	UserAgent string `yaml:"user_agent"`
+
+	// Headers are added to every request, for reverse proxies in front of
+	// lights which route or authorise requests by them. A Host header sets
+	// the host the request is for.
+	Headers map[string]string `yaml:"headers"`
+}
+
+var proxySchemes = []string{"http", "https", "socks5"}
+
+// parseProxy parses and checks a proxy URL.
+func parseProxy(proxy string) (*url.URL, error) {
+	u, err := url.Parse(proxy)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q, expected e.g. socks5://localhost:1080", proxy)
+	}
+
+	for _, scheme := range proxySchemes {
+		if u.Scheme == scheme {
+			return u, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported proxy scheme %q in %s, must be one of %s", u.Scheme, proxy, strings.Join(proxySchemes, ", "))
+}
+
+// headerNameChars are the characters header names are made of.
+var headerNameChars = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// validate checks the settings can be used.
+func (s HTTPSettings) validate() error {
+	for name, value := range s.Headers {
+		if !headerNameChars.MatchString(name) {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("header %s can't contain a line break", name)
+		}
+	}
+
+	if s.Proxy == "" {
+		return nil
+	}
+
+	_, err := parseProxy(s.Proxy)
+	return err
+}
+
+func (s HTTPSettings) isZero() bool {
+	return s.ConnectTimeout == 0 && s.RequestTimeout == 0 && s.KeepAlive == 0 &&
+		s.Proxy == "" && s.UserAgent == "" && len(s.Headers) == 0
+}
+
+// merge returns s with any non-zero settings from override applied.
+func (s HTTPSettings) merge(override HTTPSettings) HTTPSettings {
+	if override.ConnectTimeout != 0 {
+		s.ConnectTimeout = override.ConnectTimeout
+	}
+	if override.RequestTimeout != 0 {
+		s.RequestTimeout = override.RequestTimeout
+	}
+	if override.KeepAlive != 0 {
+		s.KeepAlive = override.KeepAlive
+	}
+	if override.Proxy != "" {
+		s.Proxy = override.Proxy
+	}
+	if override.UserAgent != "" {
+		s.UserAgent = override.UserAgent
+	}
+
+	// Headers are merged, rather than replaced, so a light can add to the
+	// defaults
+	if len(override.Headers) > 0 {
+		headers := make(map[string]string, len(s.Headers)+len(override.Headers))
+		for name, value := range s.Headers {
+			headers[http.CanonicalHeaderKey(name)] = value
+		}
+		for name, value := range override.Headers {
+			headers[http.CanonicalHeaderKey(name)] = value
+		}
+		s.Headers = headers
+	}
+
+	return s
+}
+
+// parseHeader parses a header given as Name: value.
+func parseHeader(header string) (string, string, error) {
+	name, value, ok := strings.Cut(header, ":")
+	if !ok || !headerNameChars.MatchString(strings.TrimSpace(name)) {
+		return "", "", fmt.Errorf("invalid header %q, expected e.g. \"X-Auth: secret\"", header)
+	}
+
+	return strings.TrimSpace(name), strings.TrimSpace(value), nil
+}
+
+// headerTransport adds the User-Agent and headers from HTTPSettings to each
+// request.
+type headerTransport struct {
+	base      http.RoundTripper
+	userAgent string
+	headers   map[string]string
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers mustn't change the request they're given
+	req = req.Clone(req.Context())
+
+	if t.userAgent != "" {
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	for name, value := range t.headers {
+		if http.CanonicalHeaderKey(name) == "Host" {
+			req.Host = value
+			continue
+		}
+		req.Header.Set(name, value)
+	}
+
+	return t.base.RoundTrip(req)
+}
+
+func (s HTTPSettings) client() (*http.Client, error) {
+	dialer := &net.Dialer{
+		Timeout:   s.ConnectTimeout,
+		KeepAlive: s.KeepAlive,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = newAddressProber(dialer, addressBook).DialContext
+
+	if s.Proxy != "" {
+		proxy, err := parseProxy(s.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	if s.UserAgent == "" && len(s.Headers) == 0 {
+		return &http.Client{Transport: transport}, nil
+	}
+
+	return &http.Client{Transport: &headerTransport{base: transport, userAgent: s.UserAgent, headers: s.Headers}}, nil
+}
+
+// HTTPDevice talks to a light with its own HTTP client, configured by
+// HTTPSettings. keylight.Device creates a default client for every request,
+// which can't be tuned, so this makes the same requests itself.
+type HTTPDevice struct {
+	Device
+
+	client         *http.Client
+	requestTimeout time.Duration
+
+	// baseURL is where requests are sent. When it's empty, they go to the
+	// device itself.
+	baseURL string
+}
+
+// withHTTPSettings gives device an HTTP client with settings. Lights known by
+// a name get one even without settings, to try each of their addresses.
+func withHTTPSettings(device Device, settings HTTPSettings) (Device, error) {
+	_, isLight := device.(KeylightDevice)
+	if settings.isZero() && (!isLight || isIPAddress(device.GetDNSAddr())) {
+		return device, nil
+	}
+
+	client, err := settings.client()
+	if err != nil {
+		return nil, err
+	}
+
+	return &HTTPDevice{
+		Device:         device,
+		client:         client,
+		requestTimeout: settings.RequestTimeout,
+	}, nil
+}
+
+func (hd *HTTPDevice) do(ctx context.Context, method, path string, body, target any) error {
+	if hd.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, hd.requestTimeout)
+		defer cancel()
+	}
+
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	base := hd.baseURL
+	if base == "" {
+		base = "http://" + net.JoinHostPort(hd.GetDNSAddr(), strconv.Itoa(hd.GetPort()))
+	}
+
+	url := base + "/" + path
+	req, err := http.NewRequestWithContext(ctx, method, url, &reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := hd.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s", method, url, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+func (hd *HTTPDevice) FetchDeviceInfo(ctx context.Context) (*keylight.DeviceInfo, error) {
+	info := &keylight.DeviceInfo{}
+	err := hd.do(ctx, http.MethodGet, "elgato/accessory-info", nil, info)
+	return info, err
+}
+
+func (hd *HTTPDevice) FetchSettings(ctx context.Context) (*keylight.DeviceSettings, error) {
+	settings := &keylight.DeviceSettings{}
+	err := hd.do(ctx, http.MethodGet, "elgato/lights/settings", nil, settings)
+	return settings, err
+}
+
+func (hd *HTTPDevice) UpdateSettings(ctx context.Context, settings *keylight.DeviceSettings) (*keylight.DeviceSettings, error) {
+	updated := &keylight.DeviceSettings{}
+	err := hd.do(ctx, http.MethodPut, "elgato/lights/settings", settings, updated)
+	return updated, err
+}
+
+func (hd *HTTPDevice) FetchLightGroup(ctx context.Context) (*keylight.LightGroup, error) {
+	lg := &keylight.LightGroup{Lights: []*keylight.Light{}}
+	err := hd.do(ctx, http.MethodGet, "elgato/lights", nil, lg)
+	return lg, err
+}
+
+func (hd *HTTPDevice) UpdateLightGroup(ctx context.Context, lg *keylight.LightGroup) (*keylight.LightGroup, error) {
+	updated := &keylight.LightGroup{Lights: []*keylight.Light{}}
+	err := hd.do(ctx, http.MethodPut, "elgato/lights", lg, updated)
+	return updated, err
+}
+
+func (hd *HTTPDevice) FetchWifiInfo(ctx context.Context) (*WifiInfo, error) {
+	var info struct {
+		Wifi *WifiInfo `json:"wifi-info"`
+	}
+	err := hd.do(ctx, http.MethodGet, "elgato/accessory-info", nil, &info)
+	return info.Wifi, err
+}
+
+// errColorThroughServer is returned for colours through a klctl server, whose
+// API only carries white light.
+var errColorThroughServer = errors.New("colours can't be controlled through a klctl server")
+
+func (hd *HTTPDevice) FetchColors(ctx context.Context) (*ColorGroup, error) {
+	if hd.baseURL != "" {
+		return nil, errColorThroughServer
+	}
+
+	cg := &ColorGroup{Lights: []*Color{}}
+	err := hd.do(ctx, http.MethodGet, "elgato/lights", nil, cg)
+	return cg, err
+}
+
+func (hd *HTTPDevice) UpdateColors(ctx context.Context, cg *ColorGroup) (*ColorGroup, error) {
+	if hd.baseURL != "" {
+		return nil, errColorThroughServer
+	}
+
+	updated := &ColorGroup{Lights: []*Color{}}
+	err := hd.do(ctx, http.MethodPut, "elgato/lights", cg, updated)
+	return updated, err
+}
+
+func (hd *HTTPDevice) RawRequest(ctx context.Context, method, path string, body []byte) (*RawResponse, error) {
+	// A klctl server's API isn't the device's, so there's nothing to pass the
+	// request through to
+	if hd.baseURL != "" {
+		return nil, errors.New("raw requests can't be sent through a klctl server")
+	}
+
+	if hd.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, hd.requestTimeout)
+		defer cancel()
+	}
+
+	base := "http://" + net.JoinHostPort(hd.GetDNSAddr(), strconv.Itoa(hd.GetPort()))
+	req, err := keylightctl.NewRawRequest(ctx, base, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := hd.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return keylightctl.ReadRawResponse(resp)
+}
+
+var _ Device = &HTTPDevice{}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPSettingsMerge(t *testing.T) {
+	defaults := HTTPSettings{ConnectTimeout: time.Second, RequestTimeout: 2 * time.Second}
+	merged := defaults.merge(HTTPSettings{RequestTimeout: 10 * time.Second, KeepAlive: -1})
+
+	require.Equal(t, HTTPSettings{ConnectTimeout: time.Second, RequestTimeout: 10 * time.Second, KeepAlive: -1}, merged)
+	require.True(t, HTTPSettings{}.isZero())
+
+	merged = defaults.merge(HTTPSettings{Proxy: "socks5://localhost:1080"})
+	require.Equal(t, "socks5://localhost:1080", merged.Proxy)
+	require.False(t, HTTPSettings{Proxy: "socks5://localhost:1080"}.isZero())
+}
+
+func TestHTTPSettingsHeaders(t *testing.T) {
+	defaults := HTTPSettings{UserAgent: "klctl", Headers: map[string]string{"x-auth": "one", "X-Site": "home"}}
+	merged := defaults.merge(HTTPSettings{Headers: map[string]string{"X-Auth": "two"}})
+
+	require.Equal(t, "klctl", merged.UserAgent)
+	require.Equal(t, map[string]string{"X-Auth": "two", "X-Site": "home"}, merged.Headers)
+	require.False(t, HTTPSettings{Headers: map[string]string{"X-Auth": "one"}}.isZero())
+
+	require.Error(t, HTTPSettings{Headers: map[string]string{"X Auth": "one"}}.validate())
+	require.Error(t, HTTPSettings{Headers: map[string]string{"X-Auth": "one\r\nX-Other: two"}}.validate())
+
+	name, value, err := parseHeader("X-Auth:  secret ")
+	require.NoError(t, err)
+	require.Equal(t, "X-Auth", name)
+	require.Equal(t, "secret", value)
+
+	_, _, err = parseHeader("X-Auth secret")
+	require.Error(t, err)
+}
+
+func TestHTTPDeviceHeaders(t *testing.T) {
+	var got *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		require.NoError(t, json.NewEncoder(w).Encode(keylight.LightGroup{Count: 1, Lights: []*keylight.Light{{On: 1}}}))
+	}))
+	defer srv.Close()
+
+	host, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	require.NoError(t, err)
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+
+	settings := HTTPSettings{UserAgent: "klctl-test", Headers: map[string]string{"X-Auth": "secret", "Host": "light.example.com"}}
+	device, err := withHTTPSettings(KeylightDevice{Device: &keylight.Device{DNSAddr: host, Port: p}}, settings)
+	require.NoError(t, err)
+
+	_, err = device.FetchLightGroup(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "klctl-test", got.UserAgent())
+	require.Equal(t, "secret", got.Header.Get("X-Auth"))
+	require.Equal(t, "light.example.com", got.Host)
+}
+
+func TestParseProxy(t *testing.T) {
+	for _, proxy := range []string{"http://proxy:3128", "https://proxy:443", "socks5://localhost:1080"} {
+		_, err := parseProxy(proxy)
+		require.NoError(t, err, proxy)
+	}
+
+	for _, proxy := range []string{"localhost:1080", "ftp://proxy", "socks5://", "::"} {
+		_, err := parseProxy(proxy)
+		require.Error(t, err, proxy)
+	}
+}
+
+func TestHTTPDeviceProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.Host+r.URL.Path)
+		require.NoError(t, json.NewEncoder(w).Encode(keylight.LightGroup{Count: 1, Lights: []*keylight.Light{{On: 1}}}))
+	}))
+	defer proxy.Close()
+
+	// The light's address isn't reachable, only the proxy is
+	base := KeylightDevice{Device: &keylight.Device{DNSAddr: "192.0.2.1", Port: 9123}}
+	device, err := withHTTPSettings(base, HTTPSettings{Proxy: proxy.URL})
+	require.NoError(t, err)
+
+	lg, err := device.FetchLightGroup(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, lg.Lights[0].On)
+	require.Equal(t, []string{"192.0.2.1:9123/elgato/lights"}, proxied)
+
+	_, err = withHTTPSettings(base, HTTPSettings{Proxy: "gopher://proxy"})
+	require.Error(t, err)
+}
+
+func TestHTTPDevice(t *testing.T) {
+	lights := &keylight.LightGroup{Count: 1, Lights: []*keylight.Light{{On: 0, Brightness: 20, Temperature: 200}}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/elgato/accessory-info", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewEncoder(w).Encode(keylight.DeviceInfo{SerialNumber: "BW33J1A00000"}))
+	})
+	mux.HandleFunc("/elgato/lights", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			require.NoError(t, json.NewDecoder(r.Body).Decode(lights))
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(lights))
+	})
+	mux.HandleFunc("/elgato/lights/settings", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		require.NoError(t, json.NewEncoder(w).Encode(keylight.DeviceSettings{}))
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	host, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	require.NoError(t, err)
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+
+	base := KeylightDevice{Device: &keylight.Device{DNSAddr: host, Port: p}}
+	unchanged, err := withHTTPSettings(base, HTTPSettings{})
+	require.NoError(t, err)
+	require.Equal(t, base, unchanged)
+
+	device, err := withHTTPSettings(base, HTTPSettings{RequestTimeout: 50 * time.Millisecond, KeepAlive: -1})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	info, err := device.FetchDeviceInfo(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "BW33J1A00000", info.SerialNumber)
+
+	lg, err := device.FetchLightGroup(ctx)
+	require.NoError(t, err)
+	lg.Lights[0].On = 1
+
+	updated, err := device.UpdateLightGroup(ctx, lg)
+	require.NoError(t, err)
+	require.Equal(t, 1, updated.Lights[0].On)
+	require.Equal(t, 1, lights.Lights[0].On)
+
+	// The settings endpoint is slower than the request timeout
+	_, err = device.FetchSettings(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestHTTPDeviceStatus(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	host, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	require.NoError(t, err)
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+
+	device, err := withHTTPSettings(KeylightDevice{Device: &keylight.Device{DNSAddr: host, Port: p}}, HTTPSettings{ConnectTimeout: time.Second})
+	require.NoError(t, err)
+
+	_, err = device.FetchLightGroup(context.Background())
+	require.ErrorContains(t, err, "404 Not Found")
+
+	// Raw requests show the response rather than failing
+	for _, d := range []Device{device, KeylightDevice{Device: &keylight.Device{DNSAddr: host, Port: p}}} {
+		resp, err := d.RawRequest(context.Background(), http.MethodGet, "/elgato/nothing", nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+		require.Contains(t, string(resp.Body), "404 page not found")
+	}
+}
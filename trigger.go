@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+)
+
+// How long to wait for peripherals to settle after they change, by default.
+// A dock often brings several displays up one after the other.
+const defaultPeripheralSettle = 2 * time.Second
+
+// PeripheralSource finds connected peripherals of one kind, such as displays,
+// and says when they may have changed. Each kind has one for each platform, as
+// in display_*.go and audio_*.go.
+type PeripheralSource interface {
+	// Connected returns the names of the connected peripherals.
+	Connected() ([]string, error)
+
+	// Changes returns a channel which is sent to when the peripherals may have
+	// changed, until ctx is done.
+	Changes(ctx context.Context) (<-chan struct{}, error)
+}
+
+// PeripheralChange is the peripherals which came and went between two
+// readings.
+type PeripheralChange struct {
+	Connected    []string
+	Disconnected []string
+}
+
+// diffPeripherals returns what changed between two readings of the
+// peripherals.
+func diffPeripherals(before, after []string) PeripheralChange {
+	was := map[string]bool{}
+	for _, name := range before {
+		was[name] = true
+	}
+	is := map[string]bool{}
+	for _, name := range after {
+		is[name] = true
+	}
+
+	var change PeripheralChange
+	for name := range is {
+		if !was[name] {
+			change.Connected = append(change.Connected, name)
+		}
+	}
+	for name := range was {
+		if !is[name] {
+			change.Disconnected = append(change.Disconnected, name)
+		}
+	}
+	sort.Strings(change.Connected)
+	sort.Strings(change.Disconnected)
+
+	return change
+}
+
+// matchPeripherals returns the peripherals whose names contain filter,
+// ignoring case. Every peripheral matches an empty filter.
+func matchPeripherals(names []string, filter string) []string {
+	if filter == "" {
+		return names
+	}
+
+	var matched []string
+	for _, name := range names {
+		if strings.Contains(strings.ToLower(name), strings.ToLower(filter)) {
+			matched = append(matched, name)
+		}
+	}
+
+	return matched
+}
+
+// PeripheralTrigger calls OnChange when peripherals are connected or
+// disconnected.
+type PeripheralTrigger struct {
+	// Kind is what's being watched, such as displays, for logs.
+	Kind string
+
+	Source PeripheralSource
+	Clock  Clock
+
+	// Settle is how long to wait after the peripherals change before reading
+	// them, so a dock's displays are seen as one change.
+	Settle time.Duration
+
+	OnChange func(ctx context.Context, change PeripheralChange)
+}
+
+// run watches the peripherals until ctx is done. Those connected when it
+// starts don't count as a change.
+func (dt *PeripheralTrigger) run(ctx context.Context) error {
+	last, err := dt.Source.Connected()
+	if err != nil {
+		return err
+	}
+	automationLog.Debug("Watching peripherals", "kind", dt.Kind, "connected", last)
+
+	changes, err := dt.Source.Changes(ctx)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changes:
+		}
+
+		// Soak up the rest of the changes while the peripherals settle
+		settled := dt.Clock.After(dt.Settle)
+	settling:
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-changes:
+			case <-settled:
+				break settling
+			}
+		}
+
+		current, err := dt.Source.Connected()
+		if err != nil {
+			automationLog.Error("Failed to read peripherals", "kind", dt.Kind, "error", err)
+			continue
+		}
+
+		change := diffPeripherals(last, current)
+		last = current
+		if len(change.Connected) == 0 && len(change.Disconnected) == 0 {
+			continue
+		}
+
+		automationLog.Info("Peripherals changed", "kind", dt.Kind, "connected", change.Connected, "disconnected", change.Disconnected)
+		dt.OnChange(ctx, change)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffPeripherals(t *testing.T) {
+	change := diffPeripherals([]string{"eDP-1", "DP-1"}, []string{"eDP-1", "DP-3", "DP-2"})
+	require.Equal(t, []string{"DP-2", "DP-3"}, change.Connected)
+	require.Equal(t, []string{"DP-1"}, change.Disconnected)
+
+	require.Equal(t, PeripheralChange{}, diffPeripherals([]string{"eDP-1"}, []string{"eDP-1"}))
+}
+
+func TestMatchPeripherals(t *testing.T) {
+	displays := []string{"card1-eDP-1", "card1-DP-3"}
+	require.Equal(t, displays, matchPeripherals(displays, ""))
+	require.Equal(t, []string{"card1-DP-3"}, matchPeripherals(displays, "dp-3"))
+	require.Empty(t, matchPeripherals(displays, "HDMI"))
+}
+
+// fakePeripherals is a PeripheralSource whose peripherals are set by the test.
+type fakePeripherals struct {
+	mu        sync.Mutex
+	connected []string
+	changes   chan struct{}
+}
+
+func (fd *fakePeripherals) Connected() ([]string, error) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	return append([]string{}, fd.connected...), nil
+}
+
+func (fd *fakePeripherals) Changes(ctx context.Context) (<-chan struct{}, error) {
+	return fd.changes, nil
+}
+
+// set changes the peripherals. The trigger is told first, so it has already
+// read the peripherals it starts with.
+func (fd *fakePeripherals) set(connected ...string) {
+	fd.changes <- struct{}{}
+
+	fd.mu.Lock()
+	fd.connected = connected
+	fd.mu.Unlock()
+}
+
+func TestPeripheralTrigger(t *testing.T) {
+	source := &fakePeripherals{connected: []string{"eDP-1"}, changes: make(chan struct{})}
+	clock := newFakeClock()
+	seen := make(chan PeripheralChange)
+
+	trigger := &PeripheralTrigger{
+		Source: source,
+		Clock:  clock,
+		Settle: 2 * time.Second,
+		OnChange: func(ctx context.Context, change PeripheralChange) {
+			seen <- change
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- trigger.run(ctx) }()
+
+	// A dock's displays arrive one at a time, but are seen together once
+	// they've settled
+	source.set("eDP-1", "DP-1")
+	clock.WaitForTimers(t, 1)
+	source.set("eDP-1", "DP-1", "DP-2")
+	clock.Advance(2 * time.Second)
+	require.Equal(t, PeripheralChange{Connected: []string{"DP-1", "DP-2"}}, <-seen)
+
+	// Changes which come to nothing aren't reported
+	source.set("eDP-1", "DP-1", "DP-2")
+	clock.WaitForTimers(t, 1)
+	clock.Advance(2 * time.Second)
+
+	source.set("eDP-1")
+	clock.WaitForTimers(t, 1)
+	clock.Advance(2 * time.Second)
+	require.Equal(t, PeripheralChange{Disconnected: []string{"DP-1", "DP-2"}}, <-seen)
+
+	cancel()
+	require.NoError(t, <-done)
+}
@@ -0,0 +1,49 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// How often peripherals are polled for on Windows, which would otherwise
+// need a window and a message loop to hear about changes.
+const peripheralPollInterval = 2 * time.Second
+
+// pollChanges reads the connected peripherals every so often, and sends to the
+// channel it returns when they've changed.
+func pollChanges(ctx context.Context, connected func() ([]string, error)) (<-chan struct{}, error) {
+	last, err := connected()
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		ticker := time.NewTicker(peripheralPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			current, err := connected()
+			if err != nil || slices.Equal(current, last) {
+				continue
+			}
+			last = current
+
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return changes, nil
+}
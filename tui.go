@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+)
+
+// How often tui reads the lights by default, to show changes made elsewhere.
+const defaultTUIInterval = 2 * time.Second
+
+// Escape sequences to draw the tui on the terminal's alternate screen, so
+// what was there before comes back afterwards.
+const (
+	tuiEnter = "\x1b[?1049h\x1b[?25l"
+	tuiLeave = "\x1b[?25h\x1b[?1049l"
+	tuiClear = "\x1b[H\x1b[2J"
+)
+
+// tuiRow is one device in the tui.
+type tuiRow struct {
+	device Device
+	writer *coalescingWriter
+
+	// lights is the device's lights as last read or changed, or nil before
+	// they've been read.
+	lights *keylight.LightGroup
+	err    error
+
+	// changedAt is when the lights were last changed in the tui. Readings
+	// from around then may not include the change yet, so are ignored.
+	changedAt time.Time
+}
+
+// tuiView is what the tui shows, and the light which keys change.
+type tuiView struct {
+	rows     []*tuiRow
+	selected int
+	steps    NudgeSteps
+
+	// settle is how long after a change readings are ignored.
+	settle time.Duration
+}
+
+// press applies a key to the selected light, returning its row if the key
+// changed it.
+func (v *tuiView) press(key nudgeKey) *tuiRow {
+	switch key {
+	case keyTab:
+		v.selected = (v.selected + 1) % len(v.rows)
+		return nil
+	case keyBackTab:
+		v.selected = (v.selected + len(v.rows) - 1) % len(v.rows)
+		return nil
+	}
+
+	row := v.rows[v.selected]
+	if row.lights == nil || len(row.lights.Lights) == 0 {
+		return nil
+	}
+	lights := row.lights.Lights
+
+	switch key {
+	case keySpace:
+		// Like toggle: everything goes off if anything is on
+		state := LightOn
+		for _, light := range lights {
+			if light.On == int(LightOn) {
+				state = LightOff
+			}
+		}
+		for _, light := range lights {
+			light.On = int(state)
+		}
+
+	case keyUp, keyDown, keyLeft, keyRight:
+		n := &nudger{steps: v.steps, brightness: lights[0].Brightness, temperature: lights[0].Temperature}
+		if !n.press(key) {
+			return nil
+		}
+		for _, light := range lights {
+			light.Brightness, light.Temperature = n.brightness, n.temperature
+		}
+
+	default:
+		return nil
+	}
+
+	row.err = nil
+	row.changedAt = time.Now()
+	return row
+}
+
+// tuiReading is a reading of one device's lights.
+type tuiReading struct {
+	index  int
+	lights *keylight.LightGroup
+	err    error
+	// at is when the reading was started.
+	at time.Time
+}
+
+// update shows a reading, unless it may be from before the lights were last
+// changed in the tui.
+func (v *tuiView) update(r tuiReading) {
+	row := v.rows[r.index]
+	if r.at.Before(row.changedAt.Add(v.settle)) {
+		return
+	}
+
+	row.err = r.err
+	if r.err == nil {
+		row.lights = r.lights
+	}
+}
+
+func (v *tuiView) render(w io.Writer) {
+	unit := statusTemperatureUnit()
+
+	width := 0
+	for _, row := range v.rows {
+		width = max(width, len(deviceLabel(row.device)))
+	}
+
+	var b strings.Builder
+	b.WriteString(tuiClear)
+	b.WriteString("Up/Down: brightness  Left/Right: temperature  Space: power  Tab: next light  q: quit\r\n\r\n")
+
+	for i, row := range v.rows {
+		marker := "  "
+		if i == v.selected {
+			marker = "> "
+		}
+		fmt.Fprintf(&b, "%s%-*s  ", marker, width, deviceLabel(row.device))
+
+		if row.lights == nil {
+			b.WriteString("reading...")
+		} else {
+			lights := make([]string, len(row.lights.Lights))
+			for j, light := range row.lights.Lights {
+				lights[j] = fmt.Sprintf("%-3s %3d%%  %s", LightState(light.On), light.Brightness, temperatureString(light.Temperature, unit, colorOutput))
+			}
+			b.WriteString(strings.Join(lights, "  |  "))
+		}
+
+		if row.err != nil {
+			fmt.Fprintf(&b, "  (%v)", row.err)
+		}
+		b.WriteString("\r\n")
+	}
+
+	io.WriteString(w, b.String())
+}
+
+// readTUILights reads every device, sending each reading as it comes in,
+// until done is closed.
+func readTUILights(ctx context.Context, devices []Device, requestTimeout time.Duration, readings chan<- tuiReading, done <-chan struct{}) {
+	at := time.Now()
+
+	// Errors are shown with each device, so one unreachable light doesn't
+	// stop the others being read
+	_ = forEachDevice(ctx, devices, func(ctx context.Context, i int, device Device) error {
+		ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+		defer cancel()
+
+		lg, err := device.FetchLightGroup(ctx)
+		select {
+		case readings <- tuiReading{index: i, lights: lg, err: err, at: at}:
+		case <-done:
+		}
+		return nil
+	})
+}
+
+// runTUI shows every device's lights, read every interval, until q or Esc is
+// pressed. Keys change the selected light straight away: up and down its
+// brightness, left and right its temperature, and space its power. Tab
+// selects the next light.
+func runTUI(ctx context.Context, devices []Device, keys io.Reader, display io.Writer, interval, requestTimeout time.Duration, steps NudgeSteps) error {
+	view := &tuiView{rows: make([]*tuiRow, len(devices)), steps: steps, settle: interval}
+
+	writeCtx, cancelWrites := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelWrites()
+
+	for i, device := range devices {
+		writer := newCoalescingWriter(device, requestTimeout)
+		go writer.run(writeCtx)
+		view.rows[i] = &tuiRow{device: device, writer: writer}
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	pressed, readErr := readNudgeKeys(keys, done)
+
+	// The lights are read once before keys are taken, so there's something
+	// for them to change
+	readings := make(chan tuiReading, len(devices))
+	readTUILights(ctx, devices, requestTimeout, readings, done)
+	for range devices {
+		view.update(<-readings)
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				readTUILights(ctx, devices, requestTimeout, readings, done)
+			}
+		}
+	}()
+
+	fmt.Fprint(display, tuiEnter)
+	defer fmt.Fprint(display, tuiLeave)
+	view.render(display)
+
+	var (
+		changed bool
+		failure error
+	)
+
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			failure = ctx.Err()
+			break loop
+
+		case err := <-readErr:
+			if !errors.Is(err, io.EOF) {
+				failure = err
+			}
+			break loop
+
+		case reading := <-readings:
+			view.update(reading)
+
+		case pressedKeys := <-pressed:
+			for _, key := range pressedKeys {
+				if key == keyQuit || key == keyEscape {
+					break loop
+				}
+
+				if row := view.press(key); row != nil {
+					row.writer.set(row.lights.Copy())
+					changed = true
+				}
+			}
+		}
+
+		view.render(display)
+	}
+
+	// Changes still being made are finished, even if ctx was cancelled
+	for _, row := range view.rows {
+		row.writer.flush()
+	}
+
+	if changed {
+		reportManualChange(context.WithoutCancel(ctx))
+	}
+
+	return failure
+}
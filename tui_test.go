@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunTUI(t *testing.T) {
+	left := &FakeDevice{Name: "key-left", DNSAddr: "192.168.1.1", LightGrp: &keylight.LightGroup{Count: 1, Lights: []*keylight.Light{{On: 1, Brightness: 40, Temperature: 200}}}}
+	right := &FakeDevice{Name: "key-right", DNSAddr: "192.168.1.2", LightGrp: &keylight.LightGroup{Count: 1, Lights: []*keylight.Light{{On: 0, Brightness: 20, Temperature: 250}}}}
+
+	// Brighten the first light, then turn the second on, a read at a time
+	var keys []io.Reader
+	for _, key := range []string{"\x1b[A", "\x1b[A", "\t", " ", "q"} {
+		keys = append(keys, strings.NewReader(key))
+	}
+	var display bytes.Buffer
+
+	err := runTUI(context.Background(), []Device{left, right}, io.MultiReader(keys...), &display, time.Hour, time.Second, NudgeSteps{Brightness: 5, Temperature: 100})
+	require.NoError(t, err)
+
+	require.Equal(t, 50, left.LightGrp.Lights[0].Brightness)
+	require.Equal(t, 1, left.LightGrp.Lights[0].On)
+	require.Equal(t, 1, right.LightGrp.Lights[0].On)
+	require.Equal(t, 20, right.LightGrp.Lights[0].Brightness)
+
+	out := display.String()
+	require.True(t, strings.HasPrefix(out, tuiEnter))
+	require.True(t, strings.HasSuffix(out, tuiLeave))
+	require.Contains(t, out, "> key-left   on   50%")
+	require.Contains(t, out, "> key-right  on   20%")
+}
+
+func TestTUIViewUpdate(t *testing.T) {
+	device := &FakeDevice{Name: "key-left"}
+	view := &tuiView{rows: []*tuiRow{{device: device}}, steps: NudgeSteps{Brightness: 5, Temperature: 100}, settle: time.Minute}
+
+	// Keys do nothing until the lights have been read
+	require.Nil(t, view.press(keyUp))
+
+	view.update(tuiReading{lights: &keylight.LightGroup{Lights: []*keylight.Light{{Brightness: 40, Temperature: 200}}}, at: time.Now()})
+	require.NotNil(t, view.press(keyDown))
+	require.Equal(t, 35, view.rows[0].lights.Lights[0].Brightness)
+
+	// Readings from just after a change may not include it
+	view.update(tuiReading{lights: &keylight.LightGroup{Lights: []*keylight.Light{{Brightness: 40, Temperature: 200}}}, at: time.Now()})
+	require.Equal(t, 35, view.rows[0].lights.Lights[0].Brightness)
+
+	view.update(tuiReading{lights: &keylight.LightGroup{Lights: []*keylight.Light{{Brightness: 60, Temperature: 200}}}, at: time.Now().Add(time.Hour)})
+	require.Equal(t, 60, view.rows[0].lights.Lights[0].Brightness)
+}
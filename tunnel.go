@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// The port SSH connects to when the tunnel target doesn't give one.
+const defaultSSHPort = "22"
+
+// The keys tried when no --identity is given, in the order ssh tries them.
+var defaultIdentityFiles = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// parseSSHTarget splits [user@]host[:port] into the user to log in as and the
+// address to connect to. The user defaults to the current one.
+func parseSSHTarget(target string) (string, string, error) {
+	username, hostPort, found := strings.Cut(target, "@")
+	if !found {
+		hostPort = target
+
+		current, err := user.Current()
+		if err != nil {
+			return "", "", fmt.Errorf("no user given in %q and can't find the current one: %w", target, err)
+		}
+		username = current.Username
+	}
+
+	if username == "" || hostPort == "" {
+		return "", "", fmt.Errorf("invalid SSH target %q, expected [user@]host[:port]", target)
+	}
+
+	host, port, err := parseHostPort(hostPort, defaultSSHPort)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid SSH target %q: %w", target, err)
+	}
+
+	return username, net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
+// sshDir returns ~/.ssh.
+func sshDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".ssh"), nil
+}
+
+// identitySigners loads the private keys to authenticate with. Missing default
+// keys are skipped, as are passphrase protected ones, which need an agent.
+func identitySigners(identities []string) ([]ssh.Signer, error) {
+	explicit := len(identities) > 0
+	if !explicit {
+		dir, err := sshDir()
+		if err != nil {
+			return nil, nil
+		}
+
+		for _, name := range defaultIdentityFiles {
+			identities = append(identities, filepath.Join(dir, name))
+		}
+	}
+
+	var signers []ssh.Signer
+	for _, path := range identities {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) && !explicit {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		signer, err := ssh.ParsePrivateKey(data)
+		var passphraseErr *ssh.PassphraseMissingError
+		if errors.As(err, &passphraseErr) {
+			tunnelLog.Debug("Skipping key with a passphrase, add it to ssh-agent to use it", "path", path)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading SSH key %s: %w", path, err)
+		}
+
+		signers = append(signers, signer)
+	}
+
+	return signers, nil
+}
+
+// sshClientConfig builds the configuration for logging in as username. Keys
+// come from ssh-agent, if it's running, and then the identity files. Host keys
+// are checked against ~/.ssh/known_hosts. The returned function closes the
+// agent connection, once the handshake is done.
+func sshClientConfig(username string, identities []string) (*ssh.ClientConfig, func(), error) {
+	dir, err := sshDir()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hostKeyCallback, err := knownhosts.New(filepath.Join(dir, "known_hosts"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading known hosts (connect once with ssh to add the host): %w", err)
+	}
+
+	var methods []ssh.AuthMethod
+	closeAgent := func() {}
+
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			tunnelLog.Debug("Can't connect to ssh-agent", "error", err)
+		} else {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+			closeAgent = func() { conn.Close() }
+		}
+	}
+
+	signers, err := identitySigners(identities)
+	if err != nil {
+		closeAgent()
+		return nil, nil, err
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+
+	if len(methods) == 0 {
+		return nil, nil, errors.New("no SSH keys found, start ssh-agent or pass --identity")
+	}
+
+	return &ssh.ClientConfig{
+		User:            username,
+		Auth:            methods,
+		HostKeyCallback: hostKeyCallback,
+	}, closeAgent, nil
+}
+
+// dialSSH logs in to target, given as [user@]host[:port].
+func dialSSH(ctx context.Context, target string, identities []string) (*ssh.Client, error) {
+	username, addr, err := parseSSHTarget(target)
+	if err != nil {
+		return nil, err
+	}
+
+	config, closeAgent, err := sshClientConfig(username, identities)
+	if err != nil {
+		return nil, err
+	}
+	defer closeAgent()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// The handshake doesn't take a context, so give it the same deadline
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("connecting to %s: %w", target, err)
+	}
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		clientConn.Close()
+		return nil, err
+	}
+
+	tunnelLog.Debug("Connected", "address", addr, "user", username)
+
+	return ssh.NewClient(clientConn, chans, reqs), nil
+}
+
+// Forward is a local port which reaches a light at the far end of a tunnel.
+type Forward struct {
+	Light  string
+	Remote string
+	Local  string
+}
+
+// Tunnel forwards local ports to lights, dialling them with dial. Over SSH,
+// that's the client's Dial, so connections are made from the remote host.
+type Tunnel struct {
+	dial func(network, addr string) (net.Conn, error)
+
+	Forwards []Forward
+}
+
+func newTunnel(dial func(network, addr string) (net.Conn, error)) *Tunnel {
+	return &Tunnel{dial: dial}
+}
+
+// forward listens on a free loopback port and forwards its connections to the
+// light at remote, until ctx is done.
+func (t *Tunnel) forward(ctx context.Context, light, remote string) (Forward, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return Forward{}, err
+	}
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go t.handle(conn, remote)
+		}
+	}()
+
+	f := Forward{Light: light, Remote: remote, Local: listener.Addr().String()}
+	t.Forwards = append(t.Forwards, f)
+	tunnelLog.Debug("Forwarding", "light", light, "remote", remote, "local", f.Local)
+
+	return f, nil
+}
+
+// handle copies data between a local connection and the light, until either
+// side closes.
+func (t *Tunnel) handle(local net.Conn, remote string) {
+	defer local.Close()
+
+	conn, err := t.dial("tcp", remote)
+	if err != nil {
+		tunnelLog.Warn("Can't reach light through the tunnel", "remote", remote, "error", err)
+		return
+	}
+	defer conn.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(conn, local)
+		closeWrite(conn)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(local, conn)
+		closeWrite(local)
+	}()
+	wg.Wait()
+}
+
+// closeWrite tells the other end we're done sending, if conn supports it.
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+}
+
+// lightArgs returns the --light arguments which point klctl at the forwards.
+func (t *Tunnel) lightArgs() []string {
+	var args []string
+	for _, f := range t.Forwards {
+		args = append(args, "--light", f.Local)
+	}
+
+	return args
+}
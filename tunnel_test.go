@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseSSHTarget(t *testing.T) {
+	username, addr, err := parseSSHTarget("me@studio-gw")
+	require.NoError(t, err)
+	require.Equal(t, "me", username)
+	require.Equal(t, "studio-gw:22", addr)
+
+	_, addr, err = parseSSHTarget("me@studio-gw:2222")
+	require.NoError(t, err)
+	require.Equal(t, "studio-gw:2222", addr)
+
+	username, _, err = parseSSHTarget("studio-gw")
+	require.NoError(t, err)
+	require.NotEmpty(t, username)
+
+	for _, target := range []string{"me@", "@studio-gw", "me@studio-gw:ssh"} {
+		_, _, err := parseSSHTarget(target)
+		require.Error(t, err, target)
+	}
+}
+
+func TestIdentitySigners(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKey(key, "")
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "id_ed25519")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(block), 0o600))
+
+	signers, err := identitySigners([]string{path})
+	require.NoError(t, err)
+	require.Len(t, signers, 1)
+
+	// Keys which were asked for must exist
+	_, err = identitySigners([]string{filepath.Join(dir, "missing")})
+	require.Error(t, err)
+
+	// Default keys are skipped when missing
+	t.Setenv("HOME", dir)
+	signers, err = identitySigners(nil)
+	require.NoError(t, err)
+	require.Empty(t, signers)
+}
+
+func TestTunnelForward(t *testing.T) {
+	light := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello from "+r.URL.Path)
+	}))
+	defer light.Close()
+
+	// Dialled from the tunnel's forwarding goroutine
+	var (
+		mu      sync.Mutex
+		dialled []string
+	)
+	tunnel := newTunnel(func(network, addr string) (net.Conn, error) {
+		mu.Lock()
+		dialled = append(dialled, addr)
+		mu.Unlock()
+		return net.Dial(network, addr)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	remote := light.Listener.Addr().String()
+	f, err := tunnel.forward(ctx, "desk", remote)
+	require.NoError(t, err)
+	require.Equal(t, []string{"--light", f.Local}, tunnel.lightArgs())
+
+	resp, err := http.Get("http://" + f.Local + "/elgato/lights")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, "hello from /elgato/lights", string(body))
+	mu.Lock()
+	require.Equal(t, []string{remote}, dialled)
+	mu.Unlock()
+
+	// The listener goes away with the context
+	cancel()
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", f.Local)
+		if err == nil {
+			conn.Close()
+		}
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+}
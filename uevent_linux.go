@@ -0,0 +1,64 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"syscall"
+)
+
+// ueventChanges listens for uevents from the kernel, as udev does, and passes
+// on those from subsystem, such as drm or sound.
+func ueventChanges(ctx context.Context, subsystem string) (<-chan struct{}, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK, syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: 1}); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	// Being non-blocking, the socket is read through the runtime's poller,
+	// so closing it ends the read below
+	f := os.NewFile(uintptr(fd), "uevent")
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		buf := make([]byte, 8192)
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+
+			if isUevent(buf[:n], subsystem) {
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return changes, nil
+}
+
+// isUevent reports whether a uevent, which is NUL separated KEY=value pairs
+// after a header, is from subsystem.
+func isUevent(event []byte, subsystem string) bool {
+	for _, field := range bytes.Split(event, []byte{0}) {
+		if string(field) == "SUBSYSTEM="+subsystem {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsUevent(t *testing.T) {
+	drm := []byte("change@/devices/pci0000:00/drm/card1\x00ACTION=change\x00SUBSYSTEM=drm\x00HOTPLUG=1\x00")
+	require.True(t, isUevent(drm, "drm"))
+	require.False(t, isUevent(drm, "sound"))
+	require.False(t, isUevent([]byte("add@/devices/usb1\x00ACTION=add\x00SUBSYSTEM=usb\x00"), "drm"))
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+)
+
+// How long a wake-up takes by default.
+const defaultWakeDuration = 20 * time.Minute
+
+// WakeTarget is where a wake-up ends. Temperature is in mireds.
+type WakeTarget struct {
+	Brightness  int `json:"brightness"`
+	Temperature int `json:"temperature"`
+}
+
+// defaultWakeTarget is daylight: full brightness, about 5000K.
+var defaultWakeTarget = WakeTarget{Brightness: 100, Temperature: 200}
+
+// parseWakeTarget parses a target such as brightness=60,temperature=5000K.
+// Anything left out is taken from defaultWakeTarget.
+func parseWakeTarget(s string) (WakeTarget, error) {
+	target := defaultWakeTarget
+	if s == "" {
+		return target, nil
+	}
+
+	for _, field := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return target, fmt.Errorf("invalid target %q, expected e.g. brightness=60,temperature=5000K", field)
+		}
+
+		switch key {
+		case ControlBrightness.String():
+			brightness, err := parseBrightness(value)
+			if err != nil || brightness < minBrightness {
+				return target, fmt.Errorf("wake-up brightness must be between %d and 100 (got %s)", minBrightness, value)
+			}
+			target.Brightness = brightness
+		case ControlTemperature.String():
+			temperature, err := parseTemperature(value, temperatureUnit)
+			if err == nil {
+				err = validateTemperature(temperature)
+			}
+			if err != nil {
+				return target, err
+			}
+			target.Temperature = temperature
+		default:
+			return target, fmt.Errorf("unknown target %q, must be one of brightness or temperature", key)
+		}
+	}
+
+	return target, nil
+}
+
+// wakeFrame returns the state of a light t (0 to 1) of the way through waking
+// up. Like a sunrise, it starts dim and as warm as the lights go. Brightness
+// rises slowly at first, as the eye notices changes in dim light far more.
+func wakeFrame(target WakeTarget, t float64) keylight.Light {
+	return keylight.Light{
+		On:          1,
+		Brightness:  lerp(minBrightness, target.Brightness, t*t),
+		Temperature: lerp(maxTemperature, target.Temperature, t),
+	}
+}
+
+// wakeStep returns how often the lights are updated during a wake-up: often
+// enough that each step is too small to notice, but no more.
+func wakeStep(over time.Duration) time.Duration {
+	return max(fadeStepInterval, min(10*time.Second, over/200))
+}
+
+// wakeProgress is a wake-up under way, saved so that one which is interrupted
+// can carry on where it would have been.
+type wakeProgress struct {
+	Target WakeTarget    `json:"target"`
+	Start  time.Time     `json:"start"`
+	Over   time.Duration `json:"over"`
+}
+
+// defaultWakeProgressPath returns wake.json in the state directory.
+func defaultWakeProgressPath() string {
+	dir := defaultStateDir()
+	if dir == "" {
+		return ""
+	}
+
+	return filepath.Join(dir, "wake.json")
+}
+
+// Wake slowly brings the lights up from off, like a sunrise.
+type Wake struct {
+	devices []Device
+	clock   Clock
+
+	// requestTimeout bounds each request to a device.
+	requestTimeout time.Duration
+
+	Target WakeTarget
+	Over   time.Duration
+
+	// progressPath is where progress is saved, or empty to not save it.
+	progressPath string
+}
+
+// start returns when the wake-up started. An unfinished wake-up to the same
+// target is carried on with, rather than starting again from dark.
+func (w *Wake) start(now time.Time) time.Time {
+	if w.progressPath == "" {
+		return now
+	}
+
+	var progress wakeProgress
+	data, err := os.ReadFile(w.progressPath)
+	if err == nil {
+		err = json.Unmarshal(data, &progress)
+	}
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		automationLog.Warn("Ignoring unreadable wake-up progress", "path", w.progressPath, "error", err)
+	case progress.Target == w.Target && progress.Over == w.Over &&
+		!now.Before(progress.Start) && now.Before(progress.Start.Add(progress.Over)):
+		automationLog.Info("Resuming wake-up", "started", progress.Start)
+		return progress.Start
+	}
+
+	if err := w.saveProgress(wakeProgress{Target: w.Target, Start: now, Over: w.Over}); err != nil {
+		automationLog.Warn("Failed to save wake-up progress, it won't be resumable", "error", err)
+	}
+
+	return now
+}
+
+func (w *Wake) saveProgress(progress wakeProgress) error {
+	if err := os.MkdirAll(filepath.Dir(w.progressPath), 0o700); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(w.progressPath, data, 0o600)
+}
+
+func (w *Wake) finish() {
+	if w.progressPath != "" {
+		os.Remove(w.progressPath)
+	}
+}
+
+// run wakes the lights up. Each step is worked out from how long it's been
+// since the start, so however late a step is, the next catches up, and a step
+// which fails is made up by the next. If the lights are changed by anything
+// else along the way, the wake-up stops, as someone is awake and has set them
+// how they like.
+func (w *Wake) run(ctx context.Context) error {
+	fetchCtx, cancel := context.WithTimeout(ctx, w.requestTimeout)
+	lgs, err := fetchLightGroups(fetchCtx, w.devices)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	start := w.start(w.clock.Now())
+	step := wakeStep(w.Over)
+
+	var written []*keylight.LightGroup
+	for {
+		t := min(1, float64(w.clock.Now().Sub(start))/float64(w.Over))
+		frame := wakeFrame(w.Target, t)
+
+		next := make([]*keylight.LightGroup, len(lgs))
+		for i, dlg := range lgs {
+			next[i] = dlg.LightGroup.Copy()
+			for _, light := range next[i].Lights {
+				*light = frame
+			}
+		}
+
+		stepCtx, cancel := context.WithTimeout(ctx, w.requestTimeout)
+		changed, err := w.changedSince(stepCtx, written)
+		if err == nil && !changed {
+			err = forEachDevice(stepCtx, w.devices, func(ctx context.Context, i int, device Device) error {
+				_, err := device.UpdateLightGroup(ctx, next[i].Copy())
+				return err
+			})
+		}
+		cancel()
+
+		switch {
+		case changed:
+			automationLog.Info("The lights were changed, so stopping the wake-up")
+			w.finish()
+			return nil
+		case err != nil && ctx.Err() == nil:
+			// The next step carries on, and can't tell what was written
+			deviceLog.Warn("Failed to update lights", "error", err)
+			written = nil
+		default:
+			written = next
+		}
+
+		if t >= 1 && written != nil {
+			w.finish()
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			automationLog.Info("Wake-up interrupted, run it again to carry on")
+			return nil
+		case <-w.clock.After(step):
+		}
+	}
+}
+
+// changedSince reports whether any device's lights are no longer as they were
+// written. Nothing has been written at first, so nothing has changed.
+func (w *Wake) changedSince(ctx context.Context, written []*keylight.LightGroup) (bool, error) {
+	if written == nil {
+		return false, nil
+	}
+
+	changed := make([]bool, len(w.devices))
+	err := forEachDevice(ctx, w.devices, func(ctx context.Context, i int, device Device) error {
+		current, err := device.FetchLightGroup(ctx)
+		if err != nil {
+			return err
+		}
+
+		changed[i] = !sameLights(current, written[i])
+		return nil
+	})
+
+	return slices.Contains(changed, true), err
+}
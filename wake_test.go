@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseWakeTarget(t *testing.T) {
+	target, err := parseWakeTarget("")
+	require.NoError(t, err)
+	require.Equal(t, defaultWakeTarget, target)
+
+	target, err = parseWakeTarget("brightness=60, temperature=5000K")
+	require.NoError(t, err)
+	require.Equal(t, WakeTarget{Brightness: 60, Temperature: 200}, target)
+
+	// Whatever isn't given is the default
+	target, err = parseWakeTarget("brightness=40")
+	require.NoError(t, err)
+	require.Equal(t, WakeTarget{Brightness: 40, Temperature: defaultWakeTarget.Temperature}, target)
+
+	for _, s := range []string{"brightness", "brightness=0", "temperature=20000K", "colour=red"} {
+		_, err := parseWakeTarget(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestWakeFrame(t *testing.T) {
+	target := WakeTarget{Brightness: 60, Temperature: 200}
+
+	require.Equal(t, keylight.Light{On: 1, Brightness: minBrightness, Temperature: maxTemperature}, wakeFrame(target, 0))
+	require.Equal(t, keylight.Light{On: 1, Brightness: 17, Temperature: 272}, wakeFrame(target, 0.5))
+	require.Equal(t, keylight.Light{On: 1, Brightness: 60, Temperature: 200}, wakeFrame(target, 1))
+}
+
+func TestWakeResumes(t *testing.T) {
+	clock := newFakeClock()
+	device := &FakeDevice{DNSAddr: "a.local", LightGrp: &keylight.LightGroup{Lights: []*keylight.Light{{}}}}
+	progressPath := filepath.Join(t.TempDir(), "wake.json")
+
+	newWake := func() *Wake {
+		return &Wake{
+			devices:        []Device{device},
+			clock:          clock,
+			requestTimeout: time.Minute,
+			Target:         WakeTarget{Brightness: 60, Temperature: 200},
+			Over:           20 * time.Minute,
+			progressPath:   progressPath,
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- newWake().run(ctx) }()
+
+	clock.WaitForTimers(t, 1)
+	require.Equal(t, keylight.Light{On: 1, Brightness: minBrightness, Temperature: maxTemperature}, *device.LightGrp.Lights[0])
+
+	clock.Advance(10 * time.Minute)
+	clock.WaitForTimers(t, 1)
+	require.Equal(t, 17, device.LightGrp.Lights[0].Brightness)
+
+	// Interrupted half way through, the progress is kept
+	cancel()
+	require.NoError(t, <-done)
+	require.FileExists(t, progressPath)
+
+	// Run again, it carries on from where it would have been
+	go func() { done <- newWake().run(context.Background()) }()
+
+	clock.WaitForTimers(t, 1)
+	require.Equal(t, 17, device.LightGrp.Lights[0].Brightness)
+
+	clock.Advance(10 * time.Minute)
+	require.NoError(t, <-done)
+	require.Equal(t, keylight.Light{On: 1, Brightness: 60, Temperature: 200}, *device.LightGrp.Lights[0])
+	require.NoFileExists(t, progressPath)
+}
+
+func TestWakeStopsWhenChangedByHand(t *testing.T) {
+	clock := newFakeClock()
+	device := &FakeDevice{DNSAddr: "a.local", LightGrp: &keylight.LightGroup{Lights: []*keylight.Light{{}}}}
+	progressPath := filepath.Join(t.TempDir(), "wake.json")
+
+	wake := &Wake{
+		devices:        []Device{device},
+		clock:          clock,
+		requestTimeout: time.Minute,
+		Target:         defaultWakeTarget,
+		Over:           time.Hour,
+		progressPath:   progressPath,
+	}
+
+	done := make(chan error)
+	go func() { done <- wake.run(context.Background()) }()
+
+	clock.WaitForTimers(t, 1)
+	device.LightGrp = &keylight.LightGroup{Lights: []*keylight.Light{{On: 1, Brightness: 80, Temperature: 250}}}
+	clock.Advance(wakeStep(wake.Over))
+
+	require.NoError(t, <-done)
+	require.Equal(t, keylight.Light{On: 1, Brightness: 80, Temperature: 250}, *device.LightGrp.Lights[0])
+	require.NoFileExists(t, progressPath)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+)
+
+// How often watch polls the lights by default. The lights have no way to
+// push changes, so polling is the only option.
+const defaultWatchInterval = 2 * time.Second
+
+// WatchEvent is a change to a light seen by watch.
+type WatchEvent struct {
+	Time    time.Time `json:"time"`
+	Address string    `json:"address"`
+	Name    string    `json:"name,omitempty"`
+	Change
+}
+
+func (e WatchEvent) String() string {
+	device := e.Address
+	if e.Name != "" {
+		device = e.Name
+	}
+
+	from, to := fmt.Sprint(e.Old), fmt.Sprint(e.New)
+	switch e.Field {
+	case "on":
+		from, to = LightState(e.Old).String(), LightState(e.New).String()
+	case ControlTemperature.String():
+		unit := statusTemperatureUnit()
+		from, to = unit.Format(e.Old), unit.Format(e.New)
+	}
+
+	return fmt.Sprintf("%s  %s light %d %s %s -> %s",
+		e.Time.Local().Format(time.TimeOnly), device, e.Light, e.Field, from, to)
+}
+
+// Watcher polls devices and reports how their lights change between polls.
+type Watcher struct {
+	devices []Device
+
+	// requestTimeout bounds each poll of a device.
+	requestTimeout time.Duration
+
+	// last is the last reading of each device, or nil before it has been
+	// read successfully.
+	last [][]keylight.Light
+}
+
+func newWatcher(devices []Device, requestTimeout time.Duration) *Watcher {
+	return &Watcher{
+		devices:        devices,
+		requestTimeout: requestTimeout,
+		last:           make([][]keylight.Light, len(devices)),
+	}
+}
+
+// poll reads every device and returns the changes since the last poll, in
+// device order. Devices which can't be read are skipped until they can be.
+func (w *Watcher) poll(ctx context.Context) []WatchEvent {
+	changes := make([][]Change, len(w.devices))
+	now := time.Now()
+
+	// Errors aren't returned, so one unreachable light doesn't stop the others
+	// being polled
+	_ = forEachDevice(ctx, w.devices, func(ctx context.Context, i int, device Device) error {
+		ctx, cancel := context.WithTimeout(ctx, w.requestTimeout)
+		defer cancel()
+
+		lg, err := device.FetchLightGroup(ctx)
+		if err != nil {
+			deviceLog.Debug("Failed to poll device", "address", device.GetDNSAddr(), "error", err)
+			return nil
+		}
+
+		lights := lightGroupValues(lg)
+		if w.last[i] != nil {
+			changes[i] = lightChanges(w.last[i], lights)
+		}
+		w.last[i] = lights
+
+		return nil
+	})
+
+	var events []WatchEvent
+	for i, device := range w.devices {
+		for _, change := range changes[i] {
+			events = append(events, WatchEvent{
+				Time:    now,
+				Address: device.GetDNSAddr(),
+				Name:    device.GetName(),
+				Change:  change,
+			})
+		}
+	}
+
+	return events
+}
+
+// run polls every interval until ctx is done, writing each change to out as
+// a line of text or, with OutputJSON, a JSON object.
+func (w *Watcher) run(ctx context.Context, interval time.Duration, out io.Writer, format string) error {
+	enc := json.NewEncoder(out)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, event := range w.poll(ctx) {
+			var err error
+			if format == OutputJSON {
+				err = enc.Encode(event)
+			} else {
+				_, err = fmt.Fprintln(out, event)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
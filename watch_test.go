@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/endocrimes/keylight-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatcherPoll(t *testing.T) {
+	ctx := context.Background()
+
+	desk := &FakeDevice{
+		Name:     "desk",
+		DNSAddr:  "192.168.1.1",
+		LightGrp: &keylight.LightGroup{Lights: []*keylight.Light{{On: 0, Brightness: 20, Temperature: 200}}},
+	}
+	shelf := &FakeDevice{
+		DNSAddr:  "192.168.1.2",
+		LightGrp: &keylight.LightGroup{Lights: []*keylight.Light{{On: 1, Brightness: 50, Temperature: 300}}},
+	}
+
+	watcher := newWatcher([]Device{desk, shelf}, time.Second)
+	require.Empty(t, watcher.poll(ctx))
+
+	desk.LightGrp = &keylight.LightGroup{Lights: []*keylight.Light{{On: 1, Brightness: 40, Temperature: 200}}}
+	shelf.FetchLightGroupError = errors.New("unreachable")
+
+	events := watcher.poll(ctx)
+	require.Len(t, events, 2)
+	require.Equal(t, "desk", events[0].Name)
+	require.Equal(t, Change{Light: 0, Field: "on", Old: 0, New: 1}, events[0].Change)
+	require.Equal(t, Change{Light: 0, Field: "brightness", Old: 20, New: 40}, events[1].Change)
+	require.Contains(t, events[0].String(), "desk light 0 on off -> on")
+
+	// The device comes back, changed while it was away
+	shelf.FetchLightGroupError = nil
+	shelf.LightGrp = &keylight.LightGroup{Lights: []*keylight.Light{{On: 1, Brightness: 50, Temperature: 250}}}
+
+	events = watcher.poll(ctx)
+	require.Len(t, events, 1)
+	require.Equal(t, "192.168.1.2", events[0].Address)
+	require.Equal(t, Change{Light: 0, Field: "temperature", Old: 300, New: 250}, events[0].Change)
+}
+
+func TestWatcherRun(t *testing.T) {
+	device := &FakeDevice{
+		DNSAddr:  "192.168.1.1",
+		LightGrp: &keylight.LightGroup{Lights: []*keylight.Light{{On: 0, Brightness: 20, Temperature: 200}}},
+	}
+
+	watcher := newWatcher([]Device{device}, time.Second)
+	watcher.poll(context.Background())
+	device.LightGrp = &keylight.LightGroup{Lights: []*keylight.Light{{On: 1, Brightness: 20, Temperature: 200}}}
+
+	// A cancelled context polls once and stops
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var out bytes.Buffer
+	require.NoError(t, watcher.run(ctx, time.Hour, &out, OutputJSON))
+
+	var event map[string]any
+	require.NoError(t, json.Unmarshal(out.Bytes(), &event))
+	require.Equal(t, "on", event["field"])
+	require.Equal(t, "192.168.1.1", event["address"])
+}
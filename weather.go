@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// The weather providers the config file can choose.
+const (
+	weatherOpenMeteo      = "open-meteo"
+	weatherOpenWeatherMap = "openweathermap"
+)
+
+const (
+	openMeteoURL      = "https://api.open-meteo.com/v1/forecast"
+	openWeatherMapURL = "https://api.openweathermap.org/data/2.5/weather"
+)
+
+// WeatherConfig is where the weather command gets the weather from.
+type WeatherConfig struct {
+	// Provider is open-meteo, the default, or openweathermap.
+	Provider string `yaml:"provider"`
+
+	// APIKey is needed for OpenWeatherMap, and for Open-Meteo's commercial
+	// plans. It can name a secret in the keychain, such as keyring:weather.
+	APIKey string `yaml:"api_key"`
+
+	// URL replaces the provider's usual address, such as for a self-hosted
+	// Open-Meteo.
+	URL string `yaml:"url"`
+}
+
+// WeatherProvider fetches the current conditions at a place.
+type WeatherProvider interface {
+	Conditions(ctx context.Context, latitude, longitude float64) (*Conditions, error)
+}
+
+// provider returns the configured weather provider.
+func (cfg WeatherConfig) provider(client *http.Client) (WeatherProvider, error) {
+	apiKey, err := resolveSecret(cfg.APIKey)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Provider {
+	case "", weatherOpenMeteo:
+		baseURL := openMeteoURL
+		if apiKey != "" {
+			baseURL = openMeteoCustomerURL
+		}
+		if cfg.URL != "" {
+			baseURL = cfg.URL
+		}
+		return &OpenMeteo{BaseURL: baseURL, APIKey: apiKey, Client: client}, nil
+
+	case weatherOpenWeatherMap:
+		if apiKey == "" {
+			return nil, errors.New("OpenWeatherMap needs an api_key in the weather section of the config file")
+		}
+		baseURL := openWeatherMapURL
+		if cfg.URL != "" {
+			baseURL = cfg.URL
+		}
+		return &OpenWeatherMap{BaseURL: baseURL, APIKey: apiKey, Client: client}, nil
+	}
+
+	return nil, fmt.Errorf("weather provider must be one of %s or %s (got %s)", weatherOpenMeteo, weatherOpenWeatherMap, cfg.Provider)
+}
+
+// Conditions is the current weather, as far as lighting cares about it.
+type Conditions struct {
+	// CloudCover is the percentage of the sky covered by cloud.
+	CloudCover int
+	// Daytime is set when the sun is up.
+	Daytime bool
+}
+
+// OpenMeteo fetches current conditions from https://open-meteo.com, which
+// needs no API key unless a commercial plan is used.
+type OpenMeteo struct {
+	BaseURL string
+	APIKey  string
+	Client  *http.Client
+}
+
+// Open-Meteo's commercial plans are served from their own address.
+const openMeteoCustomerURL = "https://customer-api.open-meteo.com/v1/forecast"
+
+// fetchWeather gets a provider's response to query, decoding it into body.
+func fetchWeather(ctx context.Context, client *http.Client, baseURL string, query url.Values, body any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"?"+query.Encode(), http.NoBody)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// The error includes the URL, and so any API key
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("failed to fetch weather: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch weather: %s", resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(body); err != nil {
+		return fmt.Errorf("failed to decode weather: %w", err)
+	}
+
+	return nil
+}
+
+func (om *OpenMeteo) Conditions(ctx context.Context, latitude, longitude float64) (*Conditions, error) {
+	query := url.Values{
+		"latitude":  {strconv.FormatFloat(latitude, 'f', -1, 64)},
+		"longitude": {strconv.FormatFloat(longitude, 'f', -1, 64)},
+		"current":   {"cloud_cover,is_day"},
+	}
+	if om.APIKey != "" {
+		query.Set("apikey", om.APIKey)
+	}
+
+	var body struct {
+		Current struct {
+			CloudCover int `json:"cloud_cover"`
+			IsDay      int `json:"is_day"`
+		} `json:"current"`
+	}
+	if err := fetchWeather(ctx, om.Client, om.BaseURL, query, &body); err != nil {
+		return nil, err
+	}
+
+	return &Conditions{
+		CloudCover: body.Current.CloudCover,
+		Daytime:    body.Current.IsDay == 1,
+	}, nil
+}
+
+// OpenWeatherMap fetches current conditions from https://openweathermap.org,
+// which needs an API key.
+type OpenWeatherMap struct {
+	BaseURL string
+	APIKey  string
+	Client  *http.Client
+}
+
+func (owm *OpenWeatherMap) Conditions(ctx context.Context, latitude, longitude float64) (*Conditions, error) {
+	query := url.Values{
+		"lat":   {strconv.FormatFloat(latitude, 'f', -1, 64)},
+		"lon":   {strconv.FormatFloat(longitude, 'f', -1, 64)},
+		"appid": {owm.APIKey},
+	}
+
+	// Times are Unix seconds
+	var body struct {
+		Time   int64 `json:"dt"`
+		Clouds struct {
+			All int `json:"all"`
+		} `json:"clouds"`
+		Sys struct {
+			Sunrise int64 `json:"sunrise"`
+			Sunset  int64 `json:"sunset"`
+		} `json:"sys"`
+	}
+	if err := fetchWeather(ctx, owm.Client, owm.BaseURL, query, &body); err != nil {
+		return nil, err
+	}
+
+	return &Conditions{
+		CloudCover: body.Clouds.All,
+		Daytime:    body.Time >= body.Sys.Sunrise && body.Time < body.Sys.Sunset,
+	}, nil
+}
+
+// Daylight coming through a window is around 5500K under a clear sky and gets
+// bluer as cloud thickens, up to about 7000K when fully overcast. Matching the
+// lights to it keeps skin tones consistent between the lit and window sides of
+// a face. After dark there's no daylight to match, so lights go warm.
+const (
+	clearSkyKelvin = 5500
+	overcastKelvin = 7000
+	nightKelvin    = 3200
+)
+
+// suggestTemperature returns the colour temperature, in Kelvin, which best
+// matches the ambient light for the given conditions.
+func suggestTemperature(conditions *Conditions) int {
+	if !conditions.Daytime {
+		return nightKelvin
+	}
+
+	cover := math.Max(0, math.Min(100, float64(conditions.CloudCover)))
+	return clearSkyKelvin + int(math.Round(cover/100*(overcastKelvin-clearSkyKelvin)))
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenMeteoConditions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "51.5", r.URL.Query().Get("latitude"))
+		require.Equal(t, "-0.12", r.URL.Query().Get("longitude"))
+		_, _ = w.Write([]byte(`{"current": {"cloud_cover": 80, "is_day": 1}}`))
+	}))
+	defer server.Close()
+
+	om := &OpenMeteo{BaseURL: server.URL, Client: server.Client()}
+	conditions, err := om.Conditions(context.Background(), 51.5, -0.12)
+	require.NoError(t, err)
+	require.Equal(t, &Conditions{CloudCover: 80, Daytime: true}, conditions)
+}
+
+func TestOpenWeatherMapConditions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "51.5", r.URL.Query().Get("lat"))
+		require.Equal(t, "-0.12", r.URL.Query().Get("lon"))
+		require.Equal(t, "secret", r.URL.Query().Get("appid"))
+		_, _ = w.Write([]byte(`{"dt": 1700050000, "clouds": {"all": 40}, "sys": {"sunrise": 1700033000, "sunset": 1700065000}}`))
+	}))
+	defer server.Close()
+
+	owm := &OpenWeatherMap{BaseURL: server.URL, APIKey: "secret", Client: server.Client()}
+	conditions, err := owm.Conditions(context.Background(), 51.5, -0.12)
+	require.NoError(t, err)
+	require.Equal(t, &Conditions{CloudCover: 40, Daytime: true}, conditions)
+
+	// The API key isn't given away in errors
+	owm.BaseURL = "http://127.0.0.1:0"
+	_, err = owm.Conditions(context.Background(), 51.5, -0.12)
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "secret")
+}
+
+func TestWeatherConfigProvider(t *testing.T) {
+	provider, err := WeatherConfig{}.provider(http.DefaultClient)
+	require.NoError(t, err)
+	require.Equal(t, &OpenMeteo{BaseURL: openMeteoURL, Client: http.DefaultClient}, provider)
+
+	provider, err = WeatherConfig{Provider: "open-meteo", APIKey: "key"}.provider(http.DefaultClient)
+	require.NoError(t, err)
+	require.Equal(t, &OpenMeteo{BaseURL: openMeteoCustomerURL, APIKey: "key", Client: http.DefaultClient}, provider)
+
+	provider, err = WeatherConfig{Provider: "openweathermap", APIKey: "key", URL: "http://weather.lan"}.provider(http.DefaultClient)
+	require.NoError(t, err)
+	require.Equal(t, &OpenWeatherMap{BaseURL: "http://weather.lan", APIKey: "key", Client: http.DefaultClient}, provider)
+
+	_, err = WeatherConfig{Provider: "openweathermap"}.provider(http.DefaultClient)
+	require.ErrorContains(t, err, "needs an api_key")
+
+	_, err = WeatherConfig{Provider: "met-office"}.provider(http.DefaultClient)
+	require.ErrorContains(t, err, "weather provider must be one of")
+}
+
+func TestSuggestTemperature(t *testing.T) {
+	require.Equal(t, 5500, suggestTemperature(&Conditions{CloudCover: 0, Daytime: true}))
+	require.Equal(t, 6250, suggestTemperature(&Conditions{CloudCover: 50, Daytime: true}))
+	require.Equal(t, 7000, suggestTemperature(&Conditions{CloudCover: 100, Daytime: true}))
+	require.Equal(t, 3200, suggestTemperature(&Conditions{CloudCover: 100, Daytime: false}))
+}